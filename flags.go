@@ -24,6 +24,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/googlecloudplatform/gcsfuse/internal/fs"
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
 	"github.com/googlecloudplatform/gcsfuse/internal/logger"
 	mountpkg "github.com/googlecloudplatform/gcsfuse/internal/mount"
 	"github.com/urfave/cli"
@@ -80,6 +82,15 @@ func newApp() (app *cli.App) {
 				Usage: "Stay in the foreground after mounting.",
 			},
 
+			cli.StringFlag{
+				Name:  "config-file",
+				Value: "",
+				Usage: "A JSON file listing several buckets to mount from this one " +
+					"process, each with its own mount point and flags. When set, " +
+					"no bucket or mount point may be given on the command line. " +
+					"See docs/mounting.md. (default: none)",
+			},
+
 			/////////////////////////
 			// File system
 			/////////////////////////
@@ -113,6 +124,23 @@ func newApp() (app *cli.App) {
 				Usage: "GID owner of all inodes.",
 			},
 
+			cli.StringFlag{
+				Name:  "mkdir-mode",
+				Value: fs.MkdirModeImmediate,
+				Usage: "What mkdir creates in GCS: immediate (a \"dir/\" " +
+					"placeholder object right away), deferred (the placeholder " +
+					"once something is created in the directory) or local " +
+					"(nothing; the directory exists locally until objects are " +
+					"created beneath it).",
+			},
+
+			cli.BoolFlag{
+				Name: "use-32bit-inodes",
+				Usage: "Keep inode numbers below 2^32, for applications and NFS " +
+					"exports that can't handle larger ones. Numbers of forgotten " +
+					"inodes are reused once the range is exhausted.",
+			},
+
 			cli.BoolFlag{
 				Name: "implicit-dirs",
 				Usage: "Implicitly define directories based on content. See " +
@@ -131,6 +159,47 @@ func newApp() (app *cli.App) {
 					"than this limit.",
 			},
 
+			cli.BoolFlag{
+				Name: "emulate-hard-links",
+				Usage: "Emulate hard links by copying the target object to the new " +
+					"name. The copies are independent afterwards, which suffices " +
+					"for tools that link a file and then unlink the original. By " +
+					"default link(2) fails with ENOTSUP.",
+			},
+
+			cli.StringFlag{
+				Name:  "read-only-prefixes",
+				Value: "",
+				Usage: "Comma-separated object name prefixes, relative to the " +
+					"mount, e.g. \"raw/,published/\". Objects under them can't be " +
+					"created, modified or removed; attempts fail with EROFS. " +
+					"(default: none)",
+			},
+
+			cli.Int64Flag{
+				Name:  "max-bytes-written",
+				Value: 0,
+				Usage: "Limit on the bytes written through this mount while it is " +
+					"mounted, after which writes fail with EDQUOT. " +
+					"(use 0 for no limit)",
+			},
+
+			cli.Int64Flag{
+				Name:  "max-objects-created",
+				Value: 0,
+				Usage: "Limit on the files, directories and symlinks created " +
+					"through this mount while it is mounted, after which creates " +
+					"fail with EDQUOT. (use 0 for no limit)",
+			},
+
+			cli.Int64Flag{
+				Name:  "max-file-size",
+				Value: 5 << 40,
+				Usage: "Size in bytes beyond which writes and truncates fail " +
+					"with EFBIG. (default: 5 TiB, the largest object GCS " +
+					"allows; use 0 for no limit)",
+			},
+
 			/////////////////////////
 			// GCS
 			/////////////////////////
@@ -141,6 +210,13 @@ func newApp() (app *cli.App) {
 				Usage: "The endpoint to connect to.",
 			},
 
+			cli.BoolFlag{
+				Name: "fake-backend",
+				Usage: "Serve buckets from memory instead of GCS, for trying " +
+					"out a workflow offline. Buckets start empty, and their " +
+					"contents are lost when gcsfuse exits.",
+			},
+
 			cli.StringFlag{
 				Name:  "billing-project",
 				Value: "",
@@ -155,6 +231,57 @@ func newApp() (app *cli.App) {
 					"(default: none, Google application default credentials used)",
 			},
 
+			cli.StringFlag{
+				Name:  "encryption-key-file",
+				Value: "",
+				Usage: "Path to a file holding a 32-byte key, raw or base64-encoded. " +
+					"If set, file contents are encrypted before upload and decrypted " +
+					"on read. (default: none, contents are stored as written)",
+			},
+
+			cli.StringFlag{
+				Name:  "content-type-overrides",
+				Value: "",
+				Usage: "Comma-separated extension=type pairs, e.g. " +
+					"\".log=text/plain\", giving the content type of new objects. " +
+					"(default: none, guessed from the extension or contents)",
+			},
+
+			cli.StringSliceFlag{
+				Name: "object-header",
+				Usage: "A pattern=header:value rule, e.g. " +
+					"\"*.jpg=Cache-Control: public, max-age=86400\", setting a " +
+					"header on new objects whose names match. Patterns without a " +
+					"slash match the last path component. Cache-Control, " +
+					"Content-Disposition, Content-Language and x-goog-meta-* " +
+					"headers are supported. May be repeated; for each header the " +
+					"first matching rule wins.",
+			},
+
+			cli.StringFlag{
+				Name:  "acl-principals",
+				Value: "",
+				Usage: "Comma-separated mappings of local users and groups to GCS " +
+					"ACL entities, e.g. \"u:1001=user-alice@example.com," +
+					"g:2000=group-eng@example.com\". If set, object ACLs are " +
+					"exposed as POSIX ACLs through getfacl and setfacl. " +
+					"(default: none, ACLs are not exposed)",
+			},
+
+			cli.BoolFlag{
+				Name: "compress-objects",
+				Usage: "Store new objects compressed in independently readable " +
+					"blocks, trading CPU for storage and egress. Objects that do " +
+					"not shrink are stored as written.",
+			},
+
+			cli.BoolFlag{
+				Name: "anonymous-access",
+				Usage: "Send requests to GCS without credentials, for reading " +
+					"buckets open to allUsers, rather than looking for a key " +
+					"file or application default credentials.",
+			},
+
 			cli.StringFlag{
 				Name:  "token-url",
 				Value: "",
@@ -166,11 +293,25 @@ func newApp() (app *cli.App) {
 				Usage: "If false, the token acquired from token-url is not reused.",
 			},
 
+			cli.Float64Flag{
+				Name:  "limit-ingress-bytes-per-sec",
+				Value: -1,
+				Usage: "Bandwidth limit for reading data from GCS, measured over a " +
+					"30-second window. (use -1 for no limit)",
+			},
+
+			cli.Float64Flag{
+				Name:  "limit-egress-bytes-per-sec",
+				Value: -1,
+				Usage: "Bandwidth limit for uploading data to GCS, measured over a " +
+					"30-second window. (use -1 for no limit)",
+			},
+
 			cli.Float64Flag{
 				Name:  "limit-bytes-per-sec",
 				Value: -1,
-				Usage: "Bandwidth limit for reading data, measured over a 30-second " +
-					"window. (use -1 for no limit)",
+				Usage: "Deprecated: use --limit-ingress-bytes-per-sec, which takes " +
+					"precedence.",
 			},
 
 			cli.Float64Flag{
@@ -187,6 +328,14 @@ func newApp() (app *cli.App) {
 					"the value in MB. ChunkSize less than 1MB is not supported",
 			},
 
+			cli.StringFlag{
+				Name:  "archive-read-policy",
+				Value: fs.ArchiveReadAllow,
+				Usage: "How to treat reads of objects in the ARCHIVE storage class, " +
+					"which incur retrieval fees: allow, warn (log once per open " +
+					"file) or deny (fail with EACCES).",
+			},
+
 			/////////////////////////
 			// Tuning
 			/////////////////////////
@@ -200,6 +349,57 @@ func newApp() (app *cli.App) {
 					"is 1 minute. A value of 0 disables retries.",
 			},
 
+			cli.IntFlag{
+				Name:  "retry-budget",
+				Value: 100,
+				Usage: "Number of failed GCS requests (429 or 5xx) that may be " +
+					"retried before further failures are returned immediately. Each " +
+					"successful request earns back a tenth of a retry. Use 0 for no " +
+					"limit.",
+			},
+
+			cli.Float64Flag{
+				Name:  "circuit-breaker-threshold",
+				Value: 0,
+				Usage: "If positive, once at least this fraction of recent GCS " +
+					"requests have failed with network or server errors, fail " +
+					"further requests immediately with EIO for " +
+					"--circuit-breaker-cooldown, rather than waiting on retries. " +
+					"(default: 0, disabled)",
+			},
+
+			cli.DurationFlag{
+				Name:  "circuit-breaker-cooldown",
+				Value: 30 * time.Second,
+				Usage: "How long the circuit breaker fails requests before letting " +
+					"one through to check whether GCS has recovered.",
+			},
+
+			cli.DurationFlag{
+				Name:  "metadata-timeout",
+				Value: 0,
+				Usage: "If positive, GCS requests to stat, list, update or delete " +
+					"objects are abandoned with EIO after this long. " +
+					"(default: 0, no limit)",
+			},
+
+			cli.DurationFlag{
+				Name:  "read-timeout",
+				Value: 0,
+				Usage: "If positive, reads from GCS are abandoned with EIO once " +
+					"they go this long without receiving any data. " +
+					"(default: 0, no limit)",
+			},
+
+			cli.DurationFlag{
+				Name:  "upload-timeout",
+				Value: 0,
+				Usage: "If positive, GCS requests to create, compose or copy " +
+					"objects are abandoned with EIO after this long, including " +
+					"the time taken to upload the contents. " +
+					"(default: 0, no limit)",
+			},
+
 			cli.IntFlag{
 				Name:  "stat-cache-capacity",
 				Value: 4096,
@@ -242,6 +442,13 @@ func newApp() (app *cli.App) {
 				Usage: "Experimental: Cache GCS files on local disk for reads.",
 			},
 
+			cli.BoolFlag{
+				Name: "experimental-local-file-cache-verify-crc32c",
+				Usage: "Experimental: When reusing a file from the local file " +
+					"cache, also check its contents against the object's CRC32C " +
+					"rather than just its generation and size.",
+			},
+
 			cli.StringFlag{
 				Name:  "temp-dir",
 				Value: "",
@@ -249,6 +456,24 @@ func newApp() (app *cli.App) {
 					"copies. (default: system default, likely /tmp)",
 			},
 
+			cli.IntFlag{
+				Name:  "max-temp-usage",
+				Value: 0,
+				Usage: "Limit on the total size of local copies of file contents " +
+					"in temp-dir, in MB. When reached, clean cached files are " +
+					"evicted and further local copies fail with ENOSPC. " +
+					"(use 0 for no limit)",
+			},
+
+			cli.IntFlag{
+				Name:  "temp-file-memory-limit",
+				Value: 64,
+				Usage: "Local copies of file contents up to this size, in KiB, " +
+					"are kept in memory rather than in temp-dir, moving there " +
+					"only once they grow larger. Saves creating a file on disk " +
+					"for each small file written. (use 0 to always use temp-dir)",
+			},
+
 			cli.BoolFlag{
 				Name: "disable-http2",
 				Usage: "Once set, the protocol used for communicating with " +
@@ -259,7 +484,8 @@ func newApp() (app *cli.App) {
 				Name:  "max-conns-per-host",
 				Value: 10,
 				Usage: "The max number of TCP connections allowed per server. " +
-					"This is effective when --disable-http2 is set.",
+					"Over HTTP/2 each connection carries many requests at once. " +
+					"(use 0 for no limit)",
 			},
 
 			cli.IntFlag{
@@ -268,6 +494,89 @@ func newApp() (app *cli.App) {
 				Usage: "The number of maximum idle connections allowed per server",
 			},
 
+			cli.DurationFlag{
+				Name:  "tcp-keepalive-interval",
+				Value: 30 * time.Second,
+				Usage: "The interval between TCP keepalive probes on connections " +
+					"to GCS. (use a negative value to disable them)",
+			},
+
+			cli.DurationFlag{
+				Name:  "http2-read-idle-timeout",
+				Value: 0,
+				Usage: "If no data is received on an HTTP/2 connection to GCS for " +
+					"this long, send a PING to check that it is still alive. " +
+					"Helps where a NAT or proxy silently drops idle connections. " +
+					"(use 0 to disable)",
+			},
+
+			cli.DurationFlag{
+				Name:  "http2-ping-timeout",
+				Value: 15 * time.Second,
+				Usage: "How long to wait for the reply to a PING sent because of " +
+					"--http2-read-idle-timeout before closing the connection.",
+			},
+
+			cli.IntFlag{
+				Name:  "fuse-worker-pool-size",
+				Value: 0,
+				Usage: "The max number of FUSE operations served concurrently. " +
+					"Operations beyond this wait for a free worker. " +
+					"(use 0 for no limit)",
+			},
+
+			cli.BoolFlag{
+				Name: "offline-mode",
+				Usage: "While GCS is unreachable, serve reads from the local file " +
+					"cache without verifying it and queue writes of dirty files, " +
+					"replaying them once GCS is back. Writes still queued at " +
+					"unmount are saved to offline-queue-dir and replayed by the " +
+					"next mount.",
+			},
+
+			cli.StringFlag{
+				Name:  "offline-queue-dir",
+				Value: "",
+				Usage: "Directory in which writes still queued at unmount are " +
+					"saved, with the generations they were based on so that " +
+					"conflicting changes made meanwhile are detected. " +
+					"(default: a directory for the bucket under temp-dir)",
+			},
+
+			cli.StringFlag{
+				Name:  "write-policy",
+				Value: fs.WritePolicyWriteThrough,
+				Usage: "When to upload flushed files: write-through (before the " +
+					"flush returns) or write-back (in the background, trading " +
+					"durability for throughput). fsync always uploads immediately.",
+			},
+
+			cli.IntFlag{
+				Name:  "write-back-max-dirty-mb",
+				Value: 512,
+				Usage: "With write-back, the amount of dirty data in MiB that may " +
+					"wait to be uploaded before flushes upload synchronously again.",
+			},
+
+			cli.IntFlag{
+				Name:  "list-shards",
+				Value: 0,
+				Usage: "Split listings of large directories into this many key " +
+					"ranges listed concurrently, cutting the latency of the first " +
+					"ls of a huge directory. Requires " +
+					"--experimental-enable-storage-client-library. (default: 0, " +
+					"list serially)",
+			},
+
+			cli.DurationFlag{
+				Name:  "temp-file-idle-timeout",
+				Value: 0,
+				Usage: "Release local copies of clean files that have not been read " +
+					"or written for this long. They are fetched again from GCS on " +
+					"demand. The default value 0 keeps them until the inode is " +
+					"forgotten.",
+			},
+
 			/////////////////////////
 			// Monitoring & Logging
 			/////////////////////////
@@ -298,6 +607,26 @@ func newApp() (app *cli.App) {
 				Usage: "The format of the log file: 'text' or 'json'.",
 			},
 
+			cli.StringFlag{
+				Name:  "audit-log",
+				Value: "",
+				Usage: "A file to which every create, sync, rename and delete " +
+					"made through the mount is appended as a line of JSON, with " +
+					"the process, user, path and object generations involved. " +
+					"(default: none)",
+			},
+
+			cli.StringFlag{
+				Name:  "health-addr",
+				Value: "",
+				Usage: "If set, serve HTTP readiness (/readyz) and liveness " +
+					"(/livez) probes at this address, e.g. \"localhost:8080\". " +
+					"Readiness requires the mount to be established and GCS to " +
+					"accept our credentials; liveness fails when GCS calls have " +
+					"been failing for minutes or the mount point stops answering " +
+					"a stat. (default: none)",
+			},
+
 			/////////////////////////
 			// Debugging
 			/////////////////////////
@@ -346,7 +675,17 @@ func newApp() (app *cli.App) {
 			cli.BoolFlag{
 				Name: "experimental-enable-storage-client-library",
 				Usage: "If true, will use go storage client library " +
-					"otherwise jacobsa/gcloud",
+					"otherwise jacobsa/gcloud. Only with the client library are " +
+					"stats of recently seen objects revalidated with generation " +
+					"preconditions rather than fetched in full.",
+			},
+
+			cli.BoolFlag{
+				Name: "experimental-enable-grpc",
+				Usage: "Talk to GCS over its gRPC API, using DirectPath where " +
+					"available, instead of JSON over HTTP. Implies " +
+					"--experimental-enable-storage-client-library. HTTP " +
+					"connection settings and --retry-budget do not apply.",
 			},
 		},
 	}
@@ -357,46 +696,84 @@ func newApp() (app *cli.App) {
 type flagStorage struct {
 	AppName    string
 	Foreground bool
+	ConfigFile string
 
 	// File system
-	MountOptions   map[string]string
-	DirMode        os.FileMode
-	FileMode       os.FileMode
-	Uid            int64
-	Gid            int64
-	ImplicitDirs   bool
-	OnlyDir        string
-	RenameDirLimit int64
+	MountOptions      map[string]string
+	DirMode           os.FileMode
+	FileMode          os.FileMode
+	Uid               int64
+	Gid               int64
+	ImplicitDirs      bool
+	Use32BitInodes    bool
+	MkdirMode         string
+	OnlyDir           string
+	RenameDirLimit    int64
+	EmulateHardLinks  bool
+	ReadOnlyPrefixes  []string
+	MaxBytesWritten   int64
+	MaxObjectsCreated int64
+	MaxFileSize       int64
 
 	// GCS
-	Endpoint                           *url.URL
-	BillingProject                     string
-	KeyFile                            string
-	TokenUrl                           string
-	ReuseTokenFromUrl                  bool
-	EgressBandwidthLimitBytesPerSecond float64
-	OpRateLimitHz                      float64
-	SequentialReadSizeMb               int32
+	Endpoint                            *url.URL
+	FakeBackend                         bool
+	BillingProject                      string
+	KeyFile                             string
+	AnonymousAccess                     bool
+	EncryptionKeyFile                   string
+	CompressObjects                     bool
+	ContentTypeOverrides                map[string]string
+	ObjectHeaderRules                   []gcsx.ObjectHeaderRule
+	ACLPrincipals                       *fs.ACLPrincipals
+	TokenUrl                            string
+	ReuseTokenFromUrl                   bool
+	IngressBandwidthLimitBytesPerSecond float64
+	EgressBandwidthLimitBytesPerSecond  float64
+	OpRateLimitHz                       float64
+	SequentialReadSizeMb                int32
+	ArchiveReadPolicy                   string
 
 	// Tuning
-	MaxRetrySleep       time.Duration
-	StatCacheCapacity   int
-	StatCacheTTL        time.Duration
-	TypeCacheTTL        time.Duration
-	HttpClientTimeout   time.Duration
-	MaxRetryDuration    time.Duration
-	RetryMultiplier     float64
-	LocalFileCache      bool
-	TempDir             string
-	DisableHTTP2        bool
-	MaxConnsPerHost     int
-	MaxIdleConnsPerHost int
+	MaxRetrySleep           time.Duration
+	RetryBudget             int
+	CircuitBreakerThreshold float64
+	CircuitBreakerCooldown  time.Duration
+	MetadataTimeout         time.Duration
+	ReadTimeout             time.Duration
+	UploadTimeout           time.Duration
+	StatCacheCapacity       int
+	StatCacheTTL            time.Duration
+	TypeCacheTTL            time.Duration
+	HttpClientTimeout       time.Duration
+	MaxRetryDuration        time.Duration
+	RetryMultiplier         float64
+	LocalFileCache          bool
+	VerifyCacheCRC32C       bool
+	TempDir                 string
+	MaxTempUsageMb          int64
+	TempFileMemoryLimitKb   int64
+	DisableHTTP2            bool
+	MaxConnsPerHost         int
+	MaxIdleConnsPerHost     int
+	TCPKeepAlive            time.Duration
+	HTTP2ReadIdleTimeout    time.Duration
+	HTTP2PingTimeout        time.Duration
+	FuseWorkerPoolSize      int
+	TempFileIdleTimeout     time.Duration
+	OfflineMode             bool
+	OfflineQueueDir         string
+	WritePolicy             string
+	WriteBackMaxDirtyMb     int64
+	ListShards              int
 
 	// Monitoring & Logging
 	StackdriverExportInterval time.Duration
 	OtelCollectorAddress      string
 	LogFile                   string
 	LogFormat                 string
+	AuditLog                  string
+	HealthAddr                string
 	DebugFuseErrors           bool
 
 	// Debugging
@@ -409,6 +786,7 @@ type flagStorage struct {
 
 	// client
 	EnableStorageClientLibrary bool
+	EnableGRPC                 bool
 }
 
 const GCSFUSE_PARENT_PROCESS_DIR = "gcsfuse-parent-process-dir"
@@ -474,6 +852,21 @@ func resolvePathForTheFlagsInContext(c *cli.Context) (err error) {
 		return fmt.Errorf("resolving for key-file: %w", err)
 	}
 
+	err = resolvePathForTheFlagInContext("encryption-key-file", c)
+	if err != nil {
+		return fmt.Errorf("resolving for encryption-key-file: %w", err)
+	}
+
+	err = resolvePathForTheFlagInContext("audit-log", c)
+	if err != nil {
+		return fmt.Errorf("resolving for audit-log: %w", err)
+	}
+
+	err = resolvePathForTheFlagInContext("config-file", c)
+	if err != nil {
+		return fmt.Errorf("resolving for config-file: %w", err)
+	}
+
 	return
 }
 
@@ -488,46 +881,80 @@ func populateFlags(c *cli.Context) (flags *flagStorage, err error) {
 	flags = &flagStorage{
 		AppName:    c.String("app-name"),
 		Foreground: c.Bool("foreground"),
+		ConfigFile: c.String("config-file"),
 
 		// File system
-		MountOptions:   make(map[string]string),
-		DirMode:        os.FileMode(*c.Generic("dir-mode").(*OctalInt)),
-		FileMode:       os.FileMode(*c.Generic("file-mode").(*OctalInt)),
-		Uid:            int64(c.Int("uid")),
-		Gid:            int64(c.Int("gid")),
-		ImplicitDirs:   c.Bool("implicit-dirs"),
-		OnlyDir:        c.String("only-dir"),
-		RenameDirLimit: int64(c.Int("rename-dir-limit")),
+		MountOptions:      make(map[string]string),
+		DirMode:           os.FileMode(*c.Generic("dir-mode").(*OctalInt)),
+		FileMode:          os.FileMode(*c.Generic("file-mode").(*OctalInt)),
+		Uid:               int64(c.Int("uid")),
+		Gid:               int64(c.Int("gid")),
+		ImplicitDirs:      c.Bool("implicit-dirs"),
+		Use32BitInodes:    c.Bool("use-32bit-inodes"),
+		MkdirMode:         c.String("mkdir-mode"),
+		OnlyDir:           c.String("only-dir"),
+		RenameDirLimit:    int64(c.Int("rename-dir-limit")),
+		EmulateHardLinks:  c.Bool("emulate-hard-links"),
+		MaxBytesWritten:   c.Int64("max-bytes-written"),
+		MaxObjectsCreated: c.Int64("max-objects-created"),
+		MaxFileSize:       c.Int64("max-file-size"),
 
 		// GCS,
-		Endpoint:                           endpoint,
-		BillingProject:                     c.String("billing-project"),
-		KeyFile:                            c.String("key-file"),
-		TokenUrl:                           c.String("token-url"),
-		ReuseTokenFromUrl:                  c.BoolT("reuse-token-from-url"),
-		EgressBandwidthLimitBytesPerSecond: c.Float64("limit-bytes-per-sec"),
-		OpRateLimitHz:                      c.Float64("limit-ops-per-sec"),
-		SequentialReadSizeMb:               int32(c.Int("sequential-read-size-mb")),
+		Endpoint:                            endpoint,
+		FakeBackend:                         c.Bool("fake-backend"),
+		BillingProject:                      c.String("billing-project"),
+		KeyFile:                             c.String("key-file"),
+		AnonymousAccess:                     c.Bool("anonymous-access"),
+		EncryptionKeyFile:                   c.String("encryption-key-file"),
+		CompressObjects:                     c.Bool("compress-objects"),
+		TokenUrl:                            c.String("token-url"),
+		ReuseTokenFromUrl:                   c.BoolT("reuse-token-from-url"),
+		IngressBandwidthLimitBytesPerSecond: c.Float64("limit-ingress-bytes-per-sec"),
+		EgressBandwidthLimitBytesPerSecond:  c.Float64("limit-egress-bytes-per-sec"),
+		OpRateLimitHz:                       c.Float64("limit-ops-per-sec"),
+		SequentialReadSizeMb:                int32(c.Int("sequential-read-size-mb")),
+		ArchiveReadPolicy:                   c.String("archive-read-policy"),
 
 		// Tuning,
-		MaxRetrySleep:       c.Duration("max-retry-sleep"),
-		StatCacheCapacity:   c.Int("stat-cache-capacity"),
-		StatCacheTTL:        c.Duration("stat-cache-ttl"),
-		TypeCacheTTL:        c.Duration("type-cache-ttl"),
-		HttpClientTimeout:   c.Duration("http-client-timeout"),
-		MaxRetryDuration:    c.Duration("max-retry-duration"),
-		RetryMultiplier:     c.Float64("retry-multiplier"),
-		LocalFileCache:      c.Bool("experimental-local-file-cache"),
-		TempDir:             c.String("temp-dir"),
-		DisableHTTP2:        c.Bool("disable-http2"),
-		MaxConnsPerHost:     c.Int("max-conns-per-host"),
-		MaxIdleConnsPerHost: c.Int("max-idle-conns-per-host"),
+		MaxRetrySleep:           c.Duration("max-retry-sleep"),
+		RetryBudget:             c.Int("retry-budget"),
+		CircuitBreakerThreshold: c.Float64("circuit-breaker-threshold"),
+		CircuitBreakerCooldown:  c.Duration("circuit-breaker-cooldown"),
+		MetadataTimeout:         c.Duration("metadata-timeout"),
+		ReadTimeout:             c.Duration("read-timeout"),
+		UploadTimeout:           c.Duration("upload-timeout"),
+		StatCacheCapacity:       c.Int("stat-cache-capacity"),
+		StatCacheTTL:            c.Duration("stat-cache-ttl"),
+		TypeCacheTTL:            c.Duration("type-cache-ttl"),
+		HttpClientTimeout:       c.Duration("http-client-timeout"),
+		MaxRetryDuration:        c.Duration("max-retry-duration"),
+		RetryMultiplier:         c.Float64("retry-multiplier"),
+		LocalFileCache:          c.Bool("experimental-local-file-cache"),
+		VerifyCacheCRC32C:       c.Bool("experimental-local-file-cache-verify-crc32c"),
+		TempDir:                 c.String("temp-dir"),
+		MaxTempUsageMb:          int64(c.Int("max-temp-usage")),
+		TempFileMemoryLimitKb:   int64(c.Int("temp-file-memory-limit")),
+		DisableHTTP2:            c.Bool("disable-http2"),
+		MaxConnsPerHost:         c.Int("max-conns-per-host"),
+		MaxIdleConnsPerHost:     c.Int("max-idle-conns-per-host"),
+		TCPKeepAlive:            c.Duration("tcp-keepalive-interval"),
+		HTTP2ReadIdleTimeout:    c.Duration("http2-read-idle-timeout"),
+		HTTP2PingTimeout:        c.Duration("http2-ping-timeout"),
+		FuseWorkerPoolSize:      c.Int("fuse-worker-pool-size"),
+		TempFileIdleTimeout:     c.Duration("temp-file-idle-timeout"),
+		OfflineMode:             c.Bool("offline-mode"),
+		OfflineQueueDir:         c.String("offline-queue-dir"),
+		WritePolicy:             c.String("write-policy"),
+		WriteBackMaxDirtyMb:     int64(c.Int("write-back-max-dirty-mb")),
+		ListShards:              c.Int("list-shards"),
 
 		// Monitoring & Logging
 		StackdriverExportInterval: c.Duration("stackdriver-export-interval"),
 		OtelCollectorAddress:      c.String("experimental-opentelemetry-collector-address"),
 		LogFile:                   c.String("log-file"),
 		LogFormat:                 c.String("log-format"),
+		AuditLog:                  c.String("audit-log"),
+		HealthAddr:                c.String("health-addr"),
 
 		// Debugging,
 		DebugFuseErrors: c.BoolT("debug_fuse_errors"),
@@ -540,6 +967,17 @@ func populateFlags(c *cli.Context) (flags *flagStorage, err error) {
 
 		// Client,
 		EnableStorageClientLibrary: c.Bool("experimental-enable-storage-client-library"),
+		EnableGRPC:                 c.Bool("experimental-enable-grpc"),
+	}
+
+	// gRPC is only available through the storage client library.
+	if flags.EnableGRPC {
+		flags.EnableStorageClientLibrary = true
+	}
+
+	// Honor the deprecated name for the ingress limit.
+	if !c.IsSet("limit-ingress-bytes-per-sec") {
+		flags.IngressBandwidthLimitBytesPerSecond = c.Float64("limit-bytes-per-sec")
 	}
 
 	// Handle the repeated "-o" flag.
@@ -547,14 +985,220 @@ func populateFlags(c *cli.Context) (flags *flagStorage, err error) {
 		mountpkg.ParseOptions(flags.MountOptions, o)
 	}
 
+	flags.ContentTypeOverrides, err = parseContentTypeOverrides(
+		c.String("content-type-overrides"))
+	if err != nil {
+		return
+	}
+
+	flags.ObjectHeaderRules, err = parseObjectHeaderRules(
+		c.StringSlice("object-header"))
+	if err != nil {
+		return
+	}
+
+	for _, prefix := range strings.Split(c.String("read-only-prefixes"), ",") {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			flags.ReadOnlyPrefixes = append(flags.ReadOnlyPrefixes, prefix)
+		}
+	}
+
+	flags.ACLPrincipals, err = parseACLPrincipals(c.String("acl-principals"))
+	if err != nil {
+		return
+	}
+
 	err = validateFlags(flags)
 
 	return
 }
 
+// Parse a comma-separated list of extension=type pairs into a map keyed by
+// lower-case extension with a leading dot.
+func parseContentTypeOverrides(s string) (m map[string]string, err error) {
+	m = make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		ext, contentType, ok := strings.Cut(pair, "=")
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		contentType = strings.TrimSpace(contentType)
+		if !ok || ext == "" || ext == "." || contentType == "" {
+			err = fmt.Errorf("Invalid content type override: %q", pair)
+			return
+		}
+
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+
+		m[ext] = contentType
+	}
+
+	return
+}
+
+// Parse pattern=header:value rules, grouping the headers of each pattern into
+// one rule in the order the patterns first appear.
+func parseObjectHeaderRules(specs []string) (rules []gcsx.ObjectHeaderRule, err error) {
+	index := make(map[string]int)
+	for _, spec := range specs {
+		pattern, header, ok := strings.Cut(spec, "=")
+		pattern = strings.TrimSpace(pattern)
+		name, value, hasValue := strings.Cut(header, ":")
+		value = strings.TrimSpace(value)
+
+		_, badPattern := path.Match(pattern, "")
+		if !ok || !hasValue || pattern == "" || badPattern != nil || value == "" {
+			err = fmt.Errorf("Invalid object header rule: %q", spec)
+			return
+		}
+
+		if name, err = gcsx.ValidateObjectHeader(name); err != nil {
+			return
+		}
+
+		i, seen := index[pattern]
+		if !seen {
+			i = len(rules)
+			index[pattern] = i
+			rules = append(rules, gcsx.ObjectHeaderRule{
+				Pattern: pattern,
+				Headers: make(map[string]string),
+			})
+		}
+
+		rules[i].Headers[name] = value
+	}
+
+	return
+}
+
+// Parse a comma-separated list of u:uid=entity and g:gid=entity mappings. An
+// empty list yields nil.
+func parseACLPrincipals(s string) (p *fs.ACLPrincipals, err error) {
+	if strings.TrimSpace(s) == "" {
+		return
+	}
+
+	p = &fs.ACLPrincipals{
+		Users:  make(map[uint32]string),
+		Groups: make(map[uint32]string),
+	}
+
+	for _, mapping := range strings.Split(s, ",") {
+		if strings.TrimSpace(mapping) == "" {
+			continue
+		}
+
+		local, entity, ok := strings.Cut(mapping, "=")
+		kind, id, _ := strings.Cut(strings.TrimSpace(local), ":")
+		entity = strings.TrimSpace(entity)
+
+		n, parseErr := strconv.ParseUint(id, 10, 32)
+		if !ok || parseErr != nil || entity == "" {
+			err = fmt.Errorf("Invalid ACL principal mapping: %q", mapping)
+			return
+		}
+
+		switch kind {
+		case "u":
+			p.Users[uint32(n)] = entity
+		case "g":
+			p.Groups[uint32(n)] = entity
+		default:
+			err = fmt.Errorf("Invalid ACL principal mapping: %q", mapping)
+			return
+		}
+	}
+
+	return
+}
+
 func validateFlags(flags *flagStorage) (err error) {
 	if flags.SequentialReadSizeMb < 1 || flags.SequentialReadSizeMb > maxSequentialReadSizeMb {
 		err = fmt.Errorf("SequentialReadSizeMb should be less than %d", maxSequentialReadSizeMb)
+		return
+	}
+
+	switch flags.ArchiveReadPolicy {
+	case "", fs.ArchiveReadAllow, fs.ArchiveReadWarn, fs.ArchiveReadDeny:
+	default:
+		err = fmt.Errorf("Unknown ArchiveReadPolicy: %q", flags.ArchiveReadPolicy)
+		return
+	}
+
+	switch flags.WritePolicy {
+	case "", fs.WritePolicyWriteThrough, fs.WritePolicyWriteBack:
+	default:
+		err = fmt.Errorf("Unknown WritePolicy: %q", flags.WritePolicy)
+		return
+	}
+
+	switch flags.MkdirMode {
+	case "", fs.MkdirModeImmediate, fs.MkdirModeDeferred, fs.MkdirModeLocal:
+	default:
+		err = fmt.Errorf("Unknown MkdirMode: %q", flags.MkdirMode)
+		return
+	}
+
+	if flags.TempFileMemoryLimitKb < 0 {
+		err = fmt.Errorf("TempFileMemoryLimitKb should not be negative")
+		return
+	}
+
+	if flags.WriteBackMaxDirtyMb < 0 {
+		err = fmt.Errorf("WriteBackMaxDirtyMb should not be negative")
+		return
+	}
+
+	if flags.MetadataTimeout < 0 ||
+		flags.ReadTimeout < 0 ||
+		flags.UploadTimeout < 0 {
+		err = fmt.Errorf("GCS request timeouts should not be negative")
+		return
+	}
+
+	if flags.RetryBudget < 0 {
+		err = fmt.Errorf("RetryBudget should not be negative")
+		return
+	}
+
+	if flags.CircuitBreakerThreshold < 0 || flags.CircuitBreakerThreshold > 1 {
+		err = fmt.Errorf("CircuitBreakerThreshold should be between 0 and 1")
+		return
+	}
+
+	if flags.FuseWorkerPoolSize < 0 {
+		err = fmt.Errorf("FuseWorkerPoolSize should not be negative")
+		return
+	}
+
+	if flags.MaxBytesWritten < 0 || flags.MaxObjectsCreated < 0 {
+		err = fmt.Errorf("Write quotas should not be negative")
+		return
+	}
+
+	if flags.MaxFileSize < 0 {
+		err = fmt.Errorf("MaxFileSize should not be negative")
+		return
+	}
+
+	if flags.ListShards < 0 {
+		err = fmt.Errorf("ListShards should not be negative")
+		return
+	}
+
+	if flags.AnonymousAccess && (flags.KeyFile != "" || flags.TokenUrl != "") {
+		err = fmt.Errorf("AnonymousAccess can't be used with a key file or token URL")
+		return
+	}
+
+	if flags.FakeBackend && flags.EnableStorageClientLibrary {
+		err = fmt.Errorf("FakeBackend can't be used with the storage client library")
+		return
 	}
 
 	return
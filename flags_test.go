@@ -73,9 +73,23 @@ func (t *FlagsTest) Defaults() {
 	ExpectEq(-1, f.Uid)
 	ExpectEq(-1, f.Gid)
 	ExpectFalse(f.ImplicitDirs)
+	ExpectEq("immediate", f.MkdirMode)
+	ExpectEq(0, len(f.ReadOnlyPrefixes))
+	ExpectEq(0, f.MaxBytesWritten)
+	ExpectEq(0, f.MaxObjectsCreated)
+	ExpectEq(5<<40, f.MaxFileSize)
 
 	// GCS
 	ExpectEq("", f.KeyFile)
+	ExpectFalse(f.AnonymousAccess)
+	ExpectFalse(f.FakeBackend)
+	ExpectFalse(f.Use32BitInodes)
+	ExpectEq("", f.EncryptionKeyFile)
+	ExpectEq("", f.HealthAddr)
+	ExpectFalse(f.CompressObjects)
+	ExpectFalse(f.EmulateHardLinks)
+	ExpectEq(nil, f.ACLPrincipals)
+	ExpectEq(-1, f.IngressBandwidthLimitBytesPerSecond)
 	ExpectEq(-1, f.EgressBandwidthLimitBytesPerSecond)
 	ExpectEq(-1, f.OpRateLimitHz)
 	ExpectTrue(f.ReuseTokenFromUrl)
@@ -85,11 +99,34 @@ func (t *FlagsTest) Defaults() {
 	ExpectEq(time.Minute, f.StatCacheTTL)
 	ExpectEq(time.Minute, f.TypeCacheTTL)
 	ExpectEq("", f.TempDir)
+	ExpectEq(0, f.MaxTempUsageMb)
+	ExpectEq(64, f.TempFileMemoryLimitKb)
+	ExpectFalse(f.VerifyCacheCRC32C)
 	ExpectEq(2, f.RetryMultiplier)
+	ExpectEq(0, f.FuseWorkerPoolSize)
+	ExpectEq(0, f.TempFileIdleTimeout)
+	ExpectFalse(f.OfflineMode)
+	ExpectEq("", f.OfflineQueueDir)
+	ExpectEq("write-through", f.WritePolicy)
+	ExpectEq(512, f.WriteBackMaxDirtyMb)
+	ExpectEq(0, f.ListShards)
+	ExpectEq(100, f.RetryBudget)
+	ExpectEq(0, f.CircuitBreakerThreshold)
+	ExpectEq(30*time.Second, f.CircuitBreakerCooldown)
+	ExpectEq(0, f.MetadataTimeout)
+	ExpectEq(0, f.ReadTimeout)
+	ExpectEq(0, f.UploadTimeout)
+	ExpectEq("allow", f.ArchiveReadPolicy)
+	ExpectEq(30*time.Second, f.TCPKeepAlive)
+	ExpectEq(0, f.HTTP2ReadIdleTimeout)
+	ExpectEq(15*time.Second, f.HTTP2PingTimeout)
 
 	// Logging
 	ExpectTrue(f.DebugFuseErrors)
 
+	// Client
+	ExpectFalse(f.EnableGRPC)
+
 	// Debugging
 	ExpectFalse(f.DebugFuse)
 	ExpectFalse(f.DebugGCS)
@@ -107,6 +144,12 @@ func (t *FlagsTest) Bools() {
 		"debug_http",
 		"debug_invariants",
 		"experimental-enable-storage-client-library",
+		"offline-mode",
+		"compress-objects",
+		"emulate-hard-links",
+		"anonymous-access",
+		"fake-backend",
+		"use-32bit-inodes",
 	}
 
 	var args []string
@@ -127,6 +170,12 @@ func (t *FlagsTest) Bools() {
 	ExpectTrue(f.DebugHTTP)
 	ExpectTrue(f.DebugInvariants)
 	ExpectTrue(f.EnableStorageClientLibrary)
+	ExpectTrue(f.OfflineMode)
+	ExpectTrue(f.CompressObjects)
+	ExpectTrue(f.EmulateHardLinks)
+	ExpectTrue(f.AnonymousAccess)
+	ExpectTrue(f.FakeBackend)
+	ExpectTrue(f.Use32BitInodes)
 
 	// --foo=false form
 	args = nil
@@ -143,6 +192,12 @@ func (t *FlagsTest) Bools() {
 	ExpectFalse(f.DebugHTTP)
 	ExpectFalse(f.DebugInvariants)
 	ExpectFalse(f.EnableStorageClientLibrary)
+	ExpectFalse(f.OfflineMode)
+	ExpectFalse(f.CompressObjects)
+	ExpectFalse(f.EmulateHardLinks)
+	ExpectFalse(f.AnonymousAccess)
+	ExpectFalse(f.FakeBackend)
+	ExpectFalse(f.Use32BitInodes)
 
 	// --foo=true form
 	args = nil
@@ -159,6 +214,18 @@ func (t *FlagsTest) Bools() {
 	ExpectTrue(f.DebugHTTP)
 	ExpectTrue(f.DebugInvariants)
 	ExpectTrue(f.EnableStorageClientLibrary)
+	ExpectTrue(f.OfflineMode)
+	ExpectTrue(f.CompressObjects)
+	ExpectTrue(f.EmulateHardLinks)
+	ExpectTrue(f.AnonymousAccess)
+	ExpectTrue(f.FakeBackend)
+	ExpectTrue(f.Use32BitInodes)
+}
+
+func (t *FlagsTest) GRPCImpliesStorageClientLibrary() {
+	f := parseArgs([]string{"--experimental-enable-grpc"})
+	ExpectTrue(f.EnableGRPC)
+	ExpectTrue(f.EnableStorageClientLibrary)
 }
 
 func (t *FlagsTest) DecimalNumbers() {
@@ -169,15 +236,35 @@ func (t *FlagsTest) DecimalNumbers() {
 		"--limit-ops-per-sec=56.78",
 		"--stat-cache-capacity=8192",
 		"--max-idle-conns-per-host=100",
+		"--fuse-worker-pool-size=32",
+		"--retry-budget=7",
+		"--write-back-max-dirty-mb=64",
+		"--max-temp-usage=512",
+		"--temp-file-memory-limit=256",
+		"--list-shards=16",
+		"--max-bytes-written=1048576",
+		"--max-objects-created=100",
+		"--max-file-size=1073741824",
+		"--circuit-breaker-threshold=0.5",
 	}
 
 	f := parseArgs(args)
 	ExpectEq(17, f.Uid)
 	ExpectEq(19, f.Gid)
-	ExpectEq(123.4, f.EgressBandwidthLimitBytesPerSecond)
+	ExpectEq(123.4, f.IngressBandwidthLimitBytesPerSecond)
 	ExpectEq(56.78, f.OpRateLimitHz)
 	ExpectEq(8192, f.StatCacheCapacity)
 	ExpectEq(100, f.MaxIdleConnsPerHost)
+	ExpectEq(32, f.FuseWorkerPoolSize)
+	ExpectEq(7, f.RetryBudget)
+	ExpectEq(64, f.WriteBackMaxDirtyMb)
+	ExpectEq(512, f.MaxTempUsageMb)
+	ExpectEq(256, f.TempFileMemoryLimitKb)
+	ExpectEq(16, f.ListShards)
+	ExpectEq(1048576, f.MaxBytesWritten)
+	ExpectEq(100, f.MaxObjectsCreated)
+	ExpectEq(1073741824, f.MaxFileSize)
+	ExpectEq(0.5, f.CircuitBreakerThreshold)
 }
 
 func (t *FlagsTest) OctalNumbers() {
@@ -196,12 +283,36 @@ func (t *FlagsTest) Strings() {
 		"--key-file", "-asdf",
 		"--temp-dir=foobar",
 		"--only-dir=baz",
+		"--archive-read-policy=deny",
+		"--write-policy=write-back",
+		"--encryption-key-file=/tmp/kek",
+		"--health-addr=localhost:8080",
+		"--mkdir-mode=deferred",
+		"--offline-queue-dir=/tmp/queue",
 	}
 
 	f := parseArgs(args)
 	ExpectEq("-asdf", f.KeyFile)
 	ExpectEq("foobar", f.TempDir)
 	ExpectEq("baz", f.OnlyDir)
+	ExpectEq("deny", f.ArchiveReadPolicy)
+	ExpectEq("write-back", f.WritePolicy)
+	ExpectEq("/tmp/kek", f.EncryptionKeyFile)
+	ExpectEq("localhost:8080", f.HealthAddr)
+	ExpectEq("deferred", f.MkdirMode)
+	ExpectEq("/tmp/queue", f.OfflineQueueDir)
+}
+
+func (t *FlagsTest) BandwidthLimits() {
+	args := []string{
+		"--limit-bytes-per-sec=1",
+		"--limit-ingress-bytes-per-sec=2",
+		"--limit-egress-bytes-per-sec=3",
+	}
+
+	f := parseArgs(args)
+	ExpectEq(2, f.IngressBandwidthLimitBytesPerSecond)
+	ExpectEq(3, f.EgressBandwidthLimitBytesPerSecond)
 }
 
 func (t *FlagsTest) Durations() {
@@ -210,6 +321,14 @@ func (t *FlagsTest) Durations() {
 		"--type-cache-ttl", "19ns",
 		"--http-client-timeout", "800ms",
 		"--max-retry-duration", "30s",
+		"--temp-file-idle-timeout", "10m",
+		"--circuit-breaker-cooldown", "1m",
+		"--metadata-timeout", "10s",
+		"--read-timeout", "20s",
+		"--upload-timeout", "5m",
+		"--tcp-keepalive-interval", "-1s",
+		"--http2-read-idle-timeout", "20s",
+		"--http2-ping-timeout", "5s",
 	}
 
 	f := parseArgs(args)
@@ -217,6 +336,14 @@ func (t *FlagsTest) Durations() {
 	ExpectEq(19*time.Nanosecond, f.TypeCacheTTL)
 	ExpectEq(800*time.Millisecond, f.HttpClientTimeout)
 	ExpectEq(30*time.Second, f.MaxRetryDuration)
+	ExpectEq(10*time.Minute, f.TempFileIdleTimeout)
+	ExpectEq(time.Minute, f.CircuitBreakerCooldown)
+	ExpectEq(10*time.Second, f.MetadataTimeout)
+	ExpectEq(20*time.Second, f.ReadTimeout)
+	ExpectEq(5*time.Minute, f.UploadTimeout)
+	ExpectEq(-time.Second, f.TCPKeepAlive)
+	ExpectEq(20*time.Second, f.HTTP2ReadIdleTimeout)
+	ExpectEq(5*time.Second, f.HTTP2PingTimeout)
 }
 
 func (t *FlagsTest) Maps() {
@@ -241,6 +368,98 @@ func (t *FlagsTest) Maps() {
 	ExpectEq("jacobsa", f.MountOptions["user"])
 }
 
+func (t *FlagsTest) ContentTypeOverrides() {
+	args := []string{
+		"--content-type-overrides", ".log=text/plain, DAT = application/x-dat",
+	}
+
+	f := parseArgs(args)
+	ExpectThat(
+		f.ContentTypeOverrides,
+		DeepEquals(map[string]string{
+			".log": "text/plain",
+			".dat": "application/x-dat",
+		}))
+}
+
+func (t *FlagsTest) TestParseContentTypeOverridesForMissingType() {
+	_, err := parseContentTypeOverrides(".log=text/plain,.dat")
+
+	AssertNe(nil, err)
+	AssertEq("Invalid content type override: \".dat\"", err.Error())
+}
+
+func (t *FlagsTest) ObjectHeaderRules() {
+	args := []string{
+		"--object-header", "*.html=cache-control: no-cache",
+		"--object-header", "*.jpg=Cache-Control: public, max-age=86400",
+		"--object-header", "*.html=x-goog-meta-site:www",
+	}
+
+	f := parseArgs(args)
+	AssertEq(2, len(f.ObjectHeaderRules))
+
+	ExpectEq("*.html", f.ObjectHeaderRules[0].Pattern)
+	ExpectThat(
+		f.ObjectHeaderRules[0].Headers,
+		DeepEquals(map[string]string{
+			"Cache-Control":    "no-cache",
+			"X-Goog-Meta-Site": "www",
+		}))
+
+	ExpectEq("*.jpg", f.ObjectHeaderRules[1].Pattern)
+	ExpectThat(
+		f.ObjectHeaderRules[1].Headers,
+		DeepEquals(map[string]string{
+			"Cache-Control": "public, max-age=86400",
+		}))
+}
+
+func (t *FlagsTest) TestParseObjectHeaderRulesForMissingValue() {
+	_, err := parseObjectHeaderRules([]string{"*.html=Cache-Control"})
+
+	AssertNe(nil, err)
+	AssertEq("Invalid object header rule: \"*.html=Cache-Control\"", err.Error())
+}
+
+func (t *FlagsTest) TestParseObjectHeaderRulesForUnsupportedHeader() {
+	_, err := parseObjectHeaderRules([]string{"*.gz=Content-Encoding: gzip"})
+
+	AssertNe(nil, err)
+	AssertEq("Unsupported object header: \"Content-Encoding\"", err.Error())
+}
+
+func (t *FlagsTest) ReadOnlyPrefixes() {
+	args := []string{
+		"--read-only-prefixes", "raw/, published/,",
+	}
+
+	f := parseArgs(args)
+	ExpectThat(f.ReadOnlyPrefixes, ElementsAre("raw/", "published/"))
+}
+
+func (t *FlagsTest) ACLPrincipals() {
+	args := []string{
+		"--acl-principals", "u:1001=user-alice@example.com, g:2000 = group-eng@example.com",
+	}
+
+	f := parseArgs(args)
+	AssertNe(nil, f.ACLPrincipals)
+	ExpectThat(
+		f.ACLPrincipals.Users,
+		DeepEquals(map[uint32]string{1001: "user-alice@example.com"}))
+	ExpectThat(
+		f.ACLPrincipals.Groups,
+		DeepEquals(map[uint32]string{2000: "group-eng@example.com"}))
+}
+
+func (t *FlagsTest) TestParseACLPrincipalsForUnknownKind() {
+	_, err := parseACLPrincipals("u:1001=user-alice@example.com,x:7=allUsers")
+
+	AssertNe(nil, err)
+	AssertEq("Invalid ACL principal mapping: \"x:7=allUsers\"", err.Error())
+}
+
 func (t *FlagsTest) ResolveWhenParentProcDirEnvNotSetAndFilePathStartsWithTilda() {
 	resolvedPath, err := getResolvedPath("~/test.txt")
 
@@ -378,10 +597,15 @@ func (t *FlagsTest) TestResolvePathForTheFlagsInContext() {
 			appCtx.String("log-file"))
 		ExpectEq(filepath.Join(currentWorkingDir, "test.txt"),
 			appCtx.String("key-file"))
+		ExpectEq(filepath.Join(currentWorkingDir, "audit.jsonl"),
+			appCtx.String("audit-log"))
+		ExpectEq(filepath.Join(currentWorkingDir, "mounts.json"),
+			appCtx.String("config-file"))
 	}
 	// Simulate argv.
 	fullArgs := []string{"some_app", "--log-file=test.txt",
-		"--key-file=test.txt"}
+		"--key-file=test.txt", "--audit-log=audit.jsonl",
+		"--config-file=mounts.json"}
 
 	err = app.Run(fullArgs)
 
@@ -419,3 +643,161 @@ func (t *FlagsTest) TestValidateFlagsForSequentialReadSizeGreaterThan1024() {
 	AssertNe(nil, err)
 	AssertEq("SequentialReadSizeMb should be less than 1024", err.Error())
 }
+
+func (t *FlagsTest) TestValidateFlagsForNegativeFuseWorkerPoolSize() {
+	flags := &flagStorage{
+		SequentialReadSizeMb: 10,
+		FuseWorkerPoolSize:   -1,
+	}
+
+	err := validateFlags(flags)
+
+	AssertNe(nil, err)
+	AssertEq("FuseWorkerPoolSize should not be negative", err.Error())
+}
+
+func (t *FlagsTest) TestValidateFlagsForUnknownArchiveReadPolicy() {
+	flags := &flagStorage{
+		SequentialReadSizeMb: 10,
+		ArchiveReadPolicy:    "sometimes",
+	}
+
+	err := validateFlags(flags)
+
+	AssertNe(nil, err)
+	AssertEq("Unknown ArchiveReadPolicy: \"sometimes\"", err.Error())
+}
+
+func (t *FlagsTest) TestValidateFlagsForNegativeRetryBudget() {
+	flags := &flagStorage{
+		SequentialReadSizeMb: 10,
+		RetryBudget:          -1,
+	}
+
+	err := validateFlags(flags)
+
+	AssertNe(nil, err)
+	AssertEq("RetryBudget should not be negative", err.Error())
+}
+
+func (t *FlagsTest) TestValidateFlagsForCircuitBreakerThresholdAboveOne() {
+	flags := &flagStorage{
+		SequentialReadSizeMb:    10,
+		CircuitBreakerThreshold: 1.5,
+	}
+
+	err := validateFlags(flags)
+
+	AssertNe(nil, err)
+	AssertEq("CircuitBreakerThreshold should be between 0 and 1", err.Error())
+}
+
+func (t *FlagsTest) TestValidateFlagsForNegativeListShards() {
+	flags := &flagStorage{
+		SequentialReadSizeMb: 10,
+		ListShards:           -1,
+	}
+
+	err := validateFlags(flags)
+
+	AssertNe(nil, err)
+	AssertEq("ListShards should not be negative", err.Error())
+}
+
+func (t *FlagsTest) TestValidateFlagsForAnonymousAccessWithKeyFile() {
+	flags := &flagStorage{
+		SequentialReadSizeMb: 10,
+		AnonymousAccess:      true,
+		KeyFile:              "/tmp/key.json",
+	}
+
+	err := validateFlags(flags)
+
+	AssertNe(nil, err)
+	AssertEq("AnonymousAccess can't be used with a key file or token URL", err.Error())
+}
+
+func (t *FlagsTest) TestValidateFlagsForFakeBackendWithStorageClientLibrary() {
+	flags := &flagStorage{
+		SequentialReadSizeMb:       10,
+		FakeBackend:                true,
+		EnableStorageClientLibrary: true,
+	}
+
+	err := validateFlags(flags)
+
+	AssertNe(nil, err)
+	AssertEq("FakeBackend can't be used with the storage client library", err.Error())
+}
+
+func (t *FlagsTest) TestValidateFlagsForNegativeMaxFileSize() {
+	flags := &flagStorage{
+		SequentialReadSizeMb: 10,
+		MaxFileSize:          -1,
+	}
+
+	err := validateFlags(flags)
+
+	AssertNe(nil, err)
+	AssertEq("MaxFileSize should not be negative", err.Error())
+}
+
+func (t *FlagsTest) TestValidateFlagsForNegativeReadTimeout() {
+	flags := &flagStorage{
+		SequentialReadSizeMb: 10,
+		ReadTimeout:          -time.Second,
+	}
+
+	err := validateFlags(flags)
+
+	AssertNe(nil, err)
+	AssertEq("GCS request timeouts should not be negative", err.Error())
+}
+
+func (t *FlagsTest) TestValidateFlagsForNegativeTempFileMemoryLimit() {
+	flags := &flagStorage{
+		SequentialReadSizeMb:  10,
+		TempFileMemoryLimitKb: -1,
+	}
+
+	err := validateFlags(flags)
+
+	AssertNe(nil, err)
+	AssertEq("TempFileMemoryLimitKb should not be negative", err.Error())
+}
+
+func (t *FlagsTest) TestValidateFlagsForNegativeWriteQuota() {
+	flags := &flagStorage{
+		SequentialReadSizeMb: 10,
+		MaxObjectsCreated:    -1,
+	}
+
+	err := validateFlags(flags)
+
+	AssertNe(nil, err)
+	AssertEq("Write quotas should not be negative", err.Error())
+}
+
+func (t *FlagsTest) TestValidateFlagsForUnknownMkdirMode() {
+	flags := &flagStorage{
+		SequentialReadSizeMb: 10,
+		MkdirMode:            "lazy",
+	}
+
+	err := validateFlags(flags)
+
+	AssertNe(nil, err)
+	AssertEq("Unknown MkdirMode: \"lazy\"", err.Error())
+}
+
+func (t *FlagsTest) TestValidateFlagsForUnknownWritePolicy() {
+	flags := &flagStorage{
+		SequentialReadSizeMb: 10,
+		WritePolicy:          "write-around",
+	}
+
+	err := validateFlags(flags)
+
+	AssertNe(nil, err)
+	AssertEq("Unknown WritePolicy: \"write-around\"", err.Error())
+}
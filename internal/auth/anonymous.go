@@ -0,0 +1,46 @@
+// Copyright 2022 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"net/http"
+
+	"github.com/jacobsa/gcloud/httputil"
+)
+
+// NewAnonymousTransport returns a transport that sends requests through the
+// wrapped one without any Authorization header, so that they are served as
+// requests from allUsers. It is for use beneath an oauth2.Transport that can't
+// be left out, which sets such a header even for an empty token, and must
+// not be used elsewhere.
+func NewAnonymousTransport(
+	wrapped httputil.CancellableRoundTripper) httputil.CancellableRoundTripper {
+	return &anonymousTransport{wrapped: wrapped}
+}
+
+type anonymousTransport struct {
+	wrapped httputil.CancellableRoundTripper
+}
+
+func (t *anonymousTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// The request is the oauth2.Transport's own copy, made so that it could
+	// set the header, so it is safe to modify here.
+	req.Header.Del("Authorization")
+	return t.wrapped.RoundTrip(req)
+}
+
+func (t *anonymousTransport) CancelRequest(req *http.Request) {
+	t.wrapped.CancelRequest(req)
+}
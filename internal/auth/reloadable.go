@@ -0,0 +1,80 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"fmt"
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+// ReloadableTokenSource is a token source whose underlying source can be
+// created afresh while in use, for example to pick up a rotated key file
+// without remounting. Safe for concurrent access.
+type ReloadableTokenSource struct {
+	newSource func() (oauth2.TokenSource, error)
+
+	mu sync.RWMutex
+
+	// GUARDED_BY(mu)
+	current oauth2.TokenSource
+}
+
+// NewReloadableTokenSource creates a token source backed by the result of
+// calling newSource, which is called again on each reload.
+func NewReloadableTokenSource(
+	newSource func() (oauth2.TokenSource, error),
+) (ts *ReloadableTokenSource, err error) {
+	current, err := newSource()
+	if err != nil {
+		return
+	}
+
+	ts = &ReloadableTokenSource{
+		newSource: newSource,
+		current:   current,
+	}
+	return
+}
+
+// Token returns a token from the current underlying source.
+func (ts *ReloadableTokenSource) Token() (*oauth2.Token, error) {
+	ts.mu.RLock()
+	current := ts.current
+	ts.mu.RUnlock()
+
+	return current.Token()
+}
+
+// Reload creates a new underlying source and switches to it once it has
+// produced a token. On failure the current source is kept, so that a key file
+// caught half written doesn't break a working mount.
+func (ts *ReloadableTokenSource) Reload() (err error) {
+	src, err := ts.newSource()
+	if err != nil {
+		return
+	}
+
+	if _, err = src.Token(); err != nil {
+		err = fmt.Errorf("Token: %w", err)
+		return
+	}
+
+	ts.mu.Lock()
+	ts.current = src
+	ts.mu.Unlock()
+	return
+}
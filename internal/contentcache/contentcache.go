@@ -18,7 +18,9 @@ package contentcache
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"io/fs"
 	"io/ioutil"
@@ -27,14 +29,22 @@ import (
 	"path"
 	"regexp"
 	"sync"
+	"time"
 
 	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
 	"github.com/googlecloudplatform/gcsfuse/internal/logger"
+	"github.com/googlecloudplatform/gcsfuse/internal/monitor"
+	"github.com/jacobsa/gcloud/gcs"
 	"github.com/jacobsa/timeutil"
 )
 
 const CacheFilePrefix = "gcsfusecache"
 
+// ErrTempUsageExceeded is returned when creating or growing a local file would
+// take the total size of local files over the limit set with SetMaxUsage, and
+// no unleased cache files are left to evict.
+var ErrTempUsageExceeded = errors.New("local temp file usage limit exceeded")
+
 // CacheObjectKey uniquely identifies GCS objects by bucket name and object name
 type CacheObjectKey struct {
 	BucketName string
@@ -50,6 +60,35 @@ type ContentCache struct {
 	tempDir    string
 	fileMap    map[CacheObjectKey]*CacheObject
 	mtimeClock timeutil.Clock
+
+	// The limit on the total size in bytes of local files, or -1 for no limit.
+	//
+	// GUARDED_BY(mu)
+	maxUsage int64
+
+	// The total size in bytes of local files: cache files plus the temp files
+	// handed out by NewTempFile.
+	//
+	// GUARDED_BY(mu)
+	usage int64
+
+	// Whether reused cache files should have their contents checked against
+	// the object's CRC32C.
+	//
+	// GUARDED_BY(mu)
+	verifyCRC32C bool
+
+	// Whether cache files may be reused without verification when GCS can't
+	// be reached.
+	//
+	// GUARDED_BY(mu)
+	serveWhenOffline bool
+
+	// The size in bytes up to which temp files are kept in memory rather than
+	// on disk, or zero to always use disk.
+	//
+	// GUARDED_BY(mu)
+	memoryLimit int64
 }
 
 // Metadata store struct
@@ -66,6 +105,31 @@ type CacheObject struct {
 	MetadataFileName        string
 	CacheFileObjectMetadata *CacheFileObjectMetadata
 	CacheFile               gcsx.TempFile
+
+	// The number of outstanding leases on the object. Leased objects hold clean
+	// content that is being read and are never evicted.
+	//
+	// GUARDED_BY(ContentCache.mu)
+	leases int
+
+	// The number of bytes accounted to the cache file.
+	//
+	// GUARDED_BY(ContentCache.mu)
+	size int64
+
+	// The last time a lease was taken or given up, used to pick eviction
+	// victims.
+	//
+	// GUARDED_BY(ContentCache.mu)
+	lastUsed time.Time
+
+	// Set when the object was replaced or removed while leased. It is no longer
+	// in the cache, and is destroyed once the last lease is given up.
+	//
+	// INVARIANT: If detached, then leases > 0
+	//
+	// GUARDED_BY(ContentCache.mu)
+	detached bool
 }
 
 // ValidateGeneration compares fresh gcs object generation and metageneration numbers against cached objects
@@ -76,6 +140,39 @@ func (c *CacheObject) ValidateGeneration(generation int64, metaGeneration int64)
 	return c.CacheFileObjectMetadata.Generation == generation && c.CacheFileObjectMetadata.MetaGeneration == metaGeneration
 }
 
+// Verify checks that the cache file holds the contents of the given object,
+// as returned by a fresh stat. The generation and size must match and, if
+// checkCRC32C is set and the object has a CRC32C, so must the checksum of the
+// file's contents. A mismatch means the cache file must not be served.
+func (c *CacheObject) Verify(o *gcs.Object, checkCRC32C bool) (ok bool, err error) {
+	if c.CacheFile == nil || !c.ValidateGeneration(o.Generation, o.MetaGeneration) {
+		return
+	}
+
+	sr, err := c.CacheFile.Stat()
+	if err != nil {
+		err = fmt.Errorf("Stat: %w", err)
+		return
+	}
+	if uint64(sr.Size) != o.Size {
+		return
+	}
+
+	if checkCRC32C && o.CRC32C != nil {
+		h := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+		if _, err = io.Copy(h, io.NewSectionReader(c.CacheFile, 0, sr.Size)); err != nil {
+			err = fmt.Errorf("checksum cache file: %w", err)
+			return
+		}
+		if h.Sum32() != *o.CRC32C {
+			return
+		}
+	}
+
+	ok = true
+	return
+}
+
 // WriteMetadataCheckpointFile writes the metadata struct to a json file so cache files can be recovered on startup
 func (c *ContentCache) WriteMetadataCheckpointFile(cacheFileName string, cacheFileObjectMetadata *CacheFileObjectMetadata) (metadataFileName string, err error) {
 	var file []byte
@@ -139,12 +236,18 @@ func (c *ContentCache) recoverFileFromCache(metadataFile fs.FileInfo) {
 	if err != nil {
 		c.debug.Printf("Skip cache file %v due to error: %v", fileName, err)
 	}
+	var size int64
+	if fileInfo, err := file.Stat(); err == nil {
+		size = fileInfo.Size()
+	}
 	cacheObject := &CacheObject{
 		MetadataFileName:        metadataAbsolutePath,
 		CacheFileObjectMetadata: &metadata,
 		CacheFile:               cacheFile,
+		size:                    size,
 	}
 	c.fileMap[*cacheObjectKey] = cacheObject
+	c.usage += size
 }
 
 // RecoverCache recovers the cache with existing persisted files when gcsfuse starts
@@ -181,13 +284,178 @@ func New(tempDir string, mtimeClock timeutil.Clock) *ContentCache {
 		tempDir:    tempDir,
 		fileMap:    make(map[CacheObjectKey]*CacheObject),
 		mtimeClock: mtimeClock,
+		maxUsage:   -1,
+	}
+}
+
+// SetMaxUsage limits the total size in bytes of local files. Once the limit
+// is reached, unleased cache files are evicted in least recently used order
+// to make room, and if that is not enough then creating or growing local
+// files fails with ErrTempUsageExceeded. A negative limit means no limit.
+// SetMaxUsage is thread-safe
+func (c *ContentCache) SetMaxUsage(maxUsage int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxUsage = maxUsage
+}
+
+// SetVerifyCRC32C sets whether callers reusing cache files should check their
+// contents against the object's CRC32C, in addition to generation and size.
+// SetVerifyCRC32C is thread-safe
+func (c *ContentCache) SetVerifyCRC32C(verifyCRC32C bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.verifyCRC32C = verifyCRC32C
+}
+
+// SetMemoryLimit sets the size in bytes up to which the temp files handed out
+// by NewTempFile and NewSparseTempFile are kept in memory. A file moves to
+// disk once it grows larger. Zero, the default, keeps every file on disk.
+// SetMemoryLimit is thread-safe
+func (c *ContentCache) SetMemoryLimit(memoryLimit int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.memoryLimit = memoryLimit
+}
+
+// VerifyCRC32C returns the value last set with SetVerifyCRC32C.
+// VerifyCRC32C is thread-safe
+func (c *ContentCache) VerifyCRC32C() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.verifyCRC32C
+}
+
+// SetServeWhenOffline sets whether callers may reuse cache files without
+// verifying them when GCS can't be reached.
+// SetServeWhenOffline is thread-safe
+func (c *ContentCache) SetServeWhenOffline(serveWhenOffline bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.serveWhenOffline = serveWhenOffline
+}
+
+// ServeWhenOffline returns the value last set with SetServeWhenOffline.
+// ServeWhenOffline is thread-safe
+func (c *ContentCache) ServeWhenOffline() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.serveWhenOffline
+}
+
+// Usage returns the total size in bytes of local files.
+// Usage is thread-safe
+func (c *ContentCache) Usage() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.usage
+}
+
+// reserve accounts n more bytes of local files, evicting unleased cache files
+// if necessary to stay within the limit.
+//
+// LOCKS_REQUIRED(c.mu)
+func (c *ContentCache) reserve(n int64) error {
+	for c.maxUsage >= 0 && c.usage+n > c.maxUsage {
+		if !c.evictLeastRecentlyUsed() {
+			return fmt.Errorf("reserving %d bytes with %d of %d in use: %w", n, c.usage, c.maxUsage, ErrTempUsageExceeded)
+		}
 	}
+	c.usage += n
+	return nil
+}
+
+// LOCKS_REQUIRED(c.mu)
+func (c *ContentCache) unreserve(n int64) {
+	c.usage -= n
 }
 
-// NewTempFile returns a handle for a temporary file on the disk. The caller
-// must call Destroy on the TempFile before releasing it.
-func (c *ContentCache) NewTempFile(rc io.ReadCloser) (gcsx.TempFile, error) {
-	return gcsx.NewTempFile(rc, c.tempDir, c.mtimeClock)
+// evictLeastRecentlyUsed evicts the unleased cache file that was used least
+// recently, returning false if there is none.
+//
+// LOCKS_REQUIRED(c.mu)
+func (c *ContentCache) evictLeastRecentlyUsed() bool {
+	var victimKey CacheObjectKey
+	var victim *CacheObject
+	for key, cacheObject := range c.fileMap {
+		if cacheObject.leases > 0 {
+			continue
+		}
+		if victim == nil || cacheObject.lastUsed.Before(victim.lastUsed) {
+			victimKey = key
+			victim = cacheObject
+		}
+	}
+	if victim == nil {
+		return false
+	}
+	c.debug.Printf("Evicting %v to stay within the temp usage limit", victimKey)
+	c.destroy(victimKey, victim)
+	monitor.RecordCacheEviction(monitor.FileCache)
+	return true
+}
+
+// destroy removes the cache object from the map, deletes its files and
+// releases its bytes. If the object is leased, its cache file is kept for the
+// readers holding the leases until ReleaseLease gives up the last of them.
+//
+// LOCKS_REQUIRED(c.mu)
+func (c *ContentCache) destroy(cacheObjectKey CacheObjectKey, cacheObject *CacheObject) {
+	delete(c.fileMap, cacheObjectKey)
+	if cacheObject.leases > 0 {
+		// Don't let the metadata file bring the object back after a restart.
+		os.Remove(cacheObject.MetadataFileName)
+		cacheObject.detached = true
+		return
+	}
+
+	cacheObject.Destroy()
+	c.unreserve(cacheObject.size)
+}
+
+// NewTempFile returns a handle for a temporary file on the disk, whose
+// initial contents of the given size are read from rc. The caller must call
+// Destroy on the TempFile before releasing it.
+func (c *ContentCache) NewTempFile(rc io.ReadCloser, size int64) (gcsx.TempFile, error) {
+	c.mu.Lock()
+	err := c.reserve(size)
+	memoryLimit := c.memoryLimit
+	c.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	tf, err := gcsx.NewMemoryTempFile(rc, memoryLimit, c.tempDir, c.mtimeClock)
+	if err != nil {
+		c.mu.Lock()
+		c.unreserve(size)
+		c.mu.Unlock()
+		return nil, err
+	}
+
+	return &accountedTempFile{TempFile: tf, cache: c, size: size}, nil
+}
+
+// NewSparseTempFile is like NewTempFile, but the initial contents are only
+// fetched as they are needed. See gcsx.NewSparseTempFile.
+func (c *ContentCache) NewSparseTempFile(size int64, fetch gcsx.FetchFunc) (gcsx.TempFile, error) {
+	c.mu.Lock()
+	err := c.reserve(size)
+	memoryLimit := c.memoryLimit
+	c.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	tf, err := gcsx.NewSparseTempFile(size, fetch, memoryLimit, c.tempDir, c.mtimeClock)
+	if err != nil {
+		c.mu.Lock()
+		c.unreserve(size)
+		c.mu.Unlock()
+		return nil, err
+	}
+
+	return &accountedTempFile{TempFile: tf, cache: c, size: size}, nil
 }
 
 // AddOrReplace creates a new cache file or updates an existing cache file
@@ -195,12 +463,37 @@ func (c *ContentCache) NewTempFile(rc io.ReadCloser) (gcsx.TempFile, error) {
 func (c *ContentCache) AddOrReplace(cacheObjectKey *CacheObjectKey, generation int64, metaGeneration int64, rc io.ReadCloser) (*CacheObject, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	return c.addOrReplace(cacheObjectKey, generation, metaGeneration, 0, rc)
+}
+
+// AddAndLease is like AddOrReplace, but returns the new cache object with a
+// lease already held on it. The caller must call ReleaseLease when done. size
+// is the number of bytes that will be read from rc, and is accounted against
+// the limit set with SetMaxUsage.
+// AddAndLease is thread-safe
+func (c *ContentCache) AddAndLease(cacheObjectKey *CacheObjectKey, generation int64, metaGeneration int64, size int64, rc io.ReadCloser) (*CacheObject, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cacheObject, err := c.addOrReplace(cacheObjectKey, generation, metaGeneration, size, rc)
+	if err != nil {
+		return nil, err
+	}
+	cacheObject.leases++
+	return cacheObject, nil
+}
+
+// LOCKS_REQUIRED(c.mu)
+func (c *ContentCache) addOrReplace(cacheObjectKey *CacheObjectKey, generation int64, metaGeneration int64, size int64, rc io.ReadCloser) (*CacheObject, error) {
 	if cacheObject, exists := c.fileMap[*cacheObjectKey]; exists {
-		cacheObject.Destroy()
+		c.destroy(*cacheObjectKey, cacheObject)
+	}
+	if err := c.reserve(size); err != nil {
+		return nil, err
 	}
 	// Create a temporary cache file on disk
 	f, err := ioutil.TempFile(c.tempDir, CacheFilePrefix)
 	if err != nil {
+		c.unreserve(size)
 		return nil, fmt.Errorf("TempFile: %w", err)
 	}
 	file := c.NewCacheFile(rc, f)
@@ -214,17 +507,96 @@ func (c *ContentCache) AddOrReplace(cacheObjectKey *CacheObjectKey, generation i
 	var metadataFileName string
 	metadataFileName, err = c.WriteMetadataCheckpointFile(file.Name(), metadata)
 	if err != nil {
+		c.unreserve(size)
 		return nil, fmt.Errorf("WriteMetadataCheckpointFile: %w", err)
 	}
 	cacheObject := &CacheObject{
 		MetadataFileName:        metadataFileName,
 		CacheFileObjectMetadata: metadata,
 		CacheFile:               file,
+		size:                    size,
+		lastUsed:                c.mtimeClock.Now(),
 	}
 	c.fileMap[*cacheObjectKey] = cacheObject
 	return cacheObject, err
 }
 
+// Hydrate reads the given generation of an object from rc into a complete
+// cache file of the given size, unless the cache already holds that
+// generation. Unlike AddOrReplace, the contents are fetched before Hydrate
+// returns and without holding the cache lock, so several objects may be
+// hydrated in parallel. An entry for another generation is replaced unless it
+// is leased. Hydrate closes rc.
+// Hydrate is thread-safe
+func (c *ContentCache) Hydrate(cacheObjectKey *CacheObjectKey, generation int64, metaGeneration int64, size int64, rc io.ReadCloser) (err error) {
+	defer rc.Close()
+
+	c.mu.Lock()
+	err = c.reserve(size)
+	c.mu.Unlock()
+	if err != nil {
+		return
+	}
+
+	f, err := ioutil.TempFile(c.tempDir, CacheFilePrefix)
+	if err != nil {
+		c.mu.Lock()
+		c.unreserve(size)
+		c.mu.Unlock()
+		return fmt.Errorf("TempFile: %w", err)
+	}
+
+	var cacheFile gcsx.TempFile
+	if _, err = io.Copy(f, rc); err == nil {
+		cacheFile, err = c.recoverCacheFile(f)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	discard := func() {
+		f.Close()
+		os.Remove(f.Name())
+		c.unreserve(size)
+	}
+
+	if err != nil {
+		discard()
+		return fmt.Errorf("reading contents: %w", err)
+	}
+
+	// Someone may have beaten us to it, or be using an older generation.
+	if existing, ok := c.fileMap[*cacheObjectKey]; ok {
+		if existing.ValidateGeneration(generation, metaGeneration) || existing.leases > 0 {
+			discard()
+			return
+		}
+		c.destroy(*cacheObjectKey, existing)
+	}
+
+	metadata := &CacheFileObjectMetadata{
+		CacheFileNameOnDisk: f.Name(),
+		BucketName:          cacheObjectKey.BucketName,
+		ObjectName:          cacheObjectKey.ObjectName,
+		Generation:          generation,
+		MetaGeneration:      metaGeneration,
+	}
+	metadataFileName, err := c.WriteMetadataCheckpointFile(f.Name(), metadata)
+	if err != nil {
+		discard()
+		return fmt.Errorf("WriteMetadataCheckpointFile: %w", err)
+	}
+
+	c.fileMap[*cacheObjectKey] = &CacheObject{
+		MetadataFileName:        metadataFileName,
+		CacheFileObjectMetadata: metadata,
+		CacheFile:               cacheFile,
+		size:                    size,
+		lastUsed:                c.mtimeClock.Now(),
+	}
+	return
+}
+
 // Get retrieves a file from the cache given the GCS object name and bucket name
 // Get is thread-safe
 func (c *ContentCache) Get(cacheObjectKey *CacheObjectKey) (*CacheObject, bool) {
@@ -234,14 +606,60 @@ func (c *ContentCache) Get(cacheObjectKey *CacheObjectKey) (*CacheObject, bool)
 	return cacheObject, exists
 }
 
-// Remove and destroys the specfied cache file and metadata on disk
+// Lease retrieves a file from the cache if it holds the given generation of
+// the object, and pins it against eviction until ReleaseLease is called. The
+// leased cache file must only be read from.
+// Lease is thread-safe
+func (c *ContentCache) Lease(cacheObjectKey *CacheObjectKey, generation int64, metaGeneration int64) (*CacheObject, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cacheObject, exists := c.fileMap[*cacheObjectKey]
+	if !exists || !cacheObject.ValidateGeneration(generation, metaGeneration) {
+		return nil, false
+	}
+	cacheObject.leases++
+	cacheObject.lastUsed = c.mtimeClock.Now()
+	return cacheObject, true
+}
+
+// ReleaseLease gives up a lease obtained from Lease or AddAndLease.
+// ReleaseLease is thread-safe
+func (c *ContentCache) ReleaseLease(cacheObject *CacheObject) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cacheObject.leases <= 0 {
+		panic("ReleaseLease called on an unleased cache object")
+	}
+	cacheObject.leases--
+	cacheObject.lastUsed = c.mtimeClock.Now()
+	if cacheObject.leases == 0 && cacheObject.detached {
+		cacheObject.Destroy()
+		c.unreserve(cacheObject.size)
+	}
+}
+
+// Evict removes and destroys the specified cache file if no lease is held on
+// it, returning whether it did so.
+// Evict is thread-safe
+func (c *ContentCache) Evict(cacheObjectKey *CacheObjectKey) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cacheObject, exists := c.fileMap[*cacheObjectKey]
+	if !exists || cacheObject.leases > 0 {
+		return false
+	}
+	c.destroy(*cacheObjectKey, cacheObject)
+	return true
+}
+
+// Remove and destroys the specfied cache file and metadata on disk, once any
+// leases on it have been given up
 // Remove is thread-safe
 func (c *ContentCache) Remove(cacheObjectKey *CacheObjectKey) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if cacheObject, exists := c.fileMap[*cacheObjectKey]; exists {
-		cacheObject.Destroy()
-		delete(c.fileMap, *cacheObjectKey)
+		c.destroy(*cacheObjectKey, cacheObject)
 	}
 }
 
@@ -261,3 +679,49 @@ func (c *ContentCache) Size() int {
 	defer c.mu.Unlock()
 	return len(c.fileMap)
 }
+
+// accountedTempFile is a temp file whose size is accounted against the
+// content cache's usage limit.
+type accountedTempFile struct {
+	gcsx.TempFile
+	cache *ContentCache
+
+	// The number of bytes currently accounted to the file. This is an upper
+	// bound on its size, since sparse writes are accounted in full.
+	size int64
+}
+
+func (tf *accountedTempFile) resize(n int64) error {
+	tf.cache.mu.Lock()
+	defer tf.cache.mu.Unlock()
+	if n > tf.size {
+		if err := tf.cache.reserve(n - tf.size); err != nil {
+			return err
+		}
+	} else {
+		tf.cache.unreserve(tf.size - n)
+	}
+	tf.size = n
+	return nil
+}
+
+func (tf *accountedTempFile) WriteAt(p []byte, offset int64) (int, error) {
+	if end := offset + int64(len(p)); end > tf.size {
+		if err := tf.resize(end); err != nil {
+			return 0, err
+		}
+	}
+	return tf.TempFile.WriteAt(p, offset)
+}
+
+func (tf *accountedTempFile) Truncate(n int64) error {
+	if err := tf.resize(n); err != nil {
+		return err
+	}
+	return tf.TempFile.Truncate(n)
+}
+
+func (tf *accountedTempFile) Destroy() {
+	tf.resize(0)
+	tf.TempFile.Destroy()
+}
@@ -16,14 +16,18 @@ package contentcache_test
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io/ioutil"
 	"os"
+	"strings"
 	"sync"
 	"testing"
 
 	"github.com/googlecloudplatform/gcsfuse/internal/contentcache"
 	"github.com/jacobsa/fuse/fsutil"
+	"github.com/jacobsa/gcloud/gcs"
 	. "github.com/jacobsa/ogletest"
 	"github.com/jacobsa/timeutil"
 )
@@ -160,3 +164,184 @@ func TestContentCacheRemove(t *testing.T) {
 	wg.Wait()
 	ExpectEq(contentCache.Size(), 0)
 }
+
+func TestContentCacheLease(t *testing.T) {
+	mtimeClock := timeutil.RealClock()
+	contentCache := contentcache.New(testTempDir, mtimeClock)
+	cacheObjectKey := &contentcache.CacheObjectKey{
+		BucketName: "foo",
+		ObjectName: "baz",
+	}
+	_, err := contentCache.AddOrReplace(cacheObjectKey, testGeneration, testMetaGeneration, nil)
+	AssertEq(err, nil)
+
+	// A lease is only granted for the cached generation.
+	_, leased := contentCache.Lease(cacheObjectKey, testGenerationOld, testMetaGeneration)
+	ExpectFalse(leased)
+	cacheObject, leased := contentCache.Lease(cacheObjectKey, testGeneration, testMetaGeneration)
+	AssertTrue(leased)
+
+	// A leased object can't be evicted.
+	ExpectFalse(contentCache.Evict(cacheObjectKey))
+	ExpectEq(contentCache.Size(), 1)
+
+	// Once released, it can.
+	contentCache.ReleaseLease(cacheObject)
+	ExpectTrue(contentCache.Evict(cacheObjectKey))
+	ExpectEq(contentCache.Size(), 0)
+}
+
+func TestContentCacheAddAndLease(t *testing.T) {
+	mtimeClock := timeutil.RealClock()
+	contentCache := contentcache.New(testTempDir, mtimeClock)
+	cacheObjectKey := &contentcache.CacheObjectKey{
+		BucketName: "foo",
+		ObjectName: "baz",
+	}
+	cacheObject, err := contentCache.AddAndLease(cacheObjectKey, testGeneration, testMetaGeneration, 0, nil)
+	AssertEq(err, nil)
+	ExpectFalse(contentCache.Evict(cacheObjectKey))
+
+	contentCache.ReleaseLease(cacheObject)
+	ExpectTrue(contentCache.Evict(cacheObjectKey))
+}
+
+func TestContentCacheReplaceLeased(t *testing.T) {
+	mtimeClock := timeutil.RealClock()
+	contentCache := contentcache.New(testTempDir, mtimeClock)
+	cacheObjectKey := &contentcache.CacheObjectKey{
+		BucketName: "foo",
+		ObjectName: "replaced",
+	}
+	leased, err := contentCache.AddAndLease(cacheObjectKey, testGenerationOld, testMetaGeneration, 4, ioutil.NopCloser(strings.NewReader("taco")))
+	AssertEq(err, nil)
+
+	// Replacing the entry leaves the leased cache file readable.
+	_, err = contentCache.AddOrReplace(cacheObjectKey, testGeneration, testMetaGeneration, ioutil.NopCloser(strings.NewReader("burrito")))
+	AssertEq(err, nil)
+	ExpectEq(contentCache.Size(), 1)
+	ExpectEq(contentCache.Usage(), 4)
+
+	buf := make([]byte, 4)
+	_, err = leased.CacheFile.ReadAt(buf, 0)
+	AssertEq(err, nil)
+	ExpectEq("taco", string(buf))
+
+	// It is destroyed with the last lease.
+	name := leased.CacheFile.Name()
+	contentCache.ReleaseLease(leased)
+	ExpectEq(contentCache.Usage(), 0)
+	_, err = os.Stat(name)
+	ExpectTrue(os.IsNotExist(err))
+
+	contentCache.Remove(cacheObjectKey)
+}
+
+func TestContentCacheHydrate(t *testing.T) {
+	mtimeClock := timeutil.RealClock()
+	contentCache := contentcache.New(testTempDir, mtimeClock)
+	cacheObjectKey := &contentcache.CacheObjectKey{
+		BucketName: "foo",
+		ObjectName: "hydrated",
+	}
+	err := contentCache.Hydrate(cacheObjectKey, testGeneration, testMetaGeneration, 4, ioutil.NopCloser(strings.NewReader("taco")))
+	AssertEq(err, nil)
+	ExpectEq(contentCache.Usage(), 4)
+
+	// The cache file is complete and holds the contents.
+	cacheObject, ok := contentCache.Lease(cacheObjectKey, testGeneration, testMetaGeneration)
+	AssertTrue(ok)
+	buf := make([]byte, 4)
+	_, err = cacheObject.CacheFile.ReadAt(buf, 0)
+	AssertEq(err, nil)
+	ExpectEq("taco", string(buf))
+
+	// A leased entry is left alone, even for another generation.
+	err = contentCache.Hydrate(cacheObjectKey, testGenerationOld, testMetaGeneration, 5, ioutil.NopCloser(strings.NewReader("burri")))
+	AssertEq(err, nil)
+	ExpectEq(contentCache.Usage(), 4)
+	ExpectTrue(cacheObject.ValidateGeneration(testGeneration, testMetaGeneration))
+
+	contentCache.ReleaseLease(cacheObject)
+	contentCache.Remove(cacheObjectKey)
+	ExpectEq(contentCache.Usage(), 0)
+}
+
+func TestContentCacheMaxUsageEvictsUnleased(t *testing.T) {
+	mtimeClock := timeutil.RealClock()
+	contentCache := contentcache.New(testTempDir, mtimeClock)
+	contentCache.SetMaxUsage(100)
+	leasedKey := &contentcache.CacheObjectKey{BucketName: "foo", ObjectName: "leased"}
+	unleasedKey := &contentcache.CacheObjectKey{BucketName: "foo", ObjectName: "unleased"}
+
+	leased, err := contentCache.AddAndLease(leasedKey, testGeneration, testMetaGeneration, 40, nil)
+	AssertEq(err, nil)
+	unleased, err := contentCache.AddAndLease(unleasedKey, testGeneration, testMetaGeneration, 40, nil)
+	AssertEq(err, nil)
+	contentCache.ReleaseLease(unleased)
+	ExpectEq(contentCache.Usage(), 80)
+
+	// Making room evicts the unleased entry only.
+	tf, err := contentCache.NewTempFile(nil, 50)
+	AssertEq(err, nil)
+	ExpectEq(contentCache.Usage(), 90)
+	ExpectEq(contentCache.Size(), 1)
+
+	// With nothing left to evict, further usage fails.
+	_, err = contentCache.NewTempFile(nil, 20)
+	ExpectTrue(errors.Is(err, contentcache.ErrTempUsageExceeded))
+
+	tf.Destroy()
+	contentCache.ReleaseLease(leased)
+	contentCache.Remove(leasedKey)
+	ExpectEq(contentCache.Usage(), 0)
+}
+
+func TestCacheObjectVerify(t *testing.T) {
+	mtimeClock := timeutil.RealClock()
+	contentCache := contentcache.New(testTempDir, mtimeClock)
+	cacheObjectKey := &contentcache.CacheObjectKey{
+		BucketName: "foo",
+		ObjectName: "baz",
+	}
+	contents := "taco"
+	cacheObject, err := contentCache.AddOrReplace(cacheObjectKey, testGeneration, testMetaGeneration, ioutil.NopCloser(strings.NewReader(contents)))
+	AssertEq(err, nil)
+	defer contentCache.Remove(cacheObjectKey)
+
+	crc := crc32.Checksum([]byte(contents), crc32.MakeTable(crc32.Castagnoli))
+	badCRC := crc + 1
+	o := &gcs.Object{
+		Generation:     testGeneration,
+		MetaGeneration: testMetaGeneration,
+		Size:           uint64(len(contents)),
+		CRC32C:         &crc,
+	}
+
+	ok, err := cacheObject.Verify(o, true)
+	AssertEq(err, nil)
+	ExpectTrue(ok)
+
+	// A different generation or size means the cache file is stale.
+	o.Generation = testGenerationOld
+	ok, err = cacheObject.Verify(o, false)
+	AssertEq(err, nil)
+	ExpectFalse(ok)
+
+	o.Generation = testGeneration
+	o.Size++
+	ok, err = cacheObject.Verify(o, false)
+	AssertEq(err, nil)
+	ExpectFalse(ok)
+
+	// The checksum is only consulted when asked for.
+	o.Size--
+	o.CRC32C = &badCRC
+	ok, err = cacheObject.Verify(o, false)
+	AssertEq(err, nil)
+	ExpectTrue(ok)
+
+	ok, err = cacheObject.Verify(o, true)
+	AssertEq(err, nil)
+	ExpectFalse(ok)
+}
@@ -0,0 +1,190 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/fs/inode"
+	"github.com/googlecloudplatform/gcsfuse/internal/logger"
+	"golang.org/x/net/context"
+)
+
+// An entry in the audit log, written as one line of JSON.
+type auditRecord struct {
+	Time time.Time `json:"time"`
+
+	// One of "create", "mkdir", "symlink", "link", "sync", "rename", "unlink"
+	// or "rmdir".
+	Op string `json:"op"`
+
+	// The process that asked for the change, and its real user ID. Either may
+	// be missing, e.g. for writes uploaded in the background.
+	Pid uint32  `json:"pid,omitempty"`
+	Uid *uint32 `json:"uid,omitempty"`
+
+	// The path within the mount, and for renames the new one.
+	Path    string `json:"path"`
+	NewPath string `json:"new_path,omitempty"`
+
+	// The generation of the object before and after the change, where known.
+	// Zero means there was no object.
+	GenerationBefore int64 `json:"generation_before"`
+	GenerationAfter  int64 `json:"generation_after"`
+}
+
+// An append-only log of the changes made through the file system, so that
+// storage admins can reconstruct who changed what. A nil *auditLog records
+// nothing.
+type auditLog struct {
+	mu sync.Mutex
+
+	// GUARDED_BY(mu)
+	f *os.File
+}
+
+func openAuditLog(path string) (a *auditLog, err error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		err = fmt.Errorf("OpenFile: %w", err)
+		return
+	}
+
+	a = &auditLog{f: f}
+	return
+}
+
+// Look up the real user ID of a process, as the kernel doesn't tell us the
+// caller's credentials.
+func processUid(pid uint32) (uid *uint32) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "Uid:" {
+			continue
+		}
+
+		if n, err := strconv.ParseUint(fields[1], 10, 32); err == nil {
+			u := uint32(n)
+			uid = &u
+		}
+
+		return
+	}
+
+	return
+}
+
+// Record a change. Failures are logged rather than failing the operation,
+// which has already happened.
+func (a *auditLog) record(r auditRecord) {
+	if a == nil {
+		return
+	}
+
+	r.Time = time.Now().UTC()
+	if r.Pid != 0 {
+		r.Uid = processUid(r.Pid)
+	}
+
+	line, err := json.Marshal(r)
+	if err != nil {
+		logger.Infof("Audit log: Marshal: %v\n", err)
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.f == nil {
+		return
+	}
+
+	if _, err = a.f.Write(append(line, '\n')); err != nil {
+		logger.Infof("Audit log: Write: %v\n", err)
+	}
+}
+
+// Record that an object was created or replaced by the named operation.
+func (a *auditLog) recordChange(
+	op string,
+	pid uint32,
+	name inode.Name,
+	before int64,
+	after int64) {
+	a.record(auditRecord{
+		Op:               op,
+		Pid:              pid,
+		Path:             name.LocalName(),
+		GenerationBefore: before,
+		GenerationAfter:  after,
+	})
+}
+
+func (a *auditLog) Close() {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.f != nil {
+		a.f.Close()
+		a.f = nil
+	}
+}
+
+// Record a sync of the file if it wrote a new generation.
+//
+// LOCKS_REQUIRED(f)
+func (fs *fileSystem) auditSync(pid uint32, f *inode.FileInode, before int64) {
+	if after := f.SourceGeneration().Object; after != before {
+		fs.audit.recordChange("sync", pid, f.Name(), before, after)
+	}
+}
+
+// Return the generation of the parent's named child, for the audit log.
+// Zero if auditing is off or the child is not backed by an object.
+//
+// LOCKS_REQUIRED(parent)
+func (fs *fileSystem) auditGeneration(
+	ctx context.Context,
+	parent inode.DirInode,
+	name string) (generation int64) {
+	if fs.audit == nil {
+		return
+	}
+
+	child, err := parent.LookUpChild(ctx, name)
+	if err == nil && child != nil && child.Object != nil {
+		generation = child.Object.Generation
+	}
+
+	return
+}
@@ -0,0 +1,111 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type AuditLogTest struct {
+	fsTest
+	logFile string
+}
+
+func init() { RegisterTestSuite(&AuditLogTest{}) }
+
+func (t *AuditLogTest) SetUp(ti *TestInfo) {
+	f, err := ioutil.TempFile("", "audit_log_test")
+	AssertEq(nil, err)
+	f.Close()
+
+	t.logFile = f.Name()
+	t.serverCfg.AuditLogFile = t.logFile
+	t.fsTest.SetUp(ti)
+}
+
+func (t *AuditLogTest) TearDown() {
+	t.fsTest.TearDown()
+	os.Remove(t.logFile)
+}
+
+type auditEntry struct {
+	Op               string `json:"op"`
+	Pid              uint32 `json:"pid"`
+	Uid              *int   `json:"uid"`
+	Path             string `json:"path"`
+	NewPath          string `json:"new_path"`
+	GenerationBefore int64  `json:"generation_before"`
+	GenerationAfter  int64  `json:"generation_after"`
+}
+
+func (t *AuditLogTest) readLog() (entries []auditEntry) {
+	f, err := os.Open(t.logFile)
+	AssertEq(nil, err)
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e auditEntry
+		AssertEq(nil, json.Unmarshal(scanner.Bytes(), &e))
+		entries = append(entries, e)
+	}
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *AuditLogTest) CreateWriteRenameDelete() {
+	AssertEq(nil, ioutil.WriteFile(path.Join(t.Dir, "foo"), []byte("taco"), 0600))
+	AssertEq(nil, os.Rename(path.Join(t.Dir, "foo"), path.Join(t.Dir, "bar")))
+	AssertEq(nil, os.Remove(path.Join(t.Dir, "bar")))
+
+	entries := t.readLog()
+	var ops []interface{}
+	for _, e := range entries {
+		ops = append(ops, e.Op)
+	}
+
+	ExpectThat(ops, ElementsAre("create", "sync", "rename", "unlink"))
+	AssertEq(4, len(entries))
+
+	// Each change starts from the generation the previous one left.
+	create, sync, rename, unlink := entries[0], entries[1], entries[2], entries[3]
+	ExpectEq("foo", create.Path)
+	ExpectEq(0, create.GenerationBefore)
+	ExpectEq(create.GenerationAfter, sync.GenerationBefore)
+	ExpectEq(sync.GenerationAfter, rename.GenerationBefore)
+	ExpectEq("bar", rename.NewPath)
+	ExpectEq(rename.GenerationAfter, unlink.GenerationBefore)
+	ExpectEq(0, unlink.GenerationAfter)
+
+	// The caller is identified.
+	ExpectEq(os.Getpid(), create.Pid)
+	AssertNe(nil, create.Uid)
+	ExpectEq(os.Getuid(), *create.Uid)
+}
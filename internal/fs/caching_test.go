@@ -19,6 +19,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"syscall"
 	"time"
 
 	"github.com/googlecloudplatform/gcsfuse/internal/fs/inode"
@@ -295,6 +296,78 @@ func (t *CachingTest) TypeOfNameChanges_RemoteModifier() {
 	ExpectFalse(fi.IsDir())
 }
 
+func (t *CachingTest) Prewarm() {
+	var err error
+
+	// Create objects in GCS without the file system seeing them.
+	for _, name := range []string{"dir/", "dir/sub/", "dir/sub/foo"} {
+		_, err = gcsutil.CreateObject(t.ctx, t.uncachedBucket, name, []byte("taco"))
+		AssertEq(nil, err)
+	}
+
+	// Prewarm the tree, then delete the file behind the cache's back.
+	err = syscall.Setxattr(
+		path.Join(t.Dir, "dir"),
+		"user.gcsfuse.prewarm",
+		[]byte("metadata"),
+		0)
+	AssertEq(nil, err)
+
+	err = t.uncachedBucket.DeleteObject(
+		t.ctx,
+		&gcs.DeleteObjectRequest{Name: "dir/sub/foo"})
+	AssertEq(nil, err)
+
+	// The file system should still find it.
+	fi, err := os.Stat(path.Join(t.Dir, "dir/sub/foo"))
+	AssertEq(nil, err)
+	ExpectEq(len("taco"), fi.Size())
+
+	// Contents can't be prewarmed without a local file cache.
+	err = syscall.Setxattr(
+		path.Join(t.Dir, "dir"),
+		"user.gcsfuse.prewarm",
+		[]byte("contents"),
+		0)
+	ExpectEq(syscall.ENOTSUP, err)
+}
+
+func (t *CachingTest) Prewarm_EveryLevel() {
+	var err error
+
+	// Create objects in GCS without the file system seeing them.
+	for _, name := range []string{"dir/", "dir/sub/", "dir/sub/foo"} {
+		_, err = gcsutil.CreateObject(t.ctx, t.uncachedBucket, name, []byte("taco"))
+		AssertEq(nil, err)
+	}
+
+	// Prewarm the tree.
+	err = syscall.Setxattr(
+		path.Join(t.Dir, "dir"),
+		"user.gcsfuse.prewarm",
+		[]byte("metadata"),
+		0)
+	AssertEq(nil, err)
+
+	// Behind the file system's back, add a directory with the same name as the
+	// file two levels down.
+	_, err = gcsutil.CreateObject(t.ctx, t.uncachedBucket, "dir/sub/foo/", []byte(""))
+	AssertEq(nil, err)
+
+	// The subdirectory's caches were warmed too, so it still sees only the
+	// file, rather than asking GCS and preferring the directory.
+	fi, err := os.Stat(path.Join(t.Dir, "dir/sub/foo"))
+	AssertEq(nil, err)
+	ExpectFalse(fi.IsDir())
+
+	// Once the caches expire, the directory wins.
+	t.cacheClock.AdvanceTime(ttl + time.Millisecond)
+
+	fi, err = os.Stat(path.Join(t.Dir, "dir/sub/foo"))
+	AssertEq(nil, err)
+	ExpectTrue(fi.IsDir())
+}
+
 ////////////////////////////////////////////////////////////////////////
 // Caching with implicit directories
 ////////////////////////////////////////////////////////////////////////
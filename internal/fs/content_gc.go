@@ -0,0 +1,78 @@
+// Copyright 2022 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/fs/inode"
+	"github.com/googlecloudplatform/gcsfuse/internal/logger"
+	"golang.org/x/net/context"
+)
+
+// Release the clean local content of every file inode that hasn't been used
+// since the given time. The content is fetched again from the inode's source
+// generation the next time it is needed. Returns the number of inodes whose
+// content was released.
+//
+// LOCKS_EXCLUDED(fs.mu)
+func (fs *fileSystem) releaseIdleContentOnce(cutoff time.Time) (released int) {
+	// Snapshot the file inodes, so that we don't hold the file system lock
+	// while acquiring inode locks.
+	var files []*inode.FileInode
+
+	fs.mu.Lock()
+	for _, in := range fs.inodes {
+		if f, ok := in.(*inode.FileInode); ok {
+			files = append(files, f)
+		}
+	}
+	fs.mu.Unlock()
+
+	for _, f := range files {
+		f.Lock()
+		if f.LastUsed().Before(cutoff) && f.ReleaseCleanContent() {
+			released++
+		}
+		f.Unlock()
+	}
+
+	return
+}
+
+// Periodically release local content that has been idle for longer than
+// idleTimeout, until the context is cancelled.
+//
+// LOCKS_EXCLUDED(fs.mu)
+func (fs *fileSystem) releaseIdleContent(
+	ctx context.Context,
+	idleTimeout time.Duration) {
+	ticker := time.NewTicker(idleTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+		}
+
+		released := fs.releaseIdleContentOnce(fs.mtimeClock.Now().Add(-idleTimeout))
+		if released > 0 {
+			logger.Infof("Released idle local content for %d files.", released)
+		}
+	}
+}
@@ -27,6 +27,11 @@ import (
 )
 
 // State required for reading from directories.
+//
+// Entries are streamed to the kernel as listing pages arrive rather than
+// after the whole directory has been listed, so that reading a directory with
+// millions of entries starts producing output immediately. A background
+// goroutine fetches pages ahead of the reader.
 type dirHandle struct {
 	/////////////////////////
 	// Constant data
@@ -35,35 +40,83 @@ type dirHandle struct {
 	in           inode.DirInode
 	implicitDirs bool
 
+	// Returns the sorted names of the directory's files with local
+	// modifications, which are listed even if GCS doesn't show them.
+	dirtyChildNames func(dir inode.Name) []string
+
 	/////////////////////////
 	// Mutable state
 	/////////////////////////
 
 	Mu locker.Locker
 
-	// All entries in the directory. Populated the first time we need one.
+	// Entries in the directory that have been made available to the kernel so
+	// far. Populated as batches arrive from the fetcher.
 	//
 	// INVARIANT: For each i, entries[i+1].Offset == entries[i].Offset + 1
 	//
 	// GUARDED_BY(Mu)
 	entries []fuseutil.Dirent
 
-	// Has entries yet been populated?
+	// Entries received from the fetcher that can't be made available yet,
+	// because a directory with the same name may still turn up in a later
+	// batch. See admitBatch.
+	//
+	// GUARDED_BY(Mu)
+	heldBack []fuseutil.Dirent
+
+	// The greatest object name (in GCS order) known to have been covered by
+	// the listing so far. Later batches hold only greater names.
+	//
+	// GUARDED_BY(Mu)
+	listedUpTo string
+
+	// Names of locally modified files, in order, that the listing hasn't yet
+	// reached. Each is added as a file if GCS doesn't list it.
 	//
-	// INVARIANT: If !entriesValid, then len(entries) == 0
+	// GUARDED_BY(Mu)
+	dirty []string
+
+	// Batches of entries from the fetcher, closed once it's done. Nil if no
+	// listing is in progress.
 	//
 	// GUARDED_BY(Mu)
-	entriesValid bool
+	batches <-chan direntBatch
+
+	// Cancels the fetcher, if any.
+	//
+	// GUARDED_BY(Mu)
+	cancelFetch func()
+
+	// Have all entries in the directory been received?
+	//
+	// INVARIANT: If entriesComplete, then batches == nil and len(heldBack) == 0
+	//
+	// GUARDED_BY(Mu)
+	entriesComplete bool
 }
 
-// Create a directory handle that obtains listings from the supplied inode.
+// A page of entries read by the fetcher, or the error that ended the listing.
+type direntBatch struct {
+	entries []fuseutil.Dirent
+	last    bool
+	err     error
+}
+
+// The number of batches the fetcher may read ahead of the kernel.
+const direntReadAhead = 4
+
+// Create a directory handle that obtains listings from the supplied inode,
+// adding the locally modified files returned by dirtyChildNames.
 func newDirHandle(
 	in inode.DirInode,
-	implicitDirs bool) (dh *dirHandle) {
+	implicitDirs bool,
+	dirtyChildNames func(dir inode.Name) []string) (dh *dirHandle) {
 	// Set up the basic struct.
 	dh = &dirHandle{
-		in:           in,
-		implicitDirs: implicitDirs,
+		in:              in,
+		implicitDirs:    implicitDirs,
+		dirtyChildNames: dirtyChildNames,
 	}
 
 	// Set up invariant checking.
@@ -95,9 +148,9 @@ func (dh *dirHandle) checkInvariants() {
 		}
 	}
 
-	// INVARIANT: If !entriesValid, then len(entries) == 0
-	if !dh.entriesValid && len(dh.entries) != 0 {
-		panic("Unexpected non-empty entries slice")
+	// INVARIANT: If entriesComplete, then batches == nil and len(heldBack) == 0
+	if dh.entriesComplete && (dh.batches != nil || len(dh.heldBack) != 0) {
+		panic("Unexpected pending entries for a complete listing")
 	}
 }
 
@@ -154,87 +207,219 @@ func fixConflictingNames(entries []fuseutil.Dirent) (err error) {
 	return
 }
 
-// Read all entries for the directory, fix up conflicting names, and fill in
-// offset fields.
+// Read the directory page by page, sending each page on the supplied channel
+// and closing it when done.
 //
-// LOCKS_REQUIRED(in)
-func readAllEntries(
+// LOCKS_EXCLUDED(in)
+func fetchEntries(
 	ctx context.Context,
-	in inode.DirInode) (entries []fuseutil.Dirent, err error) {
-	// Read one batch at a time.
+	in inode.DirInode,
+	batches chan<- direntBatch) {
+	defer close(batches)
+
 	var tok string
 	for {
-		// Read a batch.
-		var batch []fuseutil.Dirent
+		var b direntBatch
 
-		batch, tok, err = in.ReadEntries(ctx, tok)
-		if err != nil {
-			err = fmt.Errorf("ReadEntries: %w", err)
+		in.Lock()
+		b.entries, tok, b.err = in.ReadEntries(ctx, tok)
+		in.Unlock()
+
+		if b.err != nil {
+			b.err = fmt.Errorf("ReadEntries: %w", b.err)
+		}
+
+		b.last = tok == ""
+
+		select {
+		case batches <- b:
+		case <-ctx.Done():
 			return
 		}
 
-		// Accumulate.
-		entries = append(entries, batch...)
+		if b.err != nil || b.last {
+			return
+		}
+	}
+}
 
-		// Are we done?
-		if tok == "" {
-			break
+// Return the name under which the supplied entry was listed, relative to
+// the directory. Only its ordering matters.
+func listedName(e fuseutil.Dirent) string {
+	if e.Type == fuseutil.DT_Directory {
+		return e.Name + "/"
+	}
+
+	return e.Name
+}
+
+// Make the entries of a batch available to the kernel, after fixing up
+// conflicting names and filling in offset fields.
+//
+// A file named "foo" is always listed before a directory "foo/", possibly in
+// an earlier batch. Since the conflict is resolved by renaming the file, a
+// file is held back until the listing has passed the point at which the
+// directory would appear.
+//
+// LOCKS_REQUIRED(dh.Mu)
+func (dh *dirHandle) admitBatch(batch []fuseutil.Dirent, last bool) (err error) {
+	for _, e := range batch {
+		if n := listedName(e); n > dh.listedUpTo {
+			dh.listedUpTo = n
+		}
+	}
+
+	pending := append(dh.heldBack, batch...)
+	dh.heldBack = nil
+
+	// Add the locally modified files that GCS would have listed by now, unless
+	// it has. Since the listing is in order, it would be in this batch or held
+	// back from an earlier one.
+	for len(dh.dirty) > 0 && (last || dh.dirty[0] <= dh.listedUpTo) {
+		name := dh.dirty[0]
+		dh.dirty = dh.dirty[1:]
+
+		if !containsFile(pending, name) {
+			pending = append(pending, fuseutil.Dirent{
+				Name: name,
+				Type: fuseutil.DT_File,
+			})
 		}
 	}
 
 	// Ensure that the entries are sorted, for use in fixConflictingNames
 	// below.
-	sort.Sort(sortedDirents(entries))
+	sort.Sort(sortedDirents(pending))
 
 	// Fix name conflicts.
-	err = fixConflictingNames(entries)
+	err = fixConflictingNames(pending)
 	if err != nil {
 		err = fmt.Errorf("fixConflictingNames: %w", err)
 		return
 	}
 
-	// Fix up offset fields.
-	for i := 0; i < len(entries); i++ {
-		entries[i].Offset = fuseops.DirOffset(i) + 1
-	}
+	for _, e := range pending {
+		if !last && e.Type != fuseutil.DT_Directory && e.Name+"/" > dh.listedUpTo {
+			dh.heldBack = append(dh.heldBack, e)
+			continue
+		}
 
-	// Return a bogus inode ID for each entry, but not the root inode ID.
-	//
-	// NOTE(jacobsa): As far as I can tell this is harmless. Minting and
-	// returning a real inode ID is difficult because fuse does not count
-	// readdir as an operation that increases the inode ID's lookup count and
-	// we therefore don't get a forget for it later, but we would like to not
-	// have to remember every inode ID that we've ever minted for readdir.
-	//
-	// If it turns out this is not harmless, we'll need to switch to something
-	// like inode IDs based on (object name, generation) hashes. But then what
-	// about the birthday problem? And more importantly, what about our
-	// semantic of not minting a new inode ID when the generation changes due
-	// to a local action?
-	for i, _ := range entries {
-		entries[i].Inode = fuseops.RootInodeID + 1
+		// Fix up the offset field.
+		e.Offset = fuseops.DirOffset(len(dh.entries)) + 1
+
+		// Return a bogus inode ID for each entry, but not the root inode ID.
+		//
+		// NOTE(jacobsa): As far as I can tell this is harmless. Minting and
+		// returning a real inode ID is difficult because fuse does not count
+		// readdir as an operation that increases the inode ID's lookup count and
+		// we therefore don't get a forget for it later, but we would like to not
+		// have to remember every inode ID that we've ever minted for readdir.
+		//
+		// If it turns out this is not harmless, we'll need to switch to something
+		// like inode IDs based on (object name, generation) hashes. But then what
+		// about the birthday problem? And more importantly, what about our
+		// semantic of not minting a new inode ID when the generation changes due
+		// to a local action?
+		e.Inode = fuseops.RootInodeID + 1
+
+		dh.entries = append(dh.entries, e)
 	}
 
 	return
 }
 
+// Does the list contain a non-directory entry with the given name?
+func containsFile(entries []fuseutil.Dirent, name string) bool {
+	for _, e := range entries {
+		if e.Name == name && e.Type != fuseutil.DT_Directory {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Start listing the directory afresh in the background.
+//
 // LOCKS_REQUIRED(dh.Mu)
-// LOCKS_EXCLUDED(dh.in)
-func (dh *dirHandle) ensureEntries(ctx context.Context) (err error) {
-	dh.in.Lock()
-	defer dh.in.Unlock()
-
-	// Read entries.
-	var entries []fuseutil.Dirent
-	entries, err = readAllEntries(ctx, dh.in)
-	if err != nil {
-		err = fmt.Errorf("readAllEntries: %w", err)
-		return
+func (dh *dirHandle) startFetching() {
+	dh.stopFetching()
+
+	dh.entries = nil
+	dh.heldBack = nil
+	dh.listedUpTo = ""
+	dh.dirty = dh.dirtyChildNames(dh.in.Name())
+	dh.entriesComplete = false
+
+	// The fetcher outlives the op that started it.
+	ctx, cancel := context.WithCancel(context.Background())
+	batches := make(chan direntBatch, direntReadAhead)
+	go fetchEntries(ctx, dh.in, batches)
+
+	dh.batches = batches
+	dh.cancelFetch = cancel
+}
+
+// Stop the fetcher, if any.
+//
+// LOCKS_REQUIRED(dh.Mu)
+func (dh *dirHandle) stopFetching() {
+	if dh.cancelFetch != nil {
+		dh.cancelFetch()
 	}
 
-	// Update state.
-	dh.entries = entries
-	dh.entriesValid = true
+	dh.batches = nil
+	dh.cancelFetch = nil
+}
+
+// Receive batches from the fetcher until there are more than index entries
+// available or the listing is complete. Batches that have already arrived
+// are admitted without waiting.
+//
+// LOCKS_REQUIRED(dh.Mu)
+func (dh *dirHandle) ensureEntries(ctx context.Context, index int) (err error) {
+	for !dh.entriesComplete {
+		var b direntBatch
+		var ok bool
+
+		if len(dh.entries) > index {
+			select {
+			case b, ok = <-dh.batches:
+			default:
+				return
+			}
+		} else {
+			select {
+			case b, ok = <-dh.batches:
+			case <-ctx.Done():
+				err = ctx.Err()
+				return
+			}
+		}
+
+		// The fetcher closes the channel early only if cancelled, which we
+		// never do while still receiving.
+		if !ok {
+			err = fmt.Errorf("listing ended unexpectedly")
+		} else {
+			err = b.err
+		}
+
+		if err == nil {
+			err = dh.admitBatch(b.entries, b.last)
+		}
+
+		// Start over on the next attempt if anything went wrong.
+		if err != nil {
+			dh.startFetching()
+			return
+		}
+
+		if b.last {
+			dh.stopFetching()
+			dh.entriesComplete = true
+		}
+	}
 
 	return
 }
@@ -255,23 +440,20 @@ func (dh *dirHandle) ReadDir(
 	ctx context.Context,
 	op *fuseops.ReadDirOp) (err error) {
 	// If the request is for offset zero, we assume that either this is the first
-	// call or rewinddir has been called. Reset state.
-	if op.Offset == 0 {
-		dh.entries = nil
-		dh.entriesValid = false
+	// call or rewinddir has been called. Start the listing over.
+	if op.Offset == 0 || (dh.batches == nil && !dh.entriesComplete) {
+		dh.startFetching()
 	}
 
-	// Do we need to read entries from GCS?
-	if !dh.entriesValid {
-		err = dh.ensureEntries(ctx)
-		if err != nil {
-			return
-		}
+	// Wait for entries from GCS, if we don't have any past the offset yet.
+	index := int(op.Offset)
+	err = dh.ensureEntries(ctx, index)
+	if err != nil {
+		return
 	}
 
-	// Is the offset past the end of what we have buffered? If so, this must be
-	// an invalid seekdir according to posix.
-	index := int(op.Offset)
+	// Is the offset past the end of the listing? If so, this must be an
+	// invalid seekdir according to posix.
 	if index > len(dh.entries) {
 		err = fuse.EINVAL
 		return
@@ -289,3 +471,10 @@ func (dh *dirHandle) ReadDir(
 
 	return
 }
+
+// Destroy stops any listing in progress. The handle must not be used again.
+//
+// LOCKS_REQUIRED(dh.Mu)
+func (dh *dirHandle) Destroy() {
+	dh.stopFetching()
+}
@@ -0,0 +1,169 @@
+// Copyright 2022 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/fs/inode"
+)
+
+// Files with local modifications not yet synced are shown by lookups and
+// listings of their parent even when GCS doesn't show their objects, for
+// example because they were deleted there meanwhile, so that the local view
+// stays consistent until the modifications are synced or discarded.
+//
+// fs.dirtyFiles records the files that have been modified. Entries are
+// dropped when the file system syncs them, and otherwise checked when looked
+// up and dropped once the file is clean or no longer the current inode for
+// its name. Files that are
+// still open after being unlinked are kept out of it by fs.unlinkedFiles.
+
+// Record that the file has been modified locally.
+//
+// LOCKS_REQUIRED(f)
+// LOCKS_EXCLUDED(fs.mu)
+func (fs *fileSystem) noteDirtyFile(f *inode.FileInode) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if _, ok := fs.unlinkedFiles[f.ID()]; ok {
+		return
+	}
+
+	fs.dirtyFiles[f.Name()] = f
+}
+
+// Report whether f is the current inode for its name and has local
+// modifications, forgetting it if not.
+//
+// LOCKS_REQUIRED(f)
+// LOCKS_REQUIRED(fs.mu)
+func (fs *fileSystem) checkDirtyFile(f *inode.FileInode) (dirty bool) {
+	name := f.Name()
+	if fs.dirtyFiles[name] != f {
+		return
+	}
+
+	dirty = fs.generationBackedInodes[name] == inode.GenerationBackedInode(f) &&
+		!f.SourceGenerationIsAuthoritative()
+
+	if !dirty {
+		delete(fs.dirtyFiles, name)
+	}
+
+	return
+}
+
+// Return the locally modified file with the given name, or nil if there is
+// none.
+//
+// Return the file locked, incrementing its lookup count.
+//
+// LOCKS_EXCLUDED(fs.mu)
+// LOCK_FUNCTION(f)
+func (fs *fileSystem) lookUpDirtyFile(name inode.Name) (f *inode.FileInode) {
+	fs.mu.Lock()
+	f = fs.dirtyFiles[name]
+	fs.mu.Unlock()
+
+	if f == nil {
+		return
+	}
+
+	// Checking the file requires its lock, which must be acquired before ours.
+	f.Lock()
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if !fs.checkDirtyFile(f) {
+		f.Unlock()
+		f = nil
+		return
+	}
+
+	f.IncrementLookupCount()
+	return
+}
+
+// Forget the file if it no longer has local modifications, as after a
+// successful sync, so that listings stop showing it.
+//
+// LOCKS_REQUIRED(f)
+// LOCKS_EXCLUDED(fs.mu)
+func (fs *fileSystem) forgetCleanFile(f *inode.FileInode) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.checkDirtyFile(f)
+}
+
+// Return the sorted names, relative to the directory, of the locally modified
+// files within it. This takes no inode locks, so that it may be called with
+// the directory locked; files that have become clean without being forgotten
+// may be included.
+//
+// LOCKS_EXCLUDED(fs.mu)
+func (fs *fileSystem) dirtyChildNames(dir inode.Name) (names []string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	for name, f := range fs.dirtyFiles {
+		if !name.IsDirectChildOf(dir) {
+			continue
+		}
+
+		if fs.generationBackedInodes[name] != inode.GenerationBackedInode(f) {
+			continue
+		}
+
+		names = append(
+			names,
+			strings.TrimPrefix(name.GcsObjectName(), dir.GcsObjectName()))
+	}
+
+	sort.Strings(names)
+	return
+}
+
+// Stop showing the file with the given name, or if it is a directory the
+// files within it, once the objects have been deleted or renamed. Whatever
+// local modifications the inodes hold are no longer visible under the old
+// names, even if written to again.
+//
+// LOCKS_EXCLUDED(fs.mu)
+func (fs *fileSystem) forgetDirtyFiles(name inode.Name) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	forget := func(k inode.Name) {
+		delete(fs.dirtyFiles, k)
+		if f, ok := fs.generationBackedInodes[k].(*inode.FileInode); ok {
+			fs.unlinkedFiles[f.ID()] = struct{}{}
+		}
+	}
+
+	if !name.IsDir() {
+		forget(name)
+		return
+	}
+
+	for k := range fs.generationBackedInodes {
+		if strings.HasPrefix(k.LocalName(), name.LocalName()) {
+			forget(k)
+		}
+	}
+}
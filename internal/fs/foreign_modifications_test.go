@@ -20,6 +20,7 @@ package fs_test
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"math/rand"
@@ -235,6 +236,35 @@ func (t *ForeignModsTest) ReadDir_ContentsInSubDirectory() {
 	ExpectEq(currentGid(), e.Sys().(*syscall.Stat_t).Gid)
 }
 
+func (t *ForeignModsTest) ReadDir_SpanningSeveralListingPages() {
+	// Fill the first page of the listing, ending it with a file whose
+	// conflicting directory is on the second page.
+	objects := make(map[string]string)
+	for i := 0; i < inode.MaxResultsForListObjectsCall-1; i++ {
+		objects[fmt.Sprintf("a%05d", i)] = ""
+	}
+
+	objects["b"] = "taco"
+	objects["b/"] = ""
+	objects["c"] = "burrito"
+
+	AssertEq(nil, t.createObjects(objects))
+
+	// ReadDir
+	entries, err := fusetesting.ReadDirPicky(t.mfs.Dir())
+	AssertEq(nil, err)
+	AssertEq(inode.MaxResultsForListObjectsCall+2, len(entries))
+
+	names := getFileNames(entries)
+	ExpectEq("a00000", names[0])
+	ExpectEq("b", names[len(names)-3])
+	ExpectEq("b\n", names[len(names)-2])
+	ExpectEq("c", names[len(names)-1])
+
+	ExpectTrue(entries[len(entries)-3].IsDir())
+	ExpectFalse(entries[len(entries)-2].IsDir())
+}
+
 func (t *ForeignModsTest) UnreachableObjects() {
 	var fi os.FileInfo
 	var err error
@@ -774,6 +804,74 @@ func (t *ForeignModsTest) ObjectIsDeleted_File() {
 	ExpectTrue(os.IsNotExist(err), "err: %v", err)
 }
 
+func (t *ForeignModsTest) ObjectIsDeleted_DirtyFile() {
+	// Create an object.
+	AssertEq(nil, t.createWithContents("foo", "taco"))
+
+	// Open the corresponding file and modify it without syncing.
+	f, err := os.OpenFile(path.Join(t.mfs.Dir(), "foo"), os.O_RDWR, 0)
+	defer func() {
+		if f != nil {
+			ExpectEq(nil, f.Close())
+		}
+	}()
+
+	AssertEq(nil, err)
+
+	_, err = f.WriteAt([]byte("burrito"), 0)
+	AssertEq(nil, err)
+
+	// Delete the object.
+	AssertEq(
+		nil,
+		t.bucket.DeleteObject(
+			t.ctx,
+			&gcs.DeleteObjectRequest{Name: "foo"}))
+
+	// The file should still be visible by name, with the local contents.
+	fi, err := os.Stat(path.Join(t.mfs.Dir(), "foo"))
+
+	AssertEq(nil, err)
+	ExpectEq(len("burrito"), fi.Size())
+
+	entries, err := fusetesting.ReadDirPicky(t.mfs.Dir())
+
+	AssertEq(nil, err)
+	AssertEq(1, len(entries), "Names: %v", getFileNames(entries))
+	ExpectEq("foo", entries[0].Name())
+	ExpectEq(len("burrito"), entries[0].Size())
+}
+
+func (t *ForeignModsTest) ObjectIsDeleted_DirtyFileInDirectory() {
+	// Create a directory with a file in it.
+	AssertEq(nil, t.createWithContents("dir/", ""))
+	AssertEq(nil, t.createWithContents("dir/foo", "taco"))
+
+	// Open the file and modify it without syncing.
+	f, err := os.OpenFile(path.Join(t.mfs.Dir(), "dir/foo"), os.O_RDWR, 0)
+	defer func() {
+		if f != nil {
+			ExpectEq(nil, f.Close())
+		}
+	}()
+
+	AssertEq(nil, err)
+
+	_, err = f.WriteAt([]byte("burrito"), 0)
+	AssertEq(nil, err)
+
+	// Delete the file's object, leaving the directory empty in GCS.
+	AssertEq(
+		nil,
+		t.bucket.DeleteObject(
+			t.ctx,
+			&gcs.DeleteObjectRequest{Name: "dir/foo"}))
+
+	// The directory still holds the dirty file, so can't be removed.
+	err = os.Remove(path.Join(t.mfs.Dir(), "dir"))
+	ExpectThat(err, Error(HasSubstr("not empty")))
+}
+
 func (t *ForeignModsTest) ObjectIsDeleted_Directory() {
 	var err error
 
@@ -896,3 +994,165 @@ func (t *ForeignModsTest) Symlink() {
 	AssertEq(nil, err)
 	ExpectEq("bar/baz", target)
 }
+
+func (t *ForeignModsTest) Xattr_EventBasedHold() {
+	var err error
+
+	// Create an object.
+	_, err = gcsutil.CreateObject(t.ctx, t.bucket, "foo", []byte("taco"))
+	AssertEq(nil, err)
+
+	// The object attributes should be listed.
+	buf := make([]byte, 1024)
+	n, err := syscall.Listxattr(path.Join(t.Dir, "foo"), buf)
+	AssertEq(nil, err)
+	ExpectEq(
+		"user.gcsfuse.event_based_hold\x00user.gcsfuse.storage_class\x00"+
+			"user.gcsfuse.generation\x00user.gcsfuse.metageneration\x00",
+		string(buf[:n]))
+
+	// And report that the object isn't held.
+	n, err = syscall.Getxattr(
+		path.Join(t.Dir, "foo"),
+		"user.gcsfuse.event_based_hold",
+		buf)
+
+	AssertEq(nil, err)
+	ExpectEq("false", string(buf[:n]))
+
+	// Unknown attributes don't exist.
+	_, err = syscall.Getxattr(path.Join(t.Dir, "foo"), "user.taco", buf)
+	ExpectEq(syscall.ENODATA, err)
+}
+
+func (t *ForeignModsTest) Xattr_Generation() {
+	var err error
+
+	// Create an object, then update its metadata.
+	o, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", []byte("taco"))
+	AssertEq(nil, err)
+
+	contentType := "text/plain"
+	o, err = t.bucket.UpdateObject(
+		t.ctx,
+		&gcs.UpdateObjectRequest{
+			Name:        "foo",
+			ContentType: &contentType,
+		})
+	AssertEq(nil, err)
+
+	buf := make([]byte, 1024)
+	n, err := syscall.Getxattr(
+		path.Join(t.Dir, "foo"),
+		"user.gcsfuse.generation",
+		buf)
+
+	AssertEq(nil, err)
+	ExpectEq(fmt.Sprint(o.Generation), string(buf[:n]))
+
+	n, err = syscall.Getxattr(
+		path.Join(t.Dir, "foo"),
+		"user.gcsfuse.metageneration",
+		buf)
+
+	AssertEq(nil, err)
+	ExpectEq(fmt.Sprint(o.MetaGeneration), string(buf[:n]))
+}
+
+func (t *ForeignModsTest) PinnedGeneration() {
+	var err error
+
+	// Create an object.
+	o, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", []byte("taco"))
+	AssertEq(nil, err)
+
+	// Open the current generation by name.
+	pinnedPath := path.Join(t.Dir, fmt.Sprintf("foo#%d", o.Generation))
+	t.f1, err = os.Open(pinnedPath)
+	AssertEq(nil, err)
+
+	fi, err := t.f1.Stat()
+	AssertEq(nil, err)
+	ExpectEq(len("taco"), fi.Size())
+	ExpectEq(filePerms&^0222, fi.Mode())
+
+	// It can't be written.
+	_, err = os.OpenFile(pinnedPath, os.O_WRONLY, 0)
+	ExpectThat(err, Error(HasSubstr("read-only")))
+
+	// Overwrite the object. The pinned file still reads the old generation,
+	// or fails if it is gone, but never reads the new one.
+	_, err = gcsutil.CreateObject(t.ctx, t.bucket, "foo", []byte("burrito"))
+	AssertEq(nil, err)
+
+	contents, err := ioutil.ReadAll(t.f1)
+	if err == nil {
+		ExpectEq("taco", string(contents))
+	}
+
+	// The old generation can no longer be looked up, and nor can one that
+	// never existed.
+	_, err = os.Stat(pinnedPath)
+	ExpectTrue(os.IsNotExist(err), "err: %v", err)
+
+	_, err = os.Stat(path.Join(t.Dir, "foo#17"))
+	ExpectTrue(os.IsNotExist(err), "err: %v", err)
+
+	// The file itself is unaffected.
+	contents, err = ioutil.ReadFile(path.Join(t.Dir, "foo"))
+	AssertEq(nil, err)
+	ExpectEq("burrito", string(contents))
+}
+
+func (t *ForeignModsTest) Xattr_UserDefined() {
+	var err error
+
+	// Create an object with an xattr set by another mount.
+	_, err = t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:     "foo",
+			Contents: strings.NewReader("taco"),
+			Metadata: map[string]string{
+				"gcsfuse_xattr.user.checksum": "abcd",
+			},
+		})
+	AssertEq(nil, err)
+
+	buf := make([]byte, 1024)
+	n, err := syscall.Getxattr(path.Join(t.Dir, "foo"), "user.checksum", buf)
+	AssertEq(nil, err)
+	ExpectEq("abcd", string(buf[:n]))
+
+	// Set a binary value, which is stored base64-encoded.
+	err = syscall.Setxattr(path.Join(t.Dir, "foo"), "user.blob", []byte{0, 1, 2}, 0)
+	AssertEq(nil, err)
+
+	o, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+	ExpectEq("base64:AAEC", o.Metadata["gcsfuse_xattr.user.blob"])
+
+	n, err = syscall.Listxattr(path.Join(t.Dir, "foo"), buf)
+	AssertEq(nil, err)
+	ExpectEq(
+		"user.gcsfuse.event_based_hold\x00user.gcsfuse.storage_class\x00"+
+			"user.gcsfuse.generation\x00user.gcsfuse.metageneration\x00"+
+			"user.blob\x00user.checksum\x00",
+		string(buf[:n]))
+
+	// Remove the first one.
+	err = syscall.Removexattr(path.Join(t.Dir, "foo"), "user.checksum")
+	AssertEq(nil, err)
+
+	_, err = syscall.Getxattr(path.Join(t.Dir, "foo"), "user.checksum", buf)
+	ExpectEq(syscall.ENODATA, err)
+
+	o, err = t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+	_, ok := o.Metadata["gcsfuse_xattr.user.checksum"]
+	ExpectFalse(ok)
+
+	// Creating an existing xattr fails.
+	err = syscall.Setxattr(path.Join(t.Dir, "foo"), "user.blob", []byte("x"), 0x1)
+	ExpectEq(syscall.EEXIST, err)
+}
@@ -19,9 +19,11 @@ import (
 	"fmt"
 	"io"
 	iofs "io/fs"
+	"math"
 	"os"
 	"reflect"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -54,6 +56,10 @@ type ServerConfig struct {
 	// LocalFileCache
 	LocalFileCache bool
 
+	// When reusing a file from the local file cache, also check its contents
+	// against the object's CRC32C.
+	VerifyCacheCRC32C bool
+
 	// Enable debug messages
 	DebugFS bool
 
@@ -61,6 +67,15 @@ type ServerConfig struct {
 	// use the system default.
 	TempDir string
 
+	// The limit on the total size of local copies of file contents in TempDir,
+	// in MB. Zero means no limit.
+	MaxTempUsageMb int64
+
+	// Local copies of file contents up to this size, in KiB, are kept in memory
+	// rather than in TempDir until they grow larger. Zero means always using
+	// TempDir.
+	TempFileMemoryLimitKb int64
+
 	// By default, if a bucket contains the object "foo/bar" but no object named
 	// "foo/", it's as if the directory doesn't exist. This allows us to have
 	// non-flaky name resolution code.
@@ -107,6 +122,73 @@ type ServerConfig struct {
 
 	// File chunk size to read from GCS in one call. Specified in MB.
 	SequentialReadSizeMb int32
+
+	// The max number of fuse operations served concurrently. Zero means no
+	// limit.
+	FuseWorkerPoolSize int
+
+	// Clean local copies of file contents that haven't been used for this long
+	// are released, to be fetched again on demand. Zero disables this.
+	TempFileIdleTimeout time.Duration
+
+	// How to treat reads of objects in the ARCHIVE storage class: one of
+	// ArchiveReadAllow, ArchiveReadWarn or ArchiveReadDeny. Empty means allow.
+	ArchiveReadPolicy string
+
+	// While GCS is unavailable, serve cached file contents without verifying
+	// them, and queue syncs of dirty files to be replayed once it is back.
+	OfflineMode bool
+
+	// If set, files still queued to be synced at unmount are saved here along
+	// with the generations they were branched from, and writes saved by an
+	// earlier mount are queued again at startup.
+	OfflineQueueDir string
+
+	// When flushed writes are uploaded: WritePolicyWriteThrough (the default
+	// if empty) or WritePolicyWriteBack.
+	WritePolicy string
+
+	// With write-back, flushes are made synchronous while this many MiB of
+	// dirty file contents are already waiting to be uploaded.
+	WriteBackMaxDirtyMb int64
+
+	// GCS has no hard links, so link(2) fails with ENOTSUP by default. If set,
+	// it is instead emulated by copying the target object to the new name. The
+	// copies are independent, which suffices for tools that link a file and
+	// then unlink the original.
+	EmulateHardLinks bool
+
+	// If set, inode IDs are kept below 2^32 for applications that can't handle
+	// larger inode numbers. Once the range is used up, IDs of inodes since
+	// forgotten are handed out again.
+	Use32BitInodes bool
+
+	// What mkdir creates in GCS: MkdirModeImmediate (the default if empty),
+	// MkdirModeDeferred or MkdirModeLocal.
+	MkdirMode string
+
+	// If non-nil, the ACLs of objects backing files are exposed as POSIX ACLs,
+	// with GCS principals mapped to local users and groups as described. See
+	// posix_acl.go.
+	ACLPrincipals *ACLPrincipals
+
+	// Objects whose names start with any of these prefixes (e.g. "raw/") may
+	// not be created, modified or removed through the file system; attempts
+	// fail with EROFS.
+	ReadOnlyPrefixes []string
+
+	// Limits on the bytes written and objects created by this mount, beyond
+	// which writes and creates fail with EDQUOT. Zero means no limit.
+	MaxBytesWritten   int64
+	MaxObjectsCreated int64
+
+	// Writes and truncates that would make a file larger than this many bytes
+	// fail with EFBIG. Zero means no limit.
+	MaxFileSize int64
+
+	// If set, creates, syncs, renames and deletes made through the file system
+	// are appended to this file as lines of JSON.
+	AuditLogFile string
 }
 
 // Create a fuse file system server according to the supplied configuration.
@@ -125,6 +207,12 @@ func NewFileSystem(
 	mtimeClock := timeutil.RealClock()
 
 	contentCache := contentcache.New(cfg.TempDir, mtimeClock)
+	if cfg.MaxTempUsageMb > 0 {
+		contentCache.SetMaxUsage(cfg.MaxTempUsageMb * 1024 * 1024)
+	}
+	contentCache.SetMemoryLimit(cfg.TempFileMemoryLimitKb * 1024)
+	contentCache.SetVerifyCRC32C(cfg.VerifyCacheCRC32C)
+	contentCache.SetServeWhenOffline(cfg.OfflineMode)
 
 	if cfg.LocalFileCache {
 		err := contentCache.RecoverCache()
@@ -134,6 +222,20 @@ func NewFileSystem(
 		}
 	}
 
+	var audit *auditLog
+	if cfg.AuditLogFile != "" {
+		var err error
+		audit, err = openAuditLog(cfg.AuditLogFile)
+		if err != nil {
+			return nil, fmt.Errorf("openAuditLog: %w", err)
+		}
+	}
+
+	var maxInodeID fuseops.InodeID = math.MaxUint64
+	if cfg.Use32BitInodes {
+		maxInodeID = math.MaxUint32
+	}
+
 	// Set up the basic struct.
 	fs := &fileSystem{
 		mtimeClock:             mtimeClock,
@@ -146,15 +248,37 @@ func NewFileSystem(
 		dirTypeCacheTTL:        cfg.DirTypeCacheTTL,
 		renameDirLimit:         cfg.RenameDirLimit,
 		sequentialReadSizeMb:   cfg.SequentialReadSizeMb,
+		archiveReadPolicy:      cfg.ArchiveReadPolicy,
+		offlineMode:            cfg.OfflineMode,
+		offlineQueueDir:        cfg.OfflineQueueDir,
+		writeBack:              cfg.WritePolicy == WritePolicyWriteBack,
+		writeBackMaxDirtyBytes: cfg.WriteBackMaxDirtyMb * 1024 * 1024,
+		emulateHardLinks:       cfg.EmulateHardLinks,
+		mkdirMode:              cfg.MkdirMode,
+		maxFileSize:            cfg.MaxFileSize,
+		aclPrincipals:          cfg.ACLPrincipals,
+		readOnlyPrefixes:       cfg.ReadOnlyPrefixes,
+		audit:                  audit,
+		quota: writeQuota{
+			maxBytesWritten:   cfg.MaxBytesWritten,
+			maxObjectsCreated: cfg.MaxObjectsCreated,
+		},
 		uid:                    cfg.Uid,
 		gid:                    cfg.Gid,
 		fileMode:               cfg.FilePerms,
 		dirMode:                cfg.DirPerms | os.ModeDir,
 		inodes:                 make(map[fuseops.InodeID]inode.Inode),
 		nextInodeID:            fuseops.RootInodeID + 1,
+		maxInodeID:             maxInodeID,
+		inodeGenerations:       make(map[fuseops.InodeID]fuseops.GenerationNumber),
 		generationBackedInodes: make(map[inode.Name]inode.GenerationBackedInode),
 		implicitDirInodes:      make(map[inode.Name]inode.DirInode),
+		warmedListings:         inode.NewWarmedListings(cfg.DirTypeCacheTTL),
 		handles:                make(map[fuseops.HandleID]interface{}),
+		fileHandleCounts:       make(map[fuseops.InodeID]int),
+		pendingSyncs:           make(map[fuseops.InodeID]pendingSync),
+		dirtyFiles:             make(map[inode.Name]*inode.FileInode),
+		unlinkedFiles:          make(map[fuseops.InodeID]struct{}),
 	}
 
 	// Set up root bucket
@@ -178,6 +302,43 @@ func NewFileSystem(
 
 	// Set up invariant checking.
 	fs.mu = locker.New("FS", fs.checkInvariants)
+
+	// Queue again any writes left over by an earlier mount.
+	var replayed int
+	if fs.offlineQueueDir != "" {
+		var err error
+		replayed, err = fs.replayOfflineQueue(ctx, root)
+		if err != nil {
+			return nil, fmt.Errorf("replayOfflineQueue: %w", err)
+		}
+
+		if replayed > 0 {
+			logger.Infof("Replaying queued writes for %d files.\n", replayed)
+		}
+	}
+
+	// Start releasing idle local content, if configured.
+	var gcCtx context.Context
+	gcCtx, fs.stopReleasingIdleContent = context.WithCancel(context.Background())
+	if cfg.TempFileIdleTimeout > 0 {
+		go fs.releaseIdleContent(gcCtx, cfg.TempFileIdleTimeout)
+	}
+
+	// Start syncing queued writes in the background, if configured.
+	var syncCtx context.Context
+	syncCtx, fs.stopSyncingPending = context.WithCancel(context.Background())
+	fs.syncingPendingDone = make(chan struct{})
+	switch {
+	case fs.writeBack:
+		go fs.syncPending(syncCtx, writeBackInterval)
+
+	case fs.offlineMode || replayed > 0:
+		go fs.syncPending(syncCtx, offlineSyncInterval)
+
+	default:
+		close(fs.syncingPendingDone)
+	}
+
 	return fs, nil
 }
 
@@ -272,6 +433,17 @@ type fileSystem struct {
 	dirTypeCacheTTL        time.Duration
 	renameDirLimit         int64
 	sequentialReadSizeMb   int32
+	archiveReadPolicy      string
+	offlineMode            bool
+	offlineQueueDir        string
+	writeBack              bool
+	writeBackMaxDirtyBytes int64
+	emulateHardLinks       bool
+	mkdirMode              string
+	maxFileSize            int64
+	aclPrincipals          *ACLPrincipals
+	readOnlyPrefixes       []string
+	audit                  *auditLog
 
 	// The user and group owning everything in the file system.
 	uid uint32
@@ -281,6 +453,21 @@ type fileSystem struct {
 	fileMode os.FileMode
 	dirMode  os.FileMode
 
+	// Stops the goroutine releasing idle local content, if any.
+	stopReleasingIdleContent context.CancelFunc
+
+	// Stops the goroutine syncing queued writes, if any, which closes
+	// syncingPendingDone once it has returned. Closed from the start if there
+	// is no such goroutine.
+	stopSyncingPending context.CancelFunc
+	syncingPendingDone chan struct{}
+
+	// Used to explain the first refused link(2) in the log.
+	explainHardLinks sync.Once
+
+	// What this mount may still write.
+	quota writeQuota
+
 	/////////////////////////
 	// Mutable state
 	/////////////////////////
@@ -291,15 +478,40 @@ type fileSystem struct {
 
 	// The next inode ID to hand out. We assume that this will never overflow,
 	// since even if we were handing out inode IDs at 4 GHz, it would still take
-	// over a century to do so.
+	// over a century to do so. With 32-bit inode IDs it may, in which case it
+	// wraps around and IDs still in use are skipped. See allocateInodeID.
+	//
+	// INVARIANT: fuseops.RootInodeID < nextInodeID <= maxInodeID
 	//
 	// GUARDED_BY(mu)
 	nextInodeID fuseops.InodeID
 
+	// The largest inode ID that may be handed out.
+	//
+	// Constant.
+	maxInodeID fuseops.InodeID
+
+	// The number of times nextInodeID has wrapped around. An ID is only reused
+	// after a wraparound, so the pair of an ID and the value of this when it
+	// was handed out is never repeated. It is reported to the kernel as the
+	// inode's generation number, so that stale NFS file handles are detected.
+	//
+	// GUARDED_BY(mu)
+	inodeIDGeneration fuseops.GenerationNumber
+
+	// The generation numbers of live inodes whose IDs were handed out after a
+	// wraparound. Inodes missing from here have generation zero.
+	//
+	// INVARIANT: For all keys k, k is a key of inodes
+	// INVARIANT: For all values v, 0 < v <= inodeIDGeneration
+	//
+	// GUARDED_BY(mu)
+	inodeGenerations map[fuseops.InodeID]fuseops.GenerationNumber
+
 	// The collection of live inodes, keyed by inode ID. No ID less than
 	// fuseops.RootInodeID is ever used.
 	//
-	// INVARIANT: For all keys k, fuseops.RootInodeID <= k < nextInodeID
+	// INVARIANT: For all keys k, fuseops.RootInodeID <= k <= maxInodeID
 	// INVARIANT: For all keys k, inodes[k].ID() == k
 	// INVARIANT: inodes[fuseops.RootInodeID] is missing or of type inode.DirInode
 	// INVARIANT: For all v, if v.Name().IsDir() then v is inode.DirInode
@@ -353,6 +565,12 @@ type fileSystem struct {
 	// GUARDED_BY(mu)
 	implicitDirInodes map[inode.Name]inode.DirInode
 
+	// What prewarming saw of directories that had no inodes at the time, for
+	// their inodes to take when they are minted. See prewarmXattr.
+	//
+	// GUARDED_BY(mu)
+	warmedListings *inode.WarmedListings
+
 	// The collection of live handles, keyed by handle ID.
 	//
 	// INVARIANT: All values are of type *dirHandle or *handle.FileHandle
@@ -366,6 +584,50 @@ type fileSystem struct {
 	//
 	// GUARDED_BY(mu)
 	nextHandleID fuseops.HandleID
+
+	// The number of open file handles for each file inode. When the count for
+	// an inode drops to zero, any clean local content it holds is released.
+	//
+	// INVARIANT: For all values v, v > 0
+	//
+	// GUARDED_BY(mu)
+	fileHandleCounts map[fuseops.InodeID]int
+
+	// Dirty file inodes to be synced in the background, either because their
+	// flushes were deferred by the write-back policy or because syncing them
+	// failed while GCS was unavailable. Each holds a lookup count taken by the
+	// queue.
+	//
+	// INVARIANT: For each k/v, v.f.ID() == k
+	// INVARIANT: For each value v, inodes[v.f.ID()] == v.f
+	//
+	// GUARDED_BY(mu)
+	pendingSyncs map[fuseops.InodeID]pendingSync
+
+	// The total size of the files in pendingSyncs.
+	//
+	// INVARIANT: pendingSyncBytes is the sum of v.size over pendingSyncs
+	//
+	// GUARDED_BY(mu)
+	pendingSyncBytes int64
+
+	// File inodes that may have local modifications not yet synced to GCS, by
+	// name, so that lookups and listings can show them when GCS doesn't. Files
+	// found to be clean are removed lazily. See dirty_files.go.
+	//
+	// INVARIANT: For each k/v, v.Name() == k
+	// INVARIANT: For each value v, inodes[v.ID()] == v
+	//
+	// GUARDED_BY(mu)
+	dirtyFiles map[inode.Name]*inode.FileInode
+
+	// The IDs of file inodes whose names have since been unlinked or renamed
+	// away, which mustn't be shown as dirty files under those names.
+	//
+	// INVARIANT: For each key k, inodes[k] is of type *inode.FileInode
+	//
+	// GUARDED_BY(mu)
+	unlinkedFiles map[fuseops.InodeID]struct{}
 }
 
 ////////////////////////////////////////////////////////////////////////
@@ -377,9 +639,14 @@ func (fs *fileSystem) checkInvariants() {
 	// inodes
 	//////////////////////////////////
 
-	// INVARIANT: For all keys k, fuseops.RootInodeID <= k < nextInodeID
+	// INVARIANT: fuseops.RootInodeID < nextInodeID <= maxInodeID
+	if fs.nextInodeID <= fuseops.RootInodeID || fs.nextInodeID > fs.maxInodeID {
+		panic(fmt.Sprintf("Illegal next inode ID: %v", fs.nextInodeID))
+	}
+
+	// INVARIANT: For all keys k, fuseops.RootInodeID <= k <= maxInodeID
 	for id, _ := range fs.inodes {
-		if id < fuseops.RootInodeID || id >= fs.nextInodeID {
+		if id < fuseops.RootInodeID || id > fs.maxInodeID {
 			panic(fmt.Sprintf("Illegal inode ID: %v", id))
 		}
 	}
@@ -516,6 +783,135 @@ func (fs *fileSystem) checkInvariants() {
 			panic(fmt.Sprintf("Illegal handle ID: %v", k))
 		}
 	}
+
+	//////////////////////////////////
+	// fileHandleCounts
+	//////////////////////////////////
+
+	// INVARIANT: For all values v, v > 0
+	for id, v := range fs.fileHandleCounts {
+		if v <= 0 {
+			panic(fmt.Sprintf("Illegal handle count for inode %v: %v", id, v))
+		}
+	}
+
+	//////////////////////////////////
+	// pendingSyncs
+	//////////////////////////////////
+
+	var pendingSyncBytes int64
+	for k, v := range fs.pendingSyncs {
+		// INVARIANT: For each k/v, v.f.ID() == k
+		if v.f.ID() != k {
+			panic(fmt.Sprintf("ID mismatch: %v vs. %v", v.f.ID(), k))
+		}
+
+		// INVARIANT: For each value v, inodes[v.f.ID()] == v.f
+		if fs.inodes[v.f.ID()] != v.f {
+			panic(fmt.Sprintf("Queued inode %v is not live", k))
+		}
+
+		pendingSyncBytes += v.size
+	}
+
+	//////////////////////////////////
+	// pendingSyncBytes
+	//////////////////////////////////
+
+	// INVARIANT: pendingSyncBytes is the sum of v.size over pendingSyncs
+	if pendingSyncBytes != fs.pendingSyncBytes {
+		panic(fmt.Sprintf(
+			"Pending sync size mismatch: %v vs. %v",
+			pendingSyncBytes,
+			fs.pendingSyncBytes))
+	}
+
+	//////////////////////////////////
+	// dirtyFiles
+	//////////////////////////////////
+
+	for k, v := range fs.dirtyFiles {
+		// INVARIANT: For each k/v, v.Name() == k
+		if v.Name() != k {
+			panic(fmt.Sprintf("Name mismatch: %q vs. %q", v.Name(), k))
+		}
+
+		// INVARIANT: For each value v, inodes[v.ID()] == v
+		if fs.inodes[v.ID()] != v {
+			panic(fmt.Sprintf("Dirty inode %q is not live", k))
+		}
+	}
+
+	//////////////////////////////////
+	// unlinkedFiles
+	//////////////////////////////////
+
+	// INVARIANT: For each key k, inodes[k] is of type *inode.FileInode
+	for id := range fs.unlinkedFiles {
+		if _, ok := fs.inodes[id].(*inode.FileInode); !ok {
+			panic(fmt.Sprintf("Unlinked inode %v is not a live file", id))
+		}
+	}
+
+	//////////////////////////////////
+	// inodeGenerations
+	//////////////////////////////////
+
+	for id, gen := range fs.inodeGenerations {
+		// INVARIANT: For all keys k, k is a key of inodes
+		if _, ok := fs.inodes[id]; !ok {
+			panic(fmt.Sprintf("Generation recorded for dead inode %v", id))
+		}
+
+		// INVARIANT: For all values v, 0 < v <= inodeIDGeneration
+		if gen == 0 || gen > fs.inodeIDGeneration {
+			panic(fmt.Sprintf("Inode %v has unexpected generation %v", id, gen))
+		}
+	}
+}
+
+// Choose an ID for a new inode. Once nextInodeID passes maxInodeID it starts
+// again from the bottom, skipping IDs of inodes the kernel still knows about,
+// and IDs handed out from then on carry a new generation number. See
+// inodeGeneration.
+//
+// LOCKS_REQUIRED(fs.mu)
+func (fs *fileSystem) allocateInodeID() (id fuseops.InodeID) {
+	// Every ID other than the root's may be live, in which case none is free.
+	if uint64(len(fs.inodes)) > uint64(fs.maxInodeID-fuseops.RootInodeID) {
+		panic(fmt.Sprintf("All %d inode IDs are in use", len(fs.inodes)))
+	}
+
+	for {
+		id = fs.nextInodeID
+		_, live := fs.inodes[id]
+		if !live && fs.inodeIDGeneration != 0 {
+			fs.inodeGenerations[id] = fs.inodeIDGeneration
+		}
+
+		if id == fs.maxInodeID {
+			logger.Infof("Inode IDs up to %d used up; reusing free IDs.\n", id)
+			fs.nextInodeID = fuseops.RootInodeID + 1
+			fs.inodeIDGeneration++
+		} else {
+			fs.nextInodeID++
+		}
+
+		if !live {
+			return
+		}
+	}
+}
+
+// Return the generation number of the live inode with the given ID, which
+// tells it apart from earlier inodes that had the same ID.
+//
+// LOCKS_EXCLUDED(fs.mu)
+func (fs *fileSystem) inodeGeneration(id fuseops.InodeID) fuseops.GenerationNumber {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return fs.inodeGenerations[id]
 }
 
 // Implementation detail of lookUpOrCreateInodeIfNotStale; do not use outside
@@ -524,8 +920,7 @@ func (fs *fileSystem) checkInvariants() {
 // LOCKS_REQUIRED(fs.mu)
 func (fs *fileSystem) mintInode(ic inode.Core) (in inode.Inode) {
 	// Choose an ID.
-	id := fs.nextInodeID
-	fs.nextInodeID++
+	id := fs.allocateInodeID()
 
 	// Create the inode.
 	switch {
@@ -599,6 +994,17 @@ func (fs *fileSystem) mintInode(ic inode.Core) (in inode.Inode) {
 			fs.mtimeClock)
 	}
 
+	// Hand a new directory inode what prewarming saw of its children. Nothing
+	// else can see the inode yet, so locking it under fs.mu is safe.
+	if d, ok := in.(inode.DirInode); ok {
+		listedAt, children, ok := fs.warmedListings.Take(fs.cacheClock.Now(), d.Name())
+		if ok {
+			d.Lock()
+			d.PrimeCaches(listedAt, children)
+			d.Unlock()
+		}
+	}
+
 	// Place it in our map of IDs to inodes.
 	fs.inodes[in.ID()] = in
 
@@ -752,7 +1158,14 @@ func (fs *fileSystem) lookUpOrCreateChildInode(
 			return
 		}
 
+		// GCS may not show a file we hold local modifications to, for example
+		// if it was deleted there meanwhile. Show the local file.
 		if core == nil {
+			if f := fs.lookUpDirtyFile(inode.NewFileName(parent.Name(), childName)); f != nil {
+				child = f
+				return
+			}
+
 			err = fuse.ENOENT
 			return
 		}
@@ -762,6 +1175,12 @@ func (fs *fileSystem) lookUpOrCreateChildInode(
 		if child != nil {
 			return
 		}
+
+		// The record was stale. If it came from a listing made before this mount
+		// last wrote the object, make sure we ask GCS next time.
+		parent.Lock()
+		parent.ForgetListedChild(childName)
+		parent.Unlock()
 	}
 
 	err = fmt.Errorf("cannot find %q in %q with %v tries", childName, parent.Name(), maxTries)
@@ -794,20 +1213,46 @@ func (fs *fileSystem) lookUpOrCreateChildDirInode(
 }
 
 // Synchronize the supplied file inode to GCS, updating the index as
-// appropriate.
+// appropriate. pid is the process on whose behalf this happens, for the audit
+// log.
 //
 // LOCKS_EXCLUDED(fs.mu)
 // LOCKS_REQUIRED(f)
 func (fs *fileSystem) syncFile(
 	ctx context.Context,
-	f *inode.FileInode) (err error) {
+	f *inode.FileInode,
+	pid uint32) (err error) {
 	// Sync the inode.
+	before := f.SourceGeneration().Object
 	err = f.Sync(ctx)
+	fs.auditSync(pid, f, before)
+
+	// In offline mode, keep the dirty contents to sync again later rather than
+	// failing the write.
+	if err != nil && fs.offlineMode && gcsx.IsUnavailable(err) {
+		logger.Infof(
+			"Queueing write to %q while GCS is unavailable: %v\n",
+			f.Name().GcsObjectName(),
+			err)
+
+		var attrs fuseops.InodeAttributes
+		attrs, err = f.Attributes(ctx)
+		if err != nil {
+			err = fmt.Errorf("Attributes: %w", err)
+			return
+		}
+
+		fs.queuePendingSync(f, int64(attrs.Size), -1)
+		return
+	}
+
 	if err != nil {
 		err = fmt.Errorf("FileInode.Sync: %w", err)
 		return
 	}
 
+	fs.forgetCleanFile(f)
+
 	// We need not update fileIndex:
 	//
 	// We've held the inode lock the whole time, so there's no way that this
@@ -842,6 +1287,7 @@ func (fs *fileSystem) unlockAndDecrementLookupCount(in inode.Inode, N uint64) {
 	if shouldDestroy {
 		fs.mu.Lock()
 		delete(fs.inodes, in.ID())
+		delete(fs.inodeGenerations, in.ID())
 
 		// Update indexes if necessary.
 		if fs.generationBackedInodes[name] == in {
@@ -850,6 +1296,10 @@ func (fs *fileSystem) unlockAndDecrementLookupCount(in inode.Inode, N uint64) {
 		if fs.implicitDirInodes[name] == in {
 			delete(fs.implicitDirInodes, name)
 		}
+		if fs.dirtyFiles[name] == in {
+			delete(fs.dirtyFiles, name)
+		}
+		delete(fs.unlinkedFiles, in.ID())
 		fs.mu.Unlock()
 	}
 
@@ -984,7 +1434,34 @@ func (fs *fileSystem) symlinkInodeOrDie(
 ////////////////////////////////////////////////////////////////////////
 
 func (fs *fileSystem) Destroy() {
+	fs.stopReleasingIdleContent()
+	fs.stopSyncingPending()
+	<-fs.syncingPendingDone
+
+	// Make a last attempt at uploading queued writes, saving any that remain
+	// for the next mount.
+	if n := fs.syncPendingOnce(context.Background()); n > 0 {
+		var saved int
+		if fs.offlineQueueDir != "" {
+			var err error
+			saved, err = fs.saveOfflineQueue(context.Background())
+			if err != nil {
+				logger.Infof("saveOfflineQueue: %v\n", err)
+			} else if saved > 0 {
+				logger.Infof(
+					"Saved queued writes for %d files to %q.\n",
+					saved,
+					fs.offlineQueueDir)
+			}
+		}
+
+		if saved < n {
+			logger.Infof("Discarding queued writes for %d files.\n", n-saved)
+		}
+	}
+
 	fs.bucketManager.ShutDown()
+	fs.audit.Close()
 }
 
 func (fs *fileSystem) StatFS(
@@ -1018,8 +1495,13 @@ func (fs *fileSystem) LookUpInode(
 	parent := fs.dirInodeOrDie(op.Parent)
 	fs.mu.Unlock()
 
-	// Find or create the child inode.
+	// Find or create the child inode, falling back to a name pinned to a
+	// generation.
 	child, err := fs.lookUpOrCreateChildInode(ctx, parent, op.Name)
+	if errors.Is(err, fuse.ENOENT) {
+		child, err = fs.lookUpPinnedChildInode(ctx, parent, op.Name)
+	}
+
 	if err != nil {
 		return err
 	}
@@ -1029,6 +1511,7 @@ func (fs *fileSystem) LookUpInode(
 	// Fill out the response.
 	e := &op.Entry
 	e.Child = child.ID()
+	e.Generation = fs.inodeGeneration(child.ID())
 	e.Attributes, e.AttributesExpiration, err = fs.getAttributes(ctx, child)
 
 	if err != nil {
@@ -1066,31 +1549,77 @@ func (fs *fileSystem) SetInodeAttributes(
 	// Find the inode.
 	fs.mu.Lock()
 	in := fs.inodeOrDie(op.Inode)
+	isOpen := fs.fileHandleCounts[op.Inode] != 0
 	fs.mu.Unlock()
 
 	in.Lock()
 	defer in.Unlock()
 	file, isFile := in.(*inode.FileInode)
 
-	// Set file mtimes.
-	if isFile && op.Mtime != nil {
-		err = file.SetMtime(ctx, *op.Mtime)
+	// Refuse changes to protected files up front.
+	if isFile && (op.Size != nil || op.Atime != nil || op.Mtime != nil) {
+		if err = fs.checkFileWritable(file); err != nil {
+			return err
+		}
+	}
+
+	// Set file atimes and mtimes.
+	if isFile {
+		err = file.SetTimes(ctx, op.Atime, op.Mtime)
 		if err != nil {
-			err = fmt.Errorf("SetMtime: %w", err)
+			err = fmt.Errorf("SetTimes: %w", err)
 			return err
 		}
 	}
 
 	// Truncate files.
 	if isFile && op.Size != nil {
-		err = file.Truncate(ctx, int64(*op.Size))
-		if err != nil {
-			err = fmt.Errorf("Truncate: %w", err)
+		if err = fs.checkFileSize(int64(*op.Size)); err != nil {
 			return err
 		}
+
+		// Emptying a file that nothing will write to next, as with truncate(1),
+		// needs no local copy.
+		if *op.Size == 0 && !isOpen {
+			before := file.SourceGeneration().Object
+			err = file.Clear(ctx)
+			fs.auditSync(op.OpContext.Pid, file, before)
+			if err != nil {
+				err = fmt.Errorf("Clear: %w", err)
+				return err
+			}
+
+			fs.forgetCleanFile(file)
+		} else {
+			// Growing a file counts towards the bytes written.
+			var attrs fuseops.InodeAttributes
+			attrs, err = file.Attributes(ctx)
+			if err != nil {
+				err = fmt.Errorf("Attributes: %w", err)
+				return err
+			}
+
+			var growth int64
+			if *op.Size > attrs.Size {
+				growth = int64(*op.Size - attrs.Size)
+			}
+
+			if err = fs.quota.chargeBytes(growth); err != nil {
+				return err
+			}
+
+			err = file.Truncate(ctx, int64(*op.Size))
+			if err != nil {
+				fs.quota.refundBytes(growth)
+				err = fmt.Errorf("Truncate: %w", err)
+				return err
+			}
+
+			fs.noteDirtyFile(file)
+		}
 	}
 
-	// We silently ignore updates to mode and atime.
+	// We silently ignore updates to mode.
 
 	// Fill in the response.
 	op.Attributes, op.AttributesExpiration, err = fs.getAttributes(ctx, in)
@@ -1127,12 +1656,38 @@ func (fs *fileSystem) MkDir(
 	parent := fs.dirInodeOrDie(op.Parent)
 	fs.mu.Unlock()
 
+	if err = fs.checkChildWritable(parent, op.Name, true); err != nil {
+		return err
+	}
+
+	if err = fs.materializeDeferredDir(ctx, parent); err != nil {
+		return err
+	}
+
+	// Unless the placeholder is to be created immediately, nothing is created
+	// in GCS yet, and the child is just recorded in the parent.
+	local := fs.mkdirMode == MkdirModeLocal || fs.mkdirMode == MkdirModeDeferred
+	if !local {
+		if err = fs.quota.chargeObject(); err != nil {
+			return err
+		}
+	}
+
 	// Create an empty backing object for the child, failing if it already
 	// exists.
+	var result *inode.Core
 	parent.Lock()
-	result, err := parent.CreateChildDir(ctx, op.Name)
+	if local {
+		result, err = parent.CreateLocalChildDir(ctx, op.Name)
+	} else {
+		result, err = parent.CreateChildDir(ctx, op.Name)
+	}
 	parent.Unlock()
 
+	if err != nil && !local {
+		fs.quota.refundObject()
+	}
+
 	// Special case: *gcs.PreconditionError means the name already exists.
 	var preconditionErr *gcs.PreconditionError
 	if errors.As(err, &preconditionErr) {
@@ -1146,6 +1701,13 @@ func (fs *fileSystem) MkDir(
 		return err
 	}
 
+	var generation int64
+	if result.Object != nil {
+		generation = result.Object.Generation
+	}
+
+	fs.audit.recordChange("mkdir", op.OpContext.Pid, result.FullName, 0, generation)
+
 	// Attempt to create a child inode using the object we created. If we fail to
 	// do so, it means someone beat us to the punch with a newer generation
 	// (unlikely, so we're probably okay with failing here).
@@ -1160,6 +1722,7 @@ func (fs *fileSystem) MkDir(
 	// Fill out the response.
 	e := &op.Entry
 	e.Child = child.ID()
+	e.Generation = fs.inodeGeneration(child.ID())
 	e.Attributes, e.AttributesExpiration, err = fs.getAttributes(ctx, child)
 
 	if err != nil {
@@ -1179,7 +1742,7 @@ func (fs *fileSystem) MkNode(
 	}
 
 	// Create the child.
-	child, err := fs.createFile(ctx, op.Parent, op.Name, op.Mode)
+	child, err := fs.createFile(ctx, op.Parent, op.Name, op.Mode, op.OpContext.Pid)
 	if err != nil {
 		return err
 	}
@@ -1189,6 +1752,7 @@ func (fs *fileSystem) MkNode(
 	// Fill out the response.
 	e := &op.Entry
 	e.Child = child.ID()
+	e.Generation = fs.inodeGeneration(child.ID())
 	e.Attributes, e.AttributesExpiration, err = fs.getAttributes(ctx, child)
 
 	if err != nil {
@@ -1208,18 +1772,51 @@ func (fs *fileSystem) createFile(
 	ctx context.Context,
 	parentID fuseops.InodeID,
 	name string,
-	mode os.FileMode) (child inode.Inode, err error) {
+	mode os.FileMode,
+	pid uint32) (child inode.Inode, err error) {
 	// Find the parent.
 	fs.mu.Lock()
 	parent := fs.dirInodeOrDie(parentID)
 	fs.mu.Unlock()
 
+	if err = fs.checkChildWritable(parent, name, false); err != nil {
+		return
+	}
+
+	if err = fs.materializeDeferredDir(ctx, parent); err != nil {
+		return
+	}
+
+	if err = fs.quota.chargeObject(); err != nil {
+		return
+	}
+
 	// Create an empty backing object for the child, failing if it already
 	// exists.
 	parent.Lock()
 	result, err := parent.CreateChildFile(ctx, name)
 	parent.Unlock()
 
+	// In offline mode, create the file locally and queue its object to be
+	// created later rather than failing.
+	if err != nil && fs.offlineMode && gcsx.IsUnavailable(err) {
+		logger.Infof(
+			"Queueing create of %q while GCS is unavailable: %v\n",
+			inode.NewFileName(parent.Name(), name).GcsObjectName(),
+			err)
+
+		child, err = fs.createUnbornFile(ctx, parent, name)
+		if err != nil {
+			fs.quota.refundObject()
+		}
+
+		return
+	}
+
+	if err != nil {
+		fs.quota.refundObject()
+	}
+
 	// Special case: *gcs.PreconditionError means the name already exists.
 	var preconditionErr *gcs.PreconditionError
 	if errors.As(err, &preconditionErr) {
@@ -1233,6 +1830,8 @@ func (fs *fileSystem) createFile(
 		return
 	}
 
+	fs.audit.recordChange("create", pid, result.FullName, 0, result.Object.Generation)
+
 	// Attempt to create a child inode using the object we created. If we fail to
 	// do so, it means someone beat us to the punch with a newer generation
 	// (unlikely, so we're probably okay with failing here).
@@ -1250,7 +1849,7 @@ func (fs *fileSystem) CreateFile(
 	ctx context.Context,
 	op *fuseops.CreateFileOp) (err error) {
 	// Create the child.
-	child, err := fs.createFile(ctx, op.Parent, op.Name, op.Mode)
+	child, err := fs.createFile(ctx, op.Parent, op.Name, op.Mode, op.OpContext.Pid)
 	if err != nil {
 		return err
 	}
@@ -1264,6 +1863,7 @@ func (fs *fileSystem) CreateFile(
 	fs.nextHandleID++
 
 	fs.handles[handleID] = handle.NewFileHandle(child.(*inode.FileInode))
+	fs.fileHandleCounts[child.ID()]++
 	op.Handle = handleID
 
 	fs.mu.Unlock()
@@ -1271,6 +1871,7 @@ func (fs *fileSystem) CreateFile(
 	// Fill out the response.
 	e := &op.Entry
 	e.Child = child.ID()
+	e.Generation = fs.inodeGeneration(child.ID())
 	e.Attributes, e.AttributesExpiration, err = fs.getAttributes(ctx, child)
 
 	if err != nil {
@@ -1290,11 +1891,27 @@ func (fs *fileSystem) CreateSymlink(
 	parent := fs.dirInodeOrDie(op.Parent)
 	fs.mu.Unlock()
 
+	if err = fs.checkChildWritable(parent, op.Name, false); err != nil {
+		return err
+	}
+
+	if err = fs.materializeDeferredDir(ctx, parent); err != nil {
+		return err
+	}
+
+	if err = fs.quota.chargeObject(); err != nil {
+		return err
+	}
+
 	// Create the object in GCS, failing if it already exists.
 	parent.Lock()
 	result, err := parent.CreateChildSymlink(ctx, op.Name, op.Target)
 	parent.Unlock()
 
+	if err != nil {
+		fs.quota.refundObject()
+	}
+
 	// Special case: *gcs.PreconditionError means the name already exists.
 	var preconditionErr *gcs.PreconditionError
 	if errors.As(err, &preconditionErr) {
@@ -1308,6 +1925,8 @@ func (fs *fileSystem) CreateSymlink(
 		return err
 	}
 
+	fs.audit.recordChange("symlink", op.OpContext.Pid, result.FullName, 0, result.Object.Generation)
+
 	// Attempt to create a child inode using the object we created. If we fail to
 	// do so, it means someone beat us to the punch with a newer generation
 	// (unlikely, so we're probably okay with failing here).
@@ -1322,6 +1941,7 @@ func (fs *fileSystem) CreateSymlink(
 	// Fill out the response.
 	e := &op.Entry
 	e.Child = child.ID()
+	e.Generation = fs.inodeGeneration(child.ID())
 	e.Attributes, e.AttributesExpiration, err = fs.getAttributes(ctx, child)
 
 	if err != nil {
@@ -1333,38 +1953,150 @@ func (fs *fileSystem) CreateSymlink(
 }
 
 // LOCKS_EXCLUDED(fs.mu)
-func (fs *fileSystem) RmDir(
+func (fs *fileSystem) CreateLink(
 	ctx context.Context,
-	op *fuseops.RmDirOp) (err error) {
-	// Find the parent.
+	op *fuseops.CreateLinkOp) (err error) {
+	if !fs.emulateHardLinks {
+		fs.explainHardLinks.Do(func() {
+			logger.Infof(
+				"GCS has no hard links, so link(2) fails with ENOTSUP. Mount with " +
+					"--emulate-hard-links to emulate them by copying objects.\n")
+		})
+
+		err = fmt.Errorf("hard links: %w", syscall.ENOTSUP)
+		return
+	}
+
+	// Find the parent and the target.
 	fs.mu.Lock()
 	parent := fs.dirInodeOrDie(op.Parent)
+	target := fs.inodeOrDie(op.Target)
 	fs.mu.Unlock()
 
-	// Find or create the child inode, locked.
-	child, err := fs.lookUpOrCreateChildInode(ctx, parent, op.Name)
-	if err != nil {
+	file, ok := target.(*inode.FileInode)
+	if !ok {
+		if _, isDir := target.(inode.DirInode); isDir {
+			err = fmt.Errorf("hard link to a directory: %w", syscall.EPERM)
+			return
+		}
+
+		err = fmt.Errorf("hard link to a non-file: %w", syscall.ENOTSUP)
 		return
 	}
 
-	// Set up a function that throws away the lookup count increment that we
-	// implicitly did above (since we're not handing the child back to the
-	// kernel) and unlocks the child, but only once. Ensure it is called at least
-	// once in case we exit early.
-	childCleanedUp := false
-	cleanUpAndUnlockChild := func() {
-		if !childCleanedUp {
-			childCleanedUp = true
-			fs.unlockAndDecrementLookupCount(child, 1)
-		}
+	// The copy must be made within the target's bucket.
+	if p, ok := parent.(inode.BucketOwnedInode); !ok ||
+		p.Bucket().Name() != file.Bucket().Name() {
+		err = fmt.Errorf("hard link across buckets: %w", syscall.EXDEV)
+		return
 	}
 
-	defer cleanUpAndUnlockChild()
+	if err = fs.checkChildWritable(parent, op.Name, false); err != nil {
+		return
+	}
 
-	// Is the child a directory?
-	childDir, ok := child.(inode.DirInode)
-	if !ok {
-		err = fuse.ENOTDIR
+	if err = fs.materializeDeferredDir(ctx, parent); err != nil {
+		return
+	}
+
+	if err = fs.quota.chargeObject(); err != nil {
+		return
+	}
+
+	// Make sure the copy includes everything written so far.
+	file.Lock()
+	err = fs.syncFile(ctx, file, op.OpContext.Pid)
+	src := file.Source()
+	file.Unlock()
+
+	if err != nil {
+		fs.quota.refundObject()
+		return
+	}
+
+	// Copy the object to the new name, failing if it already exists.
+	parent.Lock()
+	existing, err := parent.LookUpChild(ctx, op.Name)
+	if err == nil && existing != nil {
+		err = fuse.EEXIST
+	}
+
+	var result *inode.Core
+	if err == nil {
+		result, err = parent.CloneToChildFile(ctx, op.Name, src)
+	}
+	parent.Unlock()
+
+	if err != nil {
+		fs.quota.refundObject()
+		err = fmt.Errorf("CloneToChildFile: %w", err)
+		return
+	}
+
+	fs.audit.recordChange("link", op.OpContext.Pid, result.FullName, 0, result.Object.Generation)
+
+	// Attempt to create a child inode using the object we created. If we fail to
+	// do so, it means someone beat us to the punch with a newer generation
+	// (unlikely, so we're probably okay with failing here).
+	child := fs.lookUpOrCreateInodeIfNotStale(*result)
+	if child == nil {
+		err = fmt.Errorf("Newly-created record is already stale")
+		return
+	}
+
+	defer fs.unlockAndMaybeDisposeOfInode(child, &err)
+
+	// Fill out the response.
+	e := &op.Entry
+	e.Child = child.ID()
+	e.Generation = fs.inodeGeneration(child.ID())
+	e.Attributes, e.AttributesExpiration, err = fs.getAttributes(ctx, child)
+
+	if err != nil {
+		err = fmt.Errorf("getAttributes: %w", err)
+		return
+	}
+
+	return
+}
+
+// LOCKS_EXCLUDED(fs.mu)
+func (fs *fileSystem) RmDir(
+	ctx context.Context,
+	op *fuseops.RmDirOp) (err error) {
+	// Find the parent.
+	fs.mu.Lock()
+	parent := fs.dirInodeOrDie(op.Parent)
+	fs.mu.Unlock()
+
+	if err = fs.checkChildWritable(parent, op.Name, true); err != nil {
+		return
+	}
+
+	// Find or create the child inode, locked.
+	child, err := fs.lookUpOrCreateChildInode(ctx, parent, op.Name)
+	if err != nil {
+		return
+	}
+
+	// Set up a function that throws away the lookup count increment that we
+	// implicitly did above (since we're not handing the child back to the
+	// kernel) and unlocks the child, but only once. Ensure it is called at least
+	// once in case we exit early.
+	childCleanedUp := false
+	cleanUpAndUnlockChild := func() {
+		if !childCleanedUp {
+			childCleanedUp = true
+			fs.unlockAndDecrementLookupCount(child, 1)
+		}
+	}
+
+	defer cleanUpAndUnlockChild()
+
+	// Is the child a directory?
+	childDir, ok := child.(inode.DirInode)
+	if !ok {
+		err = fuse.ENOTDIR
 		return
 	}
 
@@ -1375,32 +2107,33 @@ func (fs *fileSystem) RmDir(
 	//     https://github.com/GoogleCloudPlatform/gcsfuse/issues/9
 	//
 	//
-	var tok string
-	for {
-		var entries []fuseutil.Dirent
-		entries, tok, err = childDir.ReadEntries(ctx, tok)
-		if err != nil {
-			err = fmt.Errorf("ReadEntries: %w", err)
-			return err
-		}
+	// Files with local modifications count even if GCS doesn't show them yet.
+	if len(fs.dirtyChildNames(childDir.Name())) != 0 {
+		err = fuse.ENOTEMPTY
+		return
+	}
 
-		// Are there any entries?
-		if len(entries) != 0 {
-			err = fuse.ENOTEMPTY
-			return
-		}
+	empty, err := childDir.IsEmpty(ctx)
+	if err != nil {
+		err = fmt.Errorf("IsEmpty: %w", err)
+		return err
+	}
 
-		// Are we done listing?
-		if tok == "" {
-			break
-		}
+	if !empty {
+		err = fuse.ENOTEMPTY
+		return
 	}
 
 	// We are done with the child.
 	cleanUpAndUnlockChild()
 
+	fs.mu.Lock()
+	fs.warmedListings.EraseTree(childDir.Name())
+	fs.mu.Unlock()
+
 	// Delete the backing object.
 	parent.Lock()
+	before := fs.auditGeneration(ctx, parent, op.Name)
 	err = parent.DeleteChildDir(ctx, op.Name)
 	parent.Unlock()
 
@@ -1409,6 +2142,13 @@ func (fs *fileSystem) RmDir(
 		return err
 	}
 
+	fs.audit.recordChange(
+		"rmdir",
+		op.OpContext.Pid,
+		inode.NewDirName(parent.Name(), op.Name),
+		before,
+		0)
+
 	return
 }
 
@@ -1435,6 +2175,14 @@ func (fs *fileSystem) Rename(
 		}
 	}
 
+	// Renames copy objects in GCS, so upload any writes still queued for the
+	// name (or names beneath it) first.
+	err = fs.flushPendingSyncs(ctx, oldParent.Name().GcsObjectName()+op.OldName)
+	if err != nil {
+		err = fmt.Errorf("flushPendingSyncs: %w", err)
+		return err
+	}
+
 	// Find the object in the old location.
 	oldParent.Lock()
 	child, err := oldParent.LookUpChild(ctx, op.OldName)
@@ -1450,10 +2198,35 @@ func (fs *fileSystem) Rename(
 		return err
 	}
 
+	// Neither the old nor the new name may be protected, nor may a protected
+	// prefix be moved away along with a directory.
+	if err = fs.checkTreeWritable(child.FullName); err != nil {
+		return err
+	}
+
+	err = fs.checkChildWritable(newParent, op.NewName, child.FullName.IsDir())
+	if err != nil {
+		return err
+	}
+
+	if err = fs.materializeDeferredDir(ctx, newParent); err != nil {
+		return err
+	}
+
 	if child.FullName.IsDir() {
-		return fs.renameDir(ctx, oldParent, op.OldName, newParent, op.NewName)
+		err = fs.renameDir(ctx, oldParent, op.OldName, newParent, op.NewName)
+		if err == nil {
+			fs.audit.record(auditRecord{
+				Op:      "rename",
+				Pid:     op.OpContext.Pid,
+				Path:    child.FullName.LocalName(),
+				NewPath: inode.NewDirName(newParent.Name(), op.NewName).LocalName(),
+			})
+		}
+
+		return err
 	}
-	return fs.renameFile(ctx, oldParent, op.OldName, child.Object, newParent, op.NewName)
+	return fs.renameFile(ctx, oldParent, op.OldName, child.Object, newParent, op.NewName, op.OpContext.Pid)
 }
 
 // LOCKS_EXCLUDED(fs.mu)
@@ -1465,10 +2238,11 @@ func (fs *fileSystem) renameFile(
 	oldName string,
 	oldObject *gcs.Object,
 	newParent inode.DirInode,
-	newFileName string) error {
+	newFileName string,
+	pid uint32) error {
 	// Clone into the new location.
 	newParent.Lock()
-	_, err := newParent.CloneToChildFile(ctx, newFileName, oldObject)
+	result, err := newParent.CloneToChildFile(ctx, newFileName, oldObject)
 	newParent.Unlock()
 
 	if err != nil {
@@ -1491,6 +2265,17 @@ func (fs *fileSystem) renameFile(
 		return err
 	}
 
+	fs.forgetDirtyFiles(inode.NewFileName(oldParent.Name(), oldName))
+
+	fs.audit.record(auditRecord{
+		Op:               "rename",
+		Pid:              pid,
+		Path:             inode.NewFileName(oldParent.Name(), oldName).LocalName(),
+		NewPath:          result.FullName.LocalName(),
+		GenerationBefore: oldObject.Generation,
+		GenerationAfter:  result.Object.Generation,
+	})
+
 	return nil
 }
 
@@ -1526,6 +2311,11 @@ func (fs *fileSystem) renameDir(
 	}
 	pendingInodes = append(pendingInodes, oldDir)
 
+	// What prewarming saw beneath the old directory won't be true for long.
+	fs.mu.Lock()
+	fs.warmedListings.EraseTree(oldDir.Name())
+	fs.mu.Unlock()
+
 	// Fetch all the descendants of the old directory recursively
 	descendants, err := oldDir.ReadDescendants(ctx, int(fs.renameDirLimit+1))
 	if err != nil {
@@ -1565,8 +2355,9 @@ func (fs *fileSystem) renameDir(
 		return fuse.ENOTEMPTY
 	}
 
-	// Move all the files from the old directory to the new directory, keeping
-	// both directories locked.
+	// Work out the name of each file relative to the old directory.
+	var nameDiffs []string
+	var objects []*gcs.Object
 	for _, descendant := range descendants {
 		nameDiff := strings.TrimPrefix(
 			descendant.FullName.GcsObjectName(), oldDir.Name().GcsObjectName())
@@ -1574,15 +2365,51 @@ func (fs *fileSystem) renameDir(
 			return fmt.Errorf("unwanted descendant %q not from dir %q", descendant.FullName, oldDir.Name())
 		}
 
-		o := descendant.Object
-		if _, err := newDir.CloneToChildFile(ctx, nameDiff, o); err != nil {
+		nameDiffs = append(nameDiffs, nameDiff)
+		objects = append(objects, descendant.Object)
+	}
+
+	// Record the plan before moving more than one file, so that a rename
+	// interrupted part way through can be finished by the next mount.
+	bucket := oldDir.(inode.BucketOwnedInode).Bucket()
+	var manifest string
+	if len(descendants) > 1 {
+		m := &gcsx.RenameManifest{
+			OldDir: oldDir.Name().GcsObjectName(),
+			NewDir: newDir.Name().GcsObjectName(),
+		}
+		for i, o := range objects {
+			m.Objects = append(m.Objects, gcsx.RenamedObject{
+				Name:           nameDiffs[i],
+				Generation:     o.Generation,
+				MetaGeneration: o.MetaGeneration,
+			})
+		}
+
+		if manifest, err = gcsx.WriteRenameManifest(ctx, bucket, m); err != nil {
+			return fmt.Errorf("WriteRenameManifest: %w", err)
+		}
+
+		// Keep other mounts from taking the rename over while we work on it.
+		defer gcsx.KeepRenameManifestAlive(bucket, manifest)()
+	}
+
+	// Copy all the files from the old directory to the new directory, and only
+	// then delete them from the old one, keeping both directories locked.
+	for i, o := range objects {
+		if _, err := newDir.CloneToChildFile(ctx, nameDiffs[i], o); err != nil {
 			return fmt.Errorf("copy file %q: %w", o.Name, err)
 		}
-		if err := oldDir.DeleteChildFile(ctx, nameDiff, o.Generation, &o.MetaGeneration); err != nil {
+	}
+
+	for i, o := range objects {
+		if err := oldDir.DeleteChildFile(ctx, nameDiffs[i], o.Generation, &o.MetaGeneration); err != nil {
 			return fmt.Errorf("delete file %q: %w", o.Name, err)
 		}
 	}
 
+	fs.forgetDirtyFiles(oldDir.Name())
+
 	// We are done with both directories.
 	releaseInodes()
 
@@ -1594,6 +2421,12 @@ func (fs *fileSystem) renameDir(
 		return fmt.Errorf("DeleteChildDir: %w", err)
 	}
 
+	if manifest != "" {
+		if err = gcsx.DeleteRenameManifest(ctx, bucket, manifest); err != nil {
+			return fmt.Errorf("DeleteRenameManifest: %w", err)
+		}
+	}
+
 	return nil
 }
 
@@ -1606,9 +2439,15 @@ func (fs *fileSystem) Unlink(
 	parent := fs.dirInodeOrDie(op.Parent)
 	fs.mu.Unlock()
 
+	if err = fs.checkChildWritable(parent, op.Name, false); err != nil {
+		return
+	}
+
 	parent.Lock()
 	defer parent.Unlock()
 
+	before := fs.auditGeneration(ctx, parent, op.Name)
+
 	// Delete the backing object.
 	err = parent.DeleteChildFile(
 		ctx,
@@ -1621,6 +2460,15 @@ func (fs *fileSystem) Unlink(
 		return err
 	}
 
+	fs.forgetDirtyFiles(inode.NewFileName(parent.Name(), op.Name))
+
+	fs.audit.recordChange(
+		"unlink",
+		op.OpContext.Pid,
+		inode.NewFileName(parent.Name(), op.Name),
+		before,
+		0)
+
 	return
 }
 
@@ -1640,7 +2488,7 @@ func (fs *fileSystem) OpenDir(
 	handleID := fs.nextHandleID
 	fs.nextHandleID++
 
-	fs.handles[handleID] = newDirHandle(in, fs.implicitDirs)
+	fs.handles[handleID] = newDirHandle(in, fs.implicitDirs, fs.dirtyChildNames)
 	op.Handle = handleID
 
 	return
@@ -1671,13 +2519,18 @@ func (fs *fileSystem) ReleaseDirHandle(
 	ctx context.Context,
 	op *fuseops.ReleaseDirHandleOp) (err error) {
 	fs.mu.Lock()
-	defer fs.mu.Unlock()
 
 	// Sanity check that this handle exists and is of the correct type.
-	_ = fs.handles[op.Handle].(*dirHandle)
+	dh := fs.handles[op.Handle].(*dirHandle)
 
 	// Clear the entry from the map.
 	delete(fs.handles, op.Handle)
+	fs.mu.Unlock()
+
+	// Stop any listing still running in the background.
+	dh.Mu.Lock()
+	dh.Destroy()
+	dh.Mu.Unlock()
 
 	return
 }
@@ -1692,11 +2545,19 @@ func (fs *fileSystem) OpenFile(
 	// Find the inode.
 	in := fs.fileInodeOrDie(op.Inode)
 
+	// Protected files may only be opened for reading.
+	if !op.OpenFlags.IsReadOnly() {
+		if err = fs.checkFileWritable(in); err != nil {
+			return
+		}
+	}
+
 	// Allocate a handle.
 	handleID := fs.nextHandleID
 	fs.nextHandleID++
 
 	fs.handles[handleID] = handle.NewFileHandle(in)
+	fs.fileHandleCounts[in.ID()]++
 	op.Handle = handleID
 
 	// When we observe object generations that we didn't create, we assign them
@@ -1720,6 +2581,12 @@ func (fs *fileSystem) ReadFile(
 	fh.Lock()
 	defer fh.Unlock()
 
+	// Guard against retrieval fees for archived objects.
+	err = fs.checkArchiveRead(fh)
+	if err != nil {
+		return
+	}
+
 	// Serve the read.
 	op.BytesRead, err = fh.Read(ctx, op.Dst, op.Offset, fs.sequentialReadSizeMb)
 
@@ -1749,6 +2616,21 @@ func (fs *fileSystem) ReadSymlink(
 	return
 }
 
+// Fail with EFBIG if a file of the given size would exceed the limit, before
+// anything is written locally.
+func (fs *fileSystem) checkFileSize(size int64) (err error) {
+	if fs.maxFileSize == 0 || size <= fs.maxFileSize {
+		return
+	}
+
+	err = fmt.Errorf(
+		"file size %d exceeds the limit of %d: %w",
+		size,
+		fs.maxFileSize,
+		syscall.EFBIG)
+	return
+}
+
 // LOCKS_EXCLUDED(fs.mu)
 func (fs *fileSystem) WriteFile(
 	ctx context.Context,
@@ -1758,14 +2640,28 @@ func (fs *fileSystem) WriteFile(
 	in := fs.fileInodeOrDie(op.Inode)
 	fs.mu.Unlock()
 
+	if err = fs.checkFileWritable(in); err != nil {
+		return
+	}
+
+	if err = fs.checkFileSize(op.Offset + int64(len(op.Data))); err != nil {
+		return
+	}
+
+	if err = fs.quota.chargeBytes(int64(len(op.Data))); err != nil {
+		return
+	}
+
 	in.Lock()
 	defer in.Unlock()
 
 	// Serve the request.
 	if err := in.Write(ctx, op.Data, op.Offset); err != nil {
+		fs.quota.refundBytes(int64(len(op.Data)))
 		return err
 	}
 
+	fs.noteDirtyFile(in)
 	return
 }
 
@@ -1788,7 +2684,7 @@ func (fs *fileSystem) SyncFile(
 	defer file.Unlock()
 
 	// Sync it.
-	if err := fs.syncFile(ctx, file); err != nil {
+	if err := fs.syncFile(ctx, file, op.OpContext.Pid); err != nil {
 		return err
 	}
 
@@ -1807,8 +2703,23 @@ func (fs *fileSystem) FlushFile(
 	in.Lock()
 	defer in.Unlock()
 
+	// With write-back, leave dirty contents to be uploaded in the background
+	// unless too much is already waiting. fsync still syncs immediately.
+	if fs.writeBack && !in.SourceGenerationIsAuthoritative() {
+		var attrs fuseops.InodeAttributes
+		attrs, err = in.Attributes(ctx)
+		if err != nil {
+			err = fmt.Errorf("Attributes: %w", err)
+			return
+		}
+
+		if fs.queuePendingSync(in, int64(attrs.Size), fs.writeBackMaxDirtyBytes) {
+			return
+		}
+	}
+
 	// Sync it.
-	if err := fs.syncFile(ctx, in); err != nil {
+	if err := fs.syncFile(ctx, in, op.OpContext.Pid); err != nil {
 		return err
 	}
 
@@ -1820,25 +2731,223 @@ func (fs *fileSystem) ReleaseFileHandle(
 	ctx context.Context,
 	op *fuseops.ReleaseFileHandleOp) (err error) {
 	fs.mu.Lock()
-	defer fs.mu.Unlock()
 
 	// Destroy the handle.
-	fs.handles[op.Handle].(*handle.FileHandle).Destroy()
+	fh := fs.handles[op.Handle].(*handle.FileHandle)
+	fh.Destroy()
 
-	// Update the map.
+	// Update the maps.
 	delete(fs.handles, op.Handle)
 
+	in := fh.Inode()
+	fs.fileHandleCounts[in.ID()]--
+	lastHandle := fs.fileHandleCounts[in.ID()] == 0
+	if lastHandle {
+		delete(fs.fileHandleCounts, in.ID())
+	}
+
+	fs.mu.Unlock()
+
+	// If that was the last handle, don't keep a clean local copy of the
+	// contents around. A racing open will simply fetch it again.
+	if lastHandle {
+		in.Lock()
+		in.ReleaseCleanContent()
+		in.Unlock()
+	}
+
 	return
 }
 
+// LOCKS_EXCLUDED(fs.mu)
 func (fs *fileSystem) GetXattr(
 	ctx context.Context,
 	op *fuseops.GetXattrOp) (err error) {
+	// Find the inode.
+	fs.mu.Lock()
+	in := fs.inodeOrDie(op.Inode)
+	fs.mu.Unlock()
+
+	if fs.aclPrincipals != nil && isACLXattr(op.Name) {
+		f, ok := in.(*inode.FileInode)
+		if !ok {
+			return syscall.ENODATA
+		}
+
+		var value []byte
+		f.Lock()
+		value, err = fs.getACLXattr(ctx, f, op.Name)
+		f.Unlock()
+
+		if err != nil {
+			return
+		}
+
+		op.BytesRead, err = copyXattr(op.Dst, value)
+		return
+	}
+
+	in.Lock()
+	o := xattrSource(in)
+	in.Unlock()
+
+	if o == nil {
+		return syscall.ENODATA
+	}
+
+	for _, x := range objectXattrs {
+		if x.name == op.Name {
+			op.BytesRead, err = copyXattr(op.Dst, x.value(o))
+			return
+		}
+	}
+
+	if s, ok := o.Metadata[userXattrMetadataPrefix+op.Name]; ok && isUserXattr(op.Name) {
+		var value []byte
+		if value, err = decodeUserXattr(s); err != nil {
+			return
+		}
+
+		op.BytesRead, err = copyXattr(op.Dst, value)
+		return
+	}
+
 	return syscall.ENODATA
 }
 
+// LOCKS_EXCLUDED(fs.mu)
 func (fs *fileSystem) ListXattr(
 	ctx context.Context,
-	op *fuseops.ListXattrOp) error {
-	return syscall.ENODATA
+	op *fuseops.ListXattrOp) (err error) {
+	// Find the inode.
+	fs.mu.Lock()
+	in := fs.inodeOrDie(op.Inode)
+	fs.mu.Unlock()
+
+	in.Lock()
+	o := xattrSource(in)
+	in.Unlock()
+
+	if o == nil {
+		return syscall.ENODATA
+	}
+
+	var names []byte
+	for _, x := range objectXattrs {
+		names = append(names, x.name...)
+		names = append(names, 0)
+	}
+
+	if fs.aclPrincipals != nil {
+		for _, name := range []string{posixACLAccessXattr, textACLXattr} {
+			names = append(names, name...)
+			names = append(names, 0)
+		}
+	}
+
+	for _, name := range userXattrNames(o) {
+		names = append(names, name...)
+		names = append(names, 0)
+	}
+
+	op.BytesRead, err = copyXattr(op.Dst, names)
+	return
+}
+
+// LOCKS_EXCLUDED(fs.mu)
+func (fs *fileSystem) SetXattr(
+	ctx context.Context,
+	op *fuseops.SetXattrOp) (err error) {
+	// Find the inode.
+	fs.mu.Lock()
+	in := fs.inodeOrDie(op.Inode)
+	fs.mu.Unlock()
+
+	switch {
+	case op.Name == prewarmXattr:
+		err = fs.setPrewarmXattr(ctx, in, op.Value)
+
+	case fs.aclPrincipals != nil && isACLXattr(op.Name):
+		f, ok := in.(*inode.FileInode)
+		if !ok {
+			return syscall.ENOTSUP
+		}
+
+		if err = fs.checkFileWritable(f); err != nil {
+			return
+		}
+
+		f.Lock()
+		err = fs.setACLXattr(ctx, f, op.Name, op.Value)
+		f.Unlock()
+
+	case isUserXattr(op.Name):
+		f, ok := in.(*inode.FileInode)
+		if !ok {
+			return syscall.ENOTSUP
+		}
+
+		if err = fs.checkFileWritable(f); err != nil {
+			return
+		}
+
+		f.Lock()
+		err = setUserXattr(ctx, f, op.Name, op.Value, op.Flags)
+		f.Unlock()
+
+	default:
+		err = syscall.ENOTSUP
+	}
+
+	return
+}
+
+// LOCKS_EXCLUDED(fs.mu)
+func (fs *fileSystem) RemoveXattr(
+	ctx context.Context,
+	op *fuseops.RemoveXattrOp) (err error) {
+	// Find the inode.
+	fs.mu.Lock()
+	in := fs.inodeOrDie(op.Inode)
+	fs.mu.Unlock()
+
+	f, ok := in.(*inode.FileInode)
+	if !ok || !isUserXattr(op.Name) {
+		return syscall.ENOTSUP
+	}
+
+	if err = fs.checkFileWritable(f); err != nil {
+		return
+	}
+
+	f.Lock()
+	err = removeUserXattr(ctx, f, op.Name)
+	f.Unlock()
+
+	return
+}
+
+// Prewarm the directory in response to a write of prewarmXattr.
+//
+// LOCKS_EXCLUDED(fs.mu)
+func (fs *fileSystem) setPrewarmXattr(
+	ctx context.Context,
+	in inode.Inode,
+	value []byte) (err error) {
+	d, ok := in.(inode.BucketOwnedDirInode)
+	if !ok {
+		return syscall.ENOTDIR
+	}
+
+	var contents bool
+	switch string(value) {
+	case "", prewarmMetadata:
+	case prewarmContents:
+		contents = true
+	default:
+		return syscall.EINVAL
+	}
+
+	err = fs.prewarm(ctx, d, contents)
+	return
 }
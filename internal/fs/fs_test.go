@@ -306,7 +306,7 @@ func (bm *fakeBucketManager) SetUpBucket(
 		sb = gcsx.NewSyncerBucket(
 			bm.appendThreshold,
 			bm.tmpObjectPrefix,
-			gcsx.NewContentTypeBucket(bucket),
+			gcsx.NewContentTypeBucket(nil, bucket),
 		)
 		return
 	}
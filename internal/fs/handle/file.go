@@ -36,6 +36,12 @@ type FileHandle struct {
 	//
 	// GUARDED_BY(mu)
 	reader gcsx.RandomReader
+
+	// Whether a warning has been logged about reading an archived object
+	// through this handle.
+	//
+	// GUARDED_BY(mu)
+	archiveReadWarned bool
 }
 
 func NewFileHandle(inode *inode.FileInode) (fh *FileHandle) {
@@ -61,6 +67,16 @@ func (fh *FileHandle) Inode() *inode.FileInode {
 	return fh.inode
 }
 
+// Record that a warning about reading an archived object has been logged for
+// this handle, returning false if one already had been.
+//
+// LOCKS_REQUIRED(fh)
+func (fh *FileHandle) MarkArchiveReadWarned() (first bool) {
+	first = !fh.archiveReadWarned
+	fh.archiveReadWarned = true
+	return
+}
+
 func (fh *FileHandle) Lock() {
 	fh.mu.Lock()
 }
@@ -131,15 +147,16 @@ func (fh *FileHandle) checkInvariants() {
 // LOCKS_REQUIRED(fh)
 // LOCKS_REQUIRED(fh.inode)
 func (fh *FileHandle) tryEnsureReader(ctx context.Context, sequentialReadSizeMb int32) (err error) {
-	// If content cache enabled, CacheEnsureContent forces the file handler to fall through to the inode
-	// and fh.inode.SourceGenerationIsAuthoritative() will return false
+	// If content cache enabled, CacheEnsureContent leases a clean local copy
+	// and fh.inode.ServesCachedReads() will return true, forcing the file
+	// handle to fall through to the inode.
 	err = fh.inode.CacheEnsureContent(ctx)
 	if err != nil {
 		return
 	}
-	// If the inode is dirty, there's nothing we can do. Throw away our reader if
-	// we have one.
-	if !fh.inode.SourceGenerationIsAuthoritative() {
+	// If the inode is dirty or reads are served from the local cache, there's
+	// nothing we can do. Throw away our reader if we have one.
+	if !fh.inode.SourceGenerationIsAuthoritative() || fh.inode.ServesCachedReads() {
 		if fh.reader != nil {
 			fh.reader.Destroy()
 			fh.reader = nil
@@ -0,0 +1,89 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type HardLinkTest struct {
+	fsTest
+}
+
+func init() { RegisterTestSuite(&HardLinkTest{}) }
+
+func (t *HardLinkTest) SetUp(ti *TestInfo) {
+	t.serverCfg.EmulateHardLinks = true
+	t.fsTest.SetUp(ti)
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *HardLinkTest) LinkThenUnlink() {
+	// Write a file, leaving it open so that the contents are still dirty.
+	f, err := os.Create(path.Join(t.Dir, "foo"))
+	AssertEq(nil, err)
+	defer f.Close()
+
+	_, err = f.Write([]byte("taco"))
+	AssertEq(nil, err)
+
+	// Link it, then remove the original.
+	err = os.Link(path.Join(t.Dir, "foo"), path.Join(t.Dir, "bar"))
+	AssertEq(nil, err)
+
+	err = os.Remove(path.Join(t.Dir, "foo"))
+	AssertEq(nil, err)
+
+	// The link has the contents, both locally and in GCS.
+	contents, err := ioutil.ReadFile(path.Join(t.Dir, "bar"))
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+
+	contents, err = gcsutil.ReadObject(t.ctx, t.bucket, "bar")
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+}
+
+func (t *HardLinkTest) NameAlreadyExists() {
+	AssertEq(nil, ioutil.WriteFile(path.Join(t.Dir, "foo"), []byte("taco"), 0600))
+	AssertEq(nil, ioutil.WriteFile(path.Join(t.Dir, "bar"), []byte("burrito"), 0600))
+
+	err := os.Link(path.Join(t.Dir, "foo"), path.Join(t.Dir, "bar"))
+	ExpectThat(err, Error(HasSubstr("exists")))
+
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, "bar")
+	AssertEq(nil, err)
+	ExpectEq("burrito", string(contents))
+}
+
+func (t *HardLinkTest) Directory() {
+	AssertEq(nil, os.Mkdir(path.Join(t.Dir, "foo"), 0700))
+
+	err := os.Link(path.Join(t.Dir, "foo"), path.Join(t.Dir, "bar"))
+	ExpectThat(err, Error(HasSubstr("not permitted")))
+}
@@ -17,6 +17,7 @@ package inode
 import (
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/jacobsa/fuse"
 
@@ -145,6 +146,14 @@ func (d *baseDirInode) LookUpChild(ctx context.Context, name string) (*Core, err
 	}, nil
 }
 
+// LOCKS_REQUIRED(d)
+func (d *baseDirInode) ForgetListedChild(name string) {
+}
+
+// LOCKS_REQUIRED(d)
+func (d *baseDirInode) PrimeCaches(listedAt time.Time, children []*Core) {
+}
+
 // Not implemented
 func (d *baseDirInode) ReadDescendants(ctx context.Context, limit int) (map[Name]*Core, error) {
 	return nil, fuse.ENOSYS
@@ -171,6 +180,11 @@ func (d *baseDirInode) ReadEntries(
 // tries to mutate the base directory, they will receive a ENOSYS error
 // indicating such operation is not supported.
 
+func (d *baseDirInode) IsEmpty(ctx context.Context) (empty bool, err error) {
+	err = fuse.ENOSYS
+	return
+}
+
 func (d *baseDirInode) CreateChildFile(ctx context.Context, name string) (*Core, error) {
 	return nil, fuse.ENOSYS
 }
@@ -187,6 +201,15 @@ func (d *baseDirInode) CreateChildDir(ctx context.Context, name string) (*Core,
 	return nil, fuse.ENOSYS
 }
 
+func (d *baseDirInode) CreateLocalChildDir(ctx context.Context, name string) (*Core, error) {
+	return nil, fuse.ENOSYS
+}
+
+func (d *baseDirInode) Materialize(ctx context.Context) (err error) {
+	err = fuse.ENOSYS
+	return
+}
+
 func (d *baseDirInode) DeleteChildFile(
 	ctx context.Context,
 	name string,
@@ -62,6 +62,17 @@ type DirInode interface {
 	// true.
 	LookUpChild(ctx context.Context, name string) (*Core, error)
 
+	// Forget what recent listings said about the child with the given name, so
+	// that the next LookUpChild asks GCS. For use when a listed record turns out
+	// to be older than one already known, for example because this mount has
+	// since written the object.
+	ForgetListedChild(name string)
+
+	// Fill the type and listing caches with records for children seen in a
+	// listing issued at the given time, as ReadEntries would. See
+	// WarmedListings.
+	PrimeCaches(listedAt time.Time, children []*Core)
+
 	// Read the children objects of this dir, recursively. The result count
 	// is capped at the given limit. Internal caches are not refreshed from this
 	// call.
@@ -82,6 +93,10 @@ type DirInode interface {
 		ctx context.Context,
 		tok string) (entries []fuseutil.Dirent, newTok string, err error)
 
+	// Report whether the directory has no children, as ReadEntries would list
+	// them. Usually a single small listing suffices, however large the bucket.
+	IsEmpty(ctx context.Context) (empty bool, err error)
+
 	// Create an empty child file with the supplied (relative) name, failing with
 	// *gcs.PreconditionError if a backing object already exists in GCS.
 	// Return the full name of the child and the GCS object it backs up.
@@ -104,6 +119,18 @@ type DirInode interface {
 	// Return the full name of the child and the GCS object it backs up.
 	CreateChildDir(ctx context.Context, name string) (*Core, error)
 
+	// Record a child directory with the supplied (relative) name without
+	// creating a backing object, failing with *gcs.PreconditionError if a
+	// child with the name already exists. The directory is known only to this
+	// inode, which reports it as an implicit directory until it is deleted.
+	// Return the full name of the child.
+	CreateLocalChildDir(ctx context.Context, name string) (*Core, error)
+
+	// Create the backing object for this directory if it has none, as for a
+	// directory whose creation was deferred. It is not an error for the object
+	// to exist already.
+	Materialize(ctx context.Context) (err error)
+
 	// Delete the backing object for the child file or symlink with the given
 	// (relative) name and generation number, where zero means the latest
 	// generation. If the object/generation doesn't exist, no error is returned.
@@ -165,6 +192,27 @@ type dirInode struct {
 	//
 	// GUARDED_BY(mu)
 	cache typeCache
+
+	// Records for children seen in recent listings, used to answer lookups
+	// without statting again. Expires along with the type cache, measured from
+	// the time each listing was issued.
+	//
+	// listed.CheckInvariants() does not panic.
+	//
+	// GUARDED_BY(mu)
+	listed listingCache
+
+	// The names of child directories created by CreateLocalChildDir that have
+	// not since been deleted.
+	//
+	// GUARDED_BY(mu)
+	localDirs map[string]struct{}
+
+	// Set once Materialize has seen the backing object exist, so that later
+	// calls needn't ask GCS again.
+	//
+	// GUARDED_BY(mu)
+	materialized bool
 }
 
 var _ DirInode = &dirInode{}
@@ -213,6 +261,8 @@ func NewDirInode(
 		name:         name,
 		attrs:        attrs,
 		cache:        newTypeCache(typeCacheCapacity/2, typeCacheTTL),
+		listed:       newListingCache(typeCacheCapacity/2, typeCacheTTL),
+		localDirs:    make(map[string]struct{}),
 	}
 
 	typed.lc.Init(id)
@@ -236,6 +286,16 @@ func (d *dirInode) checkInvariants() {
 
 	// cache.CheckInvariants() does not panic.
 	d.cache.CheckInvariants()
+
+	// listed.CheckInvariants() does not panic.
+	d.listed.CheckInvariants()
+}
+
+// Report whether the named child directory holds gcsfuse's own records rather
+// than user data, and so is hidden from listings and lookups. See
+// gcsx.RenameManifestPrefix.
+func (d *dirInode) isHiddenChildDir(name string) bool {
+	return d.Name().IsBucketRoot() && name+"/" == gcsx.RenameManifestPrefix
 }
 
 func (d *dirInode) lookUpChildFile(ctx context.Context, name string) (*Core, error) {
@@ -426,6 +486,19 @@ func (d *dirInode) LookUpChild(ctx context.Context, name string) (*Core, error)
 		return d.lookUpConflicting(ctx, name)
 	}
 
+	// A file may share the name of a hidden directory.
+	if d.isHiddenChildDir(name) {
+		return d.lookUpChildFile(ctx, name)
+	}
+
+	// Can we answer from a recent listing? As below, prefer the directory.
+	if fileResult, dirResult, ok := d.listed.Get(d.cacheClock.Now(), name); ok {
+		if dirResult != nil {
+			return dirResult, nil
+		}
+		return fileResult, nil
+	}
+
 	var fileResult *Core
 	var dirResult *Core
 	lookUpFile := func(ctx context.Context) (err error) {
@@ -469,6 +542,12 @@ func (d *dirInode) LookUpChild(ctx context.Context, name string) (*Core, error)
 	var result *Core
 	if dirResult != nil {
 		result = dirResult
+	} else if _, ok := d.localDirs[name]; ok {
+		result = &Core{
+			Bucket:   d.Bucket(),
+			FullName: NewDirName(d.Name(), name),
+			Object:   nil,
+		}
 	} else if fileResult != nil {
 		result = fileResult
 	}
@@ -479,6 +558,24 @@ func (d *dirInode) LookUpChild(ctx context.Context, name string) (*Core, error)
 	return result, nil
 }
 
+// LOCKS_REQUIRED(d)
+func (d *dirInode) ForgetListedChild(name string) {
+	d.listed.Erase(name)
+}
+
+// LOCKS_REQUIRED(d)
+func (d *dirInode) PrimeCaches(listedAt time.Time, children []*Core) {
+	for _, c := range children {
+		name := path.Base(c.FullName.LocalName())
+		if c.FullName.IsDir() && d.isHiddenChildDir(name) {
+			continue
+		}
+
+		d.cache.Insert(listedAt, name, c.Type())
+		d.listed.Insert(listedAt, name, c)
+	}
+}
+
 // LOCKS_REQUIRED(d)
 func (d *dirInode) ReadDescendants(ctx context.Context, limit int) (map[Name]*Core, error) {
 	var tok string
@@ -534,6 +631,8 @@ func (d *dirInode) readObjects(
 		ProjectionVal:            gcs.NoAcl,
 	}
 
+	// The results are only known to be fresh as of when we asked for them.
+	listedAt := d.cacheClock.Now()
 	listing, err := d.bucket.ListObjects(ctx, req)
 	if err != nil {
 		err = fmt.Errorf("ListObjects: %w", err)
@@ -542,9 +641,10 @@ func (d *dirInode) readObjects(
 
 	cores = make(map[Name]*Core)
 	defer func() {
-		now := d.cacheClock.Now()
 		for fullName, c := range cores {
-			d.cache.Insert(now, path.Base(fullName.LocalName()), c.Type())
+			name := path.Base(fullName.LocalName())
+			d.cache.Insert(listedAt, name, c.Type())
+			d.listed.Insert(listedAt, name, c)
 		}
 	}()
 
@@ -560,6 +660,10 @@ func (d *dirInode) readObjects(
 		// directory "foo/" coexist, the directory would eventually occupy
 		// the value of records["foo"].
 		if strings.HasSuffix(o.Name, "/") {
+			if d.isHiddenChildDir(nameBase) {
+				continue
+			}
+
 			dirName := NewDirName(d.Name(), nameBase)
 			explicitDir := &Core{
 				Bucket:   d.Bucket(),
@@ -588,6 +692,10 @@ func (d *dirInode) readObjects(
 	// Add implicit directories into the result.
 	for _, p := range listing.CollapsedRuns {
 		pathBase := path.Base(p)
+		if d.isHiddenChildDir(pathBase) {
+			continue
+		}
+
 		dirName := NewDirName(d.Name(), pathBase)
 		if c, ok := cores[dirName]; ok && c.Type() == ExplicitDirType {
 			continue
@@ -613,6 +721,21 @@ func (d *dirInode) ReadEntries(
 		return
 	}
 
+	// Local directories have nothing in GCS to list, so report them along with
+	// the first batch of entries.
+	if tok == "" {
+		for name := range d.localDirs {
+			dirName := NewDirName(d.Name(), name)
+			if _, ok := cores[dirName]; !ok {
+				cores[dirName] = &Core{
+					Bucket:   d.Bucket(),
+					FullName: dirName,
+					Object:   nil,
+				}
+			}
+		}
+	}
+
 	for fullName, core := range cores {
 		entry := fuseutil.Dirent{
 			Name: path.Base(fullName.LocalName()),
@@ -631,6 +754,48 @@ func (d *dirInode) ReadEntries(
 	return
 }
 
+// LOCKS_REQUIRED(d)
+func (d *dirInode) IsEmpty(ctx context.Context) (empty bool, err error) {
+	if len(d.localDirs) != 0 {
+		return
+	}
+
+	// The placeholder object for this directory, if any, sorts first, so two
+	// results are enough to find a child. Only if GCS returns fewer along with
+	// a continuation token is another call needed.
+	req := &gcs.ListObjectsRequest{
+		Delimiter:                "/",
+		IncludeTrailingDelimiter: true,
+		Prefix:                   d.Name().GcsObjectName(),
+		MaxResults:               2,
+		ProjectionVal:            gcs.NoAcl,
+	}
+
+	for {
+		var listing *gcs.Listing
+		listing, err = d.bucket.ListObjects(ctx, req)
+		if err != nil {
+			err = fmt.Errorf("ListObjects: %w", err)
+			return
+		}
+
+		for _, o := range listing.Objects {
+			if o.Name != d.Name().GcsObjectName() {
+				return
+			}
+		}
+
+		if d.implicitDirs && len(listing.CollapsedRuns) != 0 {
+			return
+		}
+
+		if req.ContinuationToken = listing.ContinuationToken; req.ContinuationToken == "" {
+			empty = true
+			return
+		}
+	}
+}
+
 // LOCKS_REQUIRED(d)
 func (d *dirInode) CreateChildFile(ctx context.Context, name string) (*Core, error) {
 	metadata := map[string]string{
@@ -644,6 +809,7 @@ func (d *dirInode) CreateChildFile(ctx context.Context, name string) (*Core, err
 	}
 
 	d.cache.Insert(d.cacheClock.Now(), name, RegularFileType)
+	d.listed.Erase(name)
 	return &Core{
 		Bucket:   d.Bucket(),
 		FullName: fullName,
@@ -655,6 +821,7 @@ func (d *dirInode) CreateChildFile(ctx context.Context, name string) (*Core, err
 func (d *dirInode) CloneToChildFile(ctx context.Context, name string, src *gcs.Object) (*Core, error) {
 	// Erase any existing type information for this name.
 	d.cache.Erase(name)
+	d.listed.Erase(name)
 	fullName := NewFileName(d.Name(), name)
 
 	// Clone over anything that might already exist for the name.
@@ -692,6 +859,7 @@ func (d *dirInode) CreateChildSymlink(ctx context.Context, name string, target s
 	}
 
 	d.cache.Insert(d.cacheClock.Now(), name, SymlinkType)
+	d.listed.Erase(name)
 
 	return &Core{
 		Bucket:   d.Bucket(),
@@ -709,6 +877,7 @@ func (d *dirInode) CreateChildDir(ctx context.Context, name string) (*Core, erro
 	}
 
 	d.cache.Insert(d.cacheClock.Now(), name, ExplicitDirType)
+	d.listed.Erase(name)
 
 	return &Core{
 		Bucket:   d.Bucket(),
@@ -717,6 +886,53 @@ func (d *dirInode) CreateChildDir(ctx context.Context, name string) (*Core, erro
 	}, nil
 }
 
+// LOCKS_REQUIRED(d)
+func (d *dirInode) CreateLocalChildDir(ctx context.Context, name string) (*Core, error) {
+	existing, err := d.LookUpChild(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("LookUpChild: %w", err)
+	}
+
+	fullName := NewDirName(d.Name(), name)
+	if existing != nil {
+		return nil, &gcs.PreconditionError{
+			Err: fmt.Errorf("%q already exists", fullName.GcsObjectName()),
+		}
+	}
+
+	d.localDirs[name] = struct{}{}
+	d.cache.Insert(d.cacheClock.Now(), name, ImplicitDirType)
+	d.listed.Erase(name)
+
+	return &Core{
+		Bucket:   d.Bucket(),
+		FullName: fullName,
+		Object:   nil,
+	}, nil
+}
+
+// LOCKS_REQUIRED(d)
+func (d *dirInode) Materialize(ctx context.Context) (err error) {
+	if d.materialized || d.Name().IsBucketRoot() {
+		return
+	}
+
+	_, err = d.createNewObject(ctx, d.Name(), nil)
+
+	var preconditionErr *gcs.PreconditionError
+	if errors.As(err, &preconditionErr) {
+		err = nil
+	}
+
+	if err != nil {
+		err = fmt.Errorf("CreateObject: %w", err)
+		return
+	}
+
+	d.materialized = true
+	return
+}
+
 // LOCKS_REQUIRED(d)
 func (d *dirInode) DeleteChildFile(
 	ctx context.Context,
@@ -724,6 +940,7 @@ func (d *dirInode) DeleteChildFile(
 	generation int64,
 	metaGeneration *int64) (err error) {
 	d.cache.Erase(name)
+	d.listed.Erase(name)
 	childName := NewFileName(d.Name(), name)
 
 	err = d.bucket.DeleteObject(
@@ -748,6 +965,8 @@ func (d *dirInode) DeleteChildDir(
 	ctx context.Context,
 	name string) (err error) {
 	d.cache.Erase(name)
+	d.listed.Erase(name)
+	delete(d.localDirs, name)
 	childName := NewDirName(d.Name(), name)
 
 	// Delete the backing object. Unfortunately we have no way to precondition
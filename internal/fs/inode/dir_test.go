@@ -87,13 +87,19 @@ func (p DirentSlice) Less(i, j int) bool { return p[i].Name < p[j].Name }
 func (p DirentSlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
 
 func (t *DirTest) resetInode(implicitDirs bool) {
+	t.resetInodeWithName(
+		inode.NewDirName(inode.NewRootName(""), dirInodeName),
+		implicitDirs)
+}
+
+func (t *DirTest) resetInodeWithName(name inode.Name, implicitDirs bool) {
 	if t.in != nil {
 		t.in.Unlock()
 	}
 
 	t.in = inode.NewDirInode(
 		dirInodeID,
-		inode.NewDirName(inode.NewRootName(""), dirInodeName),
+		name,
 		fuseops.InodeAttributes{
 			Uid:  uid,
 			Gid:  gid,
@@ -626,6 +632,98 @@ func (t *DirTest) ReadEntries_NonEmpty_ImplicitDirsEnabled() {
 	ExpectEq(fuseutil.DT_Link, entry.Type)
 }
 
+func (t *DirTest) ReadEntries_HidesRenameManifests() {
+	// Look at the bucket root, where the manifests live.
+	t.resetInodeWithName(inode.NewRootName(""), true)
+
+	objs := []string{
+		gcsx.RenameManifestPrefix,
+		gcsx.RenameManifestPrefix + "taco",
+		"file",
+	}
+
+	err := gcsutil.CreateEmptyObjects(t.ctx, t.bucket, objs)
+	AssertEq(nil, err)
+
+	entries, err := t.readAllEntries()
+
+	AssertEq(nil, err)
+	AssertEq(1, len(entries))
+	ExpectEq("file", entries[0].Name)
+
+	// Nor can the manifests be looked up.
+	result, err := t.in.LookUpChild(t.ctx, path.Clean(gcsx.RenameManifestPrefix))
+	AssertEq(nil, err)
+	ExpectEq(nil, result)
+}
+
+func (t *DirTest) PrimeCaches_FromWarmedListings() {
+	// Enable implicit dirs.
+	t.resetInode(true)
+
+	objs := []string{
+		dirInodeName + "file",
+		dirInodeName + "sub/",
+		dirInodeName + "sub/implicit/foo",
+	}
+
+	err := gcsutil.CreateEmptyObjects(t.ctx, t.bucket, objs)
+	AssertEq(nil, err)
+
+	listing, err := t.bucket.ListObjects(
+		t.ctx,
+		&gcs.ListObjectsRequest{Prefix: dirInodeName})
+	AssertEq(nil, err)
+
+	// Record the whole tree.
+	warmed := inode.NewWarmedListings(typeCacheTTL)
+	warmed.InsertTree(t.clock.Now(), t.bucket, t.in.Name(), listing.Objects, true)
+
+	// Each directory has its children, implied ones included.
+	subName := inode.NewDirName(t.in.Name(), "sub")
+	_, children, ok := warmed.Take(t.clock.Now(), subName)
+	AssertTrue(ok)
+	AssertEq(1, len(children))
+	ExpectEq(dirInodeName+"sub/implicit/", children[0].FullName.GcsObjectName())
+	ExpectEq(inode.ImplicitDirType, children[0].Type())
+
+	// They can only be taken once.
+	_, _, ok = warmed.Take(t.clock.Now(), subName)
+	ExpectFalse(ok)
+
+	// Prime the inode, then remove the objects behind its back.
+	listedAt, children, ok := warmed.Take(t.clock.Now(), t.in.Name())
+	AssertTrue(ok)
+	AssertEq(2, len(children))
+	t.in.PrimeCaches(listedAt, children)
+
+	for _, name := range objs {
+		err = t.bucket.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: name})
+		AssertEq(nil, err)
+	}
+
+	// Lookups are answered from the caches.
+	result, err := t.in.LookUpChild(t.ctx, "file")
+	AssertEq(nil, err)
+	AssertNe(nil, result)
+	ExpectEq(dirInodeName+"file", result.FullName.GcsObjectName())
+
+	result, err = t.in.LookUpChild(t.ctx, "sub")
+	AssertEq(nil, err)
+	AssertNe(nil, result)
+	ExpectEq(inode.ExplicitDirType, result.Type())
+}
+
+func (t *DirTest) WarmedListings_Expire() {
+	warmed := inode.NewWarmedListings(typeCacheTTL)
+	objects := []*gcs.Object{{Name: dirInodeName + "file"}}
+	warmed.InsertTree(t.clock.Now(), t.bucket, t.in.Name(), objects, false)
+
+	t.clock.AdvanceTime(typeCacheTTL + time.Millisecond)
+	_, _, ok := warmed.Take(t.clock.Now(), t.in.Name())
+	ExpectFalse(ok)
+}
+
 func (t *DirTest) ReadEntries_TypeCaching() {
 	const name = "qux"
 	fileObjName := path.Join(dirInodeName, name)
@@ -665,6 +763,121 @@ func (t *DirTest) ReadEntries_TypeCaching() {
 	ExpectEq(dirObjName, result.Object.Name)
 }
 
+func (t *DirTest) ReadEntries_ListingAnswersLookUp() {
+	const name = "qux"
+	objName := path.Join(dirInodeName, name)
+
+	var err error
+
+	// Create a backing object for a file.
+	_, err = gcsutil.CreateObject(t.ctx, t.bucket, objName, []byte("taco"))
+	AssertEq(nil, err)
+
+	// Read the directory.
+	_, err = t.readAllEntries()
+	AssertEq(nil, err)
+
+	// Delete the object behind the inode's back.
+	err = t.bucket.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: objName})
+	AssertEq(nil, err)
+
+	// Looking up the name should be answered from the listing, without going
+	// back to the bucket.
+	result, err := t.in.LookUpChild(t.ctx, name)
+
+	AssertEq(nil, err)
+	AssertNe(nil, result)
+	AssertNe(nil, result.Object)
+	ExpectEq(objName, result.Object.Name)
+
+	// Once the listing is stale, we should notice that the object is gone.
+	t.clock.AdvanceTime(typeCacheTTL + time.Millisecond)
+
+	result, err = t.in.LookUpChild(t.ctx, name)
+
+	AssertEq(nil, err)
+	ExpectEq(nil, result)
+}
+
+func (t *DirTest) ForgetListedChild() {
+	const name = "qux"
+	objName := path.Join(dirInodeName, name)
+
+	var err error
+
+	// Create a backing object for a file, and read the directory.
+	_, err = gcsutil.CreateObject(t.ctx, t.bucket, objName, []byte("taco"))
+	AssertEq(nil, err)
+
+	_, err = t.readAllEntries()
+	AssertEq(nil, err)
+
+	// Overwrite the object.
+	o, err := gcsutil.CreateObject(t.ctx, t.bucket, objName, []byte("burrito"))
+	AssertEq(nil, err)
+
+	// Once the listing is forgotten, the new generation should be found.
+	t.in.ForgetListedChild(name)
+	result, err := t.in.LookUpChild(t.ctx, name)
+
+	AssertEq(nil, err)
+	AssertNe(nil, result)
+	AssertNe(nil, result.Object)
+	ExpectEq(o.Generation, result.Object.Generation)
+}
+
+func (t *DirTest) IsEmpty_PlaceholderOnly() {
+	_, err := gcsutil.CreateObject(t.ctx, t.bucket, dirInodeName, []byte(""))
+	AssertEq(nil, err)
+
+	empty, err := t.in.IsEmpty(t.ctx)
+	AssertEq(nil, err)
+	ExpectTrue(empty)
+}
+
+func (t *DirTest) IsEmpty_File() {
+	objs := []string{
+		dirInodeName,
+		dirInodeName + "qux",
+	}
+
+	err := gcsutil.CreateEmptyObjects(t.ctx, t.bucket, objs)
+	AssertEq(nil, err)
+
+	empty, err := t.in.IsEmpty(t.ctx)
+	AssertEq(nil, err)
+	ExpectFalse(empty)
+}
+
+func (t *DirTest) IsEmpty_ImplicitDir_Disabled() {
+	_, err := gcsutil.CreateObject(t.ctx, t.bucket, dirInodeName+"qux/baz", []byte(""))
+	AssertEq(nil, err)
+
+	empty, err := t.in.IsEmpty(t.ctx)
+	AssertEq(nil, err)
+	ExpectTrue(empty)
+}
+
+func (t *DirTest) IsEmpty_ImplicitDir_Enabled() {
+	t.resetInode(true)
+
+	_, err := gcsutil.CreateObject(t.ctx, t.bucket, dirInodeName+"qux/baz", []byte(""))
+	AssertEq(nil, err)
+
+	empty, err := t.in.IsEmpty(t.ctx)
+	AssertEq(nil, err)
+	ExpectFalse(empty)
+}
+
+func (t *DirTest) IsEmpty_LocalDir() {
+	_, err := t.in.CreateLocalChildDir(t.ctx, "qux")
+	AssertEq(nil, err)
+
+	empty, err := t.in.IsEmpty(t.ctx)
+	AssertEq(nil, err)
+	ExpectFalse(empty)
+}
+
 func (t *DirTest) CreateChildFile_DoesntExist() {
 	const name = "qux"
 	objName := path.Join(dirInodeName, name)
@@ -955,6 +1168,93 @@ func (t *DirTest) CreateChildDir_Exists() {
 	ExpectThat(err, Error(HasSubstr("exists")))
 }
 
+func (t *DirTest) CreateLocalChildDir_DoesntExist() {
+	const name = "qux"
+	objName := path.Join(dirInodeName, name) + "/"
+
+	// Call the inode.
+	result, err := t.in.CreateLocalChildDir(t.ctx, name)
+	AssertEq(nil, err)
+	AssertNe(nil, result)
+	ExpectEq(nil, result.Object)
+	ExpectEq(objName, result.FullName.GcsObjectName())
+
+	// Nothing should have been created in the bucket.
+	_, err = gcsutil.ReadObject(t.ctx, t.bucket, objName)
+	var notFoundErr *gcs.NotFoundError
+	ExpectTrue(errors.As(err, &notFoundErr))
+
+	// But the directory should be visible, even once the type cache expires.
+	t.clock.AdvanceTime(typeCacheTTL + time.Millisecond)
+
+	result, err = t.in.LookUpChild(t.ctx, name)
+	AssertEq(nil, err)
+	AssertNe(nil, result)
+	ExpectEq(objName, result.FullName.GcsObjectName())
+	ExpectEq(inode.ImplicitDirType, result.Type())
+
+	entries, err := t.readAllEntries()
+	AssertEq(nil, err)
+	AssertEq(1, len(entries))
+	ExpectEq(name, entries[0].Name)
+	ExpectEq(fuseutil.DT_Directory, entries[0].Type)
+}
+
+func (t *DirTest) CreateLocalChildDir_Exists() {
+	const name = "qux"
+
+	var err error
+
+	// Create an existing file with the name.
+	_, err = gcsutil.CreateObject(t.ctx, t.bucket, path.Join(dirInodeName, name), []byte("taco"))
+	AssertEq(nil, err)
+
+	// Call the inode.
+	_, err = t.in.CreateLocalChildDir(t.ctx, name)
+	ExpectThat(err, Error(HasSubstr("exists")))
+
+	var preconditionErr *gcs.PreconditionError
+	ExpectTrue(errors.As(err, &preconditionErr))
+}
+
+func (t *DirTest) Materialize() {
+	var err error
+
+	// Twice, to check that an existing object isn't an error.
+	err = t.in.Materialize(t.ctx)
+	AssertEq(nil, err)
+
+	err = t.in.Materialize(t.ctx)
+	AssertEq(nil, err)
+
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, dirInodeName)
+	AssertEq(nil, err)
+	ExpectEq("", string(contents))
+}
+
+func (t *DirTest) Materialize_OnlyOnce() {
+	var err error
+
+	err = t.in.Materialize(t.ctx)
+	AssertEq(nil, err)
+
+	// Once the object is known to exist, GCS shouldn't be asked again, so an
+	// object deleted behind our back isn't recreated.
+	err = t.bucket.DeleteObject(
+		t.ctx,
+		&gcs.DeleteObjectRequest{Name: dirInodeName})
+	AssertEq(nil, err)
+
+	err = t.in.Materialize(t.ctx)
+	AssertEq(nil, err)
+
+	_, err = t.bucket.StatObject(
+		t.ctx,
+		&gcs.StatObjectRequest{Name: dirInodeName})
+	var notFoundErr *gcs.NotFoundError
+	ExpectTrue(errors.As(err, &notFoundErr))
+}
+
 func (t *DirTest) DeleteChildFile_DoesntExist() {
 	const name = "qux"
 
@@ -1088,6 +1388,22 @@ func (t *DirTest) DeleteChildDir_DoesntExist() {
 	ExpectEq(nil, err)
 }
 
+func (t *DirTest) DeleteChildDir_Local() {
+	const name = "qux"
+
+	_, err := t.in.CreateLocalChildDir(t.ctx, name)
+	AssertEq(nil, err)
+
+	// Call the inode.
+	err = t.in.DeleteChildDir(t.ctx, name)
+	AssertEq(nil, err)
+
+	// The directory should be gone.
+	result, err := t.in.LookUpChild(t.ctx, name)
+	AssertEq(nil, err)
+	ExpectEq(nil, result)
+}
+
 func (t *DirTest) DeleteChildDir_Exists() {
 	const name = "qux"
 	objName := path.Join(dirInodeName, name) + "/"
@@ -21,6 +21,7 @@ import (
 	"github.com/jacobsa/fuse/fuseops"
 	"github.com/jacobsa/gcloud/gcs"
 	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
 )
 
 // An inode representing a directory backed by an object in GCS with a specific
@@ -68,6 +69,11 @@ type explicitDirInode struct {
 	generation Generation
 }
 
+// The backing object already exists.
+func (d *explicitDirInode) Materialize(ctx context.Context) (err error) {
+	return
+}
+
 func (d *explicitDirInode) SourceGeneration() (gen Generation) {
 	gen = d.generation
 	return
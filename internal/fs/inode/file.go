@@ -18,22 +18,33 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/googlecloudplatform/gcsfuse/internal/contentcache"
 	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/googlecloudplatform/gcsfuse/internal/logger"
+	"github.com/googlecloudplatform/gcsfuse/internal/monitor"
+	"github.com/googlecloudplatform/gcsfuse/internal/storage"
 	"github.com/jacobsa/fuse/fuseops"
 	"github.com/jacobsa/gcloud/gcs"
 	"github.com/jacobsa/syncutil"
 	"github.com/jacobsa/timeutil"
 	"golang.org/x/net/context"
+	storagev1 "google.golang.org/api/storage/v1"
 )
 
 // A GCS object metadata key for file mtimes. mtimes are UTC, and are stored in
 // the format defined by time.RFC3339Nano.
 const FileMtimeMetadataKey = gcsx.MtimeMetadataKey
 
+// A GCS object metadata key for file atimes, set only by utimensat(2) and
+// friends. Stored in the same format as FileMtimeMetadataKey.
+const FileAtimeMetadataKey = "gcsfuse_atime"
+
 type FileInode struct {
 	/////////////////////////
 	// Dependencies
@@ -54,6 +65,10 @@ type FileInode struct {
 	// one implementation with original functionality and one with new persistent disk content cache
 	localFileCache bool
 
+	// If non-zero, the generation of the source object to which the inode is
+	// pinned by Pin.
+	pinnedGeneration int64
+
 	/////////////////////////
 	// Mutable state
 	/////////////////////////
@@ -72,10 +87,35 @@ type FileInode struct {
 	// GUARDED_BY(mu)
 	src gcs.Object
 
-	// The current content of this inode, or nil if the source object is still
-	// authoritative.
+	// A read-only lease on a clean copy of the source object in the content
+	// cache, or nil. Only used when localFileCache is set. The lease may be
+	// given up at any time the inode is clean, and re-acquired on demand.
+	//
+	// INVARIANT: If cached != nil, localFileCache
+	//
+	// GUARDED_BY(mu)
+	cached *contentcache.CacheObject
+
+	// The current mutable content of this inode, or nil if the source object is
+	// still authoritative. This is only created once the inode is dirtied.
+	//
+	// GUARDED_BY(mu)
 	content gcsx.TempFile
 
+	// An atime to be stored with the object when the content is next synced,
+	// or nil.
+	//
+	// INVARIANT: If pendingAtime != nil, content != nil
+	//
+	// GUARDED_BY(mu)
+	pendingAtime *time.Time
+
+	// The last time the local content of this inode was used, according to
+	// mtimeClock.
+	//
+	// GUARDED_BY(mu)
+	lastUsed time.Time
+
 	// Has Destroy been called?
 	//
 	// GUARDED_BY(mu)
@@ -146,6 +186,16 @@ func (f *FileInode) checkInvariants() {
 		))
 	}
 
+	// INVARIANT: If cached != nil, localFileCache
+	if f.cached != nil && !f.localFileCache {
+		panic("Unexpected cache lease without local file cache")
+	}
+
+	// INVARIANT: If pendingAtime != nil, content != nil
+	if f.pendingAtime != nil && f.content == nil {
+		panic("Unexpected pending atime without content")
+	}
+
 	// INVARIANT: content.CheckInvariants() does not panic
 	if f.content != nil {
 		f.content.CheckInvariants()
@@ -162,11 +212,12 @@ func (f *FileInode) clobbered(ctx context.Context, forceFetchFromGcs bool) (o *g
 	}
 	o, err = f.bucket.StatObject(ctx, req)
 
-	// Special case: "not found" means we have been clobbered.
+	// Special case: "not found" means we have been clobbered, unless we are
+	// yet to create the object.
 	var notFoundErr *gcs.NotFoundError
 	if errors.As(err, &notFoundErr) {
 		err = nil
-		b = true
+		b = !f.unborn()
 		return
 	}
 
@@ -197,57 +248,250 @@ func (f *FileInode) openReader(ctx context.Context) (io.ReadCloser, error) {
 	return rc, err
 }
 
-// Ensure that content exists and is not stale
+func (f *FileInode) cacheObjectKey() *contentcache.CacheObjectKey {
+	return &contentcache.CacheObjectKey{
+		BucketName: f.bucket.Name(),
+		ObjectName: f.name.objectName,
+	}
+}
+
+// Ensure that f.cached holds a lease on a clean copy of the source
+// generation, fetching one into the content cache if necessary.
 //
 // LOCKS_REQUIRED(f.mu)
-func (f *FileInode) ensureContent(ctx context.Context) (err error) {
-	if f.localFileCache {
-		// Fetch content from the cache after validating generation numbers again
-		// Generation validation first occurs at inode creation/destruction
-		cacheObjectKey := &contentcache.CacheObjectKey{BucketName: f.bucket.Name(), ObjectName: f.name.objectName}
-		if cacheObject, exists := f.contentCache.Get(cacheObjectKey); exists {
-			if cacheObject.ValidateGeneration(f.src.Generation, f.src.MetaGeneration) {
-				f.content = cacheObject.CacheFile
-				return
-			}
+func (f *FileInode) ensureCachedContent(ctx context.Context) (err error) {
+	f.lastUsed = f.mtimeClock.Now()
+
+	// Is the lease we hold still for the right generation?
+	if f.cached != nil {
+		if f.cached.ValidateGeneration(f.src.Generation, f.src.MetaGeneration) {
+			monitor.RecordCacheHit(monitor.FileCache)
+			return
 		}
 
-		rc, err := f.openReader(ctx)
-		if err != nil {
-			err = fmt.Errorf("openReader Error: %w", err)
-			return err
+		f.releaseCachedContent()
+	}
+
+	// Reuse an existing cache entry if there is one and it still reflects
+	// GCS. It may have been left behind by an earlier inode or mount, so don't
+	// trust it blindly.
+	key := f.cacheObjectKey()
+	if cacheObject, ok := f.contentCache.Lease(key, f.src.Generation, f.src.MetaGeneration); ok {
+		var valid bool
+		valid, err = f.verifyCachedContent(ctx, cacheObject)
+
+		// If GCS can't be reached to check the copy, it's the best we have.
+		if err != nil && f.contentCache.ServeWhenOffline() && gcsx.IsUnavailable(err) {
+			logger.Infof(
+				"Serving unverified cached copy of %q while GCS is unavailable: %v\n",
+				f.name.GcsObjectName(),
+				err)
+			valid, err = true, nil
 		}
 
-		// Insert object into content cache
-		tf, err := f.contentCache.AddOrReplace(cacheObjectKey, f.src.Generation, f.src.MetaGeneration, rc)
 		if err != nil {
-			err = fmt.Errorf("AddOrReplace cache error: %w", err)
-			return err
+			f.contentCache.ReleaseLease(cacheObject)
+			err = fmt.Errorf("verifyCachedContent: %w", err)
+			return
 		}
 
-		// Update state.
-		f.content = tf.CacheFile
-	} else {
-		// Local filecache is not enabled
-		if f.content != nil {
+		if valid {
+			monitor.RecordCacheHit(monitor.FileCache)
+			f.cached = cacheObject
 			return
 		}
 
-		rc, err := f.openReader(ctx)
+		// Fall through to fetching a fresh copy.
+		f.contentCache.ReleaseLease(cacheObject)
+		f.contentCache.Evict(key)
+	}
+
+	monitor.RecordCacheMiss(monitor.FileCache)
+	rc, err := f.openReader(ctx)
+	if err != nil {
+		err = fmt.Errorf("openReader Error: %w", err)
+		return
+	}
+
+	// Insert object into content cache
+	cacheObject, err := f.contentCache.AddAndLease(key, f.src.Generation, f.src.MetaGeneration, int64(f.src.Size), rc)
+	if err != nil {
+		rc.Close()
+		err = fmt.Errorf("AddAndLease cache error: %w", err)
+		return
+	}
+
+	f.cached = cacheObject
+	return
+}
+
+// Re-stat the object in GCS and check that the cached copy still matches it.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) verifyCachedContent(
+	ctx context.Context,
+	cacheObject *contentcache.CacheObject) (valid bool, err error) {
+	o, clobbered, err := f.clobbered(ctx, true)
+	if err != nil {
+		err = fmt.Errorf("clobbered: %w", err)
+		return
+	}
+
+	if clobbered {
+		return
+	}
+
+	valid, err = cacheObject.Verify(o, f.contentCache.VerifyCRC32C())
+	if err != nil {
+		err = fmt.Errorf("Verify: %w", err)
+		return
+	}
+
+	return
+}
+
+// Give up the lease on the clean cached copy, if any, leaving the cache free
+// to evict it.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) releaseCachedContent() {
+	if f.cached == nil {
+		return
+	}
+
+	f.contentCache.ReleaseLease(f.cached)
+	f.cached = nil
+}
+
+// Open a reader for the source generation, preferring the clean cached copy
+// when the local file cache is enabled. Also returns the number of bytes that
+// will be read.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) openSourceReader(ctx context.Context) (rc io.ReadCloser, size int64, err error) {
+	if !f.localFileCache {
+		size = int64(f.src.Size)
+		rc, err = f.openReader(ctx)
 		if err != nil {
 			err = fmt.Errorf("openReader Error: %w", err)
-			return err
 		}
+		return
+	}
+
+	err = f.ensureCachedContent(ctx)
+	if err != nil {
+		err = fmt.Errorf("ensureCachedContent: %w", err)
+		return
+	}
+
+	sr, err := f.cached.CacheFile.Stat()
+	if err != nil {
+		err = fmt.Errorf("Stat: %w", err)
+		return
+	}
+
+	size = sr.Size
+	rc = ioutil.NopCloser(io.NewSectionReader(f.cached.CacheFile, 0, size))
+	return
+}
+
+// Ensure that f.content holds a mutable copy of the file's contents.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) ensureContent(ctx context.Context) (err error) {
+	f.lastUsed = f.mtimeClock.Now()
+	if f.content != nil {
+		return
+	}
 
-		tf, err := f.contentCache.NewTempFile(rc)
+	rc, size, err := f.openSourceReader(ctx)
+	if err != nil {
+		err = fmt.Errorf("openSourceReader: %w", err)
+		return
+	}
+
+	tf, err := f.contentCache.NewTempFile(rc, size)
+	if err != nil {
+		rc.Close()
+		err = fmt.Errorf("NewTempFile: %w", err)
+		return
+	}
+
+	// Hydrate the copy now rather than lazily, so that it doesn't depend on the
+	// cache lease staying around.
+	if _, err = tf.Stat(); err != nil {
+		tf.Destroy()
+		err = fmt.Errorf("Stat: %w", err)
+		return
+	}
+
+	// Update state.
+	f.content = tf
+	return
+}
+
+// Like ensureContent, but if f.content is nil start from an empty copy rather
+// than the source contents, for callers about to discard them.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) ensureEmptyContent() (err error) {
+	f.lastUsed = f.mtimeClock.Now()
+	if f.content != nil {
+		return
+	}
+
+	tf, err := f.contentCache.NewTempFile(ioutil.NopCloser(strings.NewReader("")), 0)
+	if err != nil {
+		err = fmt.Errorf("NewTempFile: %w", err)
+		return
+	}
+
+	f.content = tf
+	return
+}
+
+// Like ensureContent, but if f.content is nil don't fetch the source contents
+// until they are read, for callers about to modify them. Parts of the source
+// that are overwritten or truncated away before then are never fetched.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) ensureSparseContent(ctx context.Context) (err error) {
+	f.lastUsed = f.mtimeClock.Now()
+	if f.content != nil {
+		return
+	}
+
+	// The cached copy is local, so there's nothing to save by waiting.
+	if f.localFileCache {
+		err = f.ensureContent(ctx)
+		return
+	}
+
+	src := f.src
+	fetch := func(ctx context.Context, offset int64, n int64) (io.ReadCloser, error) {
+		rc, err := f.bucket.NewReader(
+			ctx,
+			&gcs.ReadObjectRequest{
+				Name:       src.Name,
+				Generation: src.Generation,
+				Range: &gcs.ByteRange{
+					Start: uint64(offset),
+					Limit: uint64(offset + n),
+				},
+			})
 		if err != nil {
-			err = fmt.Errorf("NewTempFile: %w", err)
-			return err
+			err = fmt.Errorf("NewReader: %w", err)
 		}
-		// Update state.
-		f.content = tf
+		return rc, err
 	}
 
+	tf, err := f.contentCache.NewSparseTempFile(int64(src.Size), fetch)
+	if err != nil {
+		err = fmt.Errorf("NewSparseTempFile: %w", err)
+		return
+	}
+
+	f.content = tf
 	return
 }
 
@@ -291,6 +535,14 @@ func (f *FileInode) SourceGenerationIsAuthoritative() bool {
 	return f.content == nil
 }
 
+// Whether reads of the clean source generation should be served by f.Read
+// from the local file cache rather than directly from GCS.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) ServesCachedReads() bool {
+	return f.cached != nil
+}
+
 // Equivalent to the generation returned by f.Source().
 //
 // LOCKS_REQUIRED(f)
@@ -315,14 +567,54 @@ func (f *FileInode) DecrementLookupCount(n uint64) (destroy bool) {
 func (f *FileInode) Destroy() (err error) {
 	f.destroyed = true
 	if f.localFileCache {
-		cacheObjectKey := &contentcache.CacheObjectKey{BucketName: f.bucket.Name(), ObjectName: f.name.objectName}
-		f.contentCache.Remove(cacheObjectKey)
-	} else if f.content != nil {
+		// Give up our lease and drop the cache entry, unless another inode for
+		// the same name is still reading it.
+		f.releaseCachedContent()
+		f.contentCache.Evict(f.cacheObjectKey())
+	}
+
+	if f.content != nil {
 		f.content.Destroy()
+		f.content = nil
+		f.pendingAtime = nil
 	}
 	return
 }
 
+// Give up any local content that merely mirrors the source generation, so
+// that it doesn't outlive the file's open handles. Dirty content is kept until
+// it has been synced. Returns whether anything was released.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) ReleaseCleanContent() (released bool) {
+	if f.destroyed {
+		return
+	}
+
+	if f.cached != nil {
+		f.releaseCachedContent()
+		released = true
+	}
+
+	if f.content != nil {
+		sr, err := f.content.Stat()
+		if err == nil && sr.Mtime == nil {
+			f.content.Destroy()
+			f.content = nil
+			released = true
+		}
+	}
+
+	return
+}
+
+// The last time the local content of this inode was read or written.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) LastUsed() time.Time {
+	return f.lastUsed
+}
+
 // LOCKS_REQUIRED(f.mu)
 func (f *FileInode) Attributes(
 	ctx context.Context) (attrs fuseops.InodeAttributes, err error) {
@@ -354,6 +646,19 @@ func (f *FileInode) Attributes(
 		}
 	}
 
+	// An atime set explicitly takes precedence.
+	if formatted, ok := f.src.Metadata[FileAtimeMetadataKey]; ok {
+		attrs.Atime, err = time.Parse(time.RFC3339Nano, formatted)
+		if err != nil {
+			err = fmt.Errorf("time.Parse(%q): %w", formatted, err)
+			return
+		}
+	}
+
+	if f.pendingAtime != nil {
+		attrs.Atime = *f.pendingAtime
+	}
+
 	// If we've got local content, its size and (maybe) mtime take precedence.
 	if f.content != nil {
 		var sr gcsx.StatResult
@@ -369,9 +674,23 @@ func (f *FileInode) Attributes(
 		}
 	}
 
+	// A pinned inode names a generation rather than whatever currently backs
+	// the object, so it is never clobbered.
+	if f.pinnedGeneration != 0 {
+		attrs.Mode &^= 0222
+		attrs.Nlink = 1
+		return
+	}
+
 	// If the object has been clobbered, we reflect that as the inode being
-	// unlinked.
+	// unlinked. When offline that can't be known, so assume not, as for the
+	// cached contents.
 	_, clobbered, err := f.clobbered(ctx, false)
+	if err != nil && f.contentCache.ServeWhenOffline() && gcsx.IsUnavailable(err) {
+		err = nil
+		clobbered = false
+	}
+
 	if err != nil {
 		err = fmt.Errorf("clobbered: %w", err)
 		return
@@ -384,6 +703,24 @@ func (f *FileInode) Attributes(
 	return
 }
 
+// Pin the inode to the generation of its source object. A pinned inode serves
+// that generation for as long as GCS keeps it, appears read-only, and must not
+// be modified. Must be called before the inode is shared.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) Pin() {
+	f.pinnedGeneration = f.src.Generation
+
+	// The local file cache keeps only the latest generation of each object.
+	f.localFileCache = false
+}
+
+// PinnedGeneration returns the generation to which Pin pinned the inode, or
+// zero if it isn't pinned.
+func (f *FileInode) PinnedGeneration() int64 {
+	return f.pinnedGeneration
+}
+
 func (f *FileInode) Bucket() gcsx.SyncerBucket {
 	return f.bucket
 }
@@ -398,15 +735,36 @@ func (f *FileInode) Read(
 	ctx context.Context,
 	dst []byte,
 	offset int64) (n int, err error) {
-	// Make sure f.content != nil.
-	err = f.ensureContent(ctx)
-	if err != nil {
-		err = fmt.Errorf("ensureContent: %w", err)
-		return
+	// Serve clean reads from the cached copy if we have one, and everything
+	// else from the mutable content.
+	var r io.ReaderAt
+	if f.content == nil && f.localFileCache {
+		err = f.ensureCachedContent(ctx)
+		if err != nil {
+			err = fmt.Errorf("ensureCachedContent: %w", err)
+			return
+		}
+
+		r = f.cached.CacheFile
+	} else {
+		err = f.ensureContent(ctx)
+		if err != nil {
+			err = fmt.Errorf("ensureContent: %w", err)
+			return
+		}
+
+		// Fetch any source contents not yet written over.
+		err = f.content.Fill(ctx, offset, int64(len(dst)))
+		if err != nil {
+			err = fmt.Errorf("Fill: %w", err)
+			return
+		}
+
+		r = f.content
 	}
 
 	// Read from the local content, propagating io.EOF.
-	n, err = f.content.ReadAt(dst, offset)
+	n, err = r.ReadAt(dst, offset)
 	switch {
 	case err == io.EOF:
 		return
@@ -427,9 +785,9 @@ func (f *FileInode) Write(
 	data []byte,
 	offset int64) (err error) {
 	// Make sure f.content != nil.
-	err = f.ensureContent(ctx)
+	err = f.ensureSparseContent(ctx)
 	if err != nil {
-		err = fmt.Errorf("ensureContent: %w", err)
+		err = fmt.Errorf("ensureSparseContent: %w", err)
 		return
 	}
 
@@ -446,6 +804,22 @@ func (f *FileInode) Write(
 func (f *FileInode) SetMtime(
 	ctx context.Context,
 	mtime time.Time) (err error) {
+	err = f.SetTimes(ctx, nil, &mtime)
+	return
+}
+
+// Set the atime and/or mtime for this file, leaving alone whichever is nil.
+// May involve a round trip to GCS.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) SetTimes(
+	ctx context.Context,
+	atime *time.Time,
+	mtime *time.Time) (err error) {
+	if atime == nil && mtime == nil {
+		return
+	}
+
 	// If we have a local temp file, stat it.
 	var sr gcsx.StatResult
 	if f.content != nil {
@@ -456,28 +830,56 @@ func (f *FileInode) SetMtime(
 		}
 	}
 
-	// If the local content is dirty, simply update its mtime and return. This
+	// If the local content is dirty, simply update its times and return. This
 	// will cause the object in the bucket to be updated once we sync. If we lose
-	// power or something the mtime update will be lost, but so will the file
-	// data modifications so this doesn't seem so bad. It's worth saving the
-	// round trip to GCS for the common case of Linux writeback caching, where we
+	// power or something the update will be lost, but so will the file data
+	// modifications so this doesn't seem so bad. It's worth saving the round
+	// trip to GCS for the common case of Linux writeback caching, where we
 	// always receive a setattr request just before a flush of a dirty file.
 	if sr.Mtime != nil {
-		f.content.SetMtime(mtime)
+		if mtime != nil {
+			f.content.SetMtime(*mtime)
+		}
+
+		if atime != nil {
+			t := *atime
+			f.pendingAtime = &t
+		}
+
 		return
 	}
 
 	// Otherwise, update the backing object's metadata.
-	formatted := mtime.UTC().Format(time.RFC3339Nano)
-	srcGen := f.SourceGeneration()
+	metadata := make(map[string]*string)
+	if mtime != nil {
+		formatted := mtime.UTC().Format(time.RFC3339Nano)
+		metadata[FileMtimeMetadataKey] = &formatted
+	}
 
+	if atime != nil {
+		formatted := atime.UTC().Format(time.RFC3339Nano)
+		metadata[FileAtimeMetadataKey] = &formatted
+	}
+
+	err = f.UpdateMetadata(ctx, metadata)
+	return
+}
+
+// Update custom metadata of the backing object, leaving alone keys not in the
+// map and removing those mapped to nil. This involves a round trip to GCS even
+// if the contents are dirty; the metadata is carried over when they are
+// synced.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) UpdateMetadata(
+	ctx context.Context,
+	metadata map[string]*string) (err error) {
+	srcGen := f.SourceGeneration()
 	req := &gcs.UpdateObjectRequest{
 		Name:                       f.src.Name,
 		Generation:                 srcGen.Object,
 		MetaGenerationPrecondition: &srcGen.Metadata,
-		Metadata: map[string]*string{
-			FileMtimeMetadataKey: &formatted,
-		},
+		Metadata:                   metadata,
 	}
 
 	o, err := f.bucket.UpdateObject(ctx, req)
@@ -506,9 +908,128 @@ func (f *FileInode) SetMtime(
 	return
 }
 
+// Return the access control list of the backing object, fetched afresh from
+// GCS. An inode that has been clobbered has an empty list.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) Acl(
+	ctx context.Context) (acl []*storagev1.ObjectAccessControl, err error) {
+	o, clobbered, err := f.clobbered(ctx, true)
+	if err != nil || clobbered {
+		return
+	}
+
+	acl = o.Acl
+	return
+}
+
+// Replace the access control list of the backing object in place, leaving
+// its generation and contents alone. Local modifications not yet synced are
+// unaffected, but syncing them later writes an object with the bucket's
+// default ACL. Fails with ENOTSUP if the bucket can't set object ACLs, as
+// with uniform bucket-level access.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) SetAcl(
+	ctx context.Context,
+	acl []*storagev1.ObjectAccessControl) (err error) {
+	if f.bucket.AclSetter == nil {
+		err = fmt.Errorf("bucket can't set object ACLs: %w", syscall.ENOTSUP)
+		return
+	}
+
+	srcGen := f.SourceGeneration()
+	err = f.bucket.AclSetter.SetObjectAcl(
+		ctx,
+		&storage.SetObjectAclRequest{
+			Name:                       f.src.Name,
+			Generation:                 srcGen.Object,
+			MetaGenerationPrecondition: &srcGen.Metadata,
+			Acl:                        acl,
+		})
+	if err != nil {
+		err = fmt.Errorf("SetObjectAcl: %w", err)
+		return
+	}
+
+	// Pick up the new meta-generation, bypassing any cached record of the old
+	// one.
+	o, err := f.bucket.StatObject(
+		ctx,
+		&gcs.StatObjectRequest{
+			Name:              f.src.Name,
+			ForceFetchFromGcs: true,
+		})
+	if err != nil {
+		err = fmt.Errorf("StatObject: %w", err)
+		return
+	}
+
+	if o.Generation == srcGen.Object {
+		f.src = *o
+	}
+
+	return
+}
+
+// Whether the inode stands for a file created while GCS was unavailable,
+// whose object is yet to be created by Sync. Its source object has generation
+// zero.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) unborn() bool {
+	return f.src.Generation == 0
+}
+
+// Create the object for an unborn file with its local contents, failing
+// without effect with *gcs.PreconditionError if an object with the name has
+// appeared meanwhile.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) createObject(ctx context.Context) (o *gcs.Object, err error) {
+	sr, err := f.content.Stat()
+	if err != nil {
+		err = fmt.Errorf("Stat: %w", err)
+		return
+	}
+
+	err = f.content.Fill(ctx, 0, sr.Size)
+	if err != nil {
+		err = fmt.Errorf("Fill: %w", err)
+		return
+	}
+
+	_, err = f.content.Seek(0, 0)
+	if err != nil {
+		err = fmt.Errorf("Seek: %w", err)
+		return
+	}
+
+	mtime := f.mtimeClock.Now()
+	if sr.Mtime != nil {
+		mtime = *sr.Mtime
+	}
+
+	// There is no meta-generation to match for an object that doesn't exist.
+	req := gcsx.ReplaceObjectRequest(&f.src, mtime.UTC(), f.content)
+	req.MetaGenerationPrecondition = nil
+	if f.pendingAtime != nil {
+		req.Metadata[FileAtimeMetadataKey] = f.pendingAtime.UTC().Format(time.RFC3339Nano)
+	}
+
+	o, err = f.bucket.CreateObject(ctx, req)
+	if err != nil {
+		err = fmt.Errorf("CreateObject: %w", err)
+		return
+	}
+
+	return
+}
+
 // Sync writes out contents to GCS. If this fails due to the generation having been
 // clobbered, treat it as a non-error (simulating the inode having been
-// unlinked).
+// unlinked). An unborn file is clobbered if an object with its name has
+// appeared since it was created.
 //
 // After this method succeeds, SourceGeneration will return the new generation
 // by which this inode should be known (which may be the same as before). If it
@@ -521,6 +1042,44 @@ func (f *FileInode) Sync(ctx context.Context) (err error) {
 		return
 	}
 
+	// An unborn file has no object yet to stat or sync against.
+	var newObj *gcs.Object
+	if f.unborn() {
+		newObj, err = f.createObject(ctx)
+	} else {
+		newObj, err = f.syncObject(ctx)
+	}
+
+	// Special case: a precondition error means we were clobbered, which we treat
+	// as being unlinked. There's no reason to return an error in that case.
+	var preconditionErr *gcs.PreconditionError
+	if errors.As(err, &preconditionErr) {
+		err = nil
+		return
+	}
+
+	if err != nil {
+		return
+	}
+
+	// If we wrote out a new object, we need to update our state. Any clean
+	// cached copy is of the old generation, so let it go too.
+	if newObj != nil {
+		f.src = *newObj
+		f.content.Destroy()
+		f.content = nil
+		f.pendingAtime = nil
+		f.releaseCachedContent()
+	}
+
+	return
+}
+
+// Sync the local contents against the latest generation of the source object,
+// returning the new object if one was written.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) syncObject(ctx context.Context) (newObj *gcs.Object, err error) {
 	// When listObjects call is made, we fetch data with projection set as noAcl
 	// which means acls and owner properties are not returned. So the f.src object
 	// here will not have acl information even though there are acls present on
@@ -537,32 +1096,29 @@ func (f *FileInode) Sync(ctx context.Context) (err error) {
 		return
 	}
 
-	// Write out the contents if they are dirty.
-	// Object properties are also synced as part of content sync. Hence, passing
-	// the latest object fetched from gcs which has all the properties populated.
-	newObj, err := f.bucket.SyncObject(ctx, latestGcsObj, f.content)
+	// Carry a staged atime over to the new object. The object may be shared
+	// with a stat cache, so modify a copy.
+	if f.pendingAtime != nil {
+		withAtime := *latestGcsObj
+		withAtime.Metadata = make(map[string]string)
+		for k, v := range latestGcsObj.Metadata {
+			withAtime.Metadata[k] = v
+		}
 
-	// Special case: a precondition error means we were clobbered, which we treat
-	// as being unlinked. There's no reason to return an error in that case.
-	var preconditionErr *gcs.PreconditionError
-	if errors.As(err, &preconditionErr) {
-		err = nil
-		return
+		withAtime.Metadata[FileAtimeMetadataKey] =
+			f.pendingAtime.UTC().Format(time.RFC3339Nano)
+		latestGcsObj = &withAtime
 	}
 
-	// Propagate other errors.
+	// Write out the contents if they are dirty.
+	// Object properties are also synced as part of content sync. Hence, passing
+	// the latest object fetched from gcs which has all the properties populated.
+	newObj, err = f.bucket.SyncObject(ctx, latestGcsObj, f.content)
 	if err != nil {
 		err = fmt.Errorf("SyncObject: %w", err)
 		return
 	}
 
-	// If we wrote out a new object, we need to update our state.
-	if newObj != nil && !f.localFileCache {
-		f.src = *newObj
-		f.content.Destroy()
-		f.content = nil
-	}
-
 	return
 }
 
@@ -572,8 +1128,14 @@ func (f *FileInode) Sync(ctx context.Context) (err error) {
 func (f *FileInode) Truncate(
 	ctx context.Context,
 	size int64) (err error) {
-	// Make sure f.content != nil.
-	err = f.ensureContent(ctx)
+	// Make sure f.content != nil. When the result will be empty, as for an open
+	// with O_TRUNC, there's no need to fetch the source contents first.
+	if size == 0 {
+		err = f.ensureEmptyContent()
+	} else {
+		err = f.ensureSparseContent(ctx)
+	}
+
 	if err != nil {
 		err = fmt.Errorf("ensureContent: %w", err)
 		return
@@ -585,10 +1147,50 @@ func (f *FileInode) Truncate(
 	return
 }
 
-// Ensures cache content on read if content cache enabled
+// Truncate the file to zero bytes. If there are no local modifications, this
+// writes an empty generation of the object straight away rather than making
+// local content to sync later, so it suits files that aren't open for writing.
+// As with Sync, if the object has been clobbered this does nothing.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) Clear(ctx context.Context) (err error) {
+	if f.content != nil {
+		err = f.Truncate(ctx, 0)
+		return
+	}
+
+	req := gcsx.ReplaceObjectRequest(
+		&f.src,
+		f.mtimeClock.Now().UTC(),
+		strings.NewReader(""))
+
+	o, err := f.bucket.CreateObject(ctx, req)
+
+	// Special case: a precondition error means we were clobbered, which we treat
+	// as being unlinked.
+	var preconditionErr *gcs.PreconditionError
+	if errors.As(err, &preconditionErr) {
+		err = nil
+		return
+	}
+
+	if err != nil {
+		err = fmt.Errorf("CreateObject: %w", err)
+		return
+	}
+
+	f.src = *o
+	f.releaseCachedContent()
+	return
+}
+
+// Ensures cache content on read if content cache enabled and the inode is
+// clean.
+//
+// LOCKS_REQUIRED(f.mu)
 func (f *FileInode) CacheEnsureContent(ctx context.Context) (err error) {
-	if f.localFileCache {
-		err = f.ensureContent(ctx)
+	if f.localFileCache && f.content == nil {
+		err = f.ensureCachedContent(ctx)
 	}
 
 	return
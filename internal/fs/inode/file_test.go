@@ -15,10 +15,12 @@
 package inode_test
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"strconv"
+	"syscall"
 	"testing"
 	"time"
 
@@ -27,12 +29,14 @@ import (
 	"github.com/googlecloudplatform/gcsfuse/internal/contentcache"
 	"github.com/googlecloudplatform/gcsfuse/internal/fs/inode"
 	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/googlecloudplatform/gcsfuse/internal/storage"
 	"github.com/jacobsa/fuse/fuseops"
 	"github.com/jacobsa/gcloud/gcs"
 	"github.com/jacobsa/gcloud/gcs/gcsfake"
 	"github.com/jacobsa/gcloud/gcs/gcsutil"
 	. "github.com/jacobsa/ogletest"
 	"github.com/jacobsa/timeutil"
+	storagev1 "google.golang.org/api/storage/v1"
 )
 
 func TestFile(t *testing.T) { RunTests(t) }
@@ -55,6 +59,8 @@ type FileTest struct {
 
 	initialContents string
 	backingObj      *gcs.Object
+	localFileCache  bool
+	aclSetter       storage.AclSetter
 
 	in *inode.FileInode
 }
@@ -98,6 +104,12 @@ func (t *FileTest) createInode() {
 		inode.NewRootName(""),
 		t.backingObj.Name,
 	)
+	syncerBucket := gcsx.NewSyncerBucket(
+		1, // Append threshold
+		".gcsfuse_tmp/",
+		t.bucket)
+	syncerBucket.AclSetter = t.aclSetter
+
 	t.in = inode.NewFileInode(
 		fileInodeID,
 		name,
@@ -107,17 +119,38 @@ func (t *FileTest) createInode() {
 			Gid:  gid,
 			Mode: fileMode,
 		},
-		gcsx.NewSyncerBucket(
-			1, // Append threshold
-			".gcsfuse_tmp/",
-			t.bucket),
-		false, // localFileCache
+		syncerBucket,
+		t.localFileCache,
 		contentcache.New("", &t.clock),
 		&t.clock)
 
 	t.in.Lock()
 }
 
+// An AclSetter that records requests, standing in for an ACL patch by
+// bumping the object's meta-generation.
+type fakeAclSetter struct {
+	bucket gcs.Bucket
+	reqs   []storage.SetObjectAclRequest
+}
+
+func (s *fakeAclSetter) SetObjectAcl(
+	ctx context.Context,
+	req *storage.SetObjectAclRequest) (err error) {
+	s.reqs = append(s.reqs, *req)
+
+	lang := "en"
+	_, err = s.bucket.UpdateObject(
+		ctx,
+		&gcs.UpdateObjectRequest{
+			Name:                       req.Name,
+			Generation:                 req.Generation,
+			MetaGenerationPrecondition: req.MetaGenerationPrecondition,
+			ContentLanguage:            &lang,
+		})
+	return
+}
+
 ////////////////////////////////////////////////////////////////////////
 // Tests
 ////////////////////////////////////////////////////////////////////////
@@ -376,6 +409,109 @@ func (t *FileTest) WriteThenSync() {
 	ExpectThat(attrs.Mtime, timeutil.TimeEq(writeTime.UTC()))
 }
 
+func (t *FileTest) WriteThenSync_LocalFileCache() {
+	var err error
+
+	t.localFileCache = true
+	t.createInode()
+	defer t.in.Destroy()
+
+	// Reading leases a clean cached copy without dirtying the inode.
+	err = t.in.CacheEnsureContent(t.ctx)
+	AssertEq(nil, err)
+
+	ExpectTrue(t.in.ServesCachedReads())
+	ExpectTrue(t.in.SourceGenerationIsAuthoritative())
+
+	// Writing creates a separate mutable copy.
+	err = t.in.Write(t.ctx, []byte("p"), 0)
+	AssertEq(nil, err)
+
+	ExpectFalse(t.in.SourceGenerationIsAuthoritative())
+
+	var buf [1024]byte
+	n, err := t.in.Read(t.ctx, buf[:], 0)
+	if err == io.EOF {
+		err = nil
+	}
+
+	AssertEq(nil, err)
+	ExpectEq("paco", string(buf[:n]))
+
+	// Sync. Both copies should be released.
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
+
+	ExpectTrue(t.in.SourceGenerationIsAuthoritative())
+	ExpectFalse(t.in.ServesCachedReads())
+
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, t.in.Name().GcsObjectName())
+	AssertEq(nil, err)
+	ExpectEq("paco", string(contents))
+
+	// Reading again caches the new generation.
+	n, err = t.in.Read(t.ctx, buf[:], 0)
+	if err == io.EOF {
+		err = nil
+	}
+
+	AssertEq(nil, err)
+	ExpectEq("paco", string(buf[:n]))
+	ExpectTrue(t.in.ServesCachedReads())
+}
+
+func (t *FileTest) ReleaseCleanContent_LocalFileCache() {
+	var err error
+
+	t.localFileCache = true
+	t.createInode()
+	defer t.in.Destroy()
+
+	err = t.in.CacheEnsureContent(t.ctx)
+	AssertEq(nil, err)
+	AssertTrue(t.in.ServesCachedReads())
+
+	// Releasing gives up the clean cached copy.
+	t.in.ReleaseCleanContent()
+	ExpectFalse(t.in.ServesCachedReads())
+}
+
+func (t *FileTest) ReleaseCleanContent_Dirty() {
+	var err error
+
+	err = t.in.Write(t.ctx, []byte("p"), 0)
+	AssertEq(nil, err)
+
+	// Dirty content must survive until it is synced.
+	t.in.ReleaseCleanContent()
+	ExpectFalse(t.in.SourceGenerationIsAuthoritative())
+
+	var buf [1024]byte
+	n, err := t.in.Read(t.ctx, buf[:], 0)
+	if err == io.EOF {
+		err = nil
+	}
+
+	AssertEq(nil, err)
+	ExpectEq("paco", string(buf[:n]))
+}
+
+func (t *FileTest) LastUsed() {
+	var err error
+
+	ExpectTrue(t.in.LastUsed().IsZero())
+
+	// Writing uses the local content.
+	t.clock.AdvanceTime(time.Second)
+	writeTime := t.clock.Now()
+
+	err = t.in.Write(t.ctx, []byte("p"), 0)
+	AssertEq(nil, err)
+
+	t.clock.AdvanceTime(time.Second)
+	ExpectThat(t.in.LastUsed(), timeutil.TimeEq(writeTime))
+}
+
 func (t *FileTest) AppendThenSync() {
 	var attrs fuseops.InodeAttributes
 	var err error
@@ -464,6 +600,156 @@ func (t *FileTest) TruncateDownwardThenSync() {
 	ExpectThat(attrs.Mtime, timeutil.TimeEq(truncateTime.UTC()))
 }
 
+func (t *FileTest) OverwriteInFull_DoesntReadSource() {
+	var err error
+
+	AssertEq("taco", t.initialContents)
+
+	// Make the source contents unreadable.
+	err = t.bucket.DeleteObject(
+		t.ctx,
+		&gcs.DeleteObjectRequest{Name: t.in.Name().GcsObjectName()})
+
+	AssertEq(nil, err)
+
+	// Overwriting every byte should still work.
+	err = t.in.Write(t.ctx, []byte("burrito"), 0)
+	AssertEq(nil, err)
+
+	buf := make([]byte, 1024)
+	n, err := t.in.Read(t.ctx, buf, 0)
+	if err == io.EOF {
+		err = nil
+	}
+
+	AssertEq(nil, err)
+	ExpectEq("burrito", string(buf[:n]))
+}
+
+func (t *FileTest) PartialWrite_FetchesRestOnRead() {
+	var err error
+
+	AssertEq("taco", t.initialContents)
+
+	err = t.in.Write(t.ctx, []byte("p"), 0)
+	AssertEq(nil, err)
+
+	// Only the unwritten bytes come from the source.
+	buf := make([]byte, 1024)
+	n, err := t.in.Read(t.ctx, buf, 0)
+	if err == io.EOF {
+		err = nil
+	}
+
+	AssertEq(nil, err)
+	ExpectEq("paco", string(buf[:n]))
+}
+
+func (t *FileTest) TruncateToZero_DoesntReadSource() {
+	var err error
+
+	// Make the source contents unreadable.
+	err = t.bucket.DeleteObject(
+		t.ctx,
+		&gcs.DeleteObjectRequest{Name: t.in.Name().GcsObjectName()})
+
+	AssertEq(nil, err)
+
+	// Truncating to zero should still work.
+	err = t.in.Truncate(t.ctx, 0)
+	AssertEq(nil, err)
+
+	attrs, err := t.in.Attributes(t.ctx)
+	AssertEq(nil, err)
+	ExpectEq(0, attrs.Size)
+}
+
+func (t *FileTest) TruncateToZeroThenSync() {
+	var err error
+
+	err = t.in.Truncate(t.ctx, 0)
+	AssertEq(nil, err)
+
+	// Sync.
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
+
+	// The generation should have advanced.
+	ExpectLt(t.backingObj.Generation, t.in.SourceGeneration().Object)
+
+	// Check the bucket.
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, t.in.Name().GcsObjectName())
+	AssertEq(nil, err)
+	ExpectEq("", string(contents))
+}
+
+func (t *FileTest) Clear_Clean() {
+	var err error
+
+	t.clock.AdvanceTime(time.Second)
+	clearTime := t.clock.Now()
+
+	err = t.in.Clear(t.ctx)
+	AssertEq(nil, err)
+
+	// A new generation should have been written directly.
+	ExpectLt(t.backingObj.Generation, t.in.SourceGeneration().Object)
+	ExpectTrue(t.in.SourceGenerationIsAuthoritative())
+
+	statReq := &gcs.StatObjectRequest{Name: t.in.Name().GcsObjectName()}
+	o, err := t.bucket.StatObject(t.ctx, statReq)
+
+	AssertEq(nil, err)
+	ExpectEq(t.in.SourceGeneration().Object, o.Generation)
+	ExpectEq(0, o.Size)
+	ExpectEq(
+		clearTime.UTC().Format(time.RFC3339Nano),
+		o.Metadata["gcsfuse_mtime"])
+}
+
+func (t *FileTest) Clear_Dirty() {
+	var err error
+
+	err = t.in.Write(t.ctx, []byte("burrito"), 0)
+	AssertEq(nil, err)
+
+	err = t.in.Clear(t.ctx)
+	AssertEq(nil, err)
+
+	// Nothing should have been written yet.
+	ExpectEq(t.backingObj.Generation, t.in.SourceGeneration().Object)
+
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
+
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, t.in.Name().GcsObjectName())
+	AssertEq(nil, err)
+	ExpectEq("", string(contents))
+}
+
+func (t *FileTest) Clear_Clobbered() {
+	var err error
+
+	// Replace the backing object.
+	newObj, err := gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		t.in.Name().GcsObjectName(),
+		[]byte("burrito"))
+
+	AssertEq(nil, err)
+
+	// Clearing should do nothing.
+	err = t.in.Clear(t.ctx)
+	AssertEq(nil, err)
+
+	ExpectEq(t.backingObj.Generation, t.in.SourceGeneration().Object)
+
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, newObj.Name)
+	AssertEq(nil, err)
+	ExpectEq("burrito", string(contents))
+}
+
 func (t *FileTest) TruncateUpwardThenSync() {
 	var attrs fuseops.InodeAttributes
 	var err error
@@ -538,6 +824,75 @@ func (t *FileTest) Sync_Clobbered() {
 	ExpectEq(newObj.Size, o.Size)
 }
 
+func (t *FileTest) Sync_Unborn() {
+	var err error
+
+	// An inode for a file whose object is yet to be created.
+	t.backingObj = &gcs.Object{Name: "foo/baz"}
+	t.createInode()
+
+	err = t.in.Truncate(t.ctx, 0)
+	AssertEq(nil, err)
+
+	err = t.in.Write(t.ctx, []byte("taco"), 0)
+	AssertEq(nil, err)
+
+	attrs, err := t.in.Attributes(t.ctx)
+	AssertEq(nil, err)
+	ExpectEq(1, attrs.Nlink)
+
+	// Sync. The object should be created.
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
+
+	statReq := &gcs.StatObjectRequest{Name: "foo/baz"}
+	o, err := t.bucket.StatObject(t.ctx, statReq)
+
+	AssertEq(nil, err)
+	ExpectEq(o.Generation, t.in.SourceGeneration().Object)
+	ExpectTrue(t.in.SourceGenerationIsAuthoritative())
+
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, "foo/baz")
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+}
+
+func (t *FileTest) Sync_UnbornClobbered() {
+	var err error
+
+	t.backingObj = &gcs.Object{Name: "foo/baz"}
+	t.createInode()
+
+	err = t.in.Truncate(t.ctx, 0)
+	AssertEq(nil, err)
+
+	// Create an object with the name meanwhile.
+	newObj, err := gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		"foo/baz",
+		[]byte("burrito"))
+
+	AssertEq(nil, err)
+
+	// Sync. The call should succeed, but nothing should change.
+	err = t.in.Sync(t.ctx)
+
+	AssertEq(nil, err)
+	ExpectEq(0, t.in.SourceGeneration().Object)
+
+	statReq := &gcs.StatObjectRequest{Name: "foo/baz"}
+	o, err := t.bucket.StatObject(t.ctx, statReq)
+
+	AssertEq(nil, err)
+	ExpectEq(newObj.Generation, o.Generation)
+
+	// The file is shown as unlinked.
+	attrs, err := t.in.Attributes(t.ctx)
+	AssertEq(nil, err)
+	ExpectEq(0, attrs.Nlink)
+}
+
 func (t *FileTest) SetMtime_ContentNotFaultedIn() {
 	var err error
 	var attrs fuseops.InodeAttributes
@@ -628,6 +983,82 @@ func (t *FileTest) SetMtime_ContentDirty() {
 		o.Metadata["gcsfuse_mtime"])
 }
 
+func (t *FileTest) SetTimes_ContentClean() {
+	var err error
+	var attrs fuseops.InodeAttributes
+
+	// Set both times.
+	atime := time.Now().UTC().Add(-123 * time.Second)
+	mtime := time.Now().UTC().Add(123 * time.Second)
+
+	err = t.in.SetTimes(t.ctx, &atime, &mtime)
+	AssertEq(nil, err)
+
+	// The inode should agree about the new times.
+	attrs, err = t.in.Attributes(t.ctx)
+
+	AssertEq(nil, err)
+	ExpectThat(attrs.Atime, timeutil.TimeEq(atime))
+	ExpectThat(attrs.Mtime, timeutil.TimeEq(mtime))
+
+	// Both should have been added to the backing object's metadata at once.
+	statReq := &gcs.StatObjectRequest{Name: t.in.Name().GcsObjectName()}
+	o, err := t.bucket.StatObject(t.ctx, statReq)
+
+	AssertEq(nil, err)
+	ExpectEq(2, o.MetaGeneration)
+	ExpectEq(
+		atime.UTC().Format(time.RFC3339Nano),
+		o.Metadata["gcsfuse_atime"])
+	ExpectEq(
+		mtime.UTC().Format(time.RFC3339Nano),
+		o.Metadata["gcsfuse_mtime"])
+}
+
+func (t *FileTest) SetTimes_ContentDirty() {
+	var err error
+	var attrs fuseops.InodeAttributes
+
+	// Dirty the content.
+	err = t.in.Write(t.ctx, []byte("a"), 0)
+	AssertEq(nil, err)
+
+	// Set atime only.
+	atime := time.Now().UTC().Add(-123 * time.Second)
+
+	err = t.in.SetTimes(t.ctx, &atime, nil)
+	AssertEq(nil, err)
+
+	// The inode should agree about the new atime.
+	attrs, err = t.in.Attributes(t.ctx)
+
+	AssertEq(nil, err)
+	ExpectThat(attrs.Atime, timeutil.TimeEq(atime))
+
+	// Nothing has been written yet.
+	statReq := &gcs.StatObjectRequest{Name: t.in.Name().GcsObjectName()}
+	o, err := t.bucket.StatObject(t.ctx, statReq)
+
+	AssertEq(nil, err)
+	ExpectEq("", o.Metadata["gcsfuse_atime"])
+
+	// Sync. Now the object in the bucket should have the atime.
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
+
+	o, err = t.bucket.StatObject(t.ctx, statReq)
+
+	AssertEq(nil, err)
+	ExpectEq(
+		atime.UTC().Format(time.RFC3339Nano),
+		o.Metadata["gcsfuse_atime"])
+
+	attrs, err = t.in.Attributes(t.ctx)
+
+	AssertEq(nil, err)
+	ExpectThat(attrs.Atime, timeutil.TimeEq(atime))
+}
+
 func (t *FileTest) SetMtime_SourceObjectGenerationChanged() {
 	var err error
 
@@ -681,3 +1112,45 @@ func (t *FileTest) SetMtime_SourceObjectMetaGenerationChanged() {
 	ExpectEq(newObj.Generation, o.Generation)
 	ExpectEq(newObj.MetaGeneration, o.MetaGeneration)
 }
+
+func (t *FileTest) SetAcl_NotSupported() {
+	acl := []*storagev1.ObjectAccessControl{{Entity: "allUsers", Role: "READER"}}
+	err := t.in.SetAcl(t.ctx, acl)
+	ExpectTrue(errors.Is(err, syscall.ENOTSUP), "err: %v", err)
+
+	// The object in the bucket should not have been changed.
+	statReq := &gcs.StatObjectRequest{Name: t.in.Name().GcsObjectName()}
+	o, err := t.bucket.StatObject(t.ctx, statReq)
+
+	AssertEq(nil, err)
+	ExpectEq(t.backingObj.Generation, o.Generation)
+	ExpectEq(t.backingObj.MetaGeneration, o.MetaGeneration)
+}
+
+func (t *FileTest) SetAcl() {
+	setter := &fakeAclSetter{bucket: t.bucket}
+	t.aclSetter = setter
+	t.createInode()
+
+	acl := []*storagev1.ObjectAccessControl{{Entity: "allUsers", Role: "READER"}}
+	err := t.in.SetAcl(t.ctx, acl)
+	AssertEq(nil, err)
+
+	// The ACL should have been set on the source generation, guarded by its
+	// meta-generation.
+	AssertEq(1, len(setter.reqs))
+	req := setter.reqs[0]
+	ExpectEq(t.backingObj.Name, req.Name)
+	ExpectEq(t.backingObj.Generation, req.Generation)
+	AssertNe(nil, req.MetaGenerationPrecondition)
+	ExpectEq(t.backingObj.MetaGeneration, *req.MetaGenerationPrecondition)
+	AssertEq(1, len(req.Acl))
+	ExpectEq("allUsers", req.Acl[0].Entity)
+	ExpectEq("READER", req.Acl[0].Role)
+
+	// The inode should have picked up the new meta-generation without a new
+	// generation.
+	sg := t.in.SourceGeneration()
+	ExpectEq(t.backingObj.Generation, sg.Object)
+	ExpectEq(t.backingObj.MetaGeneration+1, sg.Metadata)
+}
@@ -0,0 +1,130 @@
+// Copyright 2022 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inode
+
+import (
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/monitor"
+	"github.com/jacobsa/util/lrucache"
+)
+
+type listingCacheEntry struct {
+	expiry time.Time
+	file   *Core
+	dir    *Core
+}
+
+// A cache that maps from a child name to the records for that name seen in a
+// recent listing of the directory, so that the lookups that typically follow
+// a readdir can be answered without another round trip to GCS. Only positive
+// results are cached: a name missing from the cache may still exist.
+//
+// Must be created with newListingCache. May be contained in a larger struct.
+// External synchronization is required.
+type listingCache struct {
+	/////////////////////////
+	// Constant data
+	/////////////////////////
+
+	ttl time.Duration
+
+	/////////////////////////
+	// Mutable state
+	/////////////////////////
+
+	// A cache mapping names to the cache entry.
+	//
+	// INVARIANT: entries.CheckInvariants() does not panic
+	// INVARIANT: Each value is of type listingCacheEntry
+	entries lrucache.Cache
+
+	// Follows the occupancy of entries, to count what it evicts.
+	evictions *monitor.EvictionCounter
+}
+
+// Create a cache whose information expires with the supplied TTL. If the TTL
+// is zero, nothing will ever be cached.
+func newListingCache(capacity int, ttl time.Duration) listingCache {
+	return listingCache{
+		ttl:       ttl,
+		entries:   lrucache.New(capacity),
+		evictions: monitor.NewEvictionCounter(monitor.ListingCache, capacity),
+	}
+}
+
+////////////////////////////////////////////////////////////////////////
+// Public interface
+////////////////////////////////////////////////////////////////////////
+
+// Panic if any internal invariants have been violated. The careful user can
+// arrange to call this at crucial moments.
+func (lc *listingCache) CheckInvariants() {
+	lc.entries.CheckInvariants()
+}
+
+// Insert records that the listing issued at the given time returned the
+// supplied record for the name. A file and a directory record for the same
+// name are kept side by side.
+func (lc *listingCache) Insert(listedAt time.Time, name string, c *Core) {
+	// Are we disabled?
+	if lc.ttl == 0 {
+		return
+	}
+
+	var entry listingCacheEntry
+	val := lc.entries.LookUp(name)
+	if val != nil {
+		entry = val.(listingCacheEntry)
+	}
+
+	entry.expiry = listedAt.Add(lc.ttl)
+	if c.FullName.IsDir() {
+		entry.dir = c
+	} else {
+		entry.file = c
+	}
+
+	lc.entries.Insert(name, entry)
+	lc.evictions.Inserted(val != nil)
+}
+
+// Erase erases all information about the supplied name.
+func (lc *listingCache) Erase(name string) {
+	lc.evictions.Erased(lc.entries.LookUp(name) != nil)
+	lc.entries.Erase(name)
+}
+
+// Get gets the records for the given name, with ok false if there are none
+// that are fresh.
+func (lc *listingCache) Get(now time.Time, name string) (file *Core, dir *Core, ok bool) {
+	val := lc.entries.LookUp(name)
+	if val == nil {
+		monitor.RecordCacheMiss(monitor.ListingCache)
+		return
+	}
+
+	entry := val.(listingCacheEntry)
+
+	// Has the entry expired?
+	if entry.expiry.Before(now) {
+		lc.Erase(name)
+		monitor.RecordCacheMiss(monitor.ListingCache)
+		return
+	}
+
+	monitor.RecordCacheHit(monitor.ListingCache)
+	return entry.file, entry.dir, true
+}
@@ -17,6 +17,7 @@ package inode
 import (
 	"time"
 
+	"github.com/googlecloudplatform/gcsfuse/internal/monitor"
 	"github.com/jacobsa/util/lrucache"
 )
 
@@ -51,14 +52,18 @@ type typeCache struct {
 	// INVARIANT: entries.CheckInvariants() does not panic
 	// INVARIANT: Each value is of type cacheEntry
 	entries lrucache.Cache
+
+	// Follows the occupancy of entries, to count what it evicts.
+	evictions *monitor.EvictionCounter
 }
 
 // Create a cache whose information expires with the supplied TTL. If the TTL
 // is zero, nothing will ever be cached.
 func newTypeCache(perTypeCapacity int, ttl time.Duration) typeCache {
 	return typeCache{
-		ttl:     ttl,
-		entries: lrucache.New(perTypeCapacity),
+		ttl:       ttl,
+		entries:   lrucache.New(perTypeCapacity),
+		evictions: monitor.NewEvictionCounter(monitor.TypeCache, perTypeCapacity),
 	}
 }
 
@@ -78,14 +83,17 @@ func (tc *typeCache) Insert(now time.Time, name string, it Type) {
 	if tc.ttl == 0 {
 		return
 	}
+	present := tc.entries.LookUp(name) != nil
 	tc.entries.Insert(name, cacheEntry{
 		expiry:    now.Add(tc.ttl),
 		inodeType: it,
 	})
+	tc.evictions.Inserted(present)
 }
 
 // Erase erases all information about the supplied name.
 func (tc *typeCache) Erase(name string) {
+	tc.evictions.Erased(tc.entries.LookUp(name) != nil)
 	tc.entries.Erase(name)
 }
 
@@ -93,6 +101,7 @@ func (tc *typeCache) Erase(name string) {
 func (tc *typeCache) Get(now time.Time, name string) Type {
 	val := tc.entries.LookUp(name)
 	if val == nil {
+		monitor.RecordCacheMiss(monitor.TypeCache)
 		return UnknownType
 	}
 
@@ -100,8 +109,10 @@ func (tc *typeCache) Get(now time.Time, name string) Type {
 
 	// Has the entry expired?
 	if entry.expiry.Before(now) {
-		tc.entries.Erase(name)
+		tc.Erase(name)
+		monitor.RecordCacheMiss(monitor.TypeCache)
 		return UnknownType
 	}
+	monitor.RecordCacheHit(monitor.TypeCache)
 	return entry.inodeType
 }
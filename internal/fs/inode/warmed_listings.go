@@ -0,0 +1,188 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inode
+
+import (
+	"strings"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/jacobsa/gcloud/gcs"
+)
+
+type warmedListing struct {
+	listedAt time.Time
+	children []*Core
+}
+
+// WarmedListings holds the children of every directory in a tree, as seen in
+// one recursive listing, until inodes for the directories exist to take them.
+// The caches of a directory inode live only as long as the kernel remembers
+// the inode, so this is how a listing of a whole tree warms every level of it.
+// See DirInode.PrimeCaches.
+//
+// Must be created with NewWarmedListings. External synchronization is
+// required.
+type WarmedListings struct {
+	/////////////////////////
+	// Constant data
+	/////////////////////////
+
+	ttl time.Duration
+
+	/////////////////////////
+	// Mutable state
+	/////////////////////////
+
+	// The children of each directory, keyed by the directory's local name.
+	dirs map[string]warmedListing
+}
+
+// NewWarmedListings creates an empty collection whose listings expire with
+// the supplied TTL, as the caches of directory inodes do. If the TTL is zero,
+// nothing is ever kept.
+func NewWarmedListings(ttl time.Duration) *WarmedListings {
+	return &WarmedListings{
+		ttl:  ttl,
+		dirs: make(map[string]warmedListing),
+	}
+}
+
+// InsertTree records the children of root and of each directory beneath it
+// among the supplied objects, which a recursive listing of root issued at the
+// given time returned. If implicitDirs is set, directories implied by the
+// objects beneath them are recorded as well, as LookUpChild would find them.
+// Whatever was recorded before for the same directories is replaced.
+func (w *WarmedListings) InsertTree(
+	listedAt time.Time,
+	bucket gcsx.SyncerBucket,
+	root Name,
+	objects []*gcs.Object,
+	implicitDirs bool) {
+	// Are we disabled?
+	if w.ttl == 0 {
+		return
+	}
+
+	// Drop what has expired, so that listings don't pile up.
+	for k, l := range w.dirs {
+		if l.listedAt.Add(w.ttl).Before(listedAt) {
+			delete(w.dirs, k)
+		}
+	}
+
+	children := make(map[Name]map[Name]*Core)
+	add := func(parent Name, c *Core) {
+		if children[parent] == nil {
+			children[parent] = make(map[Name]*Core)
+		}
+
+		// Prefer an explicit directory to the one its contents imply.
+		if children[parent][c.FullName] != nil && c.Object == nil {
+			return
+		}
+
+		children[parent][c.FullName] = c
+	}
+
+	prefix := root.GcsObjectName()
+	for _, o := range objects {
+		if o.Name == prefix || !strings.HasPrefix(o.Name, prefix) {
+			continue
+		}
+
+		// Walk down from the root, noting each directory on the way.
+		parent := root
+		rest := strings.TrimPrefix(o.Name, prefix)
+		for {
+			i := strings.IndexByte(rest, '/')
+			if i < 0 {
+				add(parent, &Core{
+					Bucket:   bucket,
+					FullName: NewFileName(parent, rest),
+					Object:   o,
+				})
+				break
+			}
+
+			// Skip names with empty components, which LookUpChild can't reach.
+			if i == 0 {
+				break
+			}
+
+			dirName := NewDirName(parent, rest[:i+1])
+			if i == len(rest)-1 {
+				add(parent, &Core{
+					Bucket:   bucket,
+					FullName: dirName,
+					Object:   o,
+				})
+				break
+			}
+
+			if implicitDirs {
+				add(parent, &Core{
+					Bucket:   bucket,
+					FullName: dirName,
+					Object:   nil,
+				})
+			}
+
+			parent = dirName
+			rest = rest[i+1:]
+		}
+	}
+
+	for dir, m := range children {
+		l := warmedListing{listedAt: listedAt}
+		for _, c := range m {
+			l.children = append(l.children, c)
+		}
+
+		w.dirs[dir.LocalName()] = l
+	}
+}
+
+// Take returns the children recorded for the named directory and forgets
+// them, with ok false if there are none that are fresh.
+func (w *WarmedListings) Take(
+	now time.Time,
+	dir Name) (listedAt time.Time, children []*Core, ok bool) {
+	l, ok := w.dirs[dir.LocalName()]
+	if !ok {
+		return
+	}
+
+	delete(w.dirs, dir.LocalName())
+	if l.listedAt.Add(w.ttl).Before(now) {
+		ok = false
+		return
+	}
+
+	listedAt = l.listedAt
+	children = l.children
+	return
+}
+
+// EraseTree forgets what is recorded for the named directory and for every
+// directory beneath it, for use when the tree is removed or renamed.
+func (w *WarmedListings) EraseTree(dir Name) {
+	prefix := dir.LocalName()
+	for k := range w.dirs {
+		if strings.HasPrefix(k, prefix) {
+			delete(w.dirs, k)
+		}
+	}
+}
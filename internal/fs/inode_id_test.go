@@ -0,0 +1,101 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"testing"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/fs/inode"
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+func TestAllocateInodeIDWrapsAroundSkippingLiveIDs(t *testing.T) {
+	fs := &fileSystem{
+		inodes: map[fuseops.InodeID]inode.Inode{
+			fuseops.RootInodeID:     nil,
+			fuseops.RootInodeID + 1: nil,
+			9:                       nil,
+		},
+		nextInodeID:      9,
+		maxInodeID:       10,
+		inodeGenerations: make(map[fuseops.InodeID]fuseops.GenerationNumber),
+	}
+
+	// 9 is live, so 10 is next, after which the IDs start again from the
+	// bottom, skipping the live ones.
+	expected := []fuseops.InodeID{10, 3, 4}
+	for _, e := range expected {
+		if id := fs.allocateInodeID(); id != e {
+			t.Errorf("got %v, expected %v", id, e)
+		}
+	}
+
+	// The IDs handed out after the wraparound carry a new generation.
+	expectedGenerations := map[fuseops.InodeID]fuseops.GenerationNumber{
+		10: 0,
+		3:  1,
+		4:  1,
+	}
+
+	for id, e := range expectedGenerations {
+		if gen := fs.inodeGenerations[id]; gen != e {
+			t.Errorf("inode %v: got generation %v, expected %v", id, gen, e)
+		}
+	}
+}
+
+func TestInodeGenerationIncreasesWithEachWraparound(t *testing.T) {
+	fs := &fileSystem{
+		inodes: map[fuseops.InodeID]inode.Inode{
+			fuseops.RootInodeID: nil,
+		},
+		nextInodeID:      2,
+		maxInodeID:       2,
+		inodeGenerations: make(map[fuseops.InodeID]fuseops.GenerationNumber),
+	}
+
+	// The only free ID is handed out again and again, as if the inode holding
+	// it were forgotten each time, with a new generation after the first.
+	for e := fuseops.GenerationNumber(0); e < 3; e++ {
+		id := fs.allocateInodeID()
+		if id != 2 {
+			t.Fatalf("got %v, expected 2", id)
+		}
+
+		if gen := fs.inodeGenerations[id]; gen != e {
+			t.Errorf("got generation %v, expected %v", gen, e)
+		}
+	}
+}
+
+func TestAllocateInodeIDPanicsWhenAllIDsAreLive(t *testing.T) {
+	fs := &fileSystem{
+		inodes: map[fuseops.InodeID]inode.Inode{
+			fuseops.RootInodeID: nil,
+			2:                   nil,
+			3:                   nil,
+		},
+		nextInodeID: 2,
+		maxInodeID:  3,
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic")
+		}
+	}()
+
+	fs.allocateInodeID()
+}
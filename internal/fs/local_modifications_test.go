@@ -33,6 +33,7 @@ import (
 	"unicode"
 	"unicode/utf8"
 
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
 	"github.com/jacobsa/fuse/fusetesting"
 	"github.com/jacobsa/gcloud/gcs"
 	"github.com/jacobsa/gcloud/gcs/gcsutil"
@@ -1278,13 +1279,13 @@ func (t *DirectoryTest) CreateHardLink() {
 	err = ioutil.WriteFile(path.Join(t.mfs.Dir(), "foo"), []byte(""), 0700)
 	AssertEq(nil, err)
 
-	// Attempt to hard link it. We don't support doing so.
+	// Attempt to hard link it. We don't support doing so by default.
 	err = os.Link(
 		path.Join(t.mfs.Dir(), "foo"),
 		path.Join(t.mfs.Dir(), "bar"))
 
 	AssertNe(nil, err)
-	ExpectThat(err, Error(HasSubstr("not implemented")))
+	ExpectThat(err, Error(HasSubstr("not supported")))
 }
 
 func (t *DirectoryTest) Chmod() {
@@ -2395,6 +2396,14 @@ func (t *RenameTest) DirectoryContainingFiles() {
 	err = os.Rename(oldPath, newPath)
 	AssertEq(nil, err)
 
+	// The manifest recording the rename has been removed.
+	manifests, _, err := gcsutil.ListAll(
+		t.ctx,
+		t.bucket,
+		&gcs.ListObjectsRequest{Prefix: gcsx.RenameManifestPrefix})
+	AssertEq(nil, err)
+	ExpectEq(0, len(manifests))
+
 	// File count exceeds the limit.
 	file := fmt.Sprintf("%s/%d.txt", newPath, t.serverCfg.RenameDirLimit)
 	err = ioutil.WriteFile(file, []byte("taco"), 0400)
@@ -0,0 +1,78 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs_test
+
+import (
+	"os"
+	"path"
+	"syscall"
+
+	. "github.com/jacobsa/ogletest"
+)
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type MaxFileSizeTest struct {
+	fsTest
+}
+
+func init() { RegisterTestSuite(&MaxFileSizeTest{}) }
+
+func (t *MaxFileSizeTest) SetUp(ti *TestInfo) {
+	t.serverCfg.MaxFileSize = 8
+	t.fsTest.SetUp(ti)
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *MaxFileSizeTest) Write() {
+	f, err := os.Create(path.Join(t.Dir, "foo"))
+	AssertEq(nil, err)
+	defer f.Close()
+
+	_, err = f.Write([]byte("taco"))
+	AssertEq(nil, err)
+
+	// Writing up to the limit is fine, but not past it.
+	_, err = f.WriteAt([]byte("TACO"), 4)
+	AssertEq(nil, err)
+
+	_, err = f.WriteAt([]byte("!"), 8)
+	ExpectEq(syscall.EFBIG, err.(*os.PathError).Err)
+
+	fi, err := f.Stat()
+	AssertEq(nil, err)
+	ExpectEq(8, fi.Size())
+}
+
+func (t *MaxFileSizeTest) Truncate() {
+	f, err := os.Create(path.Join(t.Dir, "foo"))
+	AssertEq(nil, err)
+	defer f.Close()
+
+	err = f.Truncate(8)
+	AssertEq(nil, err)
+
+	err = f.Truncate(9)
+	ExpectEq(syscall.EFBIG, err.(*os.PathError).Err)
+
+	fi, err := f.Stat()
+	AssertEq(nil, err)
+	ExpectEq(8, fi.Size())
+}
@@ -0,0 +1,60 @@
+// Copyright 2022 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"fmt"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/fs/inode"
+	"golang.org/x/net/context"
+)
+
+// Modes for what mkdir creates in GCS.
+const (
+	// Create a zero-byte "dir/" placeholder object immediately.
+	MkdirModeImmediate = "immediate"
+
+	// Create nothing until something is created within the directory, at which
+	// point the placeholder object is created.
+	MkdirModeDeferred = "deferred"
+
+	// Never create a placeholder object. The directory is known only locally
+	// until objects are created beneath it, after which it is seen in GCS as an
+	// implicit directory (see --implicit-dirs).
+	MkdirModeLocal = "local"
+)
+
+// In deferred mkdir mode, create the placeholder object for a directory that
+// lacks one before anything is created within it.
+//
+// LOCKS_EXCLUDED(parent)
+func (fs *fileSystem) materializeDeferredDir(
+	ctx context.Context,
+	parent inode.DirInode) (err error) {
+	if fs.mkdirMode != MkdirModeDeferred {
+		return
+	}
+
+	parent.Lock()
+	err = parent.Materialize(ctx)
+	parent.Unlock()
+
+	if err != nil {
+		err = fmt.Errorf("Materialize: %w", err)
+		return
+	}
+
+	return
+}
@@ -0,0 +1,349 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/fs/inode"
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/googlecloudplatform/gcsfuse/internal/logger"
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// The name of the manifest listing the writes saved in an offline queue
+// directory. The contents of each file are saved alongside it.
+const offlineQueueManifest = "queue.json"
+
+// How much of a file's contents to copy at a time when saving or replaying
+// it.
+const offlineQueueChunkSize = 1 << 20
+
+// A queued write saved across unmounts, as recorded in the manifest.
+type savedWrite struct {
+	// The name of the bucket holding the object.
+	Bucket string `json:"bucket"`
+
+	// The object from which the local contents were branched. Its generation
+	// is the precondition for uploading them, so that changes made in GCS
+	// meanwhile are detected as conflicts. Generation zero means the object is
+	// yet to be created.
+	Source gcs.Object `json:"source"`
+
+	Mtime time.Time `json:"mtime"`
+
+	// The file in the queue directory holding the contents.
+	Contents string `json:"contents"`
+}
+
+// Save the contents of files still queued to be synced to fs.offlineQueueDir,
+// so that they can be replayed by the next mount. Files whose contents can't
+// be saved are logged and discarded.
+//
+// LOCKS_EXCLUDED(fs.mu)
+func (fs *fileSystem) saveOfflineQueue(ctx context.Context) (saved int, err error) {
+	var files []*inode.FileInode
+
+	fs.mu.Lock()
+	for _, p := range fs.pendingSyncs {
+		files = append(files, p.f)
+	}
+	fs.mu.Unlock()
+
+	if len(files) == 0 {
+		return
+	}
+
+	err = os.MkdirAll(fs.offlineQueueDir, 0700)
+	if err != nil {
+		err = fmt.Errorf("MkdirAll: %w", err)
+		return
+	}
+
+	var writes []savedWrite
+	for i, f := range files {
+		f.Lock()
+		w, saveErr := fs.saveQueuedWrite(ctx, f, fmt.Sprintf("%d.contents", i))
+		name := f.Name().GcsObjectName()
+		f.Unlock()
+
+		if saveErr != nil {
+			logger.Infof("Discarding queued write to %q: %v\n", name, saveErr)
+			continue
+		}
+
+		writes = append(writes, w)
+	}
+
+	// Write the manifest last, and atomically, so that a partly saved queue is
+	// never replayed.
+	b, err := json.Marshal(writes)
+	if err != nil {
+		err = fmt.Errorf("Marshal: %w", err)
+		return
+	}
+
+	tmp := filepath.Join(fs.offlineQueueDir, offlineQueueManifest+".tmp")
+	err = ioutil.WriteFile(tmp, b, 0600)
+	if err != nil {
+		err = fmt.Errorf("WriteFile: %w", err)
+		return
+	}
+
+	err = os.Rename(tmp, filepath.Join(fs.offlineQueueDir, offlineQueueManifest))
+	if err != nil {
+		err = fmt.Errorf("Rename: %w", err)
+		return
+	}
+
+	saved = len(writes)
+	return
+}
+
+// Save the dirty contents of the file to the named file in the queue
+// directory.
+//
+// LOCKS_REQUIRED(f)
+func (fs *fileSystem) saveQueuedWrite(
+	ctx context.Context,
+	f *inode.FileInode,
+	contents string) (w savedWrite, err error) {
+	attrs, err := f.Attributes(ctx)
+	if err != nil {
+		err = fmt.Errorf("Attributes: %w", err)
+		return
+	}
+
+	out, err := os.OpenFile(
+		filepath.Join(fs.offlineQueueDir, contents),
+		os.O_WRONLY|os.O_CREATE|os.O_TRUNC,
+		0600)
+	if err != nil {
+		err = fmt.Errorf("OpenFile: %w", err)
+		return
+	}
+	defer out.Close()
+
+	// Parts of the source not yet fetched can't be saved while GCS is
+	// unavailable, in which case Read fails.
+	buf := make([]byte, offlineQueueChunkSize)
+	for offset := int64(0); offset < int64(attrs.Size); {
+		var n int
+		n, err = f.Read(ctx, buf, offset)
+		if err != nil && err != io.EOF {
+			err = fmt.Errorf("Read: %w", err)
+			return
+		}
+
+		if n == 0 {
+			err = fmt.Errorf("Read: unexpected EOF at offset %d", offset)
+			return
+		}
+
+		if _, err = out.Write(buf[:n]); err != nil {
+			err = fmt.Errorf("Write: %w", err)
+			return
+		}
+
+		offset += int64(n)
+	}
+
+	err = out.Close()
+	if err != nil {
+		err = fmt.Errorf("Close: %w", err)
+		return
+	}
+
+	w = savedWrite{
+		Bucket:   f.Bucket().Name(),
+		Source:   *f.Source(),
+		Mtime:    attrs.Mtime,
+		Contents: contents,
+	}
+
+	return
+}
+
+// Load the writes saved by an earlier mount into local contents of the
+// corresponding files and queue them to be synced, then clear the queue
+// directory. Writes that can't be replayed are logged and discarded.
+//
+// LOCKS_EXCLUDED(fs.mu)
+// LOCKS_EXCLUDED(root)
+func (fs *fileSystem) replayOfflineQueue(
+	ctx context.Context,
+	root inode.DirInode) (replayed int, err error) {
+	b, err := ioutil.ReadFile(filepath.Join(fs.offlineQueueDir, offlineQueueManifest))
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+		return
+	}
+
+	if err != nil {
+		err = fmt.Errorf("ReadFile: %w", err)
+		return
+	}
+
+	var writes []savedWrite
+	err = json.Unmarshal(b, &writes)
+	if err != nil {
+		err = fmt.Errorf("Unmarshal: %w", err)
+		return
+	}
+
+	for _, w := range writes {
+		replayErr := fs.replaySavedWrite(ctx, root, w)
+		if replayErr != nil {
+			logger.Infof("Discarding queued write to %q: %v\n", w.Source.Name, replayErr)
+			continue
+		}
+
+		replayed++
+	}
+
+	// The replayed contents are now held by the file inodes.
+	err = os.RemoveAll(fs.offlineQueueDir)
+	if err != nil {
+		err = fmt.Errorf("RemoveAll: %w", err)
+		return
+	}
+
+	return
+}
+
+// Find the bucket in which a saved write belongs, and the name of its root
+// within the file system.
+//
+// LOCKS_EXCLUDED(root)
+func savedWriteBucket(
+	ctx context.Context,
+	root inode.DirInode,
+	w savedWrite) (bucket gcsx.SyncerBucket, rootName inode.Name, err error) {
+	root.Lock()
+	defer root.Unlock()
+
+	// A file system for a single bucket.
+	if bucketOwned, ok := root.(inode.BucketOwnedDirInode); ok {
+		bucket = bucketOwned.Bucket()
+		rootName = root.Name()
+		if bucket.Name() != w.Bucket {
+			err = fmt.Errorf("saved for bucket %q, not %q", w.Bucket, bucket.Name())
+		}
+
+		return
+	}
+
+	// A file system for all buckets, with a directory for each.
+	core, err := root.LookUpChild(ctx, w.Bucket)
+	if err != nil {
+		err = fmt.Errorf("LookUpChild: %w", err)
+		return
+	}
+
+	bucket = core.Bucket
+	rootName = core.FullName
+	return
+}
+
+// LOCKS_EXCLUDED(fs.mu)
+// LOCKS_EXCLUDED(root)
+func (fs *fileSystem) replaySavedWrite(
+	ctx context.Context,
+	root inode.DirInode,
+	w savedWrite) (err error) {
+	bucket, rootName, err := savedWriteBucket(ctx, root, w)
+	if err != nil {
+		return
+	}
+
+	in, err := os.Open(filepath.Join(fs.offlineQueueDir, w.Contents))
+	if err != nil {
+		err = fmt.Errorf("Open: %w", err)
+		return
+	}
+	defer in.Close()
+
+	src := w.Source
+	core := inode.Core{
+		Bucket:   bucket,
+		FullName: inode.NewDescendantName(rootName, src.Name),
+		Object:   &src,
+	}
+
+	child := fs.lookUpOrCreateInodeIfNotStale(core)
+	if child == nil {
+		err = errors.New("a newer generation is already known")
+		return
+	}
+
+	f, ok := child.(*inode.FileInode)
+	if !ok {
+		fs.unlockAndDecrementLookupCount(child, 1)
+		err = fmt.Errorf("not a file: %T", child)
+		return
+	}
+
+	// Release our lookup count once done, leaving the queue's.
+	defer fs.unlockAndDecrementLookupCount(f, 1)
+
+	// Replace whatever the source holds with the saved contents.
+	err = f.Truncate(ctx, 0)
+	if err != nil {
+		err = fmt.Errorf("Truncate: %w", err)
+		return
+	}
+
+	buf := make([]byte, offlineQueueChunkSize)
+	var size int64
+	for {
+		n, readErr := in.Read(buf)
+		if n > 0 {
+			err = f.Write(ctx, buf[:n], size)
+			if err != nil {
+				err = fmt.Errorf("Write: %w", err)
+				return
+			}
+
+			size += int64(n)
+		}
+
+		if readErr == io.EOF {
+			break
+		}
+
+		if readErr != nil {
+			err = fmt.Errorf("Read: %w", readErr)
+			return
+		}
+	}
+
+	err = f.SetMtime(ctx, w.Mtime)
+	if err != nil {
+		err = fmt.Errorf("SetMtime: %w", err)
+		return
+	}
+
+	fs.noteDirtyFile(f)
+	fs.queuePendingSync(f, size, -1)
+	return
+}
@@ -0,0 +1,236 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+// A bucket whose calls fail as if GCS were unreachable while down is set.
+type unreachableBucket struct {
+	gcs.Bucket
+	down bool
+}
+
+func (b *unreachableBucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (io.ReadCloser, error) {
+	if b.down {
+		return nil, gcsx.ErrCircuitOpen
+	}
+	return b.Bucket.NewReader(ctx, req)
+}
+
+func (b *unreachableBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (*gcs.Object, error) {
+	if b.down {
+		return nil, gcsx.ErrCircuitOpen
+	}
+	return b.Bucket.CreateObject(ctx, req)
+}
+
+func (b *unreachableBucket) ComposeObjects(
+	ctx context.Context,
+	req *gcs.ComposeObjectsRequest) (*gcs.Object, error) {
+	if b.down {
+		return nil, gcsx.ErrCircuitOpen
+	}
+	return b.Bucket.ComposeObjects(ctx, req)
+}
+
+func (b *unreachableBucket) StatObject(
+	ctx context.Context,
+	req *gcs.StatObjectRequest) (*gcs.Object, error) {
+	if b.down {
+		return nil, gcsx.ErrCircuitOpen
+	}
+	return b.Bucket.StatObject(ctx, req)
+}
+
+func (b *unreachableBucket) ListObjects(
+	ctx context.Context,
+	req *gcs.ListObjectsRequest) (*gcs.Listing, error) {
+	if b.down {
+		return nil, gcsx.ErrCircuitOpen
+	}
+	return b.Bucket.ListObjects(ctx, req)
+}
+
+type singleBucketManager struct {
+	bucket gcs.Bucket
+}
+
+func (bm *singleBucketManager) SetUpBucket(
+	ctx context.Context,
+	name string) (sb gcsx.SyncerBucket, err error) {
+	sb = gcsx.NewSyncerBucket(1, ".gcsfuse_tmp/", bm.bucket)
+	return
+}
+
+func (bm *singleBucketManager) ShutDown() {}
+
+// Mount a file system over the bucket in offline mode, queueing writes in
+// queueDir.
+func newOfflineFileSystem(
+	t *testing.T,
+	bucket gcs.Bucket,
+	queueDir string) (fs *fileSystem) {
+	server, err := NewFileSystem(context.Background(), &ServerConfig{
+		CacheClock:      timeutil.RealClock(),
+		BucketManager:   &singleBucketManager{bucket: bucket},
+		BucketName:      bucket.Name(),
+		TempDir:         t.TempDir(),
+		FilePerms:       0644,
+		DirPerms:        0755,
+		OfflineMode:     true,
+		OfflineQueueDir: queueDir,
+	})
+	if err != nil {
+		t.Fatalf("NewFileSystem: %v", err)
+	}
+
+	return server.(*fileSystem)
+}
+
+// Write to the file and fsync it.
+func writeAndSync(
+	t *testing.T,
+	fs *fileSystem,
+	id fuseops.InodeID,
+	contents string) {
+	ctx := context.Background()
+	err := fs.WriteFile(ctx, &fuseops.WriteFileOp{
+		Inode: id,
+		Data:  []byte(contents),
+	})
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	if err = fs.SyncFile(ctx, &fuseops.SyncFileOp{Inode: id}); err != nil {
+		t.Fatalf("SyncFile: %v", err)
+	}
+}
+
+func expectContents(
+	t *testing.T,
+	bucket gcs.Bucket,
+	name string,
+	expected string) {
+	contents, err := gcsutil.ReadObject(context.Background(), bucket, name)
+	if err != nil {
+		t.Errorf("ReadObject(%q): %v", name, err)
+		return
+	}
+
+	if string(contents) != expected {
+		t.Errorf("%q: got %q, expected %q", name, contents, expected)
+	}
+}
+
+// Queue a write to an existing object and the creation of a new one while GCS
+// is unreachable, then unmount, returning the bucket and the queue directory.
+func queueWritesAndUnmount(t *testing.T) (b *unreachableBucket, queueDir string) {
+	ctx := context.Background()
+	b = &unreachableBucket{
+		Bucket: gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket"),
+	}
+	queueDir = t.TempDir()
+
+	if _, err := gcsutil.CreateObject(ctx, b, "foo", []byte("taco")); err != nil {
+		t.Fatalf("CreateObject: %v", err)
+	}
+
+	fs := newOfflineFileSystem(t, b, queueDir)
+
+	// Look up the existing file while GCS is reachable, then lose it.
+	lookUpOp := &fuseops.LookUpInodeOp{Parent: fuseops.RootInodeID, Name: "foo"}
+	if err := fs.LookUpInode(ctx, lookUpOp); err != nil {
+		t.Fatalf("LookUpInode: %v", err)
+	}
+
+	b.down = true
+	writeAndSync(t, fs, lookUpOp.Entry.Child, "burrito")
+
+	createOp := &fuseops.CreateFileOp{
+		Parent: fuseops.RootInodeID,
+		Name:   "bar",
+		Mode:   0644,
+	}
+	if err := fs.CreateFile(ctx, createOp); err != nil {
+		t.Fatalf("CreateFile: %v", err)
+	}
+
+	writeAndSync(t, fs, createOp.Entry.Child, "enchilada")
+	fs.Destroy()
+
+	return
+}
+
+func TestOfflineQueueIsReplayedByTheNextMount(t *testing.T) {
+	b, queueDir := queueWritesAndUnmount(t)
+
+	// Nothing reached GCS before the unmount.
+	b.down = false
+	expectContents(t, b, "foo", "taco")
+	if _, err := gcsutil.ReadObject(context.Background(), b, "bar"); err == nil {
+		t.Errorf("bar was created before GCS was reachable")
+	}
+
+	// The next mount uploads the saved writes, at the latest on unmount.
+	fs := newOfflineFileSystem(t, b, queueDir)
+	fs.Destroy()
+
+	expectContents(t, b, "foo", "burrito")
+	expectContents(t, b, "bar", "enchilada")
+
+	// They are replayed only once.
+	if _, err := os.Stat(queueDir); !os.IsNotExist(err) {
+		t.Errorf("queue directory still present: %v", err)
+	}
+}
+
+func TestOfflineQueueDetectsConflictsAcrossMounts(t *testing.T) {
+	ctx := context.Background()
+	b, queueDir := queueWritesAndUnmount(t)
+
+	// Someone else modifies foo and creates bar in the meantime.
+	b.down = false
+	if _, err := gcsutil.CreateObject(ctx, b, "foo", []byte("queso")); err != nil {
+		t.Fatalf("CreateObject: %v", err)
+	}
+
+	if _, err := gcsutil.CreateObject(ctx, b, "bar", []byte("salsa")); err != nil {
+		t.Fatalf("CreateObject: %v", err)
+	}
+
+	// The saved writes are based on older generations, so don't clobber them.
+	fs := newOfflineFileSystem(t, b, queueDir)
+	fs.Destroy()
+
+	expectContents(t, b, "foo", "queso")
+	expectContents(t, b, "bar", "salsa")
+}
@@ -0,0 +1,250 @@
+// Copyright 2022 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/fs/inode"
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/googlecloudplatform/gcsfuse/internal/logger"
+	"github.com/jacobsa/fuse"
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// Policies for when flushed writes are uploaded to GCS.
+const (
+	// Upload each flushed file before the flush returns.
+	WritePolicyWriteThrough = "write-through"
+
+	// Let flushes return immediately and upload in the background, up to a
+	// bound on the amount of dirty data waiting.
+	WritePolicyWriteBack = "write-back"
+)
+
+// How often to retry syncing files whose writes were queued while GCS was
+// unavailable.
+const offlineSyncInterval = 30 * time.Second
+
+// How often to upload files whose flushes were deferred by the write-back
+// policy.
+const writeBackInterval = 5 * time.Second
+
+// A dirty file waiting to be synced in the background.
+type pendingSync struct {
+	f *inode.FileInode
+
+	// The size of the file's contents when it was last queued.
+	size int64
+}
+
+// Queue a dirty file to be synced in the background, unless that would take
+// the total size of queued files over limit (if limit is non-negative). The
+// queue holds a lookup count on the inode so that its dirty contents outlive
+// the kernel forgetting about it.
+//
+// LOCKS_REQUIRED(f)
+// LOCKS_EXCLUDED(fs.mu)
+func (fs *fileSystem) queuePendingSync(
+	f *inode.FileInode,
+	size int64,
+	limit int64) (queued bool) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	prev, ok := fs.pendingSyncs[f.ID()]
+	if limit >= 0 && fs.pendingSyncBytes-prev.size+size > limit {
+		return
+	}
+
+	if !ok {
+		f.IncrementLookupCount()
+	}
+
+	fs.pendingSyncBytes += size - prev.size
+	fs.pendingSyncs[f.ID()] = pendingSync{f: f, size: size}
+	queued = true
+	return
+}
+
+// Create a file whose object can't be created while GCS is unavailable,
+// queueing it to be created along with queued writes. If an object with the
+// name has appeared by then, the file is treated as clobbered.
+//
+// Return the child locked, incrementing its lookup count.
+//
+// LOCKS_EXCLUDED(fs.mu)
+// LOCKS_EXCLUDED(parent)
+// LOCK_FUNCTION(child)
+func (fs *fileSystem) createUnbornFile(
+	ctx context.Context,
+	parent inode.DirInode,
+	name string) (child inode.Inode, err error) {
+	bucketOwned, ok := parent.(inode.BucketOwnedDirInode)
+	if !ok {
+		err = fmt.Errorf("not a bucket owned directory: %q", parent.Name())
+		return
+	}
+
+	// An object with generation zero is yet to be created.
+	fullName := inode.NewFileName(parent.Name(), name)
+	core := inode.Core{
+		FullName: fullName,
+		Bucket:   bucketOwned.Bucket(),
+		Object:   &gcs.Object{Name: fullName.GcsObjectName()},
+	}
+
+	// Any inode already known for the name is of a newer generation, so the
+	// file exists. So it does if we get back an unborn file created earlier,
+	// which has local contents already.
+	child = fs.lookUpOrCreateInodeIfNotStale(core)
+	if child == nil {
+		err = fuse.EEXIST
+		return
+	}
+
+	f := child.(*inode.FileInode)
+	if !f.SourceGenerationIsAuthoritative() {
+		fs.unlockAndDecrementLookupCount(f, 1)
+		child = nil
+		err = fuse.EEXIST
+		return
+	}
+
+	if err = f.Truncate(ctx, 0); err != nil {
+		fs.unlockAndDecrementLookupCount(f, 1)
+		child = nil
+		err = fmt.Errorf("Truncate: %w", err)
+		return
+	}
+
+	fs.noteDirtyFile(f)
+	fs.queuePendingSync(f, 0, -1)
+	return
+}
+
+// Try to sync each queued file, returning the number that remain queued
+// because GCS is unavailable.
+//
+// LOCKS_EXCLUDED(fs.mu)
+func (fs *fileSystem) syncPendingOnce(ctx context.Context) (remaining int) {
+	// Snapshot the queue, so that we don't hold the file system lock while
+	// acquiring inode locks.
+	var files []*inode.FileInode
+
+	fs.mu.Lock()
+	for _, p := range fs.pendingSyncs {
+		files = append(files, p.f)
+	}
+	fs.mu.Unlock()
+
+	for _, f := range files {
+		f.Lock()
+		before := f.SourceGeneration().Object
+		err := f.Sync(ctx)
+		fs.auditSync(0, f, before)
+		if gcsx.IsUnavailable(err) {
+			f.Unlock()
+			remaining++
+			continue
+		}
+
+		name := f.Name().GcsObjectName()
+		switch {
+		case err != nil:
+			logger.Infof("Giving up on queued write to %q: %v\n", name, err)
+
+		// Sync leaves the inode dirty if the object changed in GCS meanwhile.
+		case !f.SourceGenerationIsAuthoritative():
+			logger.Infof(
+				"Conflict syncing queued write to %q: the object was modified "+
+					"in GCS meanwhile; local changes were not uploaded.\n",
+				name)
+		}
+
+		fs.mu.Lock()
+		fs.pendingSyncBytes -= fs.pendingSyncs[f.ID()].size
+		delete(fs.pendingSyncs, f.ID())
+		fs.checkDirtyFile(f)
+		fs.mu.Unlock()
+
+		fs.unlockAndDecrementLookupCount(f, 1)
+	}
+
+	return
+}
+
+// Periodically sync queued files until the context is cancelled, then close
+// fs.syncingPendingDone.
+//
+// LOCKS_EXCLUDED(fs.mu)
+func (fs *fileSystem) syncPending(ctx context.Context, interval time.Duration) {
+	defer close(fs.syncingPendingDone)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+		}
+
+		fs.syncPendingOnce(ctx)
+	}
+}
+
+// Sync each queued file whose object name begins with the given prefix, so
+// that operations that copy objects in GCS see their latest contents.
+//
+// LOCKS_EXCLUDED(fs.mu)
+func (fs *fileSystem) flushPendingSyncs(
+	ctx context.Context,
+	prefix string) (err error) {
+	var files []*inode.FileInode
+
+	fs.mu.Lock()
+	for _, p := range fs.pendingSyncs {
+		if strings.HasPrefix(p.f.Name().GcsObjectName(), prefix) {
+			files = append(files, p.f)
+		}
+	}
+	fs.mu.Unlock()
+
+	// The queue still holds its lookup counts, and will drop the inodes once
+	// it finds them clean.
+	for _, f := range files {
+		f.Lock()
+		before := f.SourceGeneration().Object
+		err = f.Sync(ctx)
+		fs.auditSync(0, f, before)
+		if err == nil {
+			fs.forgetCleanFile(f)
+		}
+		f.Unlock()
+
+		if err != nil {
+			err = fmt.Errorf("Sync %q: %w", f.Name().GcsObjectName(), err)
+			return
+		}
+	}
+
+	return
+}
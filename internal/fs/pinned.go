@@ -0,0 +1,152 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/fs/inode"
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/googlecloudplatform/gcsfuse/internal/storage"
+	"github.com/jacobsa/fuse"
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// A file may be looked up as "name#generation" to get a read-only file pinned
+// to that generation of its object, e.g. one recorded earlier from the
+// user.gcsfuse.generation xattr. Reads through it never return another
+// generation's contents; once GCS no longer keeps the generation they fail.
+//
+// Such names are only tried when no file or directory has the name itself.
+// Noncurrent generations may be looked up in buckets with object versioning;
+// see pinnedObject.
+const pinnedGenerationSeparator = "#"
+
+// Split a name of the form "name#generation". ok is false if the name isn't
+// of that form.
+func parsePinnedName(name string) (base string, gen int64, ok bool) {
+	i := strings.LastIndex(name, pinnedGenerationSeparator)
+	if i <= 0 {
+		return
+	}
+
+	gen, err := strconv.ParseInt(name[i+1:], 10, 64)
+	if err != nil || gen <= 0 {
+		return
+	}
+
+	base = name[:i]
+	ok = true
+	return
+}
+
+// Look up a child named "name#generation" within the parent, returning a new
+// file inode pinned to that generation. Return ENOENT if the name isn't of
+// that form, or the file doesn't exist at that generation.
+//
+// Return the child locked, incrementing its lookup count.
+//
+// LOCKS_EXCLUDED(fs.mu)
+// LOCKS_EXCLUDED(parent)
+// LOCK_FUNCTION(child)
+func (fs *fileSystem) lookUpPinnedChildInode(
+	ctx context.Context,
+	parent inode.DirInode,
+	childName string) (child inode.Inode, err error) {
+	base, gen, ok := parsePinnedName(childName)
+	if !ok {
+		err = fuse.ENOENT
+		return
+	}
+
+	parent.Lock()
+	core, err := parent.LookUpChild(ctx, base)
+	parent.Unlock()
+
+	if err != nil {
+		err = fmt.Errorf("LookUpChild: %w", err)
+		return
+	}
+
+	if core == nil || core.FullName.IsDir() || inode.IsSymlink(core.Object) {
+		err = fuse.ENOENT
+		return
+	}
+
+	if core.Object.Generation != gen {
+		core.Object, err = pinnedObject(ctx, core.Bucket, core.Object, gen)
+		if err != nil {
+			err = fmt.Errorf("pinnedObject: %w", err)
+			return
+		}
+	}
+
+	// Pinned inodes are left out of the index, which holds the inode for the
+	// latest generation of each name.
+	fs.mu.Lock()
+	f := fs.mintInode(*core).(*inode.FileInode)
+	f.Lock()
+	f.Pin()
+	f.IncrementLookupCount()
+	fs.mu.Unlock()
+
+	child = f
+	return
+}
+
+// Return a record for the given noncurrent generation of the object whose
+// current generation is described by current, or ENOENT if GCS doesn't keep
+// it or the bucket can't be asked about noncurrent generations.
+func pinnedObject(
+	ctx context.Context,
+	bucket gcsx.SyncerBucket,
+	current *gcs.Object,
+	gen int64) (o *gcs.Object, err error) {
+	if bucket.GenerationStatter == nil {
+		err = fmt.Errorf(
+			"cannot stat noncurrent generations of %q: %w",
+			current.Name,
+			fuse.ENOENT)
+		return
+	}
+
+	o, err = bucket.GenerationStatter.StatObjectGeneration(
+		ctx,
+		&storage.StatObjectGenerationRequest{
+			Name:       current.Name,
+			Generation: gen,
+		})
+
+	var notFoundErr *gcs.NotFoundError
+	if errors.As(err, &notFoundErr) {
+		err = fmt.Errorf(
+			"%q has no generation %d: %w",
+			current.Name,
+			gen,
+			fuse.ENOENT)
+		return
+	}
+
+	if err != nil {
+		err = fmt.Errorf("StatObjectGeneration: %w", err)
+		return
+	}
+
+	return
+}
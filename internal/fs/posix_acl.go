@@ -0,0 +1,457 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/fs/inode"
+	"golang.org/x/net/context"
+	storagev1 "google.golang.org/api/storage/v1"
+)
+
+// When ServerConfig.ACLPrincipals is set, the ACL of the object backing a file
+// is exposed as a POSIX ACL, so that permission audits see how the object is
+// actually shared. Named user and group entries correspond to the GCS ACL
+// entries of mapped principals, and the "other" entry to allUsers. Owner and
+// group permissions come from the mount's file mode.
+//
+// The ACL is available in the binary format used by getfacl and setfacl under
+// the usual name, and as text (e.g. "user::rw-,user:1001:r--,other::r--")
+// under textACLXattr. Writing either replaces the ACL entries of mapped
+// principals and allUsers, leaving others (such as project roles) alone.
+//
+// Reads and writes with the rw- permissions map to OWNER, and r-- to READER.
+// GCS has no other roles for objects.
+const (
+	posixACLAccessXattr = "system.posix_acl_access"
+	textACLXattr        = "user.gcsfuse.acl"
+)
+
+// ACLPrincipals maps local user and group IDs to the GCS ACL entities they
+// stand for, e.g. "user-alice@example.com" or "group-eng@example.com".
+type ACLPrincipals struct {
+	Users  map[uint32]string
+	Groups map[uint32]string
+}
+
+// Constants from the Linux ACL xattr format. See linux/posix_acl_xattr.h.
+const (
+	posixACLVersion = 2
+
+	posixACLUserObj  = 0x01
+	posixACLUser     = 0x02
+	posixACLGroupObj = 0x04
+	posixACLGroup    = 0x08
+	posixACLMask     = 0x10
+	posixACLOther    = 0x20
+
+	posixACLUndefinedID = 0xffffffff
+)
+
+const (
+	posixACLRead  = 4
+	posixACLWrite = 2
+)
+
+const (
+	gcsAllUsers   = "allUsers"
+	gcsRoleOwner  = "OWNER"
+	gcsRoleReader = "READER"
+)
+
+type posixACLEntry struct {
+	tag  uint16
+	perm uint16
+	id   uint32
+}
+
+// Entries sorted in the order the kernel requires: by tag, then by ID.
+type sortedACLEntries []posixACLEntry
+
+func (p sortedACLEntries) Len() int      { return len(p) }
+func (p sortedACLEntries) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p sortedACLEntries) Less(i, j int) bool {
+	if p[i].tag != p[j].tag {
+		return p[i].tag < p[j].tag
+	}
+
+	return p[i].id < p[j].id
+}
+
+////////////////////////////////////////////////////////////////////////
+// GCS translation
+////////////////////////////////////////////////////////////////////////
+
+func rolePerm(role string) uint16 {
+	switch role {
+	case gcsRoleOwner:
+		return posixACLRead | posixACLWrite
+	case gcsRoleReader:
+		return posixACLRead
+	}
+
+	return 0
+}
+
+func permRole(perm uint16) string {
+	switch {
+	case perm&posixACLWrite != 0:
+		return gcsRoleOwner
+	case perm&posixACLRead != 0:
+		return gcsRoleReader
+	}
+
+	return ""
+}
+
+// Return the local user or group for the supplied GCS entity, if mapped.
+func (p *ACLPrincipals) lookUp(entity string) (tag uint16, id uint32, ok bool) {
+	for uid, e := range p.Users {
+		if e == entity {
+			return posixACLUser, uid, true
+		}
+	}
+
+	for gid, e := range p.Groups {
+		if e == entity {
+			return posixACLGroup, gid, true
+		}
+	}
+
+	return
+}
+
+// Translate a GCS object ACL into a POSIX ACL for a file with the given mode.
+// Entries for unmapped principals are left out.
+func (p *ACLPrincipals) toPosix(
+	acl []*storagev1.ObjectAccessControl,
+	mode os.FileMode) (entries []posixACLEntry) {
+	groupObj := uint16(mode>>3) & 7
+	entries = append(entries,
+		posixACLEntry{tag: posixACLUserObj, perm: uint16(mode>>6) & 7, id: posixACLUndefinedID},
+		posixACLEntry{tag: posixACLGroupObj, perm: groupObj, id: posixACLUndefinedID})
+
+	// Collect named entries, merging multiple roles for one principal.
+	named := make(map[posixACLEntry]uint16)
+	var other uint16
+	for _, a := range acl {
+		if a.Entity == gcsAllUsers {
+			other |= rolePerm(a.Role)
+			continue
+		}
+
+		if tag, id, ok := p.lookUp(a.Entity); ok {
+			named[posixACLEntry{tag: tag, id: id}] |= rolePerm(a.Role)
+		}
+	}
+
+	mask := groupObj
+	for e, perm := range named {
+		e.perm = perm
+		entries = append(entries, e)
+		mask |= perm
+	}
+
+	if len(named) != 0 {
+		entries = append(entries,
+			posixACLEntry{tag: posixACLMask, perm: mask, id: posixACLUndefinedID})
+	}
+
+	entries = append(entries,
+		posixACLEntry{tag: posixACLOther, perm: other, id: posixACLUndefinedID})
+
+	sort.Sort(sortedACLEntries(entries))
+	return
+}
+
+// Translate a POSIX ACL into a new GCS object ACL, replacing the entries of
+// mapped principals and allUsers in the existing one. Owner, owning group and
+// mask entries have no GCS counterpart and are ignored.
+func (p *ACLPrincipals) fromPosix(
+	entries []posixACLEntry,
+	existing []*storagev1.ObjectAccessControl) (
+	acl []*storagev1.ObjectAccessControl,
+	err error) {
+	// Keep what we can't express.
+	for _, a := range existing {
+		if _, _, ok := p.lookUp(a.Entity); ok || a.Entity == gcsAllUsers {
+			continue
+		}
+
+		acl = append(acl, &storagev1.ObjectAccessControl{
+			Entity: a.Entity,
+			Role:   a.Role,
+		})
+	}
+
+	for _, e := range entries {
+		var entity string
+		var ok bool
+
+		switch e.tag {
+		case posixACLUser:
+			if entity, ok = p.Users[e.id]; !ok {
+				err = fmt.Errorf("no GCS principal for user %d: %w", e.id, syscall.EINVAL)
+				return
+			}
+
+		case posixACLGroup:
+			if entity, ok = p.Groups[e.id]; !ok {
+				err = fmt.Errorf("no GCS principal for group %d: %w", e.id, syscall.EINVAL)
+				return
+			}
+
+		case posixACLOther:
+			// Nobody can own an object anonymously.
+			entity = gcsAllUsers
+			e.perm &^= posixACLWrite
+
+		default:
+			continue
+		}
+
+		if role := permRole(e.perm); role != "" {
+			acl = append(acl, &storagev1.ObjectAccessControl{
+				Entity: entity,
+				Role:   role,
+			})
+		}
+	}
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Encodings
+////////////////////////////////////////////////////////////////////////
+
+// Encode entries in the binary xattr format.
+func encodePosixACL(entries []posixACLEntry) (b []byte) {
+	b = make([]byte, 4+8*len(entries))
+	binary.LittleEndian.PutUint32(b, posixACLVersion)
+	for i, e := range entries {
+		buf := b[4+8*i:]
+		binary.LittleEndian.PutUint16(buf[0:], e.tag)
+		binary.LittleEndian.PutUint16(buf[2:], e.perm)
+		binary.LittleEndian.PutUint32(buf[4:], e.id)
+	}
+
+	return
+}
+
+// Decode entries from the binary xattr format.
+func decodePosixACL(b []byte) (entries []posixACLEntry, err error) {
+	if len(b) < 4 || (len(b)-4)%8 != 0 {
+		err = fmt.Errorf("malformed ACL of length %d: %w", len(b), syscall.EINVAL)
+		return
+	}
+
+	if v := binary.LittleEndian.Uint32(b); v != posixACLVersion {
+		err = fmt.Errorf("unsupported ACL version %d: %w", v, syscall.EINVAL)
+		return
+	}
+
+	for buf := b[4:]; len(buf) > 0; buf = buf[8:] {
+		entries = append(entries, posixACLEntry{
+			tag:  binary.LittleEndian.Uint16(buf[0:]),
+			perm: binary.LittleEndian.Uint16(buf[2:]),
+			id:   binary.LittleEndian.Uint32(buf[4:]),
+		})
+	}
+
+	return
+}
+
+var aclTagNames = map[uint16]string{
+	posixACLUserObj:  "user",
+	posixACLUser:     "user",
+	posixACLGroupObj: "group",
+	posixACLGroup:    "group",
+	posixACLMask:     "mask",
+	posixACLOther:    "other",
+}
+
+// Format entries as text in the short form of getfacl, e.g.
+// "user::rw-,user:1001:r--,group::r--,mask::r--,other::---".
+func formatPosixACL(entries []posixACLEntry) string {
+	var parts []string
+	for _, e := range entries {
+		var qualifier string
+		if e.tag == posixACLUser || e.tag == posixACLGroup {
+			qualifier = strconv.FormatUint(uint64(e.id), 10)
+		}
+
+		perms := []byte("---")
+		for i, bit := range []uint16{4, 2, 1} {
+			if e.perm&bit != 0 {
+				perms[i] = "rwx"[i]
+			}
+		}
+
+		parts = append(parts, aclTagNames[e.tag]+":"+qualifier+":"+string(perms))
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// Parse text as accepted by setfacl, with entries separated by commas or
+// newlines. Tags may be abbreviated to their first letter.
+func parsePosixACL(s string) (entries []posixACLEntry, err error) {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == '\n'
+	})
+
+	for _, f := range fields {
+		parts := strings.Split(strings.TrimSpace(f), ":")
+		if len(parts) != 3 {
+			err = fmt.Errorf("malformed ACL entry %q: %w", f, syscall.EINVAL)
+			return
+		}
+
+		e := posixACLEntry{id: posixACLUndefinedID}
+		named := parts[1] != ""
+
+		switch tag := parts[0]; {
+		case tag == "user" || tag == "u":
+			e.tag = posixACLUserObj
+			if named {
+				e.tag = posixACLUser
+			}
+
+		case tag == "group" || tag == "g":
+			e.tag = posixACLGroupObj
+			if named {
+				e.tag = posixACLGroup
+			}
+
+		case (tag == "mask" || tag == "m") && !named:
+			e.tag = posixACLMask
+
+		case (tag == "other" || tag == "o") && !named:
+			e.tag = posixACLOther
+
+		default:
+			err = fmt.Errorf("malformed ACL entry %q: %w", f, syscall.EINVAL)
+			return
+		}
+
+		if named {
+			var id uint64
+			id, err = strconv.ParseUint(parts[1], 10, 32)
+			if err != nil {
+				err = fmt.Errorf("malformed ACL entry %q: %w", f, syscall.EINVAL)
+				return
+			}
+
+			e.id = uint32(id)
+		}
+
+		for _, c := range parts[2] {
+			switch c {
+			case 'r':
+				e.perm |= 4
+			case 'w':
+				e.perm |= 2
+			case 'x':
+				e.perm |= 1
+			case '-':
+			default:
+				err = fmt.Errorf("malformed ACL entry %q: %w", f, syscall.EINVAL)
+				return
+			}
+		}
+
+		entries = append(entries, e)
+	}
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Extended attributes
+////////////////////////////////////////////////////////////////////////
+
+// Is the name one of the extended attributes holding the POSIX ACL?
+func isACLXattr(name string) bool {
+	return name == posixACLAccessXattr || name == textACLXattr
+}
+
+// Return the value of the named ACL xattr for the file.
+//
+// LOCKS_REQUIRED(f)
+func (fs *fileSystem) getACLXattr(
+	ctx context.Context,
+	f *inode.FileInode,
+	name string) (value []byte, err error) {
+	acl, err := f.Acl(ctx)
+	if err != nil {
+		err = fmt.Errorf("Acl: %w", err)
+		return
+	}
+
+	entries := fs.aclPrincipals.toPosix(acl, fs.fileMode)
+	if name == posixACLAccessXattr {
+		value = encodePosixACL(entries)
+	} else {
+		value = []byte(formatPosixACL(entries))
+	}
+
+	return
+}
+
+// Set the named ACL xattr for the file to the supplied value.
+//
+// LOCKS_REQUIRED(f)
+func (fs *fileSystem) setACLXattr(
+	ctx context.Context,
+	f *inode.FileInode,
+	name string,
+	value []byte) (err error) {
+	var entries []posixACLEntry
+	if name == posixACLAccessXattr {
+		entries, err = decodePosixACL(value)
+	} else {
+		entries, err = parsePosixACL(string(value))
+	}
+
+	if err != nil {
+		return
+	}
+
+	existing, err := f.Acl(ctx)
+	if err != nil {
+		err = fmt.Errorf("Acl: %w", err)
+		return
+	}
+
+	acl, err := fs.aclPrincipals.fromPosix(entries, existing)
+	if err != nil {
+		return
+	}
+
+	if err = f.SetAcl(ctx, acl); err != nil {
+		err = fmt.Errorf("SetAcl: %w", err)
+		return
+	}
+
+	return
+}
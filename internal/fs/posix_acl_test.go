@@ -0,0 +1,103 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"errors"
+	"reflect"
+	"syscall"
+	"testing"
+
+	storagev1 "google.golang.org/api/storage/v1"
+)
+
+var testACLPrincipals = &ACLPrincipals{
+	Users:  map[uint32]string{1001: "user-alice@example.com"},
+	Groups: map[uint32]string{2000: "group-eng@example.com"},
+}
+
+func TestPosixACLFromGCS(t *testing.T) {
+	acl := []*storagev1.ObjectAccessControl{
+		{Entity: "project-owners-123", Role: "OWNER"},
+		{Entity: "user-alice@example.com", Role: "READER"},
+		{Entity: "group-eng@example.com", Role: "OWNER"},
+		{Entity: "allUsers", Role: "READER"},
+	}
+
+	entries := testACLPrincipals.toPosix(acl, 0640)
+
+	const expected = "user::rw-,user:1001:r--,group::r--,group:2000:rw-,mask::rw-,other::r--"
+	if got := formatPosixACL(entries); got != expected {
+		t.Errorf("Got %q, expected %q", got, expected)
+	}
+
+	// The binary form round trips.
+	decoded, err := decodePosixACL(encodePosixACL(entries))
+	if err != nil {
+		t.Fatalf("decodePosixACL: %v", err)
+	}
+
+	if !reflect.DeepEqual(decoded, entries) {
+		t.Errorf("Got %v, expected %v", decoded, entries)
+	}
+}
+
+func TestPosixACLToGCS(t *testing.T) {
+	existing := []*storagev1.ObjectAccessControl{
+		{Entity: "project-owners-123", Role: "OWNER"},
+		{Entity: "user-alice@example.com", Role: "OWNER"},
+		{Entity: "allUsers", Role: "READER"},
+	}
+
+	entries, err := parsePosixACL("u::rw-,u:1001:r--,g::r--,g:2000:rw-,m::rw-,o::---")
+	if err != nil {
+		t.Fatalf("parsePosixACL: %v", err)
+	}
+
+	acl, err := testACLPrincipals.fromPosix(entries, existing)
+	if err != nil {
+		t.Fatalf("fromPosix: %v", err)
+	}
+
+	expected := []*storagev1.ObjectAccessControl{
+		{Entity: "project-owners-123", Role: "OWNER"},
+		{Entity: "user-alice@example.com", Role: "READER"},
+		{Entity: "group-eng@example.com", Role: "OWNER"},
+	}
+
+	if !reflect.DeepEqual(acl, expected) {
+		t.Errorf("Got %v, expected %v", acl, expected)
+	}
+}
+
+func TestPosixACLToGCSForUnmappedUser(t *testing.T) {
+	entries, err := parsePosixACL("user::rw-,user:42:r--,group::r--,other::---")
+	if err != nil {
+		t.Fatalf("parsePosixACL: %v", err)
+	}
+
+	_, err = testACLPrincipals.fromPosix(entries, nil)
+	if !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("Got %v, expected EINVAL", err)
+	}
+}
+
+func TestParsePosixACLForMalformedEntry(t *testing.T) {
+	for _, s := range []string{"user:rw-", "mask:7:rw-", "user::rwz", "bogus::r--"} {
+		if _, err := parsePosixACL(s); !errors.Is(err, syscall.EINVAL) {
+			t.Errorf("%q: got %v, expected EINVAL", s, err)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/contentcache"
+	"github.com/googlecloudplatform/gcsfuse/internal/fs/inode"
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/googlecloudplatform/gcsfuse/internal/logger"
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+	"golang.org/x/sync/errgroup"
+)
+
+// Setting this extended attribute on a directory warms caches for the tree
+// beneath it before returning, so that the first access by a latency-sensitive
+// job doesn't pay for it. For example:
+//
+//	setfattr -n user.gcsfuse.prewarm -v contents /mnt/some/dir
+//
+// The value "metadata" (or an empty value) fills the stat cache for every
+// object in the tree and the type and listing caches of every directory in
+// it, so that lookups throughout the tree are answered locally until those
+// caches expire. The value "contents" additionally fetches every file in the
+// tree into the local file cache.
+//
+// Directories whose inodes don't exist yet have their share of the listing
+// kept in a bucket-wide WarmedListings until the inodes are minted.
+const prewarmXattr = "user.gcsfuse.prewarm"
+
+const (
+	prewarmMetadata = "metadata"
+	prewarmContents = "contents"
+)
+
+// The number of files whose contents are fetched at once while prewarming.
+const prewarmParallelism = 16
+
+// Warm caches for the tree rooted at d, as described for prewarmXattr.
+//
+// LOCKS_EXCLUDED(fs.mu)
+// LOCKS_EXCLUDED(d)
+func (fs *fileSystem) prewarm(
+	ctx context.Context,
+	d inode.BucketOwnedDirInode,
+	contents bool) (err error) {
+	if contents && !fs.localFileCache {
+		err = fmt.Errorf(
+			"prewarming contents requires the local file cache: %w",
+			syscall.ENOTSUP)
+		return
+	}
+
+	// List everything beneath it. The stat cache notes what it sees.
+	bucket := d.Bucket()
+	listedAt := fs.cacheClock.Now()
+	var listed []*gcs.Object
+	req := &gcs.ListObjectsRequest{Prefix: d.Name().GcsObjectName()}
+	for {
+		var listing *gcs.Listing
+		listing, err = bucket.ListObjects(ctx, req)
+		if err != nil {
+			err = fmt.Errorf("ListObjects: %w", err)
+			return
+		}
+
+		for _, o := range listing.Objects {
+			// Leave gcsfuse's own records alone, as the file system does.
+			if d.Name().IsBucketRoot() &&
+				strings.HasPrefix(o.Name, gcsx.RenameManifestPrefix) {
+				continue
+			}
+
+			listed = append(listed, o)
+		}
+
+		if listing.ContinuationToken == "" {
+			break
+		}
+		req.ContinuationToken = listing.ContinuationToken
+	}
+
+	// Record the children of every directory in the tree, and hand directory
+	// inodes that already exist their share now.
+	type primed struct {
+		d        inode.DirInode
+		listedAt time.Time
+		children []*inode.Core
+	}
+
+	var live []primed
+	fs.mu.Lock()
+	fs.warmedListings.InsertTree(listedAt, bucket, d.Name(), listed, fs.implicitDirs)
+	for _, in := range fs.inodes {
+		dir, ok := in.(inode.DirInode)
+		if !ok || !strings.HasPrefix(dir.Name().LocalName(), d.Name().LocalName()) {
+			continue
+		}
+
+		if t, children, ok := fs.warmedListings.Take(listedAt, dir.Name()); ok {
+			live = append(live, primed{dir, t, children})
+		}
+	}
+	fs.mu.Unlock()
+
+	for _, p := range live {
+		p.d.Lock()
+		p.d.PrimeCaches(p.listedAt, p.children)
+		p.d.Unlock()
+	}
+
+	if !contents {
+		return
+	}
+
+	var files []*gcs.Object
+	for _, o := range listed {
+		if !strings.HasSuffix(o.Name, "/") {
+			files = append(files, o)
+		}
+	}
+
+	// Fetch the files in parallel.
+	group, ctx := errgroup.WithContext(ctx)
+	objects := make(chan *gcs.Object)
+
+	group.Go(func() (err error) {
+		defer close(objects)
+		for _, o := range files {
+			select {
+			case objects <- o:
+			case <-ctx.Done():
+				err = ctx.Err()
+				return
+			}
+		}
+
+		return
+	})
+
+	for i := 0; i < prewarmParallelism; i++ {
+		group.Go(func() (err error) {
+			for o := range objects {
+				if err = fs.hydrate(ctx, bucket, o); err != nil {
+					return
+				}
+			}
+
+			return
+		})
+	}
+
+	err = group.Wait()
+	if err == nil {
+		logger.Infof(
+			"Prewarmed %d files under %q\n",
+			len(files),
+			d.Name().GcsObjectName())
+	}
+
+	return
+}
+
+// Fetch the supplied object into the local file cache, unless it is already
+// there.
+func (fs *fileSystem) hydrate(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	o *gcs.Object) (err error) {
+	key := &contentcache.CacheObjectKey{
+		BucketName: bucket.Name(),
+		ObjectName: o.Name,
+	}
+
+	if cached, ok := fs.contentCache.Get(key); ok &&
+		cached.ValidateGeneration(o.Generation, o.MetaGeneration) {
+		return
+	}
+
+	rc, err := bucket.NewReader(
+		ctx,
+		&gcs.ReadObjectRequest{
+			Name:       o.Name,
+			Generation: o.Generation,
+		})
+	if err != nil {
+		err = fmt.Errorf("NewReader(%q): %w", o.Name, err)
+		return
+	}
+
+	err = fs.contentCache.Hydrate(
+		key,
+		o.Generation,
+		o.MetaGeneration,
+		int64(o.Size),
+		rc)
+	if err != nil {
+		err = fmt.Errorf("Hydrate(%q): %w", o.Name, err)
+		return
+	}
+
+	return
+}
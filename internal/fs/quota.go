@@ -0,0 +1,87 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"fmt"
+	"sync/atomic"
+	"syscall"
+)
+
+// Limits on how much a mount may write during its lifetime. Bytes are counted
+// as they are passed to write(2), whether or not they overwrite earlier ones,
+// and as truncate(2) extends files; objects as files, directories, symlinks
+// and links are created. Once a limit would be exceeded, further writes,
+// truncates or creates fail with EDQUOT.
+type writeQuota struct {
+	// Zero means no limit.
+	maxBytesWritten   int64
+	maxObjectsCreated int64
+
+	// Accessed atomically.
+	bytesWritten   int64
+	objectsCreated int64
+}
+
+// Account for n more bytes written, failing if that would exceed the limit.
+// If the bytes then aren't written after all, call refundBytes.
+func (q *writeQuota) chargeBytes(n int64) (err error) {
+	if q.maxBytesWritten == 0 {
+		return
+	}
+
+	if total := atomic.AddInt64(&q.bytesWritten, n); total > q.maxBytesWritten {
+		atomic.AddInt64(&q.bytesWritten, -n)
+		err = fmt.Errorf(
+			"writing %d bytes would exceed the limit of %d: %w",
+			n,
+			q.maxBytesWritten,
+			syscall.EDQUOT)
+		return
+	}
+
+	return
+}
+
+func (q *writeQuota) refundBytes(n int64) {
+	if q.maxBytesWritten != 0 {
+		atomic.AddInt64(&q.bytesWritten, -n)
+	}
+}
+
+// Account for a new object, failing if that would exceed the limit. If the
+// object then isn't created after all, call refundObject.
+func (q *writeQuota) chargeObject() (err error) {
+	if q.maxObjectsCreated == 0 {
+		return
+	}
+
+	if total := atomic.AddInt64(&q.objectsCreated, 1); total > q.maxObjectsCreated {
+		atomic.AddInt64(&q.objectsCreated, -1)
+		err = fmt.Errorf(
+			"creating more than %d objects: %w",
+			q.maxObjectsCreated,
+			syscall.EDQUOT)
+		return
+	}
+
+	return
+}
+
+func (q *writeQuota) refundObject() {
+	if q.maxObjectsCreated != 0 {
+		atomic.AddInt64(&q.objectsCreated, -1)
+	}
+}
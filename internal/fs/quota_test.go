@@ -0,0 +1,93 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"syscall"
+
+	. "github.com/jacobsa/ogletest"
+)
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type WriteQuotaTest struct {
+	fsTest
+}
+
+func init() { RegisterTestSuite(&WriteQuotaTest{}) }
+
+func (t *WriteQuotaTest) SetUp(ti *TestInfo) {
+	t.serverCfg.MaxBytesWritten = 8
+	t.serverCfg.MaxObjectsCreated = 2
+	t.fsTest.SetUp(ti)
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *WriteQuotaTest) BytesWritten() {
+	f, err := os.Create(path.Join(t.Dir, "foo"))
+	AssertEq(nil, err)
+	defer f.Close()
+
+	_, err = f.Write([]byte("taco"))
+	AssertEq(nil, err)
+
+	// Overwriting counts too.
+	_, err = f.WriteAt([]byte("burrito"), 0)
+	ExpectEq(syscall.EDQUOT, err.(*os.PathError).Err)
+
+	_, err = f.WriteAt([]byte("TACO"), 0)
+	ExpectEq(nil, err)
+}
+
+func (t *WriteQuotaTest) TruncateGrowth() {
+	f, err := os.Create(path.Join(t.Dir, "foo"))
+	AssertEq(nil, err)
+	defer f.Close()
+
+	_, err = f.Write([]byte("taco"))
+	AssertEq(nil, err)
+
+	// Extending the file counts towards the limit; shrinking it doesn't.
+	err = f.Truncate(16)
+	ExpectEq(syscall.EDQUOT, err.(*os.PathError).Err)
+
+	AssertEq(nil, f.Truncate(2))
+	AssertEq(nil, f.Truncate(6))
+
+	_, err = f.WriteAt([]byte("!"), 0)
+	ExpectEq(syscall.EDQUOT, err.(*os.PathError).Err)
+}
+
+func (t *WriteQuotaTest) ObjectsCreated() {
+	AssertEq(nil, ioutil.WriteFile(path.Join(t.Dir, "foo"), nil, 0600))
+	AssertEq(nil, os.Mkdir(path.Join(t.Dir, "dir"), 0700))
+
+	err := ioutil.WriteFile(path.Join(t.Dir, "bar"), nil, 0600)
+	ExpectEq(syscall.EDQUOT, err.(*os.PathError).Err)
+
+	err = os.Symlink("foo", path.Join(t.Dir, "baz"))
+	ExpectEq(syscall.EDQUOT, err.(*os.LinkError).Err)
+
+	// Existing objects may still be removed.
+	ExpectEq(nil, os.Remove(path.Join(t.Dir, "foo")))
+}
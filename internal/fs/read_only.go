@@ -0,0 +1,106 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/fs/inode"
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+)
+
+// Return EROFS if the object with the given name lies under one of
+// ServerConfig.ReadOnlyPrefixes, or EPERM if it lies under the prefix of
+// gcsfuse's own rename records. Operations that would create, modify or
+// remove an object check this before touching any local state, so that a
+// refused write never leaves a dirty file behind.
+func (fs *fileSystem) checkWritable(name inode.Name) (err error) {
+	objectName := name.GcsObjectName()
+	if strings.HasPrefix(objectName, gcsx.RenameManifestPrefix) {
+		err = fmt.Errorf(
+			"%q is reserved for gcsfuse: %w",
+			objectName,
+			syscall.EPERM)
+		return
+	}
+
+	for _, prefix := range fs.readOnlyPrefixes {
+		if strings.HasPrefix(objectName, prefix) {
+			err = fmt.Errorf(
+				"%q is under read-only prefix %q: %w",
+				objectName,
+				prefix,
+				syscall.EROFS)
+			return
+		}
+	}
+
+	return
+}
+
+// Return EROFS if a file named name in the given parent directory, or a
+// directory if isDir is set, would lie under a read-only prefix.
+func (fs *fileSystem) checkChildWritable(
+	parent inode.Inode,
+	name string,
+	isDir bool) (err error) {
+	if isDir {
+		err = fs.checkWritable(inode.NewDirName(parent.Name(), name))
+	} else {
+		err = fs.checkWritable(inode.NewFileName(parent.Name(), name))
+	}
+
+	return
+}
+
+// Like checkWritable, but for a directory also return EROFS if a read-only
+// prefix lies beneath it.
+func (fs *fileSystem) checkTreeWritable(name inode.Name) (err error) {
+	if err = fs.checkWritable(name); err != nil || !name.IsDir() {
+		return
+	}
+
+	objectName := name.GcsObjectName()
+	for _, prefix := range fs.readOnlyPrefixes {
+		if strings.HasPrefix(prefix, objectName) {
+			err = fmt.Errorf(
+				"%q contains read-only prefix %q: %w",
+				objectName,
+				prefix,
+				syscall.EROFS)
+			return
+		}
+	}
+
+	return
+}
+
+// Like checkWritable, but also return EROFS if the file is pinned to a
+// generation of its object.
+func (fs *fileSystem) checkFileWritable(f *inode.FileInode) (err error) {
+	if gen := f.PinnedGeneration(); gen != 0 {
+		err = fmt.Errorf(
+			"%q is pinned to generation %d: %w",
+			f.Name().GcsObjectName(),
+			gen,
+			syscall.EROFS)
+		return
+	}
+
+	err = fs.checkWritable(f.Name())
+	return
+}
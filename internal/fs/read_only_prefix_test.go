@@ -0,0 +1,124 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"syscall"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	. "github.com/jacobsa/ogletest"
+)
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type ReadOnlyPrefixTest struct {
+	fsTest
+}
+
+func init() { RegisterTestSuite(&ReadOnlyPrefixTest{}) }
+
+func (t *ReadOnlyPrefixTest) SetUp(ti *TestInfo) {
+	t.serverCfg.ImplicitDirectories = true
+	t.serverCfg.ReadOnlyPrefixes = []string{"raw/"}
+	t.fsTest.SetUp(ti)
+
+	_, err := gcsutil.CreateObject(t.ctx, t.bucket, "raw/foo", []byte("taco"))
+	AssertEq(nil, err)
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *ReadOnlyPrefixTest) ReadingIsAllowed() {
+	contents, err := ioutil.ReadFile(path.Join(t.Dir, "raw/foo"))
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+}
+
+func (t *ReadOnlyPrefixTest) OpenForWriting() {
+	_, err := os.OpenFile(path.Join(t.Dir, "raw/foo"), os.O_WRONLY, 0)
+	ExpectEq(syscall.EROFS, err.(*os.PathError).Err)
+
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, "raw/foo")
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+}
+
+func (t *ReadOnlyPrefixTest) Create() {
+	err := ioutil.WriteFile(path.Join(t.Dir, "raw/bar"), []byte("burrito"), 0600)
+	ExpectEq(syscall.EROFS, err.(*os.PathError).Err)
+
+	err = os.Mkdir(path.Join(t.Dir, "raw/dir"), 0700)
+	ExpectEq(syscall.EROFS, err.(*os.PathError).Err)
+}
+
+func (t *ReadOnlyPrefixTest) Remove() {
+	err := os.Remove(path.Join(t.Dir, "raw/foo"))
+	ExpectEq(syscall.EROFS, err.(*os.PathError).Err)
+
+	err = os.Truncate(path.Join(t.Dir, "raw/foo"), 0)
+	ExpectEq(syscall.EROFS, err.(*os.PathError).Err)
+}
+
+func (t *ReadOnlyPrefixTest) Rename() {
+	// Neither out of nor into the prefix.
+	err := os.Rename(path.Join(t.Dir, "raw/foo"), path.Join(t.Dir, "bar"))
+	ExpectEq(syscall.EROFS, err.(*os.LinkError).Err)
+
+	AssertEq(nil, ioutil.WriteFile(path.Join(t.Dir, "baz"), []byte("x"), 0600))
+	err = os.Rename(path.Join(t.Dir, "baz"), path.Join(t.Dir, "raw/baz"))
+	ExpectEq(syscall.EROFS, err.(*os.LinkError).Err)
+}
+
+func (t *ReadOnlyPrefixTest) WritingElsewhereIsAllowed() {
+	err := ioutil.WriteFile(path.Join(t.Dir, "bar"), []byte("burrito"), 0600)
+	AssertEq(nil, err)
+
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, "bar")
+	AssertEq(nil, err)
+	ExpectEq("burrito", string(contents))
+}
+
+func (t *ReadOnlyPrefixTest) RenameManifestsAreHiddenAndReserved() {
+	_, err := gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		gcsx.RenameManifestPrefix+"taco",
+		[]byte("{}"))
+	AssertEq(nil, err)
+
+	manifestDir := path.Join(t.Dir, path.Clean(gcsx.RenameManifestPrefix))
+
+	// The manifests can't be seen.
+	_, err = os.Stat(manifestDir)
+	ExpectTrue(os.IsNotExist(err), "err: %v", err)
+
+	entries, err := ioutil.ReadDir(t.Dir)
+	AssertEq(nil, err)
+	for _, e := range entries {
+		ExpectNe(path.Clean(gcsx.RenameManifestPrefix), e.Name())
+	}
+
+	// Nor can anything be put among them.
+	err = os.Mkdir(manifestDir, 0700)
+	ExpectEq(syscall.EPERM, err.(*os.PathError).Err)
+}
@@ -33,6 +33,7 @@ func NewServer(ctx context.Context, cfg *ServerConfig) (fuse.Server, error) {
 	if cfg.DebugFS {
 		fs = wrappers.WithDebugLogging(fs)
 	}
+	fs = wrappers.WithWorkerPool(fs, cfg.FuseWorkerPoolSize)
 	fs = wrappers.WithErrorMapping(fs)
 	fs = wrappers.WithMonitoring(fs)
 	return fuseutil.NewFileSystemServer(fs), nil
@@ -0,0 +1,80 @@
+// Copyright 2022 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"syscall"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/fs/handle"
+	"github.com/googlecloudplatform/gcsfuse/internal/logger"
+)
+
+// The storage class of objects whose contents incur retrieval fees when read.
+const archiveStorageClass = "ARCHIVE"
+
+// Policies for reading the contents of objects in the ARCHIVE storage class.
+const (
+	// Read archived objects like any other.
+	ArchiveReadAllow = "allow"
+
+	// Read archived objects, but log a warning the first time each handle does.
+	ArchiveReadWarn = "warn"
+
+	// Refuse to read archived objects, failing with EACCES.
+	ArchiveReadDeny = "deny"
+)
+
+// Apply the archive read policy to a read through the supplied handle. Reads
+// served from a local copy of the file's contents, whether modified or held
+// in the content cache, cost nothing and are always allowed.
+//
+// LOCKS_REQUIRED(fh)
+// LOCKS_EXCLUDED(fh.Inode())
+func (fs *fileSystem) checkArchiveRead(fh *handle.FileHandle) (err error) {
+	if fs.archiveReadPolicy == ArchiveReadAllow || fs.archiveReadPolicy == "" {
+		return
+	}
+
+	in := fh.Inode()
+	in.Lock()
+	archived := in.SourceGenerationIsAuthoritative() &&
+		in.Source().StorageClass == archiveStorageClass
+	fromGCS := !in.ServesCachedReads()
+	name := in.Name()
+	in.Unlock()
+
+	if !archived || !fromGCS {
+		return
+	}
+
+	switch fs.archiveReadPolicy {
+	case ArchiveReadDeny:
+		logger.Infof(
+			"Refusing to read %q from the %s storage class.\n",
+			name.GcsObjectName(),
+			archiveStorageClass)
+		err = syscall.EACCES
+
+	case ArchiveReadWarn:
+		if fh.MarkArchiveReadWarned() {
+			logger.Infof(
+				"Reading %q from the %s storage class incurs retrieval fees.\n",
+				name.GcsObjectName(),
+				archiveStorageClass)
+		}
+	}
+
+	return
+}
@@ -23,6 +23,7 @@ import (
 	"syscall"
 
 	"cloud.google.com/go/storage"
+	"github.com/googlecloudplatform/gcsfuse/internal/contentcache"
 	"github.com/googlecloudplatform/gcsfuse/internal/logger"
 	"github.com/jacobsa/fuse/fuseops"
 	"github.com/jacobsa/fuse/fuseutil"
@@ -52,6 +53,11 @@ func errno(err error) error {
 		return syscall.ENOENT
 	}
 
+	// The local temp file usage limit has been reached
+	if errors.Is(err, contentcache.ErrTempUsageExceeded) {
+		return syscall.ENOSPC
+	}
+
 	// The HTTP request is canceled
 	if strings.Contains(err.Error(), "net/http: request canceled") {
 		return syscall.ECANCELED
@@ -62,6 +68,11 @@ func errno(err error) error {
 		return syscall.EACCES
 	}
 
+	// The object is protected by a retention policy or a hold
+	if isRetentionError(err) {
+		return syscall.EPERM
+	}
+
 	// Translate API errors into an em errno
 	var apiErr *googleapi.Error
 	if errors.As(err, &apiErr) {
@@ -76,6 +87,41 @@ func errno(err error) error {
 	return DefaultFSError
 }
 
+// Phrases in the messages of 403 errors that GCS returns for objects under a
+// retention policy or hold.
+var retentionPhrases = []string{
+	"retention policy",
+	"event-based hold",
+	"temporary hold",
+	"object hold",
+}
+
+// isRetentionError reports whether err is GCS refusing to delete or overwrite
+// an object because of a bucket retention policy or an object hold.
+func isRetentionError(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusForbidden {
+		return false
+	}
+
+	for _, e := range apiErr.Errors {
+		if e.Reason == "retentionPolicyNotMet" {
+			return true
+		}
+	}
+
+	// Holds are reported with a generic reason, so look for the phrases GCS
+	// uses to describe them.
+	msg := strings.ToLower(apiErr.Message)
+	for _, phrase := range retentionPhrases {
+		if strings.Contains(msg, phrase) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // WithErrorMapping wraps a FileSystem, processing the returned errors, and
 // mapping them into syscall.Errno that can be understood by FUSE.
 func WithErrorMapping(wrapped fuseutil.FileSystem) fuseutil.FileSystem {
@@ -92,7 +138,11 @@ type errorMapping struct {
 
 func (em *errorMapping) mapError(op string, err error) error {
 	fsErr := errno(err)
-	if err != nil && fsErr != nil && err != fsErr {
+	if isRetentionError(err) {
+		em.logger.Printf(
+			"%s: %v, object is protected by a retention policy or hold: %v",
+			op, fsErr, err)
+	} else if err != nil && fsErr != nil && err != fsErr {
 		em.logger.Printf("%s: %v, %v", op, fsErr, err)
 	}
 	return fsErr
@@ -0,0 +1,348 @@
+// Copyright 2022 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrappers
+
+import (
+	"context"
+
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/fuseutil"
+)
+
+// WithWorkerPool wraps a FileSystem, bounding the number of operations that
+// may be in flight in the wrapped file system at once to size. Operations
+// beyond that wait for a free slot, or until their context is cancelled.
+//
+// Forget operations bypass the pool: they are cheap, never touch GCS, and the
+// kernel does not expect them to block. If size is not positive, the file
+// system is returned unchanged.
+func WithWorkerPool(
+	wrapped fuseutil.FileSystem,
+	size int) fuseutil.FileSystem {
+	if size <= 0 {
+		return wrapped
+	}
+
+	return &workerPool{
+		wrapped: wrapped,
+		slots:   make(chan struct{}, size),
+	}
+}
+
+type workerPool struct {
+	wrapped fuseutil.FileSystem
+
+	// A counting semaphore. Sending acquires a slot, receiving releases it.
+	slots chan struct{}
+}
+
+func (wp *workerPool) acquire(ctx context.Context) error {
+	select {
+	case wp.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (wp *workerPool) release() {
+	<-wp.slots
+}
+
+func (wp *workerPool) Destroy() {
+	wp.wrapped.Destroy()
+}
+
+func (wp *workerPool) StatFS(
+	ctx context.Context,
+	op *fuseops.StatFSOp) error {
+	if err := wp.acquire(ctx); err != nil {
+		return err
+	}
+	defer wp.release()
+	return wp.wrapped.StatFS(ctx, op)
+}
+
+func (wp *workerPool) LookUpInode(
+	ctx context.Context,
+	op *fuseops.LookUpInodeOp) error {
+	if err := wp.acquire(ctx); err != nil {
+		return err
+	}
+	defer wp.release()
+	return wp.wrapped.LookUpInode(ctx, op)
+}
+
+func (wp *workerPool) GetInodeAttributes(
+	ctx context.Context,
+	op *fuseops.GetInodeAttributesOp) error {
+	if err := wp.acquire(ctx); err != nil {
+		return err
+	}
+	defer wp.release()
+	return wp.wrapped.GetInodeAttributes(ctx, op)
+}
+
+func (wp *workerPool) SetInodeAttributes(
+	ctx context.Context,
+	op *fuseops.SetInodeAttributesOp) error {
+	if err := wp.acquire(ctx); err != nil {
+		return err
+	}
+	defer wp.release()
+	return wp.wrapped.SetInodeAttributes(ctx, op)
+}
+
+func (wp *workerPool) ForgetInode(
+	ctx context.Context,
+	op *fuseops.ForgetInodeOp) error {
+	return wp.wrapped.ForgetInode(ctx, op)
+}
+
+func (wp *workerPool) BatchForget(
+	ctx context.Context,
+	op *fuseops.BatchForgetOp) error {
+	return wp.wrapped.BatchForget(ctx, op)
+}
+
+func (wp *workerPool) MkDir(
+	ctx context.Context,
+	op *fuseops.MkDirOp) error {
+	if err := wp.acquire(ctx); err != nil {
+		return err
+	}
+	defer wp.release()
+	return wp.wrapped.MkDir(ctx, op)
+}
+
+func (wp *workerPool) MkNode(
+	ctx context.Context,
+	op *fuseops.MkNodeOp) error {
+	if err := wp.acquire(ctx); err != nil {
+		return err
+	}
+	defer wp.release()
+	return wp.wrapped.MkNode(ctx, op)
+}
+
+func (wp *workerPool) CreateFile(
+	ctx context.Context,
+	op *fuseops.CreateFileOp) error {
+	if err := wp.acquire(ctx); err != nil {
+		return err
+	}
+	defer wp.release()
+	return wp.wrapped.CreateFile(ctx, op)
+}
+
+func (wp *workerPool) CreateLink(
+	ctx context.Context,
+	op *fuseops.CreateLinkOp) error {
+	if err := wp.acquire(ctx); err != nil {
+		return err
+	}
+	defer wp.release()
+	return wp.wrapped.CreateLink(ctx, op)
+}
+
+func (wp *workerPool) CreateSymlink(
+	ctx context.Context,
+	op *fuseops.CreateSymlinkOp) error {
+	if err := wp.acquire(ctx); err != nil {
+		return err
+	}
+	defer wp.release()
+	return wp.wrapped.CreateSymlink(ctx, op)
+}
+
+func (wp *workerPool) Rename(
+	ctx context.Context,
+	op *fuseops.RenameOp) error {
+	if err := wp.acquire(ctx); err != nil {
+		return err
+	}
+	defer wp.release()
+	return wp.wrapped.Rename(ctx, op)
+}
+
+func (wp *workerPool) RmDir(
+	ctx context.Context,
+	op *fuseops.RmDirOp) error {
+	if err := wp.acquire(ctx); err != nil {
+		return err
+	}
+	defer wp.release()
+	return wp.wrapped.RmDir(ctx, op)
+}
+
+func (wp *workerPool) Unlink(
+	ctx context.Context,
+	op *fuseops.UnlinkOp) error {
+	if err := wp.acquire(ctx); err != nil {
+		return err
+	}
+	defer wp.release()
+	return wp.wrapped.Unlink(ctx, op)
+}
+
+func (wp *workerPool) OpenDir(
+	ctx context.Context,
+	op *fuseops.OpenDirOp) error {
+	if err := wp.acquire(ctx); err != nil {
+		return err
+	}
+	defer wp.release()
+	return wp.wrapped.OpenDir(ctx, op)
+}
+
+func (wp *workerPool) ReadDir(
+	ctx context.Context,
+	op *fuseops.ReadDirOp) error {
+	if err := wp.acquire(ctx); err != nil {
+		return err
+	}
+	defer wp.release()
+	return wp.wrapped.ReadDir(ctx, op)
+}
+
+func (wp *workerPool) ReleaseDirHandle(
+	ctx context.Context,
+	op *fuseops.ReleaseDirHandleOp) error {
+	if err := wp.acquire(ctx); err != nil {
+		return err
+	}
+	defer wp.release()
+	return wp.wrapped.ReleaseDirHandle(ctx, op)
+}
+
+func (wp *workerPool) OpenFile(
+	ctx context.Context,
+	op *fuseops.OpenFileOp) error {
+	if err := wp.acquire(ctx); err != nil {
+		return err
+	}
+	defer wp.release()
+	return wp.wrapped.OpenFile(ctx, op)
+}
+
+func (wp *workerPool) ReadFile(
+	ctx context.Context,
+	op *fuseops.ReadFileOp) error {
+	if err := wp.acquire(ctx); err != nil {
+		return err
+	}
+	defer wp.release()
+	return wp.wrapped.ReadFile(ctx, op)
+}
+
+func (wp *workerPool) WriteFile(
+	ctx context.Context,
+	op *fuseops.WriteFileOp) error {
+	if err := wp.acquire(ctx); err != nil {
+		return err
+	}
+	defer wp.release()
+	return wp.wrapped.WriteFile(ctx, op)
+}
+
+func (wp *workerPool) SyncFile(
+	ctx context.Context,
+	op *fuseops.SyncFileOp) error {
+	if err := wp.acquire(ctx); err != nil {
+		return err
+	}
+	defer wp.release()
+	return wp.wrapped.SyncFile(ctx, op)
+}
+
+func (wp *workerPool) FlushFile(
+	ctx context.Context,
+	op *fuseops.FlushFileOp) error {
+	if err := wp.acquire(ctx); err != nil {
+		return err
+	}
+	defer wp.release()
+	return wp.wrapped.FlushFile(ctx, op)
+}
+
+func (wp *workerPool) ReleaseFileHandle(
+	ctx context.Context,
+	op *fuseops.ReleaseFileHandleOp) error {
+	if err := wp.acquire(ctx); err != nil {
+		return err
+	}
+	defer wp.release()
+	return wp.wrapped.ReleaseFileHandle(ctx, op)
+}
+
+func (wp *workerPool) ReadSymlink(
+	ctx context.Context,
+	op *fuseops.ReadSymlinkOp) error {
+	if err := wp.acquire(ctx); err != nil {
+		return err
+	}
+	defer wp.release()
+	return wp.wrapped.ReadSymlink(ctx, op)
+}
+
+func (wp *workerPool) RemoveXattr(
+	ctx context.Context,
+	op *fuseops.RemoveXattrOp) error {
+	if err := wp.acquire(ctx); err != nil {
+		return err
+	}
+	defer wp.release()
+	return wp.wrapped.RemoveXattr(ctx, op)
+}
+
+func (wp *workerPool) GetXattr(
+	ctx context.Context,
+	op *fuseops.GetXattrOp) error {
+	if err := wp.acquire(ctx); err != nil {
+		return err
+	}
+	defer wp.release()
+	return wp.wrapped.GetXattr(ctx, op)
+}
+
+func (wp *workerPool) ListXattr(
+	ctx context.Context,
+	op *fuseops.ListXattrOp) error {
+	if err := wp.acquire(ctx); err != nil {
+		return err
+	}
+	defer wp.release()
+	return wp.wrapped.ListXattr(ctx, op)
+}
+
+func (wp *workerPool) SetXattr(
+	ctx context.Context,
+	op *fuseops.SetXattrOp) error {
+	if err := wp.acquire(ctx); err != nil {
+		return err
+	}
+	defer wp.release()
+	return wp.wrapped.SetXattr(ctx, op)
+}
+
+func (wp *workerPool) Fallocate(
+	ctx context.Context,
+	op *fuseops.FallocateOp) error {
+	if err := wp.acquire(ctx); err != nil {
+		return err
+	}
+	defer wp.release()
+	return wp.wrapped.Fallocate(ctx, op)
+}
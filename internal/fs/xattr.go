@@ -0,0 +1,247 @@
+// Copyright 2022 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/fs/inode"
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// Flags accepted by setxattr(2).
+const (
+	xattrCreate  = 0x1
+	xattrReplace = 0x2
+)
+
+// An extended attribute describing the GCS object backing an inode. These
+// are read-only, and computed from the inode's source object on demand.
+type objectXattr struct {
+	name  string
+	value func(o *gcs.Object) []byte
+}
+
+// The extended attributes exposed for inodes backed by GCS objects, in the
+// order in which they are listed.
+var objectXattrs = []objectXattr{
+	// Whether an event-based hold prevents the object from being deleted or
+	// overwritten.
+	{
+		name: "user.gcsfuse.event_based_hold",
+		value: func(o *gcs.Object) []byte {
+			return []byte(strconv.FormatBool(o.EventBasedHold))
+		},
+	},
+
+	// The object's storage class, e.g. STANDARD or ARCHIVE.
+	{
+		name: "user.gcsfuse.storage_class",
+		value: func(o *gcs.Object) []byte {
+			return []byte(o.StorageClass)
+		},
+	},
+
+	// The object's generation, which changes whenever its contents are
+	// replaced. Opening "name#generation" reads this generation only.
+	{
+		name: "user.gcsfuse.generation",
+		value: func(o *gcs.Object) []byte {
+			return []byte(strconv.FormatInt(o.Generation, 10))
+		},
+	},
+
+	// The object's meta-generation, which changes whenever its metadata is
+	// updated.
+	{
+		name: "user.gcsfuse.metageneration",
+		value: func(o *gcs.Object) []byte {
+			return []byte(strconv.FormatInt(o.MetaGeneration, 10))
+		},
+	},
+}
+
+// Return the object backing the inode for the purposes of extended
+// attributes, or nil if the inode exposes none.
+//
+// LOCKS_REQUIRED(in)
+func xattrSource(in inode.Inode) *gcs.Object {
+	if f, ok := in.(*inode.FileInode); ok {
+		return f.Source()
+	}
+
+	return nil
+}
+
+// Copy an extended attribute value (or name list) into dst following the
+// getxattr(2) conventions: an empty dst asks only for the size, and a dst
+// that is too small is an error.
+func copyXattr(dst []byte, value []byte) (n int, err error) {
+	n = len(value)
+	if len(dst) == 0 {
+		return
+	}
+
+	if len(dst) < n {
+		err = syscall.ERANGE
+		return
+	}
+
+	copy(dst, value)
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// User xattrs
+////////////////////////////////////////////////////////////////////////
+
+// Other user.* xattrs set on files are persisted in the backing object's
+// custom metadata, under this prefix followed by the full xattr name. Names
+// under userXattrReserved belong to gcsfuse and are never stored.
+const (
+	userXattrPrefix         = "user."
+	userXattrReserved       = "user.gcsfuse."
+	userXattrMetadataPrefix = "gcsfuse_xattr."
+)
+
+// Values that aren't printable UTF-8 are stored base64-encoded, marked with
+// this prefix. So are values that happen to start with it.
+const userXattrBase64Prefix = "base64:"
+
+// GCS limits custom metadata to 8 KiB per object, shared with the mtime and
+// anything other tools store. Larger values fail with E2BIG, and values that
+// would take the total stored for user xattrs past the limit with ENOSPC.
+const (
+	maxUserXattrValueSize = 2048
+	maxUserXattrTotalSize = 4096
+)
+
+// Is the name that of an xattr persisted in object metadata?
+func isUserXattr(name string) bool {
+	return strings.HasPrefix(name, userXattrPrefix) &&
+		!strings.HasPrefix(name, userXattrReserved) &&
+		len(name) > len(userXattrPrefix)
+}
+
+func encodeUserXattr(value []byte) string {
+	printable := utf8.Valid(value) &&
+		!bytes.HasPrefix(value, []byte(userXattrBase64Prefix)) &&
+		strings.IndexFunc(string(value), unicode.IsControl) < 0
+
+	if printable {
+		return string(value)
+	}
+
+	return userXattrBase64Prefix + base64.StdEncoding.EncodeToString(value)
+}
+
+func decodeUserXattr(s string) (value []byte, err error) {
+	if !strings.HasPrefix(s, userXattrBase64Prefix) {
+		value = []byte(s)
+		return
+	}
+
+	value, err = base64.StdEncoding.DecodeString(
+		strings.TrimPrefix(s, userXattrBase64Prefix))
+	if err != nil {
+		err = fmt.Errorf("decoding xattr value: %w", err)
+		return
+	}
+
+	return
+}
+
+// Return the names of the user xattrs stored in the object's metadata, in
+// sorted order.
+func userXattrNames(o *gcs.Object) (names []string) {
+	for k := range o.Metadata {
+		if name := strings.TrimPrefix(k, userXattrMetadataPrefix); name != k {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+	return
+}
+
+// Return the number of bytes of metadata taken by the object's user xattrs,
+// ignoring the named one.
+func userXattrSize(o *gcs.Object, except string) (n int) {
+	for k, v := range o.Metadata {
+		if strings.HasPrefix(k, userXattrMetadataPrefix) &&
+			k != userXattrMetadataPrefix+except {
+			n += len(k) + len(v)
+		}
+	}
+
+	return
+}
+
+// Set the user xattr on the file, following the setxattr(2) flags.
+//
+// LOCKS_REQUIRED(f)
+func setUserXattr(
+	ctx context.Context,
+	f *inode.FileInode,
+	name string,
+	value []byte,
+	flags uint32) (err error) {
+	key := userXattrMetadataPrefix + name
+	_, exists := f.Source().Metadata[key]
+
+	switch {
+	case flags&xattrCreate != 0 && exists:
+		return syscall.EEXIST
+	case flags&xattrReplace != 0 && !exists:
+		return syscall.ENODATA
+	}
+
+	if len(value) > maxUserXattrValueSize {
+		return syscall.E2BIG
+	}
+
+	encoded := encodeUserXattr(value)
+	if userXattrSize(f.Source(), name)+len(key)+len(encoded) > maxUserXattrTotalSize {
+		return syscall.ENOSPC
+	}
+
+	err = f.UpdateMetadata(ctx, map[string]*string{key: &encoded})
+	return
+}
+
+// Remove the user xattr from the file.
+//
+// LOCKS_REQUIRED(f)
+func removeUserXattr(
+	ctx context.Context,
+	f *inode.FileInode,
+	name string) (err error) {
+	key := userXattrMetadataPrefix + name
+	if _, ok := f.Source().Metadata[key]; !ok {
+		return syscall.ENODATA
+	}
+
+	err = f.UpdateMetadata(ctx, map[string]*string{key: nil})
+	return
+}
@@ -0,0 +1,62 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestUserXattrEncoding(t *testing.T) {
+	testCases := []struct {
+		value   []byte
+		encoded string
+	}{
+		{[]byte("sha256:abcd"), "sha256:abcd"},
+		{[]byte(""), ""},
+		{[]byte("café"), "café"},
+		{[]byte{0, 1, 2}, "base64:AAEC"},
+		{[]byte("line\n"), "base64:bGluZQo="},
+		{[]byte("base64:x"), "base64:YmFzZTY0Ong="},
+	}
+
+	for _, tc := range testCases {
+		encoded := encodeUserXattr(tc.value)
+		if encoded != tc.encoded {
+			t.Errorf("%q: got %q, expected %q", tc.value, encoded, tc.encoded)
+		}
+
+		decoded, err := decodeUserXattr(encoded)
+		if err != nil || !bytes.Equal(decoded, tc.value) {
+			t.Errorf("%q: decoded to %q, %v", tc.value, decoded, err)
+		}
+	}
+}
+
+func TestIsUserXattr(t *testing.T) {
+	testCases := map[string]bool{
+		"user.checksum":       true,
+		"user.":               false,
+		"user.gcsfuse.acl":    false,
+		"security.selinux":    false,
+		"trusted.overlay.foo": false,
+	}
+
+	for name, expected := range testCases {
+		if got := isUserXattr(name); got != expected {
+			t.Errorf("%q: got %v, expected %v", name, got, expected)
+		}
+	}
+}
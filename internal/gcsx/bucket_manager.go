@@ -17,6 +17,7 @@ package gcsx
 import (
 	"errors"
 	"fmt"
+	"math"
 	"os"
 	"path"
 	"time"
@@ -26,6 +27,7 @@ import (
 	"golang.org/x/net/context"
 
 	"github.com/googlecloudplatform/gcsfuse/internal/canned"
+	"github.com/googlecloudplatform/gcsfuse/internal/health"
 	"github.com/googlecloudplatform/gcsfuse/internal/logger"
 	"github.com/googlecloudplatform/gcsfuse/internal/monitor"
 	"github.com/jacobsa/gcloud/gcs"
@@ -35,15 +37,53 @@ import (
 )
 
 type BucketConfig struct {
-	BillingProject                     string
-	OnlyDir                            string
-	EgressBandwidthLimitBytesPerSecond float64
-	OpRateLimitHz                      float64
-	StatCacheCapacity                  int
-	StatCacheTTL                       time.Duration
-	EnableMonitoring                   bool
-	EnableStorageClientLibrary         bool
-	DebugGCS                           bool
+	BillingProject                      string
+	OnlyDir                             string
+	IngressBandwidthLimitBytesPerSecond float64
+	EgressBandwidthLimitBytesPerSecond  float64
+	OpRateLimitHz                       float64
+	StatCacheCapacity                   int
+	StatCacheTTL                        time.Duration
+	EnableMonitoring                    bool
+	EnableStorageClientLibrary          bool
+	DebugGCS                            bool
+
+	// If non-empty, a 32-byte key used to encrypt object contents on the client
+	// side. See NewEncryptingBucket. Appending by composition is disabled for
+	// encrypted buckets.
+	EncryptionKey []byte
+
+	// Content types to use for new objects, by lower-case file extension
+	// including the leading dot. See NewContentTypeBucket.
+	ContentTypeOverrides map[string]string
+
+	// Headers to set on new objects by name. See NewObjectHeaderBucket.
+	ObjectHeaderRules []ObjectHeaderRule
+
+	// If set, new objects are stored compressed. See NewCompressingBucket.
+	// Appending by composition is disabled for compressed buckets.
+	EnableCompression bool
+
+	// If positive, calls to GCS fail fast with ErrCircuitOpen for
+	// CircuitBreakerCooldown once at least this fraction of recent calls have
+	// found GCS unavailable. See NewCircuitBreakerBucket.
+	CircuitBreakerThreshold float64
+	CircuitBreakerCooldown  time.Duration
+
+	// Limits on how long calls to GCS may take. See NewTimeoutBucket.
+	CallTimeouts CallTimeouts
+
+	// If set, the outcome of every call to GCS is reported to this checker.
+	// See health.NewBucket.
+	HealthChecker *health.Checker
+
+	// Set if the bucket is mounted read-only, so that nothing in it should be
+	// modified on the mount's behalf.
+	ReadOnly bool
+
+	// The directory in which to stage contents that must be transformed before
+	// upload. If empty, the system default is used.
+	TempDir string
 
 	// Files backed by on object of length at least AppendThreshold that have
 	// only been appended to (i.e. none of the object's contents have been
@@ -98,9 +138,10 @@ func NewBucketManager(config BucketConfig, conn *Connection, storageHandle stora
 func setUpRateLimiting(
 	in gcs.Bucket,
 	opRateLimitHz float64,
+	ingressBandwidthLimit float64,
 	egressBandwidthLimit float64) (out gcs.Bucket, err error) {
 	// If no rate limiting has been requested, just return the bucket.
-	if !(opRateLimitHz > 0 || egressBandwidthLimit > 0) {
+	if !(opRateLimitHz > 0 || ingressBandwidthLimit > 0 || egressBandwidthLimit > 0) {
 		out = in
 		return
 	}
@@ -110,8 +151,8 @@ func setUpRateLimiting(
 		opRateLimitHz = 1e15
 	}
 
-	if !(egressBandwidthLimit > 0) {
-		egressBandwidthLimit = 1e15
+	if !(ingressBandwidthLimit > 0) {
+		ingressBandwidthLimit = 1e15
 	}
 
 	// Choose token bucket capacities, targeting only a few percent error in each
@@ -127,25 +168,43 @@ func setUpRateLimiting(
 		return
 	}
 
-	egressCapacity, err := ratelimit.ChooseTokenBucketCapacity(
-		egressBandwidthLimit,
+	ingressCapacity, err := ratelimit.ChooseTokenBucketCapacity(
+		ingressBandwidthLimit,
 		window)
 
 	if err != nil {
-		err = fmt.Errorf("Choosing egress bandwidth token bucket capacity: %w", err)
+		err = fmt.Errorf("Choosing ingress bandwidth token bucket capacity: %w", err)
 		return
 	}
 
 	// Create the throttles.
 	opThrottle := ratelimit.NewThrottle(opRateLimitHz, opCapacity)
-	egressThrottle := ratelimit.NewThrottle(egressBandwidthLimit, egressCapacity)
+	ingressThrottle := ratelimit.NewThrottle(ingressBandwidthLimit, ingressCapacity)
 
-	// And the bucket.
+	// And the bucket. Package ratelimit names its read throttle for egress from
+	// GCS, which is ingress to us.
 	out = ratelimit.NewThrottledBucket(
 		opThrottle,
-		egressThrottle,
+		ingressThrottle,
 		in)
 
+	// Limit uploads separately, if requested.
+	if egressBandwidthLimit > 0 {
+		var egressCapacity uint64
+		egressCapacity, err = ratelimit.ChooseTokenBucketCapacity(
+			egressBandwidthLimit,
+			window)
+
+		if err != nil {
+			err = fmt.Errorf("Choosing egress bandwidth token bucket capacity: %w", err)
+			return
+		}
+
+		out = NewUploadThrottledBucket(
+			ratelimit.NewThrottle(egressBandwidthLimit, egressCapacity),
+			out)
+	}
+
 	return
 }
 
@@ -154,12 +213,29 @@ func setUpRateLimiting(
 // Special case: if the bucket name is canned.FakeBucketName, set up a fake
 // bucket as described in that package.
 func (bm *bucketManager) SetUpGcsBucket(ctx context.Context, name string) (b gcs.Bucket, err error) {
+	b, _, _, err = bm.setUpGcsBucket(ctx, name)
+	return
+}
+
+// Like SetUpGcsBucket, but also return means of setting object ACLs in the
+// bucket and of statting noncurrent generations, where there are any.
+func (bm *bucketManager) setUpGcsBucket(
+	ctx context.Context,
+	name string) (
+	b gcs.Bucket,
+	aclSetter storage.AclSetter,
+	generationStatter storage.GenerationStatter,
+	err error) {
 	if bm.config.EnableStorageClientLibrary {
-		b, err = bm.storageHandle.BucketHandle(name)
+		bh, err := bm.storageHandle.BucketHandle(name)
 		if err != nil {
-			return
+			return nil, nil, nil, err
 		}
 
+		b = bh
+		aclSetter = bh
+		generationStatter = bh
+
 		if reqtrace.Enabled() {
 			b = gcs.GetWrappedWithReqtraceBucket(b)
 		}
@@ -175,6 +251,26 @@ func (bm *bucketManager) SetUpGcsBucket(ctx context.Context, name string) (b gcs
 				BillingProject: bm.config.BillingProject,
 			},
 		)
+		if err != nil {
+			return
+		}
+
+		generationStatter = bm.conn.GenerationStatter(name, bm.config.BillingProject)
+
+		// Find out whether the bucket has object ACLs at all. This is best
+		// effort; don't fail the mount over it.
+		var ubla bool
+		aclSetter, ubla, err = bm.conn.AclSetter(ctx, name, bm.config.BillingProject)
+		if err != nil {
+			logger.Infof("Cannot set object ACLs in bucket %q: %v\n", name, err)
+			aclSetter = nil
+			err = nil
+		} else if ubla {
+			logger.Infof(
+				"Bucket %q uses uniform bucket-level access; skipping object ACLs.\n",
+				name)
+			b = NewUniformAccessBucket(b)
+		}
 	}
 	return
 }
@@ -183,17 +279,69 @@ func (bm *bucketManager) SetUpBucket(
 	ctx context.Context,
 	name string) (sb SyncerBucket, err error) {
 	var b gcs.Bucket
+	var aclSetter storage.AclSetter
+	var generationStatter storage.GenerationStatter
 	// Set up the appropriate backing bucket.
 	if name == canned.FakeBucketName {
 		b = canned.MakeFakeBucket(ctx)
 	} else {
-		b, err = bm.SetUpGcsBucket(ctx, name)
+		b, aclSetter, generationStatter, err = bm.setUpGcsBucket(ctx, name)
 		if err != nil {
 			err = fmt.Errorf("OpenBucket: %w", err)
 			return
 		}
 	}
 
+	// Abandon calls to GCS that take too long, if requested. This sits below
+	// the health checks and circuit breaker, so that timeouts count as
+	// failures.
+	if bm.config.CallTimeouts != (CallTimeouts{}) {
+		b = NewTimeoutBucket(bm.config.CallTimeouts, b)
+	}
+
+	// Report call outcomes for health checks, if requested. This sits directly
+	// over the backing bucket so that only calls that reach GCS count.
+	if bm.config.HealthChecker != nil {
+		b = health.NewBucket(bm.config.HealthChecker, b)
+	}
+
+	// Fail fast while GCS seems to be down, if requested.
+	if bm.config.CircuitBreakerThreshold > 0 {
+		b = NewCircuitBreakerBucket(
+			bm.config.CircuitBreakerThreshold,
+			bm.config.CircuitBreakerCooldown,
+			timeutil.RealClock(),
+			b)
+	}
+
+	// Encrypt object contents, if requested.
+	appendThreshold := bm.config.AppendThreshold
+	if len(bm.config.EncryptionKey) != 0 {
+		b, err = NewEncryptingBucket(bm.config.EncryptionKey, b)
+		if err != nil {
+			err = fmt.Errorf("NewEncryptingBucket: %w", err)
+			return
+		}
+
+		if generationStatter != nil {
+			generationStatter = newTranslatingGenerationStatter(b, generationStatter)
+		}
+
+		appendThreshold = math.MaxInt64
+	}
+
+	// Compress object contents, if requested. This must sit above encryption,
+	// since ciphertext does not compress.
+	if bm.config.EnableCompression {
+		b = NewCompressingBucket(bm.config.TempDir, b)
+
+		if generationStatter != nil {
+			generationStatter = newTranslatingGenerationStatter(b, generationStatter)
+		}
+
+		appendThreshold = math.MaxInt64
+	}
+
 	// Limit to a requested prefix of the bucket, if any.
 	if bm.config.OnlyDir != "" {
 		b, err = NewPrefixBucket(path.Clean(bm.config.OnlyDir)+"/", b)
@@ -201,12 +349,23 @@ func (bm *bucketManager) SetUpBucket(
 			err = fmt.Errorf("NewPrefixBucket: %w", err)
 			return
 		}
+
+		if aclSetter != nil {
+			aclSetter = NewPrefixAclSetter(path.Clean(bm.config.OnlyDir)+"/", aclSetter)
+		}
+
+		if generationStatter != nil {
+			generationStatter = NewPrefixGenerationStatter(
+				path.Clean(bm.config.OnlyDir)+"/",
+				generationStatter)
+		}
 	}
 
 	// Enable rate limiting, if requested.
 	b, err = setUpRateLimiting(
 		b,
 		bm.config.OpRateLimitHz,
+		bm.config.IngressBandwidthLimitBytesPerSecond,
 		bm.config.EgressBandwidthLimitBytesPerSecond)
 
 	if err != nil {
@@ -219,13 +378,18 @@ func (bm *bucketManager) SetUpBucket(
 		cacheCapacity := bm.config.StatCacheCapacity
 		b = gcscaching.NewFastStatBucket(
 			bm.config.StatCacheTTL,
-			gcscaching.NewStatCache(cacheCapacity),
+			monitor.NewStatCache(cacheCapacity),
 			timeutil.RealClock(),
 			b)
 	}
 
 	// Enable content type awareness
-	b = NewContentTypeBucket(b)
+	b = NewContentTypeBucket(bm.config.ContentTypeOverrides, b)
+
+	// Set headers on new objects, if requested.
+	if len(bm.config.ObjectHeaderRules) > 0 {
+		b = NewObjectHeaderBucket(bm.config.ObjectHeaderRules, b)
+	}
 
 	// Enable monitoring
 	if bm.config.EnableMonitoring {
@@ -238,9 +402,11 @@ func (bm *bucketManager) SetUpBucket(
 		return
 	}
 	sb = NewSyncerBucket(
-		bm.config.AppendThreshold,
+		appendThreshold,
 		bm.config.TmpObjectPrefix,
 		b)
+	sb.AclSetter = aclSetter
+	sb.GenerationStatter = generationStatter
 
 	// Check whether this bucket works, giving the user a warning early if there
 	// is some problem.
@@ -251,6 +417,19 @@ func (bm *bucketManager) SetUpBucket(
 		}
 	}
 
+	// Finish any directory renames that a mount was interrupted in the middle
+	// of, without holding up this one. Read-only mounts leave them to the next
+	// writable one.
+	if !bm.config.ReadOnly {
+		go func() {
+			if n, err := ResumeRenames(bm.gcCtx, sb, timeutil.RealClock()); err != nil {
+				logger.Infof("Failed to finish interrupted renames: %v\n", err)
+			} else if n > 0 {
+				logger.Infof("Finished %d interrupted renames.\n", n)
+			}
+		}()
+	}
+
 	// Periodically garbage collect temporary objects
 	go garbageCollect(bm.gcCtx, bm.config.TmpObjectPrefix, sb)
 
@@ -8,6 +8,7 @@ import (
 	"github.com/googlecloudplatform/gcsfuse/internal/storage"
 	"github.com/jacobsa/gcloud/gcs"
 	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
 	. "github.com/jacobsa/ogletest"
 	"github.com/jacobsa/timeutil"
 )
@@ -92,6 +93,27 @@ func (t *BucketManagerTest) TestSetupGcsBucketWhenEnableStorageClientLibraryIsFa
 	ExpectEq(nil, err)
 }
 
+func (t *BucketManagerTest) TestSetupGcsBucketWithFakeConnection() {
+	var bm bucketManager
+	bm.conn = NewFakeConnection(timeutil.RealClock())
+	ctx := context.Background()
+
+	// Create an object through one handle to the bucket.
+	bucket, err := bm.SetUpGcsBucket(ctx, TestBucketName)
+	AssertEq(nil, err)
+
+	_, err = gcsutil.CreateObject(ctx, bucket, "foo", []byte("taco"))
+	AssertEq(nil, err)
+
+	// It should be visible through another.
+	bucket, err = bm.SetUpGcsBucket(ctx, TestBucketName)
+	AssertEq(nil, err)
+
+	contents, err := gcsutil.ReadObject(ctx, bucket, "foo")
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+}
+
 func (t *BucketManagerTest) TestSetUpBucketMethod() {
 	var bm bucketManager
 	bucketConfig := BucketConfig{
@@ -0,0 +1,312 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/logger"
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+// ErrCircuitOpen is returned for calls refused while a circuit breaker is
+// open. It wraps EIO, and counts as GCS being unavailable.
+var ErrCircuitOpen = fmt.Errorf("GCS circuit breaker is open: %w", syscall.EIO)
+
+// The number of most recent calls over which the failure rate is measured,
+// and the number that must have been made before the breaker may trip.
+const (
+	circuitBreakerWindow   = 20
+	circuitBreakerMinCalls = 10
+)
+
+// NewCircuitBreakerBucket creates a wrapper bucket that stops calling GCS
+// when it seems to be down. Once at least the given fraction of recent calls
+// have failed with errors for which IsUnavailable is true, the breaker opens,
+// and calls fail immediately with ErrCircuitOpen for the cool-down period.
+// Then a single trial call is let through: if it succeeds the breaker closes,
+// and otherwise it stays open for another cool-down period.
+//
+// REQUIRES: 0 < threshold <= 1
+func NewCircuitBreakerBucket(
+	threshold float64,
+	cooldown time.Duration,
+	clock timeutil.Clock,
+	b gcs.Bucket) gcs.Bucket {
+	return &circuitBreakerBucket{
+		Bucket: b,
+		breaker: &circuitBreaker{
+			name:      b.Name(),
+			threshold: threshold,
+			cooldown:  cooldown,
+			clock:     clock,
+			outcomes:  make([]bool, circuitBreakerWindow),
+		},
+	}
+}
+
+////////////////////////////////////////////////////////////////////////
+// Breaker
+////////////////////////////////////////////////////////////////////////
+
+type circuitBreaker struct {
+	/////////////////////////
+	// Constant data
+	/////////////////////////
+
+	name      string
+	threshold float64
+	cooldown  time.Duration
+	clock     timeutil.Clock
+
+	/////////////////////////
+	// Mutable state
+	/////////////////////////
+
+	mu sync.Mutex
+
+	// The outcomes of the most recent calls, true for a failure, as a ring
+	// whose next slot is outcomes[next].
+	//
+	// INVARIANT: len(outcomes) == circuitBreakerWindow
+	// INVARIANT: 0 <= calls <= len(outcomes)
+	// INVARIANT: failures is the number of true outcomes among the last calls
+	//
+	// GUARDED_BY(mu)
+	outcomes []bool
+	next     int
+	calls    int
+	failures int
+
+	// The time until which calls are refused, or zero if the breaker is
+	// closed.
+	//
+	// GUARDED_BY(mu)
+	openUntil time.Time
+
+	// Set while the trial call after a cool-down period is outstanding.
+	//
+	// GUARDED_BY(mu)
+	trialInFlight bool
+}
+
+// allow returns nil if a call may go through, and whether it is the trial
+// call after a cool-down period.
+//
+// LOCKS_EXCLUDED(cb.mu)
+func (cb *circuitBreaker) allow() (trial bool, err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.openUntil.IsZero() {
+		return
+	}
+
+	if cb.clock.Now().Before(cb.openUntil) || cb.trialInFlight {
+		err = ErrCircuitOpen
+		return
+	}
+
+	cb.trialInFlight = true
+	trial = true
+	return
+}
+
+// record records the outcome of a call that allow let through.
+//
+// LOCKS_EXCLUDED(cb.mu)
+func (cb *circuitBreaker) record(trial bool, err error) {
+	failed := IsUnavailable(err)
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if trial {
+		cb.trialInFlight = false
+
+		// A cancelled trial says nothing; let the next call try instead.
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+
+		if failed {
+			cb.openUntil = cb.clock.Now().Add(cb.cooldown)
+			return
+		}
+
+		logger.Infof("GCS circuit breaker for %q closed", cb.name)
+		cb.openUntil = time.Time{}
+		cb.reset()
+		return
+	}
+
+	// Push the outcome onto the ring, dropping the oldest.
+	if cb.calls == len(cb.outcomes) && cb.outcomes[cb.next] {
+		cb.failures--
+	}
+	if cb.calls < len(cb.outcomes) {
+		cb.calls++
+	}
+	cb.outcomes[cb.next] = failed
+	cb.next = (cb.next + 1) % len(cb.outcomes)
+	if failed {
+		cb.failures++
+	}
+
+	// Trip if the failure rate is high enough.
+	if cb.openUntil.IsZero() &&
+		cb.calls >= circuitBreakerMinCalls &&
+		float64(cb.failures) >= cb.threshold*float64(cb.calls) {
+		logger.Infof(
+			"GCS circuit breaker for %q opened after %d of the last %d calls failed; failing fast for %v",
+			cb.name,
+			cb.failures,
+			cb.calls,
+			cb.cooldown)
+
+		cb.openUntil = cb.clock.Now().Add(cb.cooldown)
+		cb.reset()
+	}
+}
+
+// LOCKS_REQUIRED(cb.mu)
+func (cb *circuitBreaker) reset() {
+	for i := range cb.outcomes {
+		cb.outcomes[i] = false
+	}
+	cb.next = 0
+	cb.calls = 0
+	cb.failures = 0
+}
+
+////////////////////////////////////////////////////////////////////////
+// Bucket
+////////////////////////////////////////////////////////////////////////
+
+type circuitBreakerBucket struct {
+	gcs.Bucket
+	breaker *circuitBreaker
+}
+
+func (b *circuitBreakerBucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (rc io.ReadCloser, err error) {
+	trial, err := b.breaker.allow()
+	if err != nil {
+		return
+	}
+
+	rc, err = b.Bucket.NewReader(ctx, req)
+	b.breaker.record(trial, err)
+	return
+}
+
+func (b *circuitBreakerBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	trial, err := b.breaker.allow()
+	if err != nil {
+		return
+	}
+
+	o, err = b.Bucket.CreateObject(ctx, req)
+	b.breaker.record(trial, err)
+	return
+}
+
+func (b *circuitBreakerBucket) CopyObject(
+	ctx context.Context,
+	req *gcs.CopyObjectRequest) (o *gcs.Object, err error) {
+	trial, err := b.breaker.allow()
+	if err != nil {
+		return
+	}
+
+	o, err = b.Bucket.CopyObject(ctx, req)
+	b.breaker.record(trial, err)
+	return
+}
+
+func (b *circuitBreakerBucket) ComposeObjects(
+	ctx context.Context,
+	req *gcs.ComposeObjectsRequest) (o *gcs.Object, err error) {
+	trial, err := b.breaker.allow()
+	if err != nil {
+		return
+	}
+
+	o, err = b.Bucket.ComposeObjects(ctx, req)
+	b.breaker.record(trial, err)
+	return
+}
+
+func (b *circuitBreakerBucket) StatObject(
+	ctx context.Context,
+	req *gcs.StatObjectRequest) (o *gcs.Object, err error) {
+	trial, err := b.breaker.allow()
+	if err != nil {
+		return
+	}
+
+	o, err = b.Bucket.StatObject(ctx, req)
+	b.breaker.record(trial, err)
+	return
+}
+
+func (b *circuitBreakerBucket) ListObjects(
+	ctx context.Context,
+	req *gcs.ListObjectsRequest) (listing *gcs.Listing, err error) {
+	trial, err := b.breaker.allow()
+	if err != nil {
+		return
+	}
+
+	listing, err = b.Bucket.ListObjects(ctx, req)
+	b.breaker.record(trial, err)
+	return
+}
+
+func (b *circuitBreakerBucket) UpdateObject(
+	ctx context.Context,
+	req *gcs.UpdateObjectRequest) (o *gcs.Object, err error) {
+	trial, err := b.breaker.allow()
+	if err != nil {
+		return
+	}
+
+	o, err = b.Bucket.UpdateObject(ctx, req)
+	b.breaker.record(trial, err)
+	return
+}
+
+func (b *circuitBreakerBucket) DeleteObject(
+	ctx context.Context,
+	req *gcs.DeleteObjectRequest) (err error) {
+	trial, err := b.breaker.allow()
+	if err != nil {
+		return
+	}
+
+	err = b.Bucket.DeleteObject(ctx, req)
+	b.breaker.record(trial, err)
+	return
+}
@@ -0,0 +1,149 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx_test
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+)
+
+func TestCircuitBreakerBucket(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+// A bucket whose StatObject fails with err, if set.
+type failingBucket struct {
+	gcs.Bucket
+	err   error
+	calls int
+}
+
+func (b *failingBucket) StatObject(
+	ctx context.Context,
+	req *gcs.StatObjectRequest) (*gcs.Object, error) {
+	b.calls++
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	return b.Bucket.StatObject(ctx, req)
+}
+
+const circuitBreakerCooldown = time.Minute
+
+type CircuitBreakerBucketTest struct {
+	ctx     context.Context
+	clock   timeutil.SimulatedClock
+	wrapped *failingBucket
+	bucket  gcs.Bucket
+}
+
+func init() { RegisterTestSuite(&CircuitBreakerBucketTest{}) }
+
+func (t *CircuitBreakerBucketTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.clock.SetTime(time.Date(2015, 4, 5, 2, 15, 0, 0, time.Local))
+	t.wrapped = &failingBucket{
+		Bucket: gcsfake.NewFakeBucket(&t.clock, "some_bucket"),
+	}
+	t.bucket = gcsx.NewCircuitBreakerBucket(0.5, circuitBreakerCooldown, &t.clock, t.wrapped)
+}
+
+func (t *CircuitBreakerBucketTest) stat() error {
+	_, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	return err
+}
+
+// trip makes enough calls fail to open the breaker.
+func (t *CircuitBreakerBucketTest) trip() {
+	t.wrapped.err = &googleapi.Error{Code: 503}
+	for i := 0; i < 10; i++ {
+		AssertFalse(errors.Is(t.stat(), gcsx.ErrCircuitOpen))
+	}
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *CircuitBreakerBucketTest) RejectedRequestsDoNotTrip() {
+	t.wrapped.err = &googleapi.Error{Code: 403}
+	for i := 0; i < 20; i++ {
+		t.stat()
+	}
+
+	ExpectEq(20, t.wrapped.calls)
+}
+
+func (t *CircuitBreakerBucketTest) FewFailuresDoNotTrip() {
+	// Four failures in ten calls is below the threshold.
+	for i := 0; i < 10; i++ {
+		if i%3 == 0 {
+			t.wrapped.err = &googleapi.Error{Code: 503}
+		} else {
+			t.wrapped.err = nil
+		}
+		t.stat()
+	}
+
+	t.wrapped.err = nil
+	var notFoundErr *gcs.NotFoundError
+	ExpectTrue(errors.As(t.stat(), &notFoundErr))
+}
+
+func (t *CircuitBreakerBucketTest) FailsFastWhileOpen() {
+	t.trip()
+	calls := t.wrapped.calls
+
+	err := t.stat()
+	ExpectTrue(errors.Is(err, gcsx.ErrCircuitOpen))
+	ExpectTrue(errors.Is(err, syscall.EIO))
+	ExpectTrue(gcsx.IsUnavailable(err))
+	ExpectEq(calls, t.wrapped.calls)
+}
+
+func (t *CircuitBreakerBucketTest) ClosesAfterSuccessfulTrial() {
+	t.trip()
+	t.wrapped.err = nil
+
+	t.clock.AdvanceTime(circuitBreakerCooldown + time.Second)
+	var notFoundErr *gcs.NotFoundError
+	ExpectTrue(errors.As(t.stat(), &notFoundErr))
+	ExpectTrue(errors.As(t.stat(), &notFoundErr))
+}
+
+func (t *CircuitBreakerBucketTest) ReopensAfterFailedTrial() {
+	t.trip()
+
+	t.clock.AdvanceTime(circuitBreakerCooldown + time.Second)
+	calls := t.wrapped.calls
+	ExpectFalse(errors.Is(t.stat(), gcsx.ErrCircuitOpen))
+	ExpectEq(calls+1, t.wrapped.calls)
+
+	ExpectTrue(errors.Is(t.stat(), gcsx.ErrCircuitOpen))
+	ExpectEq(calls+1, t.wrapped.calls)
+}
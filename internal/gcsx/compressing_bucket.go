@@ -0,0 +1,601 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"sync"
+
+	"github.com/jacobsa/fuse/fsutil"
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/util/lrucache"
+	"golang.org/x/net/context"
+)
+
+const (
+	// Metadata keys recording that an object is stored compressed, and the
+	// block index needed to read it. Both are hidden from objects returned by
+	// the compressing bucket.
+	compressionMetadataKey      = "gcsfuse_compression"
+	compressionIndexMetadataKey = "gcsfuse_compression_index"
+	compressionVersion          = "deflate-v1"
+
+	// Plaintext is compressed in independent blocks of at least this size, so
+	// that a range can be read by decompressing only the blocks covering it.
+	compressionBlockSize = 1 << 18
+
+	// GCS limits custom metadata to 8 KiB in total, so larger objects use
+	// larger blocks to keep the index within this many entries.
+	maxCompressionIndexBlocks = 768
+
+	// The number of objects whose indexes are remembered between a stat or
+	// listing and the reads that follow it.
+	compressionIndexCacheCapacity = 4096
+)
+
+// NewCompressingBucket creates a wrapper bucket that stores the contents of
+// newly created objects compressed, and decompresses them on read.
+//
+// Contents are compressed with DEFLATE in independent blocks, whose
+// compressed lengths are recorded in the object's metadata. Random reads
+// decompress only the blocks they cover. Contents that do not shrink are
+// stored as written. Objects returned by the bucket report their uncompressed
+// size and no content checksums, and objects that were not written through a
+// compressing bucket are passed through unchanged.
+//
+// New contents are staged in anonymous files in tempDir, or the system default
+// if empty, before upload. Composing objects is not supported.
+func NewCompressingBucket(tempDir string, b gcs.Bucket) gcs.Bucket {
+	return &compressingBucket{
+		Bucket:  b,
+		tempDir: tempDir,
+		indexes: lrucache.New(compressionIndexCacheCapacity),
+	}
+}
+
+// The layout of a compressed object.
+type compressionIndex struct {
+	generation int64
+	blockSize  uint64
+	size       uint64
+
+	// offsets[i] is the offset in GCS of block i. The final entry is the size
+	// of the object in GCS.
+	offsets []uint64
+}
+
+type compressingBucket struct {
+	gcs.Bucket
+
+	tempDir string
+
+	mu sync.Mutex
+
+	// Indexes of recently seen compressed objects, by name.
+	//
+	// INVARIANT: Each value is of type compressionIndex
+	//
+	// GUARDED_BY(mu)
+	indexes lrucache.Cache
+}
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+// Choose a block size for contents of the given size that keeps the index
+// within maxCompressionIndexBlocks entries.
+func chooseCompressionBlockSize(size uint64) (blockSize uint64) {
+	blockSize = compressionBlockSize
+	if size <= blockSize*maxCompressionIndexBlocks {
+		return
+	}
+
+	blocks := (size + maxCompressionIndexBlocks - 1) / maxCompressionIndexBlocks
+	blockSize = (blocks + compressionBlockSize - 1) /
+		compressionBlockSize * compressionBlockSize
+
+	return
+}
+
+func encodeCompressionIndex(blockSize, size uint64, lengths []uint64) string {
+	buf := make([]byte, binary.MaxVarintLen64*(len(lengths)+2))
+	n := binary.PutUvarint(buf, blockSize)
+	n += binary.PutUvarint(buf[n:], size)
+	for _, l := range lengths {
+		n += binary.PutUvarint(buf[n:], l)
+	}
+
+	return base64.StdEncoding.EncodeToString(buf[:n])
+}
+
+func decodeCompressionIndex(o *gcs.Object) (idx compressionIndex, err error) {
+	buf, err := base64.StdEncoding.DecodeString(o.Metadata[compressionIndexMetadataKey])
+	if err != nil {
+		err = fmt.Errorf("decoding index: %w", err)
+		return
+	}
+
+	next := func() (v uint64) {
+		v, n := binary.Uvarint(buf)
+		if n <= 0 {
+			err = errors.New("truncated index")
+			return
+		}
+
+		buf = buf[n:]
+		return
+	}
+
+	idx.generation = o.Generation
+	idx.blockSize = next()
+	idx.size = next()
+	if err != nil {
+		return
+	}
+
+	if idx.blockSize == 0 {
+		err = errors.New("zero block size in index")
+		return
+	}
+
+	blocks := (idx.size + idx.blockSize - 1) / idx.blockSize
+	idx.offsets = make([]uint64, 1, blocks+1)
+	for i := uint64(0); i < blocks && err == nil; i++ {
+		idx.offsets = append(idx.offsets, idx.offsets[i]+next())
+	}
+
+	if err != nil {
+		return
+	}
+
+	if idx.offsets[blocks] != o.Size {
+		err = fmt.Errorf(
+			"index covers %d bytes, object has %d",
+			idx.offsets[blocks],
+			o.Size)
+		return
+	}
+
+	return
+}
+
+// Compress size bytes from src into dst in blocks of the given size,
+// returning the compressed length of each.
+func compressBlocks(
+	dst io.Writer,
+	src io.Reader,
+	size uint64,
+	blockSize uint64) (lengths []uint64, err error) {
+	cw := &countingWriter{w: dst}
+	fw, err := flate.NewWriter(cw, flate.DefaultCompression)
+	if err != nil {
+		return
+	}
+
+	buf := make([]byte, blockSize)
+	for off := uint64(0); off < size; off += blockSize {
+		n := blockSize
+		if size-off < n {
+			n = size - off
+		}
+
+		if _, err = io.ReadFull(src, buf[:n]); err != nil {
+			err = fmt.Errorf("reading block: %w", err)
+			return
+		}
+
+		before := cw.n
+		fw.Reset(cw)
+		if _, err = fw.Write(buf[:n]); err != nil {
+			return
+		}
+
+		if err = fw.Close(); err != nil {
+			return
+		}
+
+		lengths = append(lengths, cw.n-before)
+	}
+
+	return
+}
+
+type countingWriter struct {
+	w io.Writer
+	n uint64
+}
+
+func (cw *countingWriter) Write(p []byte) (n int, err error) {
+	n, err = cw.w.Write(p)
+	cw.n += uint64(n)
+	return
+}
+
+// Convert an object as stored in GCS to the object seen by users of the
+// bucket, remembering its index for later reads. Objects with an unreadable
+// index are passed through as stored.
+func (b *compressingBucket) translate(o *gcs.Object) *gcs.Object {
+	if o == nil || o.Metadata[compressionMetadataKey] != compressionVersion {
+		return o
+	}
+
+	idx, err := decodeCompressionIndex(o)
+	if err != nil {
+		return o
+	}
+
+	b.mu.Lock()
+	b.indexes.Insert(o.Name, idx)
+	b.mu.Unlock()
+
+	translated := *o
+	translated.Size = idx.size
+	translated.MD5 = nil
+	translated.CRC32C = nil
+
+	translated.Metadata = make(map[string]string)
+	for k, v := range o.Metadata {
+		if k != compressionMetadataKey && k != compressionIndexMetadataKey {
+			translated.Metadata[k] = v
+		}
+	}
+
+	return &translated
+}
+
+// Find the index for the given generation of the named object, or the latest
+// generation if zero. ok is false if the object is not compressed.
+func (b *compressingBucket) lookUpIndex(
+	ctx context.Context,
+	name string,
+	generation int64) (idx compressionIndex, ok bool, err error) {
+	if generation != 0 {
+		b.mu.Lock()
+		idx, ok = b.indexes.LookUp(name).(compressionIndex)
+		b.mu.Unlock()
+
+		if ok && idx.generation == generation {
+			return
+		}
+	}
+
+	o, err := b.Bucket.StatObject(
+		ctx,
+		&gcs.StatObjectRequest{Name: name, ForceFetchFromGcs: true})
+	if err != nil {
+		return
+	}
+
+	if generation != 0 && o.Generation != generation {
+		err = &gcs.NotFoundError{
+			Err: fmt.Errorf("object %q generation %d not found", name, generation),
+		}
+		return
+	}
+
+	if o.Metadata[compressionMetadataKey] != compressionVersion {
+		ok = false
+		return
+	}
+
+	idx, err = decodeCompressionIndex(o)
+	if err != nil {
+		err = fmt.Errorf("object %q: %w", name, err)
+		return
+	}
+
+	ok = true
+	b.translate(o)
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Bucket interface
+////////////////////////////////////////////////////////////////////////
+
+func (b *compressingBucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (rc io.ReadCloser, err error) {
+	idx, ok, err := b.lookUpIndex(ctx, req.Name, req.Generation)
+	if err != nil {
+		return
+	}
+
+	if !ok {
+		rc, err = b.Bucket.NewReader(ctx, req)
+		return
+	}
+
+	// Clamp the requested range to the object.
+	start, limit := uint64(0), uint64(math.MaxUint64)
+	if req.Range != nil {
+		start, limit = req.Range.Start, req.Range.Limit
+	}
+
+	if limit > idx.size {
+		limit = idx.size
+	}
+
+	if start >= limit {
+		rc = ioutil.NopCloser(bytes.NewReader(nil))
+		return
+	}
+
+	// Read the blocks covering it.
+	first := start / idx.blockSize
+	last := (limit - 1) / idx.blockSize
+
+	src, err := b.Bucket.NewReader(
+		ctx,
+		&gcs.ReadObjectRequest{
+			Name:       req.Name,
+			Generation: idx.generation,
+			Range: &gcs.ByteRange{
+				Start: idx.offsets[first],
+				Limit: idx.offsets[last+1],
+			},
+		})
+	if err != nil {
+		return
+	}
+
+	rc = &decompressingReader{
+		idx:       idx,
+		src:       src,
+		block:     first,
+		skip:      start - first*idx.blockSize,
+		remaining: limit - start,
+	}
+
+	return
+}
+
+func (b *compressingBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	// Stage the contents, since the block size depends on their length and
+	// the index must be known before the upload begins.
+	plain, err := fsutil.AnonymousFile(b.tempDir)
+	if err != nil {
+		err = fmt.Errorf("AnonymousFile: %w", err)
+		return
+	}
+	defer plain.Close()
+
+	n, err := io.Copy(plain, req.Contents)
+	if err != nil {
+		err = fmt.Errorf("staging contents: %w", err)
+		return
+	}
+	size := uint64(n)
+
+	packed, err := fsutil.AnonymousFile(b.tempDir)
+	if err != nil {
+		err = fmt.Errorf("AnonymousFile: %w", err)
+		return
+	}
+	defer packed.Close()
+
+	if _, err = plain.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+
+	blockSize := chooseCompressionBlockSize(size)
+	lengths, err := compressBlocks(packed, plain, size, blockSize)
+	if err != nil {
+		err = fmt.Errorf("compressBlocks: %w", err)
+		return
+	}
+
+	var packedSize uint64
+	for _, l := range lengths {
+		packedSize += l
+	}
+
+	// Upload whichever is smaller.
+	newReq := *req
+	var contents *os.File
+	if packedSize < size {
+		contents = packed
+		newReq.CRC32C = nil
+		newReq.MD5 = nil
+
+		newReq.Metadata = make(map[string]string)
+		for k, v := range req.Metadata {
+			newReq.Metadata[k] = v
+		}
+		newReq.Metadata[compressionMetadataKey] = compressionVersion
+		newReq.Metadata[compressionIndexMetadataKey] =
+			encodeCompressionIndex(blockSize, size, lengths)
+	} else {
+		contents = plain
+	}
+
+	if _, err = contents.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+	newReq.Contents = contents
+
+	o, err = b.Bucket.CreateObject(ctx, &newReq)
+	if err != nil {
+		return
+	}
+
+	o = b.translate(o)
+	return
+}
+
+func (b *compressingBucket) CopyObject(
+	ctx context.Context,
+	req *gcs.CopyObjectRequest) (o *gcs.Object, err error) {
+	// The index travels with the metadata, so the copy stays readable.
+	o, err = b.Bucket.CopyObject(ctx, req)
+	if err != nil {
+		return
+	}
+
+	o = b.translate(o)
+	return
+}
+
+func (b *compressingBucket) ComposeObjects(
+	ctx context.Context,
+	req *gcs.ComposeObjectsRequest) (o *gcs.Object, err error) {
+	err = errors.New("ComposeObjects is not supported for compressed buckets")
+	return
+}
+
+func (b *compressingBucket) StatObject(
+	ctx context.Context,
+	req *gcs.StatObjectRequest) (o *gcs.Object, err error) {
+	o, err = b.Bucket.StatObject(ctx, req)
+	if err != nil {
+		return
+	}
+
+	o = b.translate(o)
+	return
+}
+
+func (b *compressingBucket) ListObjects(
+	ctx context.Context,
+	req *gcs.ListObjectsRequest) (listing *gcs.Listing, err error) {
+	listing, err = b.Bucket.ListObjects(ctx, req)
+	if err != nil {
+		return
+	}
+
+	for i, o := range listing.Objects {
+		listing.Objects[i] = b.translate(o)
+	}
+
+	return
+}
+
+func (b *compressingBucket) UpdateObject(
+	ctx context.Context,
+	req *gcs.UpdateObjectRequest) (o *gcs.Object, err error) {
+	for _, k := range []string{compressionMetadataKey, compressionIndexMetadataKey} {
+		if _, ok := req.Metadata[k]; ok {
+			err = fmt.Errorf("metadata key %q is reserved", k)
+			return
+		}
+	}
+
+	o, err = b.Bucket.UpdateObject(ctx, req)
+	if err != nil {
+		return
+	}
+
+	o = b.translate(o)
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Reader
+////////////////////////////////////////////////////////////////////////
+
+// A reader that decompresses consecutive blocks from its source, returning
+// remaining bytes after discarding the first skip bytes.
+type decompressingReader struct {
+	idx compressionIndex
+	src io.ReadCloser
+
+	// The index of the next block to read.
+	block uint64
+
+	skip      uint64
+	remaining uint64
+
+	// Decompressed bytes not yet returned to the caller.
+	pending []byte
+	buf     []byte
+}
+
+func (r *decompressingReader) Read(p []byte) (n int, err error) {
+	if r.remaining == 0 {
+		err = io.EOF
+		return
+	}
+
+	for len(r.pending) == 0 {
+		if err = r.decompressNext(); err != nil {
+			return
+		}
+	}
+
+	if uint64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+
+	n = copy(p, r.pending)
+	r.pending = r.pending[n:]
+	r.remaining -= uint64(n)
+	return
+}
+
+func (r *decompressingReader) decompressNext() (err error) {
+	n := r.idx.blockSize
+	if end := (r.block + 1) * r.idx.blockSize; end > r.idx.size {
+		n = r.idx.size - r.block*r.idx.blockSize
+	}
+
+	if uint64(cap(r.buf)) < n {
+		r.buf = make([]byte, n)
+	}
+
+	compressed := r.idx.offsets[r.block+1] - r.idx.offsets[r.block]
+	lr := io.LimitReader(r.src, int64(compressed))
+	fr := flate.NewReader(lr)
+	defer fr.Close()
+
+	if _, err = io.ReadFull(fr, r.buf[:n]); err != nil {
+		err = fmt.Errorf("decompressing block %d: %w", r.block, err)
+		return
+	}
+
+	// Make sure the next block starts where it should, even if the
+	// decompressor stopped short of the end of this one.
+	if _, err = io.Copy(ioutil.Discard, lr); err != nil {
+		err = fmt.Errorf("reading block %d: %w", r.block, err)
+		return
+	}
+	r.block++
+
+	// Discard anything before the start of the requested range.
+	r.pending = r.buf[:n]
+	skip := r.skip
+	if skip > n {
+		skip = n
+	}
+	r.pending = r.pending[skip:]
+	r.skip -= skip
+
+	return
+}
+
+func (r *decompressingReader) Close() error {
+	return r.src.Close()
+}
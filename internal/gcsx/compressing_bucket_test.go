@@ -0,0 +1,220 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+)
+
+func TestCompressingBucket(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type CompressingBucketTest struct {
+	ctx     context.Context
+	wrapped gcs.Bucket
+	bucket  gcs.Bucket
+}
+
+var _ SetUpInterface = &CompressingBucketTest{}
+
+func init() { RegisterTestSuite(&CompressingBucketTest{}) }
+
+func (t *CompressingBucketTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.wrapped = gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket")
+	t.bucket = gcsx.NewCompressingBucket("", t.wrapped)
+}
+
+// Highly compressible contents spanning several blocks, with a partial final
+// block.
+func makeLogContents() []byte {
+	var buf bytes.Buffer
+	for i := 0; buf.Len() < 3<<18+1234; i++ {
+		fmt.Fprintf(&buf, "2023/01/01 00:00:00 request %d served\n", i)
+	}
+
+	return buf.Bytes()[:3<<18+1234]
+}
+
+func (t *CompressingBucketTest) readRange(
+	name string,
+	start uint64,
+	limit uint64) (contents []byte, err error) {
+	rc, err := t.bucket.NewReader(
+		t.ctx,
+		&gcs.ReadObjectRequest{
+			Name:  name,
+			Range: &gcs.ByteRange{Start: start, Limit: limit},
+		})
+	if err != nil {
+		return
+	}
+	defer rc.Close()
+
+	contents, err = ioutil.ReadAll(rc)
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *CompressingBucketTest) RoundTrip() {
+	for _, size := range []int{0, 1, 1 << 18, 3<<18 + 1234} {
+		contents := makeLogContents()[:size]
+
+		_, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", contents)
+		AssertEq(nil, err)
+
+		actual, err := gcsutil.ReadObject(t.ctx, t.bucket, "foo")
+		AssertEq(nil, err)
+		ExpectTrue(bytes.Equal(contents, actual), "size: %d", size)
+	}
+}
+
+func (t *CompressingBucketTest) RangeReads() {
+	contents := makeLogContents()
+	_, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", contents)
+	AssertEq(nil, err)
+
+	ranges := []struct {
+		start uint64
+		limit uint64
+	}{
+		{0, 10},
+		{10, 1 << 18},
+		{1<<18 - 5, 1<<18 + 5},
+		{100, 3<<18 + 7},
+		{3 << 18, 3<<18 + 1234},
+		{3<<18 + 1000, 1 << 30},
+		{1 << 21, 1<<21 + 1},
+	}
+
+	for _, r := range ranges {
+		actual, err := t.readRange("foo", r.start, r.limit)
+		AssertEq(nil, err)
+
+		start, limit := r.start, r.limit
+		if limit > uint64(len(contents)) {
+			limit = uint64(len(contents))
+		}
+		if start > limit {
+			start = limit
+		}
+
+		ExpectTrue(
+			bytes.Equal(contents[start:limit], actual),
+			"range: [%d, %d)", r.start, r.limit)
+	}
+}
+
+func (t *CompressingBucketTest) StoredCompressed() {
+	contents := makeLogContents()
+	o, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", contents)
+	AssertEq(nil, err)
+
+	// The bucket reports the uncompressed size and hides its metadata.
+	ExpectEq(len(contents), o.Size)
+	ExpectEq(0, len(o.Metadata))
+
+	// GCS holds much less.
+	raw, err := t.wrapped.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+	ExpectLt(raw.Size, len(contents)/4)
+
+	// Stat and listing agree with the create.
+	o, err = t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+	ExpectEq(len(contents), o.Size)
+
+	listing, err := t.bucket.ListObjects(t.ctx, &gcs.ListObjectsRequest{})
+	AssertEq(nil, err)
+	AssertEq(1, len(listing.Objects))
+	ExpectEq(len(contents), listing.Objects[0].Size)
+}
+
+func (t *CompressingBucketTest) IncompressibleContentsStoredAsWritten() {
+	contents := make([]byte, 1<<16)
+	_, err := rand.Read(contents)
+	AssertEq(nil, err)
+
+	_, err = gcsutil.CreateObject(t.ctx, t.bucket, "foo", contents)
+	AssertEq(nil, err)
+
+	raw, err := gcsutil.ReadObject(t.ctx, t.wrapped, "foo")
+	AssertEq(nil, err)
+	ExpectTrue(bytes.Equal(contents, raw))
+}
+
+func (t *CompressingBucketTest) UnmodifiedObjectsPassThrough() {
+	_, err := gcsutil.CreateObject(t.ctx, t.wrapped, "foo", []byte("taco"))
+	AssertEq(nil, err)
+
+	actual, err := t.readRange("foo", 1, 3)
+	AssertEq(nil, err)
+	ExpectEq("ac", string(actual))
+}
+
+func (t *CompressingBucketTest) AboveEncryption() {
+	encrypting, err := gcsx.NewEncryptingBucket(
+		bytes.Repeat([]byte{0x17}, 32),
+		t.wrapped)
+	AssertEq(nil, err)
+
+	t.bucket = gcsx.NewCompressingBucket("", encrypting)
+
+	contents := makeLogContents()
+	_, err = gcsutil.CreateObject(t.ctx, t.bucket, "foo", contents)
+	AssertEq(nil, err)
+
+	actual, err := t.readRange("foo", 1<<18-5, 1<<18+5)
+	AssertEq(nil, err)
+	ExpectTrue(bytes.Equal(contents[1<<18-5:1<<18+5], actual))
+
+	raw, err := t.wrapped.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+	ExpectLt(raw.Size, len(contents)/4)
+}
+
+func (t *CompressingBucketTest) ReservedMetadata() {
+	_, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", makeLogContents())
+	AssertEq(nil, err)
+
+	v := "taco"
+	_, err = t.bucket.UpdateObject(
+		t.ctx,
+		&gcs.UpdateObjectRequest{
+			Name:     "foo",
+			Metadata: map[string]*string{"gcsfuse_compression_index": &v},
+		})
+	ExpectThat(err, Error(HasSubstr("reserved")))
+}
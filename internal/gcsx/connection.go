@@ -16,15 +16,26 @@ package gcsx
 
 import (
 	"fmt"
+	"net/http"
 	"strings"
 	"syscall"
 
+	"github.com/googlecloudplatform/gcsfuse/internal/storage"
 	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/timeutil"
 	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/option"
+	storagev1 "google.golang.org/api/storage/v1"
 )
 
 type Connection struct {
 	wrapped gcs.Conn
+
+	// A JSON API client for the calls gcs.Conn lacks, such as patching object
+	// ACLs. Nil for fake connections.
+	service *storagev1.Service
 }
 
 func NewConnection(cfg *gcs.ConnConfig) (c *Connection, err error) {
@@ -34,8 +45,43 @@ func NewConnection(cfg *gcs.ConnConfig) (c *Connection, err error) {
 		return
 	}
 
+	// Talk to the same endpoint with the same credentials as the wrapped
+	// connection.
+	transport := http.RoundTripper(http.DefaultTransport)
+	if cfg.Transport != nil {
+		transport = cfg.Transport
+	}
+
+	client := &http.Client{
+		Transport: &oauth2.Transport{
+			Source: cfg.TokenSource,
+			Base:   transport,
+		},
+	}
+
+	service, err := storagev1.NewService(
+		context.Background(),
+		option.WithHTTPClient(client),
+		option.WithEndpoint(fmt.Sprintf("%s://%s/storage/v1/", cfg.Url.Scheme, cfg.Url.Host)),
+		option.WithUserAgent(cfg.UserAgent))
+	if err != nil {
+		err = fmt.Errorf("Cannot create JSON API service: %w", err)
+		return
+	}
+
 	c = &Connection{
 		wrapped: wrapped,
+		service: service,
+	}
+	return
+}
+
+// NewFakeConnection returns a connection to an in-memory fake of GCS, in which
+// buckets of any name exist and are initially empty. Buckets opened more than
+// once share their contents.
+func NewFakeConnection(clock timeutil.Clock) (c *Connection) {
+	c = &Connection{
+		wrapped: gcsfake.NewConn(clock),
 	}
 	return
 }
@@ -59,3 +105,38 @@ func (c *Connection) OpenBucket(
 
 	return
 }
+
+// AclSetter returns a storage.AclSetter for the named bucket, or nil if the
+// connection can't set ACLs. It asks GCS whether the bucket has uniform
+// bucket-level access, reporting the answer in ubla; if so, the setter fails
+// with storage.ErrUniformBucketLevelAccess.
+func (c *Connection) AclSetter(
+	ctx context.Context,
+	name string,
+	billingProject string) (s storage.AclSetter, ubla bool, err error) {
+	if c.service == nil {
+		return
+	}
+
+	ubla, err = storage.UniformBucketLevelAccess(ctx, c.service, name, billingProject)
+	if err != nil {
+		err = fmt.Errorf("UniformBucketLevelAccess: %w", err)
+		return
+	}
+
+	s = storage.NewJSONAclSetter(c.service, name, billingProject, ubla)
+	return
+}
+
+// GenerationStatter returns a storage.GenerationStatter for the named bucket,
+// or nil if the connection can't stat noncurrent generations.
+func (c *Connection) GenerationStatter(
+	name string,
+	billingProject string) (s storage.GenerationStatter) {
+	if c.service == nil {
+		return
+	}
+
+	s = storage.NewJSONGenerationStatter(c.service, name, billingProject)
+	return
+}
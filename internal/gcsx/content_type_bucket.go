@@ -15,8 +15,11 @@
 package gcsx
 
 import (
+	"bufio"
 	"mime"
+	"net/http"
 	"path"
+	"strings"
 
 	"github.com/jacobsa/gcloud/gcs"
 	"golang.org/x/net/context"
@@ -24,12 +27,29 @@ import (
 
 // NewContentTypeBucket creates a wrapper bucket that guesses MIME types for
 // newly created or composed objects when an explicit type is not already set.
-func NewContentTypeBucket(b gcs.Bucket) gcs.Bucket {
-	return contentTypeBucket{b}
+//
+// The type is taken from overrides, keyed by lower-case file extension
+// including the leading dot, then from the system's extension table. Failing
+// both, the type of a newly created object is sniffed from its first bytes.
+func NewContentTypeBucket(overrides map[string]string, b gcs.Bucket) gcs.Bucket {
+	return contentTypeBucket{b, overrides}
 }
 
 type contentTypeBucket struct {
 	gcs.Bucket
+	overrides map[string]string
+}
+
+// The number of leading bytes considered when sniffing a content type.
+const sniffLen = 512
+
+func (b contentTypeBucket) typeByExtension(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if t, ok := b.overrides[ext]; ok {
+		return t
+	}
+
+	return mime.TypeByExtension(ext)
 }
 
 func (b contentTypeBucket) CreateObject(
@@ -37,7 +57,21 @@ func (b contentTypeBucket) CreateObject(
 	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
 	// Guess a content type if necessary.
 	if req.ContentType == "" {
-		req.ContentType = mime.TypeByExtension(path.Ext(req.Name))
+		req.ContentType = b.typeByExtension(req.Name)
+	}
+
+	// Failing that, look at the contents. Errors here will surface again when
+	// the contents are uploaded.
+	if req.ContentType == "" {
+		br := bufio.NewReaderSize(req.Contents, sniffLen)
+		head, _ := br.Peek(sniffLen)
+		if len(head) > 0 {
+			if t := http.DetectContentType(head); t != "application/octet-stream" {
+				req.ContentType = t
+			}
+		}
+
+		req.Contents = br
 	}
 
 	// Pass on the request.
@@ -50,7 +84,7 @@ func (b contentTypeBucket) ComposeObjects(
 	req *gcs.ComposeObjectsRequest) (o *gcs.Object, err error) {
 	// Guess a content type if necessary.
 	if req.ContentType == "" {
-		req.ContentType = mime.TypeByExtension(path.Ext(req.DstName))
+		req.ContentType = b.typeByExtension(req.DstName)
 	}
 
 	// Pass on the request.
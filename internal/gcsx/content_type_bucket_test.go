@@ -83,6 +83,7 @@ func TestContentTypeBucket_CreateObject(t *testing.T) {
 	for i, tc := range contentTypeBucketTestCases {
 		// Set up a bucket.
 		bucket := gcsx.NewContentTypeBucket(
+			nil,
 			gcsfake.NewFakeBucket(timeutil.RealClock(), ""))
 
 		// Create the object.
@@ -111,6 +112,7 @@ func TestContentTypeBucket_ComposeObjects(t *testing.T) {
 	for i, tc := range contentTypeBucketTestCases {
 		// Set up a bucket.
 		bucket := gcsx.NewContentTypeBucket(
+			nil,
 			gcsfake.NewFakeBucket(timeutil.RealClock(), ""))
 
 		// Create a source object.
@@ -142,3 +144,75 @@ func TestContentTypeBucket_ComposeObjects(t *testing.T) {
 		}
 	}
 }
+
+func TestContentTypeBucket_Sniffing(t *testing.T) {
+	testCases := []struct {
+		name     string
+		contents string
+		expected string
+	}{
+		0: {"foo/bar", "<html><body>taco</body></html>", "text/html; charset=utf-8"},
+		1: {"foo/bar.asdf", "\x89PNG\x0d\x0a\x1a\x0a", "image/png"},
+		2: {"foo/bar", "\x00\x01\x02\x03", ""},
+		3: {"foo/bar.jpg", "<html></html>", "image/jpeg"},
+	}
+
+	for i, tc := range testCases {
+		bucket := gcsx.NewContentTypeBucket(
+			nil,
+			gcsfake.NewFakeBucket(timeutil.RealClock(), ""))
+
+		o, err := bucket.CreateObject(
+			context.Background(),
+			&gcs.CreateObjectRequest{
+				Name:     tc.name,
+				Contents: strings.NewReader(tc.contents),
+			})
+		if err != nil {
+			t.Fatalf("Test case %d: CreateObject: %v", i, err)
+		}
+
+		if got, want := o.ContentType, tc.expected; got != want {
+			t.Errorf("Test case %d: o.ContentType is %q, want %q", i, got, want)
+		}
+
+		// Sniffing must not eat the contents.
+		if got, want := o.Size, uint64(len(tc.contents)); got != want {
+			t.Errorf("Test case %d: o.Size is %d, want %d", i, got, want)
+		}
+	}
+}
+
+func TestContentTypeBucket_Overrides(t *testing.T) {
+	bucket := gcsx.NewContentTypeBucket(
+		map[string]string{".log": "text/plain", ".jpg": "application/x-taco"},
+		gcsfake.NewFakeBucket(timeutil.RealClock(), ""))
+
+	testCases := []struct {
+		name     string
+		request  string
+		expected string
+	}{
+		0: {"foo/bar.log", "", "text/plain"},
+		1: {"foo/BAR.JPG", "", "application/x-taco"},
+		2: {"foo/bar.jpg", "image/gif", "image/gif"},
+		3: {"foo/bar.png", "", "image/png"},
+	}
+
+	for i, tc := range testCases {
+		o, err := bucket.CreateObject(
+			context.Background(),
+			&gcs.CreateObjectRequest{
+				Name:        tc.name,
+				ContentType: tc.request,
+				Contents:    strings.NewReader(""),
+			})
+		if err != nil {
+			t.Fatalf("Test case %d: CreateObject: %v", i, err)
+		}
+
+		if got, want := o.ContentType, tc.expected; got != want {
+			t.Errorf("Test case %d: o.ContentType is %q, want %q", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,626 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/util/lrucache"
+	"golang.org/x/net/context"
+)
+
+const (
+	// Metadata keys recording that an object's contents are encrypted, and the
+	// wrapped data encryption key needed to read them. Both are hidden from
+	// objects returned by the encrypting bucket.
+	encryptionMetadataKey   = "gcsfuse_encryption"
+	encryptedKeyMetadataKey = "gcsfuse_encrypted_key"
+	encryptionVersion       = "v1"
+
+	// Plaintext is sealed in blocks of this size, each of which grows by
+	// encryptionOverhead bytes in GCS. Fixed-size blocks let a plaintext range
+	// be mapped to a ciphertext range without reading the whole object.
+	encryptionBlockSize = 1 << 16
+	encryptionOverhead  = 16
+
+	// The number of objects whose wrapped keys are remembered between a stat or
+	// listing and the reads that follow it.
+	encryptionKeyCacheCapacity = 4096
+)
+
+// LoadEncryptionKey reads a key encryption key from the file at the supplied
+// path. The file must contain 32 bytes, either raw or base64-encoded.
+func LoadEncryptionKey(path string) (key []byte, err error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		err = fmt.Errorf("ReadFile: %w", err)
+		return
+	}
+
+	if len(contents) == 32 {
+		key = contents
+		return
+	}
+
+	key, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(contents)))
+	if err != nil || len(key) != 32 {
+		err = fmt.Errorf("%q does not contain a 32-byte key", path)
+		key = nil
+		return
+	}
+
+	return
+}
+
+// NewEncryptingBucket creates a wrapper bucket that encrypts the contents of
+// newly created objects before they reach the wrapped bucket and decrypts them
+// on read, so that plaintext never leaves the machine.
+//
+// Each object is encrypted with its own random data encryption key, which is
+// stored in the object's metadata after being wrapped with the supplied
+// 32-byte key encryption key. Objects returned by the bucket report their
+// plaintext size and no content checksums. Objects that were not written
+// through an encrypting bucket are passed through unchanged.
+//
+// Composing objects is not supported, since concatenated ciphertexts cannot be
+// decrypted as a unit.
+func NewEncryptingBucket(key []byte, b gcs.Bucket) (eb gcs.Bucket, err error) {
+	kek, err := newGCM(key)
+	if err != nil {
+		err = fmt.Errorf("key encryption key: %w", err)
+		return
+	}
+
+	eb = &encryptingBucket{
+		Bucket: b,
+		kek:    kek,
+		keys:   lrucache.New(encryptionKeyCacheCapacity),
+	}
+
+	return
+}
+
+type encryptedKey struct {
+	generation int64
+	wrapped    string
+
+	// The size of the object in GCS, i.e. of the ciphertext.
+	size uint64
+}
+
+type encryptingBucket struct {
+	gcs.Bucket
+
+	kek cipher.AEAD
+
+	mu sync.Mutex
+
+	// Wrapped keys of recently seen encrypted objects, by name.
+	//
+	// INVARIANT: Each value is of type encryptedKey
+	//
+	// GUARDED_BY(mu)
+	keys lrucache.Cache
+}
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+func newGCM(key []byte) (aead cipher.AEAD, err error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return
+	}
+
+	aead, err = cipher.NewGCM(block)
+	return
+}
+
+// The nonce for the i'th block of an object. Every object has its own key, so
+// the block index alone keeps nonces unique.
+func blockNonce(aead cipher.AEAD, i uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], i)
+	return nonce
+}
+
+// The additional data for a block, distinguishing the final block so that an
+// object truncated on a block boundary fails to decrypt.
+func blockAdditionalData(final bool) []byte {
+	if final {
+		return []byte{1}
+	}
+
+	return []byte{0}
+}
+
+// Return the number of blocks in a ciphertext of the given size, and the size
+// of the plaintext it decrypts to. Every ciphertext has at least one block,
+// even for empty plaintext.
+func encryptedLayout(size uint64) (blocks uint64, plaintextSize uint64) {
+	const sealedBlockSize = encryptionBlockSize + encryptionOverhead
+
+	blocks = size / sealedBlockSize
+	plaintextSize = blocks * encryptionBlockSize
+
+	if r := size % sealedBlockSize; r > 0 {
+		blocks++
+		if r > encryptionOverhead {
+			plaintextSize += r - encryptionOverhead
+		}
+	}
+
+	return
+}
+
+func (b *encryptingBucket) wrapKey(dek []byte) (wrapped string, err error) {
+	nonce := make([]byte, b.kek.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		err = fmt.Errorf("generating nonce: %w", err)
+		return
+	}
+
+	wrapped = base64.StdEncoding.EncodeToString(b.kek.Seal(nonce, nonce, dek, nil))
+	return
+}
+
+func (b *encryptingBucket) unwrapKey(wrapped string) (aead cipher.AEAD, err error) {
+	sealed, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil {
+		err = fmt.Errorf("decoding wrapped key: %w", err)
+		return
+	}
+
+	n := b.kek.NonceSize()
+	if len(sealed) < n {
+		err = errors.New("wrapped key is too short")
+		return
+	}
+
+	dek, err := b.kek.Open(nil, sealed[:n], sealed[n:], nil)
+	if err != nil {
+		err = fmt.Errorf("unwrapping key (wrong encryption key?): %w", err)
+		return
+	}
+
+	aead, err = newGCM(dek)
+	return
+}
+
+// Convert an object as stored in GCS to the object seen by users of the
+// bucket, remembering its wrapped key for later reads.
+func (b *encryptingBucket) translate(o *gcs.Object) *gcs.Object {
+	if o == nil || o.Metadata[encryptionMetadataKey] != encryptionVersion {
+		return o
+	}
+
+	b.mu.Lock()
+	b.keys.Insert(o.Name, encryptedKey{
+		generation: o.Generation,
+		wrapped:    o.Metadata[encryptedKeyMetadataKey],
+		size:       o.Size,
+	})
+	b.mu.Unlock()
+
+	translated := *o
+	_, translated.Size = encryptedLayout(o.Size)
+	translated.MD5 = nil
+	translated.CRC32C = nil
+
+	translated.Metadata = make(map[string]string)
+	for k, v := range o.Metadata {
+		if k != encryptionMetadataKey && k != encryptedKeyMetadataKey {
+			translated.Metadata[k] = v
+		}
+	}
+
+	return &translated
+}
+
+// Find the wrapped key for the given generation of the named object, or the
+// latest generation if zero. ok is false if the object is not encrypted.
+func (b *encryptingBucket) lookUpKey(
+	ctx context.Context,
+	name string,
+	generation int64) (k encryptedKey, ok bool, err error) {
+	if generation != 0 {
+		b.mu.Lock()
+		k, ok = b.keys.LookUp(name).(encryptedKey)
+		b.mu.Unlock()
+
+		if ok && k.generation == generation {
+			return
+		}
+	}
+
+	o, err := b.Bucket.StatObject(
+		ctx,
+		&gcs.StatObjectRequest{Name: name, ForceFetchFromGcs: true})
+	if err != nil {
+		return
+	}
+
+	if generation != 0 && o.Generation != generation {
+		err = &gcs.NotFoundError{
+			Err: fmt.Errorf("object %q generation %d not found", name, generation),
+		}
+		return
+	}
+
+	if o.Metadata[encryptionMetadataKey] != encryptionVersion {
+		ok = false
+		return
+	}
+
+	k = encryptedKey{
+		generation: o.Generation,
+		wrapped:    o.Metadata[encryptedKeyMetadataKey],
+		size:       o.Size,
+	}
+	ok = true
+
+	b.translate(o)
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Bucket interface
+////////////////////////////////////////////////////////////////////////
+
+func (b *encryptingBucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (rc io.ReadCloser, err error) {
+	k, ok, err := b.lookUpKey(ctx, req.Name, req.Generation)
+	if err != nil {
+		return
+	}
+
+	if !ok {
+		rc, err = b.Bucket.NewReader(ctx, req)
+		return
+	}
+
+	aead, err := b.unwrapKey(k.wrapped)
+	if err != nil {
+		err = fmt.Errorf("object %q: %w", req.Name, err)
+		return
+	}
+
+	// Clamp the requested plaintext range to the object.
+	blocks, size := encryptedLayout(k.size)
+	start, limit := uint64(0), uint64(math.MaxUint64)
+	if req.Range != nil {
+		start, limit = req.Range.Start, req.Range.Limit
+	}
+
+	if limit > size {
+		limit = size
+	}
+
+	if start >= limit {
+		rc = ioutil.NopCloser(bytes.NewReader(nil))
+		return
+	}
+
+	// Read the blocks covering it.
+	const sealedBlockSize = encryptionBlockSize + encryptionOverhead
+	first := start / encryptionBlockSize
+	last := (limit - 1) / encryptionBlockSize
+
+	cipherRange := &gcs.ByteRange{
+		Start: first * sealedBlockSize,
+		Limit: (last + 1) * sealedBlockSize,
+	}
+	if cipherRange.Limit > k.size {
+		cipherRange.Limit = k.size
+	}
+
+	src, err := b.Bucket.NewReader(
+		ctx,
+		&gcs.ReadObjectRequest{
+			Name:       req.Name,
+			Generation: k.generation,
+			Range:      cipherRange,
+		})
+	if err != nil {
+		return
+	}
+
+	rc = &decryptingReader{
+		aead:      aead,
+		src:       src,
+		block:     first,
+		blocks:    blocks,
+		skip:      start - first*encryptionBlockSize,
+		remaining: limit - start,
+		sealed:    make([]byte, sealedBlockSize),
+	}
+
+	return
+}
+
+func (b *encryptingBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	dek := make([]byte, 32)
+	if _, err = rand.Read(dek); err != nil {
+		err = fmt.Errorf("generating key: %w", err)
+		return
+	}
+
+	aead, err := newGCM(dek)
+	if err != nil {
+		return
+	}
+
+	wrapped, err := b.wrapKey(dek)
+	if err != nil {
+		return
+	}
+
+	// Checksums supplied by the caller describe the plaintext, which is not
+	// what will be uploaded.
+	encReq := *req
+	encReq.Contents = &encryptingReader{
+		aead:  aead,
+		src:   bufio.NewReaderSize(req.Contents, encryptionBlockSize),
+		plain: make([]byte, encryptionBlockSize),
+	}
+	encReq.CRC32C = nil
+	encReq.MD5 = nil
+
+	encReq.Metadata = make(map[string]string)
+	for k, v := range req.Metadata {
+		encReq.Metadata[k] = v
+	}
+	encReq.Metadata[encryptionMetadataKey] = encryptionVersion
+	encReq.Metadata[encryptedKeyMetadataKey] = wrapped
+
+	o, err = b.Bucket.CreateObject(ctx, &encReq)
+	if err != nil {
+		return
+	}
+
+	o = b.translate(o)
+	return
+}
+
+func (b *encryptingBucket) CopyObject(
+	ctx context.Context,
+	req *gcs.CopyObjectRequest) (o *gcs.Object, err error) {
+	// The wrapped key travels with the metadata, so the copy stays readable.
+	o, err = b.Bucket.CopyObject(ctx, req)
+	if err != nil {
+		return
+	}
+
+	o = b.translate(o)
+	return
+}
+
+func (b *encryptingBucket) ComposeObjects(
+	ctx context.Context,
+	req *gcs.ComposeObjectsRequest) (o *gcs.Object, err error) {
+	err = errors.New("ComposeObjects is not supported for encrypted buckets")
+	return
+}
+
+func (b *encryptingBucket) StatObject(
+	ctx context.Context,
+	req *gcs.StatObjectRequest) (o *gcs.Object, err error) {
+	o, err = b.Bucket.StatObject(ctx, req)
+	if err != nil {
+		return
+	}
+
+	o = b.translate(o)
+	return
+}
+
+func (b *encryptingBucket) ListObjects(
+	ctx context.Context,
+	req *gcs.ListObjectsRequest) (listing *gcs.Listing, err error) {
+	listing, err = b.Bucket.ListObjects(ctx, req)
+	if err != nil {
+		return
+	}
+
+	for i, o := range listing.Objects {
+		listing.Objects[i] = b.translate(o)
+	}
+
+	return
+}
+
+func (b *encryptingBucket) UpdateObject(
+	ctx context.Context,
+	req *gcs.UpdateObjectRequest) (o *gcs.Object, err error) {
+	if _, ok := req.Metadata[encryptionMetadataKey]; ok {
+		err = fmt.Errorf("metadata key %q is reserved", encryptionMetadataKey)
+		return
+	}
+
+	if _, ok := req.Metadata[encryptedKeyMetadataKey]; ok {
+		err = fmt.Errorf("metadata key %q is reserved", encryptedKeyMetadataKey)
+		return
+	}
+
+	o, err = b.Bucket.UpdateObject(ctx, req)
+	if err != nil {
+		return
+	}
+
+	o = b.translate(o)
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Readers
+////////////////////////////////////////////////////////////////////////
+
+// A reader that seals its source in blocks of encryptionBlockSize.
+type encryptingReader struct {
+	aead  cipher.AEAD
+	src   *bufio.Reader
+	block uint64
+	done  bool
+
+	// Scratch space for one block of plaintext.
+	plain []byte
+
+	// Sealed bytes not yet returned to the caller.
+	pending []byte
+}
+
+func (r *encryptingReader) Read(p []byte) (n int, err error) {
+	for len(r.pending) == 0 {
+		if r.done {
+			err = io.EOF
+			return
+		}
+
+		if err = r.sealNext(); err != nil {
+			return
+		}
+	}
+
+	n = copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return
+}
+
+func (r *encryptingReader) sealNext() (err error) {
+	n, err := io.ReadFull(r.src, r.plain)
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		r.done = true
+
+	case err != nil:
+		return
+
+	default:
+		// Look ahead so that a final full block is marked as such.
+		_, err = r.src.Peek(1)
+		if err == io.EOF {
+			r.done = true
+		} else if err != nil {
+			return
+		}
+	}
+
+	err = nil
+	r.pending = r.aead.Seal(
+		r.pending[:0],
+		blockNonce(r.aead, r.block),
+		r.plain[:n],
+		blockAdditionalData(r.done))
+	r.block++
+
+	return
+}
+
+// A reader that opens sealed blocks from its source, returning remaining bytes
+// of plaintext after discarding the first skip bytes.
+type decryptingReader struct {
+	aead cipher.AEAD
+	src  io.ReadCloser
+
+	// The index of the next block to read, and the total in the object.
+	block  uint64
+	blocks uint64
+
+	skip      uint64
+	remaining uint64
+
+	// Scratch space for one sealed block.
+	sealed []byte
+
+	// Plaintext not yet returned to the caller.
+	pending []byte
+}
+
+func (r *decryptingReader) Read(p []byte) (n int, err error) {
+	if r.remaining == 0 {
+		err = io.EOF
+		return
+	}
+
+	for len(r.pending) == 0 {
+		if err = r.openNext(); err != nil {
+			return
+		}
+	}
+
+	if uint64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+
+	n = copy(p, r.pending)
+	r.pending = r.pending[n:]
+	r.remaining -= uint64(n)
+	return
+}
+
+func (r *decryptingReader) openNext() (err error) {
+	n, err := io.ReadFull(r.src, r.sealed)
+	if err == io.ErrUnexpectedEOF && r.block == r.blocks-1 {
+		err = nil
+	}
+
+	if err != nil {
+		err = fmt.Errorf("reading block %d: %w", r.block, err)
+		return
+	}
+
+	r.pending, err = r.aead.Open(
+		r.pending[:0],
+		blockNonce(r.aead, r.block),
+		r.sealed[:n],
+		blockAdditionalData(r.block == r.blocks-1))
+	if err != nil {
+		err = fmt.Errorf("decrypting block %d: %w", r.block, err)
+		return
+	}
+	r.block++
+
+	// Discard anything before the start of the requested range.
+	skip := r.skip
+	if skip > uint64(len(r.pending)) {
+		skip = uint64(len(r.pending))
+	}
+	r.pending = r.pending[skip:]
+	r.skip -= skip
+
+	return
+}
+
+func (r *decryptingReader) Close() error {
+	return r.src.Close()
+}
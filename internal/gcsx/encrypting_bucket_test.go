@@ -0,0 +1,272 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+)
+
+func TestEncryptingBucket(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type EncryptingBucketTest struct {
+	ctx     context.Context
+	key     []byte
+	wrapped gcs.Bucket
+	bucket  gcs.Bucket
+}
+
+var _ SetUpInterface = &EncryptingBucketTest{}
+
+func init() { RegisterTestSuite(&EncryptingBucketTest{}) }
+
+func (t *EncryptingBucketTest) SetUp(ti *TestInfo) {
+	var err error
+
+	t.ctx = ti.Ctx
+	t.key = bytes.Repeat([]byte{0x17}, 32)
+	t.wrapped = gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket")
+
+	t.bucket, err = gcsx.NewEncryptingBucket(t.key, t.wrapped)
+	AssertEq(nil, err)
+}
+
+// Contents spanning several encryption blocks, with a partial final block.
+func makeContents() []byte {
+	contents := make([]byte, 3<<16+1234)
+	for i := range contents {
+		contents[i] = byte(i * 7)
+	}
+
+	return contents
+}
+
+func (t *EncryptingBucketTest) readRange(
+	name string,
+	start uint64,
+	limit uint64) (contents []byte, err error) {
+	rc, err := t.bucket.NewReader(
+		t.ctx,
+		&gcs.ReadObjectRequest{
+			Name:  name,
+			Range: &gcs.ByteRange{Start: start, Limit: limit},
+		})
+	if err != nil {
+		return
+	}
+	defer rc.Close()
+
+	contents, err = ioutil.ReadAll(rc)
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *EncryptingBucketTest) BadKey() {
+	_, err := gcsx.NewEncryptingBucket([]byte("too short"), t.wrapped)
+	ExpectNe(nil, err)
+}
+
+func (t *EncryptingBucketTest) RoundTrip() {
+	for _, size := range []int{0, 1, 1 << 16, 3<<16 + 1234} {
+		contents := makeContents()[:size]
+
+		_, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", contents)
+		AssertEq(nil, err)
+
+		actual, err := gcsutil.ReadObject(t.ctx, t.bucket, "foo")
+		AssertEq(nil, err)
+		ExpectTrue(bytes.Equal(contents, actual), "size: %d", size)
+	}
+}
+
+func (t *EncryptingBucketTest) RangeReads() {
+	contents := makeContents()
+	_, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", contents)
+	AssertEq(nil, err)
+
+	ranges := []struct {
+		start uint64
+		limit uint64
+	}{
+		{0, 10},
+		{10, 1 << 16},
+		{1<<16 - 5, 1<<16 + 5},
+		{100, 3<<16 + 7},
+		{3 << 16, 3<<16 + 1234},
+		{3<<16 + 1000, 1 << 30},
+		{1 << 20, 1<<20 + 1},
+	}
+
+	for _, r := range ranges {
+		actual, err := t.readRange("foo", r.start, r.limit)
+		AssertEq(nil, err)
+
+		start, limit := r.start, r.limit
+		if limit > uint64(len(contents)) {
+			limit = uint64(len(contents))
+		}
+		if start > limit {
+			start = limit
+		}
+
+		ExpectTrue(
+			bytes.Equal(contents[start:limit], actual),
+			"range: [%d, %d)", r.start, r.limit)
+	}
+}
+
+func (t *EncryptingBucketTest) CiphertextStoredInWrappedBucket() {
+	contents := makeContents()
+	o, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", contents)
+	AssertEq(nil, err)
+
+	// The bucket reports the plaintext size and hides its metadata.
+	ExpectEq(len(contents), o.Size)
+	ExpectEq(0, len(o.Metadata))
+
+	// GCS holds something else, four blocks' overhead larger.
+	raw, err := gcsutil.ReadObject(t.ctx, t.wrapped, "foo")
+	AssertEq(nil, err)
+	ExpectEq(len(contents)+4*16, len(raw))
+	ExpectFalse(bytes.Contains(raw, contents[:64]))
+
+	// Stat and listing agree with the create.
+	o, err = t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+	ExpectEq(len(contents), o.Size)
+	ExpectEq(nil, o.CRC32C)
+
+	listing, err := t.bucket.ListObjects(t.ctx, &gcs.ListObjectsRequest{})
+	AssertEq(nil, err)
+	AssertEq(1, len(listing.Objects))
+	ExpectEq(len(contents), listing.Objects[0].Size)
+}
+
+func (t *EncryptingBucketTest) UserMetadataPreserved() {
+	_, err := t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:     "foo",
+			Contents: bytes.NewReader([]byte("taco")),
+			Metadata: map[string]string{"bar": "baz"},
+		})
+	AssertEq(nil, err)
+
+	o, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+	ExpectThat(o.Metadata, DeepEquals(map[string]string{"bar": "baz"}))
+}
+
+func (t *EncryptingBucketTest) UnencryptedObjectsPassThrough() {
+	_, err := gcsutil.CreateObject(t.ctx, t.wrapped, "foo", []byte("taco"))
+	AssertEq(nil, err)
+
+	actual, err := t.readRange("foo", 1, 3)
+	AssertEq(nil, err)
+	ExpectEq("ac", string(actual))
+
+	o, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+	ExpectEq(4, o.Size)
+}
+
+func (t *EncryptingBucketTest) CopiesRemainReadable() {
+	contents := makeContents()
+	_, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", contents)
+	AssertEq(nil, err)
+
+	o, err := t.bucket.CopyObject(
+		t.ctx,
+		&gcs.CopyObjectRequest{SrcName: "foo", DstName: "bar"})
+	AssertEq(nil, err)
+	ExpectEq(len(contents), o.Size)
+
+	actual, err := gcsutil.ReadObject(t.ctx, t.bucket, "bar")
+	AssertEq(nil, err)
+	ExpectTrue(bytes.Equal(contents, actual))
+}
+
+func (t *EncryptingBucketTest) WrongKey() {
+	_, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", []byte("taco"))
+	AssertEq(nil, err)
+
+	other, err := gcsx.NewEncryptingBucket(bytes.Repeat([]byte{1}, 32), t.wrapped)
+	AssertEq(nil, err)
+
+	_, err = gcsutil.ReadObject(t.ctx, other, "foo")
+	ExpectThat(err, Error(HasSubstr("wrong encryption key")))
+}
+
+func (t *EncryptingBucketTest) ComposeUnsupported() {
+	_, err := t.bucket.ComposeObjects(
+		t.ctx,
+		&gcs.ComposeObjectsRequest{
+			DstName: "foo",
+			Sources: []gcs.ComposeSource{{Name: "bar"}},
+		})
+	ExpectNe(nil, err)
+}
+
+func (t *EncryptingBucketTest) LoadEncryptionKey() {
+	dir, err := ioutil.TempDir("", "encrypting_bucket_test")
+	AssertEq(nil, err)
+	defer os.RemoveAll(dir)
+
+	// Raw and base64-encoded keys are both accepted.
+	raw := path.Join(dir, "raw")
+	AssertEq(nil, ioutil.WriteFile(raw, t.key, 0600))
+
+	key, err := gcsx.LoadEncryptionKey(raw)
+	AssertEq(nil, err)
+	ExpectTrue(bytes.Equal(t.key, key))
+
+	encoded := path.Join(dir, "encoded")
+	AssertEq(nil, ioutil.WriteFile(
+		encoded,
+		[]byte(base64.StdEncoding.EncodeToString(t.key)+"\n"),
+		0600))
+
+	key, err = gcsx.LoadEncryptionKey(encoded)
+	AssertEq(nil, err)
+	ExpectTrue(bytes.Equal(t.key, key))
+
+	// Anything else is not.
+	bad := path.Join(dir, "bad")
+	AssertEq(nil, ioutil.WriteFile(bad, []byte("taco"), 0600))
+
+	_, err = gcsx.LoadEncryptionKey(bad)
+	ExpectNe(nil, err)
+}
@@ -0,0 +1,61 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"github.com/googlecloudplatform/gcsfuse/internal/storage"
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// Implemented by bucket wrappers that rewrite the records of the objects they
+// return, such as those for encryption and compression.
+type objectTranslator interface {
+	translate(o *gcs.Object) *gcs.Object
+}
+
+// Wrap a GenerationStatter so that the records it returns are rewritten as
+// the supplied bucket rewrites those from StatObject. If the bucket doesn't
+// rewrite records, return the wrapped statter.
+func newTranslatingGenerationStatter(
+	b gcs.Bucket,
+	wrapped storage.GenerationStatter) storage.GenerationStatter {
+	t, ok := b.(objectTranslator)
+	if !ok {
+		return wrapped
+	}
+
+	return &translatingGenerationStatter{
+		translator: t,
+		wrapped:    wrapped,
+	}
+}
+
+type translatingGenerationStatter struct {
+	translator objectTranslator
+	wrapped    storage.GenerationStatter
+}
+
+func (s *translatingGenerationStatter) StatObjectGeneration(
+	ctx context.Context,
+	req *storage.StatObjectGenerationRequest) (o *gcs.Object, err error) {
+	o, err = s.wrapped.StatObjectGeneration(ctx, req)
+	if err != nil {
+		return
+	}
+
+	o = s.translator.translate(o)
+	return
+}
@@ -0,0 +1,170 @@
+// Copyright 2022 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// Headers that an ObjectHeaderRule may set, besides custom metadata.
+const (
+	CacheControlHeader       = "Cache-Control"
+	ContentDispositionHeader = "Content-Disposition"
+	ContentLanguageHeader    = "Content-Language"
+)
+
+// Headers beginning with this prefix set custom metadata, keyed by the rest of
+// the header name.
+const customMetadataHeaderPrefix = "X-Goog-Meta-"
+
+// An ObjectHeaderRule gives headers to serve with the objects whose names
+// match a pattern.
+type ObjectHeaderRule struct {
+	// A pattern in the syntax of path.Match. If it contains no slash, it is
+	// matched against the last component of the object name, and otherwise
+	// against the whole name.
+	Pattern string
+
+	// Values by canonical header name. See ValidateObjectHeader.
+	Headers map[string]string
+}
+
+// ValidateObjectHeader returns the canonical form of the header name, or an
+// error if an ObjectHeaderRule may not set it.
+func ValidateObjectHeader(name string) (canonical string, err error) {
+	canonical = http.CanonicalHeaderKey(strings.TrimSpace(name))
+	switch {
+	case canonical == CacheControlHeader,
+		canonical == ContentDispositionHeader,
+		canonical == ContentLanguageHeader:
+
+	case strings.HasPrefix(canonical, customMetadataHeaderPrefix) &&
+		len(canonical) > len(customMetadataHeaderPrefix):
+
+	default:
+		err = fmt.Errorf("Unsupported object header: %q", name)
+	}
+
+	return
+}
+
+// Does the rule apply to the object with the given name?
+func (r *ObjectHeaderRule) matches(name string) bool {
+	if !strings.Contains(r.Pattern, "/") {
+		name = path.Base(name)
+	}
+
+	ok, _ := path.Match(r.Pattern, name)
+	return ok
+}
+
+// NewObjectHeaderBucket creates a wrapper bucket that sets headers on newly
+// created or composed objects according to the supplied rules, for anything
+// serving the objects over HTTP (such as a CDN) to pass on.
+//
+// For each header, the first rule matching the object's name gives its value.
+// Headers already set in a request, for example those carried over from the
+// previous generation of an object, are left alone.
+func NewObjectHeaderBucket(rules []ObjectHeaderRule, b gcs.Bucket) gcs.Bucket {
+	return objectHeaderBucket{b, rules}
+}
+
+type objectHeaderBucket struct {
+	gcs.Bucket
+	rules []ObjectHeaderRule
+}
+
+// Fill in the unset headers of an object being created with the given name.
+func (b objectHeaderBucket) apply(
+	name string,
+	cacheControl *string,
+	contentDisposition *string,
+	contentLanguage *string,
+	metadata *map[string]string) {
+	for i := range b.rules {
+		r := &b.rules[i]
+		if !r.matches(name) {
+			continue
+		}
+
+		for header, value := range r.Headers {
+			switch header {
+			case CacheControlHeader:
+				setIfEmpty(cacheControl, value)
+
+			case ContentDispositionHeader:
+				setIfEmpty(contentDisposition, value)
+
+			case ContentLanguageHeader:
+				setIfEmpty(contentLanguage, value)
+
+			default:
+				key := strings.ToLower(strings.TrimPrefix(header, customMetadataHeaderPrefix))
+				if _, ok := (*metadata)[key]; ok {
+					continue
+				}
+
+				// Don't modify the caller's map.
+				m := make(map[string]string, len(*metadata)+1)
+				for k, v := range *metadata {
+					m[k] = v
+				}
+
+				m[key] = value
+				*metadata = m
+			}
+		}
+	}
+}
+
+func setIfEmpty(s *string, value string) {
+	if *s == "" {
+		*s = value
+	}
+}
+
+func (b objectHeaderBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	b.apply(
+		req.Name,
+		&req.CacheControl,
+		&req.ContentDisposition,
+		&req.ContentLanguage,
+		&req.Metadata)
+
+	o, err = b.Bucket.CreateObject(ctx, req)
+	return
+}
+
+func (b objectHeaderBucket) ComposeObjects(
+	ctx context.Context,
+	req *gcs.ComposeObjectsRequest) (o *gcs.Object, err error) {
+	b.apply(
+		req.DstName,
+		&req.CacheControl,
+		&req.ContentDisposition,
+		&req.ContentLanguage,
+		&req.Metadata)
+
+	o, err = b.Bucket.ComposeObjects(ctx, req)
+	return
+}
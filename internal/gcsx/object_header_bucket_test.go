@@ -0,0 +1,196 @@
+// Copyright 2022 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx_test
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+)
+
+func TestObjectHeaderBucket(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type ObjectHeaderBucketTest struct {
+	ctx    context.Context
+	bucket gcs.Bucket
+}
+
+var _ SetUpInterface = &ObjectHeaderBucketTest{}
+
+func init() { RegisterTestSuite(&ObjectHeaderBucketTest{}) }
+
+func (t *ObjectHeaderBucketTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.bucket = gcsx.NewObjectHeaderBucket(
+		[]gcsx.ObjectHeaderRule{
+			{
+				Pattern: "*.html",
+				Headers: map[string]string{
+					gcsx.CacheControlHeader: "no-cache",
+					"X-Goog-Meta-Site":      "www",
+				},
+			},
+			{
+				Pattern: "static/*",
+				Headers: map[string]string{
+					gcsx.CacheControlHeader:    "public, max-age=86400",
+					gcsx.ContentLanguageHeader: "en",
+				},
+			},
+		},
+		gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket"))
+}
+
+// A bucket that records the last compose request it saw.
+type composeRecordingBucket struct {
+	gcs.Bucket
+	req *gcs.ComposeObjectsRequest
+}
+
+func (b *composeRecordingBucket) ComposeObjects(
+	ctx context.Context,
+	req *gcs.ComposeObjectsRequest) (*gcs.Object, error) {
+	b.req = req
+	return b.Bucket.ComposeObjects(ctx, req)
+}
+
+func (t *ObjectHeaderBucketTest) create(req *gcs.CreateObjectRequest) *gcs.Object {
+	req.Contents = strings.NewReader("taco")
+	o, err := t.bucket.CreateObject(t.ctx, req)
+	AssertEq(nil, err)
+	return o
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *ObjectHeaderBucketTest) NoMatchingRule() {
+	o := t.create(&gcs.CreateObjectRequest{Name: "foo/bar.jpg"})
+
+	ExpectEq("", o.CacheControl)
+	ExpectEq("", o.ContentLanguage)
+	ExpectEq(0, len(o.Metadata))
+}
+
+func (t *ObjectHeaderBucketTest) PatternWithoutSlashMatchesBaseName() {
+	o := t.create(&gcs.CreateObjectRequest{Name: "foo/index.html"})
+
+	ExpectEq("no-cache", o.CacheControl)
+	ExpectEq("www", o.Metadata["site"])
+}
+
+func (t *ObjectHeaderBucketTest) PatternWithSlashMatchesWholeName() {
+	o := t.create(&gcs.CreateObjectRequest{Name: "static/logo.jpg"})
+	ExpectEq("public, max-age=86400", o.CacheControl)
+	ExpectEq("en", o.ContentLanguage)
+
+	o = t.create(&gcs.CreateObjectRequest{Name: "foo/static/logo.jpg"})
+	ExpectEq("", o.CacheControl)
+}
+
+func (t *ObjectHeaderBucketTest) FirstMatchingRuleWins() {
+	o := t.create(&gcs.CreateObjectRequest{Name: "static/index.html"})
+
+	ExpectEq("no-cache", o.CacheControl)
+	ExpectEq("en", o.ContentLanguage)
+	ExpectEq("www", o.Metadata["site"])
+}
+
+func (t *ObjectHeaderBucketTest) ExistingHeadersAreKept() {
+	metadata := map[string]string{"site": "blog", "gcsfuse_mtime": "x"}
+	o := t.create(&gcs.CreateObjectRequest{
+		Name:         "index.html",
+		CacheControl: "private",
+		Metadata:     metadata,
+	})
+
+	ExpectEq("private", o.CacheControl)
+	ExpectEq("blog", o.Metadata["site"])
+	ExpectEq("x", o.Metadata["gcsfuse_mtime"])
+}
+
+func (t *ObjectHeaderBucketTest) RequestMetadataIsNotModified() {
+	metadata := map[string]string{"gcsfuse_mtime": "x"}
+	o := t.create(&gcs.CreateObjectRequest{
+		Name:     "index.html",
+		Metadata: metadata,
+	})
+
+	ExpectEq("www", o.Metadata["site"])
+	ExpectEq("x", o.Metadata["gcsfuse_mtime"])
+	ExpectEq(1, len(metadata))
+}
+
+func (t *ObjectHeaderBucketTest) ComposeObjects() {
+	wrapped := &composeRecordingBucket{
+		Bucket: gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket"),
+	}
+
+	bucket := gcsx.NewObjectHeaderBucket(
+		[]gcsx.ObjectHeaderRule{
+			{
+				Pattern: "*.html",
+				Headers: map[string]string{gcsx.CacheControlHeader: "no-cache"},
+			},
+		},
+		wrapped)
+
+	_, err := bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:     "a.jpg",
+			Contents: strings.NewReader("taco"),
+		})
+	AssertEq(nil, err)
+
+	_, err = bucket.ComposeObjects(
+		t.ctx,
+		&gcs.ComposeObjectsRequest{
+			DstName: "index.html",
+			Sources: []gcs.ComposeSource{{Name: "a.jpg"}},
+		})
+
+	AssertEq(nil, err)
+	AssertNe(nil, wrapped.req)
+	ExpectEq("no-cache", wrapped.req.CacheControl)
+}
+
+func (t *ObjectHeaderBucketTest) ValidateObjectHeader() {
+	name, err := gcsx.ValidateObjectHeader(" cache-control ")
+	AssertEq(nil, err)
+	ExpectEq(gcsx.CacheControlHeader, name)
+
+	name, err = gcsx.ValidateObjectHeader("x-goog-meta-site")
+	AssertEq(nil, err)
+	ExpectEq("X-Goog-Meta-Site", name)
+
+	_, err = gcsx.ValidateObjectHeader("Content-Encoding")
+	ExpectNe(nil, err)
+
+	_, err = gcsx.ValidateObjectHeader("x-goog-meta-")
+	ExpectNe(nil, err)
+}
@@ -22,6 +22,7 @@ import (
 
 	"golang.org/x/net/context"
 
+	"github.com/googlecloudplatform/gcsfuse/internal/storage"
 	"github.com/jacobsa/gcloud/gcs"
 )
 
@@ -208,3 +209,65 @@ func (b *prefixBucket) DeleteObject(
 	err = b.wrapped.DeleteObject(ctx, mReq)
 	return
 }
+
+// NewPrefixAclSetter wraps an AclSetter in the same way that NewPrefixBucket
+// wraps a bucket, adding the prefix to the names of objects.
+func NewPrefixAclSetter(
+	prefix string,
+	wrapped storage.AclSetter) storage.AclSetter {
+	return &prefixAclSetter{
+		prefix:  prefix,
+		wrapped: wrapped,
+	}
+}
+
+type prefixAclSetter struct {
+	prefix  string
+	wrapped storage.AclSetter
+}
+
+func (s *prefixAclSetter) SetObjectAcl(
+	ctx context.Context,
+	req *storage.SetObjectAclRequest) (err error) {
+	// Modify the request and call through.
+	mReq := new(storage.SetObjectAclRequest)
+	*mReq = *req
+	mReq.Name = s.prefix + req.Name
+
+	err = s.wrapped.SetObjectAcl(ctx, mReq)
+	return
+}
+
+// NewPrefixGenerationStatter wraps a GenerationStatter in the same way that
+// NewPrefixBucket wraps a bucket.
+func NewPrefixGenerationStatter(
+	prefix string,
+	wrapped storage.GenerationStatter) storage.GenerationStatter {
+	return &prefixGenerationStatter{
+		prefix:  prefix,
+		wrapped: wrapped,
+	}
+}
+
+type prefixGenerationStatter struct {
+	prefix  string
+	wrapped storage.GenerationStatter
+}
+
+func (s *prefixGenerationStatter) StatObjectGeneration(
+	ctx context.Context,
+	req *storage.StatObjectGenerationRequest) (o *gcs.Object, err error) {
+	// Modify the request and call through.
+	mReq := new(storage.StatObjectGenerationRequest)
+	*mReq = *req
+	mReq.Name = s.prefix + req.Name
+
+	o, err = s.wrapped.StatObjectGeneration(ctx, mReq)
+
+	// Modify the returned object.
+	if o != nil {
+		o.Name = strings.TrimPrefix(o.Name, s.prefix)
+	}
+
+	return
+}
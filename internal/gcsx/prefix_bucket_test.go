@@ -23,6 +23,7 @@ import (
 	"golang.org/x/net/context"
 
 	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/googlecloudplatform/gcsfuse/internal/storage"
 	"github.com/jacobsa/gcloud/gcs"
 	"github.com/jacobsa/gcloud/gcs/gcsfake"
 	"github.com/jacobsa/gcloud/gcs/gcsutil"
@@ -395,3 +396,33 @@ func (t *PrefixBucketTest) DeleteObject() {
 	var notFoundErr *gcs.NotFoundError
 	ExpectTrue(errors.As(err, &notFoundErr))
 }
+
+// A GenerationStatter that remembers the names it is asked about.
+type recordingGenerationStatter struct {
+	names []string
+}
+
+func (s *recordingGenerationStatter) StatObjectGeneration(
+	ctx context.Context,
+	req *storage.StatObjectGenerationRequest) (o *gcs.Object, err error) {
+	s.names = append(s.names, req.Name)
+	o = &gcs.Object{Name: req.Name, Generation: req.Generation}
+	return
+}
+
+func (t *PrefixBucketTest) StatObjectGeneration() {
+	wrapped := &recordingGenerationStatter{}
+	s := gcsx.NewPrefixGenerationStatter(t.prefix, wrapped)
+
+	o, err := s.StatObjectGeneration(
+		t.ctx,
+		&storage.StatObjectGenerationRequest{
+			Name:       "taco",
+			Generation: 17,
+		})
+
+	AssertEq(nil, err)
+	ExpectThat(wrapped.names, ElementsAre(t.prefix+"taco"))
+	ExpectEq("taco", o.Name)
+	ExpectEq(17, o.Generation)
+}
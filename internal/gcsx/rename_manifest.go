@@ -0,0 +1,388 @@
+// Copyright 2022 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/logger"
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+// RenameManifestPrefix is the prefix of the names of the objects that record
+// directory renames in progress. It must differ from the prefix of temporary
+// objects, which are garbage collected once stale.
+const RenameManifestPrefix = ".gcsfuse_renames/"
+
+// The mount carrying out a rename updates its manifest this often, so that
+// other mounts can tell that the rename is still in progress.
+const renameManifestHeartbeat = time.Minute
+
+// RenameManifestStaleAfter is how long a manifest must have gone without a
+// heartbeat before another mount takes over its rename.
+const RenameManifestStaleAfter = 10 * time.Minute
+
+// Metadata keys of manifest objects, recording the mount carrying out the
+// rename and when it last showed signs of life.
+const (
+	renameOwnerMetadataKey     = "gcsfuse_rename_owner"
+	renameHeartbeatMetadataKey = "gcsfuse_rename_heartbeat"
+)
+
+// A name for this process in manifests it owns, for the logs of other mounts.
+var renameOwner = func() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+
+	return fmt.Sprintf("%s:%d", host, os.Getpid())
+}()
+
+// A RenameManifest records the plan for renaming a directory before any object
+// is moved, so that a rename interrupted part way through can be finished.
+//
+// A rename proceeds in two phases: every object is first copied to its new
+// name, and only then are the old names deleted. The manifest is deleted last.
+type RenameManifest struct {
+	// The GCS object names of the old and new directories, with trailing
+	// slashes.
+	OldDir string `json:"old_dir"`
+	NewDir string `json:"new_dir"`
+
+	// The objects to move, named relative to the directories.
+	Objects []RenamedObject `json:"objects"`
+}
+
+// RenamedObject is an entry of a RenameManifest.
+type RenamedObject struct {
+	Name           string `json:"name"`
+	Generation     int64  `json:"generation"`
+	MetaGeneration int64  `json:"meta_generation"`
+}
+
+// WriteRenameManifest stores the supplied manifest in the bucket, returning
+// the name of the object holding it.
+func WriteRenameManifest(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	m *RenameManifest) (name string, err error) {
+	contents, err := json.Marshal(m)
+	if err != nil {
+		err = fmt.Errorf("Marshal: %w", err)
+		return
+	}
+
+	var buf [8]byte
+	if _, err = io.ReadFull(rand.Reader, buf[:]); err != nil {
+		err = fmt.Errorf("ReadFull: %w", err)
+		return
+	}
+
+	var zero int64
+	o, err := bucket.CreateObject(
+		ctx,
+		&gcs.CreateObjectRequest{
+			Name:                   RenameManifestPrefix + hex.EncodeToString(buf[:]),
+			GenerationPrecondition: &zero,
+			ContentType:            "application/json",
+			Metadata: map[string]string{
+				renameOwnerMetadataKey: renameOwner,
+			},
+			Contents: bytes.NewReader(contents),
+		})
+	if err != nil {
+		err = fmt.Errorf("CreateObject: %w", err)
+		return
+	}
+
+	name = o.Name
+	return
+}
+
+// DeleteRenameManifest deletes the manifest with the given name, once the
+// rename it describes is complete.
+func DeleteRenameManifest(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	name string) (err error) {
+	err = bucket.DeleteObject(ctx, &gcs.DeleteObjectRequest{Name: name})
+	var notFoundErr *gcs.NotFoundError
+	if errors.As(err, &notFoundErr) {
+		err = nil
+	}
+
+	return
+}
+
+// KeepRenameManifestAlive records a heartbeat on the manifest with the given
+// name every so often until the returned function is called, so that other
+// mounts leave the rename to this one while it is in progress.
+func KeepRenameManifestAlive(
+	bucket gcs.Bucket,
+	name string) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(renameManifestHeartbeat)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case <-ticker.C:
+			}
+
+			// The manifest may be gone already if the rename has just finished.
+			err := heartbeatRenameManifest(ctx, bucket, name, nil)
+			var notFoundErr *gcs.NotFoundError
+			if err != nil && ctx.Err() == nil && !errors.As(err, &notFoundErr) {
+				logger.Infof("Heartbeat for rename manifest %q: %v\n", name, err)
+			}
+		}
+	}()
+
+	stop = func() {
+		cancel()
+		<-done
+	}
+
+	return
+}
+
+// Record that this process owns the manifest with the given name and is
+// alive. If metaGeneration is non-nil, do so only if the manifest's
+// meta-generation is still that.
+func heartbeatRenameManifest(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	name string,
+	metaGeneration *int64) (err error) {
+	owner := renameOwner
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	_, err = bucket.UpdateObject(
+		ctx,
+		&gcs.UpdateObjectRequest{
+			Name:                       name,
+			MetaGenerationPrecondition: metaGeneration,
+			Metadata: map[string]*string{
+				renameOwnerMetadataKey:     &owner,
+				renameHeartbeatMetadataKey: &now,
+			},
+		})
+
+	return
+}
+
+// ResumeRenames finishes the renames described by any manifests left in the
+// bucket by mounts that have stopped updating them for RenameManifestStaleAfter
+// (judged by the supplied clock), deleting each manifest once its rename is
+// complete. Renames still in progress elsewhere are left alone. It returns the
+// number of renames finished.
+func ResumeRenames(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	clock timeutil.Clock) (resumed int, err error) {
+	manifests, err := listRenameManifests(ctx, bucket)
+	if err != nil {
+		err = fmt.Errorf("listRenameManifests: %w", err)
+		return
+	}
+
+	var notFoundErr *gcs.NotFoundError
+	var preconditionErr *gcs.PreconditionError
+
+	for _, o := range manifests {
+		if clock.Now().Sub(o.Updated) < RenameManifestStaleAfter {
+			continue
+		}
+
+		// Take over the manifest, unless another mount beats us to it.
+		name := o.Name
+		metaGeneration := o.MetaGeneration
+		err = heartbeatRenameManifest(ctx, bucket, name, &metaGeneration)
+		if errors.As(err, &notFoundErr) || errors.As(err, &preconditionErr) {
+			err = nil
+			continue
+		}
+
+		if err != nil {
+			err = fmt.Errorf("heartbeatRenameManifest(%q): %w", name, err)
+			return
+		}
+
+		var m RenameManifest
+		if m, err = readRenameManifest(ctx, bucket, name); err != nil {
+			err = fmt.Errorf("readRenameManifest(%q): %w", name, err)
+			return
+		}
+
+		logger.Infof(
+			"Finishing interrupted rename of %q to %q (%d objects) left by %s.\n",
+			m.OldDir,
+			m.NewDir,
+			len(m.Objects),
+			o.Metadata[renameOwnerMetadataKey])
+
+		stop := KeepRenameManifestAlive(bucket, name)
+		err = finishRename(ctx, bucket, &m)
+		stop()
+
+		if err != nil {
+			err = fmt.Errorf("finishRename(%q): %w", name, err)
+			return
+		}
+
+		if err = DeleteRenameManifest(ctx, bucket, name); err != nil {
+			err = fmt.Errorf("DeleteRenameManifest(%q): %w", name, err)
+			return
+		}
+
+		resumed++
+	}
+
+	return
+}
+
+func listRenameManifests(
+	ctx context.Context,
+	bucket gcs.Bucket) (objects []*gcs.Object, err error) {
+	objects, _, err = gcsutil.ListAll(
+		ctx,
+		bucket,
+		&gcs.ListObjectsRequest{Prefix: RenameManifestPrefix})
+	return
+}
+
+func readRenameManifest(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	name string) (m RenameManifest, err error) {
+	contents, err := gcsutil.ReadObject(ctx, bucket, name)
+	if err != nil {
+		err = fmt.Errorf("ReadObject: %w", err)
+		return
+	}
+
+	if err = json.Unmarshal(contents, &m); err != nil {
+		err = fmt.Errorf("Unmarshal: %w", err)
+		return
+	}
+
+	return
+}
+
+// Repeat both phases of the rename. Copies whose source is gone were
+// completed, since no source is deleted until every copy is made.
+func finishRename(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	m *RenameManifest) (err error) {
+	var notFoundErr *gcs.NotFoundError
+	var preconditionErr *gcs.PreconditionError
+
+	for _, o := range m.Objects {
+		// The new directory was empty when the rename began, so an existing
+		// destination was either copied already or written since, and must not
+		// be overwritten. CopyObjectRequest has no destination precondition, so
+		// check first, leaving a small window for a racing write.
+		_, err = bucket.StatObject(
+			ctx,
+			&gcs.StatObjectRequest{Name: m.NewDir + o.Name})
+
+		switch {
+		case err == nil:
+			continue
+
+		case errors.As(err, &notFoundErr):
+
+		default:
+			err = fmt.Errorf("StatObject(%q): %w", m.NewDir+o.Name, err)
+			return
+		}
+
+		_, err = bucket.CopyObject(
+			ctx,
+			&gcs.CopyObjectRequest{
+				SrcName:                       m.OldDir + o.Name,
+				SrcGeneration:                 o.Generation,
+				SrcMetaGenerationPrecondition: &o.MetaGeneration,
+				DstName:                       m.NewDir + o.Name,
+			})
+
+		switch {
+		case errors.As(err, &notFoundErr):
+			err = nil
+
+		case errors.As(err, &preconditionErr):
+			// The source was modified after the plan was made, so leave it be.
+			logger.Infof("Not moving %q, which has changed.\n", m.OldDir+o.Name)
+			err = nil
+
+		case err != nil:
+			err = fmt.Errorf("CopyObject(%q): %w", m.OldDir+o.Name, err)
+			return
+		}
+	}
+
+	for _, o := range m.Objects {
+		err = bucket.DeleteObject(
+			ctx,
+			&gcs.DeleteObjectRequest{
+				Name:                       m.OldDir + o.Name,
+				Generation:                 o.Generation,
+				MetaGenerationPrecondition: &o.MetaGeneration,
+			})
+
+		if errors.As(err, &notFoundErr) || errors.As(err, &preconditionErr) {
+			err = nil
+		}
+
+		if err != nil {
+			err = fmt.Errorf("DeleteObject(%q): %w", m.OldDir+o.Name, err)
+			return
+		}
+	}
+
+	// Finally the old directory itself, which may not have a backing object.
+	err = bucket.DeleteObject(ctx, &gcs.DeleteObjectRequest{Name: m.OldDir})
+	if errors.As(err, &notFoundErr) {
+		err = nil
+	}
+
+	if err != nil {
+		err = fmt.Errorf("DeleteObject(%q): %w", m.OldDir, err)
+		return
+	}
+
+	return
+}
@@ -0,0 +1,211 @@
+// Copyright 2022 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+)
+
+func TestRenameManifest(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type RenameManifestTest struct {
+	ctx    context.Context
+	clock  timeutil.SimulatedClock
+	bucket gcs.Bucket
+}
+
+var _ SetUpInterface = &RenameManifestTest{}
+
+func init() { RegisterTestSuite(&RenameManifestTest{}) }
+
+func (t *RenameManifestTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.clock.SetTime(time.Date(2015, 4, 5, 2, 15, 0, 0, time.Local))
+	t.bucket = gcsfake.NewFakeBucket(&t.clock, "some_bucket")
+}
+
+func (t *RenameManifestTest) create(name string, contents string) *gcs.Object {
+	o, err := gcsutil.CreateObject(t.ctx, t.bucket, name, []byte(contents))
+	AssertEq(nil, err)
+	return o
+}
+
+func (t *RenameManifestTest) exists(name string) bool {
+	_, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: name})
+	var notFoundErr *gcs.NotFoundError
+	if errors.As(err, &notFoundErr) {
+		return false
+	}
+
+	AssertEq(nil, err)
+	return true
+}
+
+func (t *RenameManifestTest) read(name string) string {
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, name)
+	AssertEq(nil, err)
+	return string(contents)
+}
+
+// Write a manifest for moving the given objects from a/ to b/.
+func (t *RenameManifestTest) writeManifest(objects ...*gcs.Object) string {
+	m := &gcsx.RenameManifest{
+		OldDir: "a/",
+		NewDir: "b/",
+	}
+
+	for _, o := range objects {
+		m.Objects = append(m.Objects, gcsx.RenamedObject{
+			Name:           strings.TrimPrefix(o.Name, "a/"),
+			Generation:     o.Generation,
+			MetaGeneration: o.MetaGeneration,
+		})
+	}
+
+	name, err := gcsx.WriteRenameManifest(t.ctx, t.bucket, m)
+	AssertEq(nil, err)
+	ExpectTrue(strings.HasPrefix(name, gcsx.RenameManifestPrefix))
+	return name
+}
+
+// Resume renames whose manifests have gone without a heartbeat for long
+// enough.
+func (t *RenameManifestTest) resumeStale() (int, error) {
+	t.clock.AdvanceTime(gcsx.RenameManifestStaleAfter)
+	return gcsx.ResumeRenames(t.ctx, t.bucket, &t.clock)
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *RenameManifestTest) NoManifests() {
+	t.create("a/foo", "taco")
+
+	n, err := t.resumeStale()
+
+	AssertEq(nil, err)
+	ExpectEq(0, n)
+	ExpectTrue(t.exists("a/foo"))
+}
+
+func (t *RenameManifestTest) DeletedManifest() {
+	name := t.writeManifest(t.create("a/foo", "taco"))
+
+	AssertEq(nil, gcsx.DeleteRenameManifest(t.ctx, t.bucket, name))
+	AssertEq(nil, gcsx.DeleteRenameManifest(t.ctx, t.bucket, name))
+
+	n, err := t.resumeStale()
+	AssertEq(nil, err)
+	ExpectEq(0, n)
+	ExpectTrue(t.exists("a/foo"))
+}
+
+func (t *RenameManifestTest) InterruptedBeforeCopying() {
+	t.create("a/", "")
+	name := t.writeManifest(
+		t.create("a/foo", "taco"),
+		t.create("a/bar/baz", "burrito"))
+
+	n, err := t.resumeStale()
+
+	AssertEq(nil, err)
+	ExpectEq(1, n)
+	ExpectEq("taco", t.read("b/foo"))
+	ExpectEq("burrito", t.read("b/bar/baz"))
+	ExpectFalse(t.exists("a/foo"))
+	ExpectFalse(t.exists("a/bar/baz"))
+	ExpectFalse(t.exists("a/"))
+	ExpectFalse(t.exists(name))
+}
+
+func (t *RenameManifestTest) InterruptedWhileDeleting() {
+	foo := t.create("a/foo", "taco")
+	bar := t.create("a/bar", "burrito")
+	name := t.writeManifest(foo, bar)
+
+	// Both copies were made, and one of the deletes.
+	t.create("b/foo", "taco")
+	t.create("b/bar", "burrito")
+	AssertEq(nil, t.bucket.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "a/foo"}))
+
+	n, err := t.resumeStale()
+
+	AssertEq(nil, err)
+	ExpectEq(1, n)
+	ExpectEq("taco", t.read("b/foo"))
+	ExpectEq("burrito", t.read("b/bar"))
+	ExpectFalse(t.exists("a/foo"))
+	ExpectFalse(t.exists("a/bar"))
+	ExpectFalse(t.exists(name))
+}
+
+func (t *RenameManifestTest) SourceChangedSinceManifest() {
+	foo := t.create("a/foo", "taco")
+	name := t.writeManifest(foo, t.create("a/bar", "burrito"))
+
+	t.create("a/foo", "enchilada")
+
+	n, err := t.resumeStale()
+
+	AssertEq(nil, err)
+	ExpectEq(1, n)
+	ExpectEq("enchilada", t.read("a/foo"))
+	ExpectFalse(t.exists("b/foo"))
+	ExpectEq("burrito", t.read("b/bar"))
+	ExpectFalse(t.exists(name))
+}
+
+func (t *RenameManifestTest) RenameStillInProgress() {
+	name := t.writeManifest(t.create("a/foo", "taco"))
+
+	// The manifest is fresh, so its owner may still be working on it.
+	n, err := gcsx.ResumeRenames(t.ctx, t.bucket, &t.clock)
+
+	AssertEq(nil, err)
+	ExpectEq(0, n)
+	ExpectTrue(t.exists("a/foo"))
+	ExpectFalse(t.exists("b/foo"))
+	ExpectTrue(t.exists(name))
+}
+
+func (t *RenameManifestTest) DestinationWrittenSinceManifest() {
+	name := t.writeManifest(t.create("a/foo", "taco"))
+
+	t.create("b/foo", "enchilada")
+
+	n, err := t.resumeStale()
+
+	AssertEq(nil, err)
+	ExpectEq(1, n)
+	ExpectEq("enchilada", t.read("b/foo"))
+	ExpectFalse(t.exists(name))
+}
@@ -0,0 +1,248 @@
+// Copyright 2022 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/jacobsa/fuse/fsutil"
+)
+
+// The storage behind a temp file, with semantics matching os.File.
+type backingFile interface {
+	io.ReadSeeker
+	io.ReaderAt
+	io.Writer
+	io.WriterAt
+	io.Closer
+	Truncate(size int64) error
+	Name() string
+}
+
+// Create storage for a temp file. If memoryLimit is positive, the contents are
+// kept in memory until they grow larger than that. Otherwise they live in an
+// anonymous file in dir, or the system default temporary location if empty.
+func newBackingFile(memoryLimit int64, dir string) (bf backingFile, err error) {
+	if memoryLimit > 0 {
+		bf = &spillFile{limit: memoryLimit, dir: dir}
+		return
+	}
+
+	f, err := fsutil.AnonymousFile(dir)
+	if err != nil {
+		err = fmt.Errorf("AnonymousFile: %w", err)
+		return
+	}
+
+	bf = f
+	return
+}
+
+// A spillFile holds its contents in memory until they would grow larger than
+// a limit, then moves them to an anonymous file, which it uses from then on.
+// This saves creating a file on disk for every small file written.
+type spillFile struct {
+	limit int64
+	dir   string
+
+	// The contents and seek position while in memory.
+	//
+	// INVARIANT: f != nil => len(buf) == 0
+	// INVARIANT: int64(len(buf)) <= limit
+	buf []byte
+	pos int64
+
+	// The file holding the contents once they have spilled, or nil.
+	f *os.File
+}
+
+func (sf *spillFile) Read(p []byte) (n int, err error) {
+	if sf.f != nil {
+		return sf.f.Read(p)
+	}
+
+	n, err = sf.ReadAt(p, sf.pos)
+	sf.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+
+	return
+}
+
+func (sf *spillFile) Seek(offset int64, whence int) (pos int64, err error) {
+	if sf.f != nil {
+		return sf.f.Seek(offset, whence)
+	}
+
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = sf.pos + offset
+	case io.SeekEnd:
+		pos = int64(len(sf.buf)) + offset
+	default:
+		err = fmt.Errorf("Invalid whence: %d", whence)
+		return
+	}
+
+	if pos < 0 {
+		err = errors.New("Negative seek position")
+		return
+	}
+
+	sf.pos = pos
+	return
+}
+
+func (sf *spillFile) ReadAt(p []byte, offset int64) (n int, err error) {
+	if sf.f != nil {
+		return sf.f.ReadAt(p, offset)
+	}
+
+	if offset < 0 {
+		err = errors.New("Negative offset")
+		return
+	}
+
+	if offset < int64(len(sf.buf)) {
+		n = copy(p, sf.buf[offset:])
+	}
+
+	if n < len(p) {
+		err = io.EOF
+	}
+
+	return
+}
+
+func (sf *spillFile) Write(p []byte) (n int, err error) {
+	if sf.f == nil && sf.pos+int64(len(p)) > sf.limit {
+		if err = sf.spill(); err != nil {
+			return
+		}
+	}
+
+	if sf.f != nil {
+		return sf.f.Write(p)
+	}
+
+	n, err = sf.WriteAt(p, sf.pos)
+	sf.pos += int64(n)
+	return
+}
+
+func (sf *spillFile) WriteAt(p []byte, offset int64) (n int, err error) {
+	if offset < 0 {
+		err = errors.New("Negative offset")
+		return
+	}
+
+	end := offset + int64(len(p))
+	if sf.f == nil && end > sf.limit {
+		if err = sf.spill(); err != nil {
+			return
+		}
+	}
+
+	if sf.f != nil {
+		return sf.f.WriteAt(p, offset)
+	}
+
+	if end > int64(len(sf.buf)) {
+		sf.resize(end)
+	}
+
+	n = copy(sf.buf[offset:], p)
+	return
+}
+
+func (sf *spillFile) Truncate(size int64) (err error) {
+	if size < 0 {
+		err = errors.New("Negative size")
+		return
+	}
+
+	if sf.f == nil && size > sf.limit {
+		if err = sf.spill(); err != nil {
+			return
+		}
+	}
+
+	if sf.f != nil {
+		return sf.f.Truncate(size)
+	}
+
+	sf.resize(size)
+	return
+}
+
+func (sf *spillFile) Close() (err error) {
+	sf.buf = nil
+	if sf.f != nil {
+		err = sf.f.Close()
+		sf.f = nil
+	}
+
+	return
+}
+
+// Name returns the empty string until the contents have spilled to disk.
+func (sf *spillFile) Name() string {
+	if sf.f != nil {
+		return sf.f.Name()
+	}
+
+	return ""
+}
+
+// Change the length of the in-memory contents, zero-filling any new bytes.
+func (sf *spillFile) resize(size int64) {
+	if size <= int64(len(sf.buf)) {
+		sf.buf = sf.buf[:size]
+		return
+	}
+
+	sf.buf = append(sf.buf, make([]byte, size-int64(len(sf.buf)))...)
+}
+
+// Move the contents to disk.
+func (sf *spillFile) spill() (err error) {
+	f, err := fsutil.AnonymousFile(sf.dir)
+	if err != nil {
+		err = fmt.Errorf("AnonymousFile: %w", err)
+		return
+	}
+
+	if _, err = f.WriteAt(sf.buf, 0); err != nil {
+		f.Close()
+		err = fmt.Errorf("WriteAt: %w", err)
+		return
+	}
+
+	if _, err = f.Seek(sf.pos, io.SeekStart); err != nil {
+		f.Close()
+		err = fmt.Errorf("Seek: %w", err)
+		return
+	}
+
+	sf.f = f
+	sf.buf = nil
+	return
+}
@@ -84,6 +84,23 @@ func (oc *fullObjectCreator) Create(
 	srcObject *gcs.Object,
 	mtime time.Time,
 	r io.Reader) (o *gcs.Object, err error) {
+	o, err = oc.bucket.CreateObject(ctx, ReplaceObjectRequest(srcObject, mtime, r))
+	if err != nil {
+		err = fmt.Errorf("CreateObject: %w", err)
+		return
+	}
+
+	return
+}
+
+// ReplaceObjectRequest returns a request to replace the contents of srcObject
+// with those of r, keeping its metadata and other properties and recording the
+// supplied mtime. The request fails with *gcs.PreconditionError if srcObject
+// is no longer the current generation.
+func ReplaceObjectRequest(
+	srcObject *gcs.Object,
+	mtime time.Time,
+	r io.Reader) (req *gcs.CreateObjectRequest) {
 	MetadataMap:= make(map[string]string)
 
 	/* Copy Metadata fields from existing object to retain them for new object. */
@@ -93,7 +110,7 @@ func (oc *fullObjectCreator) Create(
 
 	MetadataMap[MtimeMetadataKey] = mtime.Format(time.RFC3339Nano)
 
-	req := &gcs.CreateObjectRequest{
+	req = &gcs.CreateObjectRequest{
 		Name:                       srcObject.Name,
 		GenerationPrecondition:     &srcObject.Generation,
 		MetaGenerationPrecondition: &srcObject.MetaGeneration,
@@ -108,12 +125,6 @@ func (oc *fullObjectCreator) Create(
 		StorageClass:               srcObject.StorageClass,
 	}
 
-	o, err = oc.bucket.CreateObject(ctx, req)
-	if err != nil {
-		err = fmt.Errorf("CreateObject: %w", err)
-		return
-	}
-
 	return
 }
 
@@ -215,6 +226,13 @@ func (os *syncer) SyncObject(
 
 		o, err = os.appendCreator.Create(ctx, srcObject, mtime, content)
 	} else {
+		// We're about to read all of the content.
+		err = content.Fill(ctx, 0, sr.Size)
+		if err != nil {
+			err = fmt.Errorf("Fill: %w", err)
+			return
+		}
+
 		_, err = content.Seek(0, 0)
 		if err != nil {
 			err = fmt.Errorf("Seek: %w", err)
@@ -15,12 +15,20 @@
 package gcsx
 
 import (
+	"github.com/googlecloudplatform/gcsfuse/internal/storage"
 	"github.com/jacobsa/gcloud/gcs"
 )
 
 type SyncerBucket struct {
 	gcs.Bucket
 	Syncer
+
+	// Sets object ACLs in place, or nil if the bucket doesn't support it.
+	AclSetter storage.AclSetter
+
+	// Stats noncurrent generations of objects, or nil if the bucket doesn't
+	// support it.
+	GenerationStatter storage.GenerationStatter
 }
 
 // NewSyncerBucket creates a SyncerBucket, which can be used either as
@@ -31,5 +39,5 @@ func NewSyncerBucket(
 	bucket gcs.Bucket,
 ) SyncerBucket {
 	syncer := NewSyncer(appendThreshold, tmpObjectPrefix, bucket)
-	return SyncerBucket{bucket, syncer}
+	return SyncerBucket{Bucket: bucket, Syncer: syncer}
 }
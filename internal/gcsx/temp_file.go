@@ -21,8 +21,8 @@ import (
 	"os"
 	"time"
 
-	"github.com/jacobsa/fuse/fsutil"
 	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
 )
 
 // TempFile is a temporary file that keeps track of the lowest offset at which
@@ -39,6 +39,12 @@ type TempFile interface {
 	io.WriterAt
 	Truncate(n int64) (err error)
 
+	// Make sure that the bytes in [offset, offset+n) that have not been written
+	// hold the initial contents, fetching any that a temp file created with
+	// NewSparseTempFile doesn't yet have. Reading such bytes before they are
+	// fetched is an error. A no-op for other temp files.
+	Fill(ctx context.Context, offset int64, n int64) (err error)
+
 	// Retrieve the file name
 	Name() string
 
@@ -81,11 +87,22 @@ func NewTempFile(
 	source io.ReadCloser,
 	dir string,
 	clock timeutil.Clock) (tf TempFile, err error) {
-	// Create an anonymous file to wrap. When we close it, its resources will be
-	// magically cleaned up.
-	f, err := fsutil.AnonymousFile(dir)
+	tf, err = NewMemoryTempFile(source, 0, dir, clock)
+	return
+}
+
+// NewMemoryTempFile is like NewTempFile, but keeps the contents in memory
+// until they grow larger than memoryLimit bytes, only then moving them to
+// dir. A memoryLimit of zero means always using dir.
+func NewMemoryTempFile(
+	source io.ReadCloser,
+	memoryLimit int64,
+	dir string,
+	clock timeutil.Clock) (tf TempFile, err error) {
+	// Create an anonymous file to wrap, unless we start in memory. When we close
+	// it, its resources will be magically cleaned up.
+	f, err := newBackingFile(memoryLimit, dir)
 	if err != nil {
-		err = fmt.Errorf("AnonymousFile: %w", err)
 		return
 	}
 
@@ -100,6 +117,51 @@ func NewTempFile(
 	return
 }
 
+// A FetchFunc returns a reader for the n bytes of a sparse temp file's initial
+// contents starting at offset.
+type FetchFunc func(
+	ctx context.Context,
+	offset int64,
+	n int64) (rc io.ReadCloser, err error)
+
+// NewSparseTempFile creates a temp file whose initial contents, of the given
+// size, are only fetched with the supplied function when Fill asks for them.
+// Bytes that are written or truncated away before then are never fetched, so
+// a file that is overwritten in full needn't be read at all. memoryLimit is as
+// for NewMemoryTempFile.
+func NewSparseTempFile(
+	size int64,
+	fetch FetchFunc,
+	memoryLimit int64,
+	dir string,
+	clock timeutil.Clock) (tf TempFile, err error) {
+	f, err := newBackingFile(memoryLimit, dir)
+	if err != nil {
+		return
+	}
+
+	if err = f.Truncate(size); err != nil {
+		f.Close()
+		err = fmt.Errorf("Truncate: %w", err)
+		return
+	}
+
+	t := &tempFile{
+		state:          fileComplete,
+		clock:          clock,
+		f:              f,
+		dirtyThreshold: size,
+		fetch:          fetch,
+	}
+
+	if size > 0 {
+		t.unfetched = []byteRange{{0, size}}
+	}
+
+	tf = t
+	return
+}
+
 // NewCacheFile creates a wrapper temp file whose initial contents are given by the
 // supplied source. dir is a directory on whose file system the file will live,
 // or the system default temporary location if empty.
@@ -160,13 +222,16 @@ type tempFile struct {
 
 	source io.ReadCloser
 
+	// For sparse temp files, the source of the initial contents.
+	fetch FetchFunc
+
 	/////////////////////////
 	// Mutable state
 	/////////////////////////
 	state fileState
 
 	// A file containing our current contents.
-	f *os.File
+	f backingFile
 
 	// The lowest byte index that has been modified from the initial contents.
 	//
@@ -178,6 +243,20 @@ type tempFile struct {
 	//
 	// INVARIANT: mtime == nil => Stat().DirtyThreshold == Stat().Size
 	mtime *time.Time
+
+	// The ranges of the initial contents that are neither fetched nor written,
+	// in order. These read as zeroes from f.
+	//
+	// INVARIANT: The ranges are non-empty, disjoint, and sorted.
+	// INVARIANT: The ranges are contained in [0, Stat().Size)
+	// INVARIANT: len(unfetched) > 0 => fetch != nil
+	unfetched []byteRange
+}
+
+// A byteRange is the range of offsets [start, limit).
+type byteRange struct {
+	start int64
+	limit int64
 }
 
 ////////////////////////////////////////////////////////////////////////
@@ -216,6 +295,22 @@ func (tf *tempFile) CheckInvariants() {
 	if tf.mtime == nil && sr.DirtyThreshold != sr.Size {
 		panic(fmt.Errorf("Mismatch: %d vs. %d", sr.DirtyThreshold, sr.Size))
 	}
+
+	// INVARIANT: The ranges are non-empty, disjoint, and sorted.
+	// INVARIANT: The ranges are contained in [0, Stat().Size)
+	var prev int64
+	for _, r := range tf.unfetched {
+		if !(prev <= r.start && r.start < r.limit && r.limit <= sr.Size) {
+			panic(fmt.Errorf("Bad unfetched range: %v", tf.unfetched))
+		}
+
+		prev = r.limit
+	}
+
+	// INVARIANT: len(unfetched) > 0 => fetch != nil
+	if len(tf.unfetched) > 0 && tf.fetch == nil {
+		panic("Unfetched ranges without a fetch function")
+	}
 }
 
 func (tf *tempFile) Destroy() {
@@ -231,6 +326,16 @@ func (tf *tempFile) Read(p []byte) (int, error) {
 	if err != nil {
 		return 0, fmt.Errorf("Cannot Read incomplete file: %w", err)
 	}
+
+	pos, err := tf.f.Seek(0, 1)
+	if err != nil {
+		return 0, fmt.Errorf("Seek: %w", err)
+	}
+
+	if err := tf.checkFetched(pos, int64(len(p))); err != nil {
+		return 0, err
+	}
+
 	return tf.f.Read(p)
 }
 
@@ -247,6 +352,11 @@ func (tf *tempFile) ReadAt(p []byte, offset int64) (int, error) {
 	if err != nil {
 		return 0, fmt.Errorf("Cannot ReadAt incomplete file: %w", err)
 	}
+
+	if err := tf.checkFetched(offset, int64(len(p))); err != nil {
+		return 0, err
+	}
+
 	return tf.f.ReadAt(p, offset)
 }
 
@@ -283,6 +393,9 @@ func (tf *tempFile) WriteAt(p []byte, offset int64) (int, error) {
 	newMtime := tf.clock.Now()
 	tf.mtime = &newMtime
 
+	// The written bytes no longer need fetching.
+	tf.dropUnfetched(offset, offset+int64(len(p)))
+
 	// Call through.
 	return tf.f.WriteAt(p, offset)
 }
@@ -301,10 +414,39 @@ func (tf *tempFile) Truncate(n int64) error {
 	newMtime := tf.clock.Now()
 	tf.mtime = &newMtime
 
+	// Bytes past the new end are gone, and any that reappear read as zeroes.
+	tf.dropUnfetched(n, math.MaxInt64)
+
 	// Call through.
 	return tf.f.Truncate(n)
 }
 
+func (tf *tempFile) Fill(ctx context.Context, offset int64, n int64) (err error) {
+	// Fetch a little more than asked for, so that sequential reads don't each
+	// cost a round trip.
+	limit := offset + maxInt64(n, minFetchLength)
+	if limit < offset {
+		limit = math.MaxInt64
+	}
+
+	for _, r := range tf.unfetched {
+		start := maxInt64(r.start, offset)
+		end := minInt64(r.limit, limit)
+		if start >= end {
+			continue
+		}
+
+		if err = tf.fetchRange(ctx, start, end); err != nil {
+			return
+		}
+	}
+
+	// Forget what we fetched only now, since the loop above ranges over the
+	// slice.
+	tf.dropUnfetched(offset, limit)
+	return
+}
+
 func (tf *tempFile) SetMtime(mtime time.Time) {
 	tf.mtime = &mtime
 }
@@ -325,6 +467,84 @@ func minInt64(a int64, b int64) int64 {
 	return b
 }
 
+func maxInt64(a int64, b int64) int64 {
+	if a > b {
+		return a
+	}
+
+	return b
+}
+
+// The smallest read of a sparse temp file's initial contents that Fill makes.
+const minFetchLength = 1 << 20 // 1 MiB
+
+// Return an error if any of the bytes [offset, offset+n) are unfetched.
+func (tf *tempFile) checkFetched(offset int64, n int64) error {
+	for _, r := range tf.unfetched {
+		if r.start < offset+n && offset < r.limit {
+			return fmt.Errorf(
+				"Bytes [%d, %d) haven't been fetched",
+				maxInt64(r.start, offset),
+				minInt64(r.limit, offset+n))
+		}
+	}
+
+	return nil
+}
+
+// Remove [start, limit) from the unfetched ranges.
+func (tf *tempFile) dropUnfetched(start int64, limit int64) {
+	var remaining []byteRange
+	for _, r := range tf.unfetched {
+		if r.limit <= start || limit <= r.start {
+			remaining = append(remaining, r)
+			continue
+		}
+
+		if r.start < start {
+			remaining = append(remaining, byteRange{r.start, start})
+		}
+
+		if limit < r.limit {
+			remaining = append(remaining, byteRange{limit, r.limit})
+		}
+	}
+
+	tf.unfetched = remaining
+}
+
+// Copy the initial contents [start, limit) into place, without touching the
+// dirty state.
+func (tf *tempFile) fetchRange(ctx context.Context, start int64, limit int64) (err error) {
+	rc, err := tf.fetch(ctx, start, limit-start)
+	if err != nil {
+		err = fmt.Errorf("fetch: %w", err)
+		return
+	}
+
+	defer rc.Close()
+
+	_, err = io.CopyN(&offsetWriter{tf.f, start}, rc, limit-start)
+	if err != nil {
+		err = fmt.Errorf("CopyN: %w", err)
+		return
+	}
+
+	return
+}
+
+// An io.Writer that writes to an io.WriterAt, starting at an offset.
+type offsetWriter struct {
+	w      io.WriterAt
+	offset int64
+}
+
+func (w *offsetWriter) Write(p []byte) (n int, err error) {
+	n, err = w.w.WriteAt(p, w.offset)
+	w.offset += int64(n)
+	return
+}
+
 const (
 	minCopyLength = 64 * 1024 * 1024 // 64 MB
 )
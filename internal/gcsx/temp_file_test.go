@@ -250,3 +250,226 @@ func (t *TempFileTest) SetMtime() {
 	AssertEq(nil, err)
 	ExpectThat(sr.Mtime, Pointee(timeutil.TimeEq(mtime)))
 }
+
+func (tf *checkingTempFile) Fill(ctx context.Context, offset int64, n int64) error {
+	tf.wrapped.CheckInvariants()
+	defer tf.wrapped.CheckInvariants()
+	return tf.wrapped.Fill(ctx, offset, n)
+}
+
+////////////////////////////////////////////////////////////////////////
+// Sparse temp files
+////////////////////////////////////////////////////////////////////////
+
+type SparseTempFileTest struct {
+	ctx   context.Context
+	clock timeutil.SimulatedClock
+
+	// The ranges requested from the fetch function.
+	fetched []string
+
+	tf checkingTempFile
+}
+
+func init() { RegisterTestSuite(&SparseTempFileTest{}) }
+
+var _ SetUpInterface = &SparseTempFileTest{}
+
+func (t *SparseTempFileTest) SetUp(ti *TestInfo) {
+	var err error
+	t.ctx = ti.Ctx
+	t.clock.SetTime(time.Date(2012, 8, 15, 22, 56, 0, 0, time.Local))
+
+	fetch := func(
+		ctx context.Context,
+		offset int64,
+		n int64) (rc io.ReadCloser, err error) {
+		t.fetched = append(t.fetched, fmt.Sprintf("[%d, %d)", offset, offset+n))
+		rc = dummyReadCloser{strings.NewReader(initialContent[offset : offset+n])}
+		return
+	}
+
+	t.tf.wrapped, err = gcsx.NewSparseTempFile(
+		int64(initialContentSize),
+		fetch,
+		0,
+		"",
+		&t.clock)
+
+	AssertEq(nil, err)
+}
+
+func (t *SparseTempFileTest) Stat_InitialState() {
+	sr, err := t.tf.Stat()
+
+	AssertEq(nil, err)
+	ExpectEq(initialContentSize, sr.Size)
+	ExpectEq(initialContentSize, sr.DirtyThreshold)
+	ExpectEq(nil, sr.Mtime)
+	ExpectThat(t.fetched, ElementsAre())
+}
+
+func (t *SparseTempFileTest) ReadAt_Unfetched() {
+	var buf [2]byte
+	_, err := t.tf.ReadAt(buf[:], 1)
+
+	ExpectThat(err, Error(HasSubstr("fetched")))
+}
+
+func (t *SparseTempFileTest) FillThenReadAt() {
+	err := t.tf.Fill(t.ctx, 1, 2)
+	AssertEq(nil, err)
+
+	var buf [2]byte
+	n, err := t.tf.ReadAt(buf[:], 1)
+
+	AssertEq(nil, err)
+	ExpectEq(initialContent[1:3], string(buf[:n]))
+
+	// The fetch runs to the end of the contents.
+	ExpectThat(t.fetched, ElementsAre("[1, 11)"))
+
+	// Fetching doesn't dirty the file.
+	sr, err := t.tf.Stat()
+
+	AssertEq(nil, err)
+	ExpectEq(initialContentSize, sr.DirtyThreshold)
+	ExpectEq(nil, sr.Mtime)
+}
+
+func (t *SparseTempFileTest) WriteThenFill() {
+	_, err := t.tf.WriteAt([]byte("fo"), 1)
+	AssertEq(nil, err)
+
+	// Only the bytes around the write are fetched.
+	err = t.tf.Fill(t.ctx, 0, int64(initialContentSize))
+	AssertEq(nil, err)
+
+	ExpectThat(t.fetched, ElementsAre("[0, 1)", "[3, 11)"))
+
+	expected := []byte(initialContent)
+	expected[1] = 'f'
+	expected[2] = 'o'
+
+	actual, err := readAll(&t.tf)
+	AssertEq(nil, err)
+	ExpectEq(string(expected), string(actual))
+}
+
+func (t *SparseTempFileTest) OverwriteThenFill() {
+	_, err := t.tf.WriteAt([]byte("enchiladas!"), 0)
+	AssertEq(nil, err)
+
+	err = t.tf.Fill(t.ctx, 0, int64(initialContentSize))
+	AssertEq(nil, err)
+
+	ExpectThat(t.fetched, ElementsAre())
+
+	actual, err := readAll(&t.tf)
+	AssertEq(nil, err)
+	ExpectEq("enchiladas!", string(actual))
+}
+
+func (t *SparseTempFileTest) TruncateThenFill() {
+	err := t.tf.Truncate(2)
+	AssertEq(nil, err)
+
+	// Growing again gives zeroes rather than the old contents.
+	err = t.tf.Truncate(4)
+	AssertEq(nil, err)
+
+	err = t.tf.Fill(t.ctx, 0, 4)
+	AssertEq(nil, err)
+
+	ExpectThat(t.fetched, ElementsAre("[0, 2)"))
+
+	actual, err := readAll(&t.tf)
+	AssertEq(nil, err)
+	ExpectEq(initialContent[0:2]+"\x00\x00", string(actual))
+}
+
+////////////////////////////////////////////////////////////////////////
+// Memory temp files
+////////////////////////////////////////////////////////////////////////
+
+type MemoryTempFileTest struct {
+	clock timeutil.SimulatedClock
+
+	// The underlying temp file, for checking its name.
+	raw gcsx.TempFile
+	tf  checkingTempFile
+}
+
+func init() { RegisterTestSuite(&MemoryTempFileTest{}) }
+
+var _ SetUpInterface = &MemoryTempFileTest{}
+
+func (t *MemoryTempFileTest) SetUp(ti *TestInfo) {
+	var err error
+	t.clock.SetTime(time.Date(2012, 8, 15, 22, 56, 0, 0, time.Local))
+
+	// Leave room for a few more bytes in memory.
+	t.raw, err = gcsx.NewMemoryTempFile(
+		dummyReadCloser{strings.NewReader(initialContent)},
+		int64(initialContentSize)+4,
+		"",
+		&t.clock)
+
+	AssertEq(nil, err)
+	t.tf.wrapped = t.raw
+}
+
+func (t *MemoryTempFileTest) WithinLimit() {
+	_, err := t.tf.WriteAt([]byte("fo"), int64(initialContentSize)+2)
+	AssertEq(nil, err)
+
+	// The contents are still in memory.
+	ExpectEq("", t.raw.Name())
+
+	actual, err := readAll(&t.tf)
+	AssertEq(nil, err)
+	ExpectEq(initialContent+"\x00\x00fo", string(actual))
+
+	sr, err := t.tf.Stat()
+	AssertEq(nil, err)
+	ExpectEq(initialContentSize+4, sr.Size)
+	ExpectEq(initialContentSize, sr.DirtyThreshold)
+}
+
+func (t *MemoryTempFileTest) WriteBeyondLimit() {
+	_, err := t.tf.WriteAt([]byte("enchilada"), int64(initialContentSize))
+	AssertEq(nil, err)
+
+	// The contents have moved to disk, intact.
+	ExpectNe("", t.raw.Name())
+
+	actual, err := readAll(&t.tf)
+	AssertEq(nil, err)
+	ExpectEq(initialContent+"enchilada", string(actual))
+}
+
+func (t *MemoryTempFileTest) TruncateBeyondLimit() {
+	err := t.tf.Truncate(int64(initialContentSize) + 5)
+	AssertEq(nil, err)
+
+	ExpectNe("", t.raw.Name())
+
+	actual, err := readAll(&t.tf)
+	AssertEq(nil, err)
+	ExpectEq(initialContent+"\x00\x00\x00\x00\x00", string(actual))
+}
+
+func (t *MemoryTempFileTest) ShrinkThenGrow() {
+	err := t.tf.Truncate(2)
+	AssertEq(nil, err)
+
+	// The truncated bytes don't reappear.
+	err = t.tf.Truncate(4)
+	AssertEq(nil, err)
+
+	ExpectEq("", t.raw.Name())
+
+	actual, err := readAll(&t.tf)
+	AssertEq(nil, err)
+	ExpectEq(initialContent[0:2]+"\x00\x00", string(actual))
+}
@@ -0,0 +1,251 @@
+// Copyright 2022 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// ErrCallTimedOut is returned for calls to GCS abandoned after running past
+// their deadline. It wraps EIO, and counts as GCS being unavailable.
+var ErrCallTimedOut = fmt.Errorf("GCS call timed out: %w", syscall.EIO)
+
+// CallTimeouts limits how long calls to GCS may take. Zero means no limit.
+type CallTimeouts struct {
+	// For stating, listing, updating and deleting objects.
+	Metadata time.Duration
+
+	// For opening a reader, and then for each read from it. A download may take
+	// any time in total, so long as it keeps making progress.
+	Read time.Duration
+
+	// For creating, composing and copying objects, including sending the
+	// contents of new objects.
+	Upload time.Duration
+}
+
+// NewTimeoutBucket creates a wrapper bucket that gives each call a deadline
+// according to the supplied timeouts, so that a hung call to GCS can't block
+// its caller forever. Calls that time out fail with ErrCallTimedOut.
+func NewTimeoutBucket(timeouts CallTimeouts, b gcs.Bucket) gcs.Bucket {
+	return &timeoutBucket{
+		Bucket:   b,
+		timeouts: timeouts,
+	}
+}
+
+type timeoutBucket struct {
+	gcs.Bucket
+	timeouts CallTimeouts
+}
+
+// Derive the context for a call with the given timeout, if any.
+func withTimeout(
+	ctx context.Context,
+	timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// Return ErrCallTimedOut in place of an error caused by the call's own
+// deadline, as opposed to its caller's.
+func checkTimedOut(
+	ctx context.Context,
+	callCtx context.Context,
+	timeout time.Duration,
+	err error) error {
+	if err == nil || timeout <= 0 || ctx.Err() != nil {
+		return err
+	}
+
+	if callCtx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("%w after %v: %v", ErrCallTimedOut, timeout, err)
+	}
+
+	return err
+}
+
+func (b *timeoutBucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (rc io.ReadCloser, err error) {
+	if b.timeouts.Read <= 0 {
+		rc, err = b.Bucket.NewReader(ctx, req)
+		return
+	}
+
+	// The reader outlives this call, so rather than a deadline use a timer that
+	// is only running while we wait on GCS.
+	callCtx, cancel := context.WithCancel(ctx)
+	r := &timeoutReader{
+		ctx:     ctx,
+		timeout: b.timeouts.Read,
+		cancel:  cancel,
+	}
+
+	r.timer = time.AfterFunc(r.timeout, r.expire)
+	r.wrapped, err = b.Bucket.NewReader(callCtx, req)
+	err = r.stop(err)
+	if err != nil {
+		cancel()
+		return
+	}
+
+	rc = r
+	return
+}
+
+func (b *timeoutBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	callCtx, cancel := withTimeout(ctx, b.timeouts.Upload)
+	defer cancel()
+
+	o, err = b.Bucket.CreateObject(callCtx, req)
+	err = checkTimedOut(ctx, callCtx, b.timeouts.Upload, err)
+	return
+}
+
+func (b *timeoutBucket) CopyObject(
+	ctx context.Context,
+	req *gcs.CopyObjectRequest) (o *gcs.Object, err error) {
+	callCtx, cancel := withTimeout(ctx, b.timeouts.Upload)
+	defer cancel()
+
+	o, err = b.Bucket.CopyObject(callCtx, req)
+	err = checkTimedOut(ctx, callCtx, b.timeouts.Upload, err)
+	return
+}
+
+func (b *timeoutBucket) ComposeObjects(
+	ctx context.Context,
+	req *gcs.ComposeObjectsRequest) (o *gcs.Object, err error) {
+	callCtx, cancel := withTimeout(ctx, b.timeouts.Upload)
+	defer cancel()
+
+	o, err = b.Bucket.ComposeObjects(callCtx, req)
+	err = checkTimedOut(ctx, callCtx, b.timeouts.Upload, err)
+	return
+}
+
+func (b *timeoutBucket) StatObject(
+	ctx context.Context,
+	req *gcs.StatObjectRequest) (o *gcs.Object, err error) {
+	callCtx, cancel := withTimeout(ctx, b.timeouts.Metadata)
+	defer cancel()
+
+	o, err = b.Bucket.StatObject(callCtx, req)
+	err = checkTimedOut(ctx, callCtx, b.timeouts.Metadata, err)
+	return
+}
+
+func (b *timeoutBucket) ListObjects(
+	ctx context.Context,
+	req *gcs.ListObjectsRequest) (listing *gcs.Listing, err error) {
+	callCtx, cancel := withTimeout(ctx, b.timeouts.Metadata)
+	defer cancel()
+
+	listing, err = b.Bucket.ListObjects(callCtx, req)
+	err = checkTimedOut(ctx, callCtx, b.timeouts.Metadata, err)
+	return
+}
+
+func (b *timeoutBucket) UpdateObject(
+	ctx context.Context,
+	req *gcs.UpdateObjectRequest) (o *gcs.Object, err error) {
+	callCtx, cancel := withTimeout(ctx, b.timeouts.Metadata)
+	defer cancel()
+
+	o, err = b.Bucket.UpdateObject(callCtx, req)
+	err = checkTimedOut(ctx, callCtx, b.timeouts.Metadata, err)
+	return
+}
+
+func (b *timeoutBucket) DeleteObject(
+	ctx context.Context,
+	req *gcs.DeleteObjectRequest) (err error) {
+	callCtx, cancel := withTimeout(ctx, b.timeouts.Metadata)
+	defer cancel()
+
+	err = b.Bucket.DeleteObject(callCtx, req)
+	err = checkTimedOut(ctx, callCtx, b.timeouts.Metadata, err)
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Reader
+////////////////////////////////////////////////////////////////////////
+
+// A reader whose context is cancelled if a read takes longer than the
+// timeout. Once that happens, every later read fails too.
+type timeoutReader struct {
+	wrapped io.ReadCloser
+
+	// The caller's context, and a function cancelling the one derived from it
+	// for the read.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	timeout time.Duration
+
+	// Runs expire once the timeout has passed. Only running while we wait on
+	// GCS.
+	timer *time.Timer
+
+	// Set to 1 by expire.
+	expired int32
+}
+
+func (r *timeoutReader) expire() {
+	atomic.StoreInt32(&r.expired, 1)
+	r.cancel()
+}
+
+// Stop the timer after waiting on GCS, returning ErrCallTimedOut in place of
+// err if it fired.
+func (r *timeoutReader) stop(err error) error {
+	r.timer.Stop()
+	if err == nil ||
+		err == io.EOF ||
+		r.ctx.Err() != nil ||
+		atomic.LoadInt32(&r.expired) == 0 {
+		return err
+	}
+
+	return fmt.Errorf("%w after %v: %v", ErrCallTimedOut, r.timeout, err)
+}
+
+func (r *timeoutReader) Read(p []byte) (n int, err error) {
+	r.timer.Reset(r.timeout)
+	n, err = r.wrapped.Read(p)
+	err = r.stop(err)
+	return
+}
+
+func (r *timeoutReader) Close() (err error) {
+	r.timer.Stop()
+	err = r.wrapped.Close()
+	r.cancel()
+	return
+}
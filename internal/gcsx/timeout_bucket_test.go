@@ -0,0 +1,188 @@
+// Copyright 2022 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx_test
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+func TestTimeoutBucket(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+// A bucket whose StatObject hangs until its context is done, if hang is set,
+// and whose readers return a byte at a time every readDelay.
+type slowBucket struct {
+	gcs.Bucket
+	hang      bool
+	readDelay time.Duration
+}
+
+func (b *slowBucket) StatObject(
+	ctx context.Context,
+	req *gcs.StatObjectRequest) (*gcs.Object, error) {
+	if b.hang {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	return b.Bucket.StatObject(ctx, req)
+}
+
+func (b *slowBucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (io.ReadCloser, error) {
+	return &slowReader{ctx: ctx, delay: b.readDelay, remaining: 10}, nil
+}
+
+type slowReader struct {
+	ctx       context.Context
+	delay     time.Duration
+	remaining int
+}
+
+func (r *slowReader) Read(p []byte) (n int, err error) {
+	if r.remaining == 0 {
+		err = io.EOF
+		return
+	}
+
+	select {
+	case <-r.ctx.Done():
+		err = r.ctx.Err()
+
+	case <-time.After(r.delay):
+		p[0] = 'x'
+		n = 1
+		r.remaining--
+	}
+
+	return
+}
+
+func (r *slowReader) Close() error {
+	return nil
+}
+
+const callTimeout = 50 * time.Millisecond
+
+type TimeoutBucketTest struct {
+	ctx     context.Context
+	wrapped *slowBucket
+	bucket  gcs.Bucket
+}
+
+func init() { RegisterTestSuite(&TimeoutBucketTest{}) }
+
+func (t *TimeoutBucketTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.wrapped = &slowBucket{
+		Bucket: gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket"),
+	}
+
+	t.bucket = gcsx.NewTimeoutBucket(
+		gcsx.CallTimeouts{
+			Metadata: callTimeout,
+			Read:     callTimeout,
+		},
+		t.wrapped)
+}
+
+func (t *TimeoutBucketTest) read() (contents []byte, err error) {
+	rc, err := t.bucket.NewReader(t.ctx, &gcs.ReadObjectRequest{Name: "foo"})
+	if err != nil {
+		return
+	}
+
+	defer rc.Close()
+	contents, err = ioutil.ReadAll(rc)
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *TimeoutBucketTest) FastCall() {
+	_, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+
+	var notFoundErr *gcs.NotFoundError
+	ExpectTrue(errors.As(err, &notFoundErr))
+}
+
+func (t *TimeoutBucketTest) HungCall() {
+	t.wrapped.hang = true
+	_, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+
+	ExpectTrue(errors.Is(err, gcsx.ErrCallTimedOut))
+	ExpectTrue(gcsx.IsUnavailable(err))
+}
+
+func (t *TimeoutBucketTest) CancelledByCaller() {
+	t.wrapped.hang = true
+	ctx, cancel := context.WithCancel(t.ctx)
+	cancel()
+
+	_, err := t.bucket.StatObject(ctx, &gcs.StatObjectRequest{Name: "foo"})
+
+	ExpectTrue(errors.Is(err, context.Canceled))
+	ExpectFalse(errors.Is(err, gcsx.ErrCallTimedOut))
+}
+
+func (t *TimeoutBucketTest) NoTimeoutForKind() {
+	t.wrapped.hang = true
+	t.bucket = gcsx.NewTimeoutBucket(
+		gcsx.CallTimeouts{Read: callTimeout},
+		t.wrapped)
+
+	ctx, cancel := context.WithTimeout(t.ctx, 2*callTimeout)
+	defer cancel()
+
+	// Only the caller's deadline applies.
+	_, err := t.bucket.StatObject(ctx, &gcs.StatObjectRequest{Name: "foo"})
+
+	ExpectTrue(errors.Is(err, context.DeadlineExceeded))
+	ExpectFalse(errors.Is(err, gcsx.ErrCallTimedOut))
+}
+
+func (t *TimeoutBucketTest) SteadyRead() {
+	// The whole read takes longer than the timeout, but each byte arrives well
+	// within it.
+	t.wrapped.readDelay = callTimeout / 5
+	contents, err := t.read()
+
+	AssertEq(nil, err)
+	ExpectEq("xxxxxxxxxx", string(contents))
+}
+
+func (t *TimeoutBucketTest) StalledRead() {
+	t.wrapped.readDelay = time.Hour
+	_, err := t.read()
+
+	ExpectTrue(errors.Is(err, gcsx.ErrCallTimedOut))
+}
@@ -0,0 +1,60 @@
+// Copyright 2022 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/storage"
+	"google.golang.org/api/googleapi"
+)
+
+// IsUnavailable reports whether err indicates that GCS could not be reached
+// or is temporarily unable to serve requests, as opposed to having rejected
+// the request itself.
+func IsUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, storage.ErrRetryBudgetExhausted) ||
+		errors.Is(err, ErrCircuitOpen) ||
+		errors.Is(err, ErrCallTimedOut) {
+		return true
+	}
+
+	// Failures to connect, resolve or read from the network.
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return true
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+
+	// Rate limiting and server errors.
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == http.StatusTooManyRequests ||
+			(apiErr.Code >= 500 && apiErr.Code < 600)
+	}
+
+	return false
+}
@@ -0,0 +1,56 @@
+// Copyright 2022 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx_test
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/googlecloudplatform/gcsfuse/internal/storage"
+	"github.com/jacobsa/gcloud/gcs"
+	. "github.com/jacobsa/ogletest"
+	"google.golang.org/api/googleapi"
+)
+
+func TestUnavailable(t *testing.T) { RunTests(t) }
+
+type UnavailableTest struct {
+}
+
+func init() { RegisterTestSuite(&UnavailableTest{}) }
+
+func (t *UnavailableTest) Nil() {
+	ExpectFalse(gcsx.IsUnavailable(nil))
+}
+
+func (t *UnavailableTest) NetworkErrors() {
+	err := &url.Error{Op: "Get", URL: "https://x", Err: errors.New("no route")}
+	ExpectTrue(gcsx.IsUnavailable(fmt.Errorf("StatObject: %w", err)))
+	ExpectTrue(gcsx.IsUnavailable(storage.ErrRetryBudgetExhausted))
+}
+
+func (t *UnavailableTest) ServerErrors() {
+	ExpectTrue(gcsx.IsUnavailable(&googleapi.Error{Code: 503}))
+	ExpectTrue(gcsx.IsUnavailable(&googleapi.Error{Code: 429}))
+}
+
+func (t *UnavailableTest) RejectedRequests() {
+	ExpectFalse(gcsx.IsUnavailable(&googleapi.Error{Code: 403}))
+	ExpectFalse(gcsx.IsUnavailable(&gcs.PreconditionError{}))
+	ExpectFalse(gcsx.IsUnavailable(errors.New("taco")))
+}
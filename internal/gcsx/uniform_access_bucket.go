@@ -0,0 +1,73 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// NewUniformAccessBucket creates a wrapper for a bucket with uniform
+// bucket-level access, whose objects have no ACLs of their own. GCS rejects
+// requests that carry object ACLs for such buckets, so the wrapper drops them
+// from created and composed objects, and asks for listings without them.
+//
+// The storage client library's bucket handle does this itself; this is for
+// buckets opened without it.
+func NewUniformAccessBucket(b gcs.Bucket) gcs.Bucket {
+	return uniformAccessBucket{b}
+}
+
+type uniformAccessBucket struct {
+	gcs.Bucket
+}
+
+func (b uniformAccessBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	// Leave the caller's request alone.
+	if req.Acl != nil {
+		withoutAcl := *req
+		withoutAcl.Acl = nil
+		req = &withoutAcl
+	}
+
+	o, err = b.Bucket.CreateObject(ctx, req)
+	return
+}
+
+func (b uniformAccessBucket) ComposeObjects(
+	ctx context.Context,
+	req *gcs.ComposeObjectsRequest) (o *gcs.Object, err error) {
+	// Leave the caller's request alone.
+	if req.Acl != nil {
+		withoutAcl := *req
+		withoutAcl.Acl = nil
+		req = &withoutAcl
+	}
+
+	o, err = b.Bucket.ComposeObjects(ctx, req)
+	return
+}
+
+func (b uniformAccessBucket) ListObjects(
+	ctx context.Context,
+	req *gcs.ListObjectsRequest) (listing *gcs.Listing, err error) {
+	withoutAcl := *req
+	withoutAcl.ProjectionVal = gcs.NoAcl
+
+	listing, err = b.Bucket.ListObjects(ctx, &withoutAcl)
+	return
+}
@@ -0,0 +1,144 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+	storagev1 "google.golang.org/api/storage/v1"
+)
+
+func TestUniformAccessBucket(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+// A bucket that records the requests passed through to it.
+type aclRecordingBucket struct {
+	gcs.Bucket
+	creates  []*gcs.CreateObjectRequest
+	composes []*gcs.ComposeObjectsRequest
+	lists    []*gcs.ListObjectsRequest
+}
+
+func (b *aclRecordingBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (*gcs.Object, error) {
+	b.creates = append(b.creates, req)
+	return b.Bucket.CreateObject(ctx, req)
+}
+
+func (b *aclRecordingBucket) ComposeObjects(
+	ctx context.Context,
+	req *gcs.ComposeObjectsRequest) (*gcs.Object, error) {
+	b.composes = append(b.composes, req)
+	return b.Bucket.ComposeObjects(ctx, req)
+}
+
+func (b *aclRecordingBucket) ListObjects(
+	ctx context.Context,
+	req *gcs.ListObjectsRequest) (*gcs.Listing, error) {
+	b.lists = append(b.lists, req)
+	return b.Bucket.ListObjects(ctx, req)
+}
+
+type UniformAccessBucketTest struct {
+	ctx     context.Context
+	wrapped *aclRecordingBucket
+	bucket  gcs.Bucket
+	acl     []*storagev1.ObjectAccessControl
+}
+
+var _ SetUpInterface = &UniformAccessBucketTest{}
+
+func init() { RegisterTestSuite(&UniformAccessBucketTest{}) }
+
+func (t *UniformAccessBucketTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.wrapped = &aclRecordingBucket{
+		Bucket: gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket"),
+	}
+	t.bucket = gcsx.NewUniformAccessBucket(t.wrapped)
+	t.acl = []*storagev1.ObjectAccessControl{{Entity: "allUsers", Role: "READER"}}
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *UniformAccessBucketTest) CreateObject() {
+	req := &gcs.CreateObjectRequest{
+		Name:     "foo",
+		Contents: strings.NewReader("taco"),
+		Acl:      t.acl,
+	}
+
+	_, err := t.bucket.CreateObject(t.ctx, req)
+	AssertEq(nil, err)
+
+	// The ACL should not have been sent, and the caller's request should be
+	// untouched.
+	AssertEq(1, len(t.wrapped.creates))
+	ExpectEq(nil, t.wrapped.creates[0].Acl)
+	ExpectEq("foo", t.wrapped.creates[0].Name)
+	ExpectEq(1, len(req.Acl))
+}
+
+func (t *UniformAccessBucketTest) ComposeObjects() {
+	_, err := t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:     "foo",
+			Contents: strings.NewReader("taco"),
+		})
+	AssertEq(nil, err)
+
+	req := &gcs.ComposeObjectsRequest{
+		DstName: "bar",
+		Sources: []gcs.ComposeSource{{Name: "foo"}},
+		Acl:     t.acl,
+	}
+
+	_, err = t.bucket.ComposeObjects(t.ctx, req)
+	AssertEq(nil, err)
+
+	AssertEq(1, len(t.wrapped.composes))
+	ExpectEq(nil, t.wrapped.composes[0].Acl)
+	ExpectEq("bar", t.wrapped.composes[0].DstName)
+	ExpectEq(1, len(req.Acl))
+}
+
+func (t *UniformAccessBucketTest) ListObjects() {
+	req := &gcs.ListObjectsRequest{
+		Prefix:        "foo",
+		ProjectionVal: gcs.Full,
+	}
+
+	_, err := t.bucket.ListObjects(t.ctx, req)
+	AssertEq(nil, err)
+
+	AssertEq(1, len(t.wrapped.lists))
+	ExpectEq(gcs.NoAcl, t.wrapped.lists[0].ProjectionVal)
+	ExpectEq("foo", t.wrapped.lists[0].Prefix)
+	ExpectEq(gcs.Full, req.ProjectionVal)
+}
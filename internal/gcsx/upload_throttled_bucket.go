@@ -0,0 +1,41 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/ratelimit"
+	"golang.org/x/net/context"
+)
+
+// NewUploadThrottledBucket creates a wrapper bucket that limits the rate at
+// which the contents of new objects are uploaded to the given throttle.
+// Copies and compositions happen within GCS, and are not limited.
+func NewUploadThrottledBucket(throttle ratelimit.Throttle, b gcs.Bucket) gcs.Bucket {
+	return uploadThrottledBucket{b, throttle}
+}
+
+type uploadThrottledBucket struct {
+	gcs.Bucket
+	throttle ratelimit.Throttle
+}
+
+func (b uploadThrottledBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	req.Contents = ratelimit.ThrottledReader(ctx, req.Contents, b.throttle)
+	o, err = b.Bucket.CreateObject(ctx, req)
+	return
+}
@@ -0,0 +1,82 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+// A throttle that lets everything through, counting the tokens asked for.
+type countingThrottle struct {
+	tokens uint64
+}
+
+func (t *countingThrottle) Capacity() uint64 {
+	return 1 << 20
+}
+
+func (t *countingThrottle) Wait(ctx context.Context, tokens uint64) error {
+	t.tokens += tokens
+	return nil
+}
+
+func TestUploadThrottledBucket(t *testing.T) {
+	ctx := context.Background()
+	throttle := &countingThrottle{}
+	bucket := gcsx.NewUploadThrottledBucket(
+		throttle,
+		gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket"))
+
+	// Uploads are charged for their contents. The throttled reader charges for
+	// each read before making it, so may ask for more than it gets.
+	const contents = "taco burrito enchilada"
+	_, err := bucket.CreateObject(ctx, &gcs.CreateObjectRequest{
+		Name:     "foo",
+		Contents: strings.NewReader(contents),
+	})
+	if err != nil {
+		t.Fatalf("CreateObject: %v", err)
+	}
+
+	uploaded := throttle.tokens
+	if uploaded < uint64(len(contents)) {
+		t.Errorf("Tokens after upload: got %d, want at least %d", uploaded, len(contents))
+	}
+
+	// Reads and copies are not.
+	if _, err = gcsutil.ReadObject(ctx, bucket, "foo"); err != nil {
+		t.Fatalf("ReadObject: %v", err)
+	}
+
+	_, err = bucket.CopyObject(ctx, &gcs.CopyObjectRequest{
+		SrcName: "foo",
+		DstName: "bar",
+	})
+	if err != nil {
+		t.Fatalf("CopyObject: %v", err)
+	}
+
+	if throttle.tokens != uploaded {
+		t.Errorf("Tokens after read and copy: got %d, want %d", throttle.tokens, uploaded)
+	}
+}
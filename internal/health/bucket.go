@@ -0,0 +1,104 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package health
+
+import (
+	"io"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// NewBucket returns a gcs.Bucket that reports the outcome of each call to the
+// checker.
+func NewBucket(c *Checker, b gcs.Bucket) gcs.Bucket {
+	return &healthBucket{
+		checker: c,
+		wrapped: b,
+	}
+}
+
+type healthBucket struct {
+	checker *Checker
+	wrapped gcs.Bucket
+}
+
+func (hb *healthBucket) Name() string {
+	return hb.wrapped.Name()
+}
+
+func (hb *healthBucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (io.ReadCloser, error) {
+	rc, err := hb.wrapped.NewReader(ctx, req)
+	hb.checker.RecordGCSResult(err)
+	return rc, err
+}
+
+func (hb *healthBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (*gcs.Object, error) {
+	o, err := hb.wrapped.CreateObject(ctx, req)
+	hb.checker.RecordGCSResult(err)
+	return o, err
+}
+
+func (hb *healthBucket) CopyObject(
+	ctx context.Context,
+	req *gcs.CopyObjectRequest) (*gcs.Object, error) {
+	o, err := hb.wrapped.CopyObject(ctx, req)
+	hb.checker.RecordGCSResult(err)
+	return o, err
+}
+
+func (hb *healthBucket) ComposeObjects(
+	ctx context.Context,
+	req *gcs.ComposeObjectsRequest) (*gcs.Object, error) {
+	o, err := hb.wrapped.ComposeObjects(ctx, req)
+	hb.checker.RecordGCSResult(err)
+	return o, err
+}
+
+func (hb *healthBucket) StatObject(
+	ctx context.Context,
+	req *gcs.StatObjectRequest) (*gcs.Object, error) {
+	o, err := hb.wrapped.StatObject(ctx, req)
+	hb.checker.RecordGCSResult(err)
+	return o, err
+}
+
+func (hb *healthBucket) ListObjects(
+	ctx context.Context,
+	req *gcs.ListObjectsRequest) (*gcs.Listing, error) {
+	listing, err := hb.wrapped.ListObjects(ctx, req)
+	hb.checker.RecordGCSResult(err)
+	return listing, err
+}
+
+func (hb *healthBucket) UpdateObject(
+	ctx context.Context,
+	req *gcs.UpdateObjectRequest) (*gcs.Object, error) {
+	o, err := hb.wrapped.UpdateObject(ctx, req)
+	hb.checker.RecordGCSResult(err)
+	return o, err
+}
+
+func (hb *healthBucket) DeleteObject(
+	ctx context.Context,
+	req *gcs.DeleteObjectRequest) error {
+	err := hb.wrapped.DeleteObject(ctx, req)
+	hb.checker.RecordGCSResult(err)
+	return err
+}
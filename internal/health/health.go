@@ -0,0 +1,264 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package health serves readiness and liveness probes for a mount, so that an
+// orchestrator such as Kubernetes can restart a mount that has wedged.
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+)
+
+// How long calls to GCS may go on failing before the mount is reported as not
+// live.
+const gcsFailureWindow = 5 * time.Minute
+
+// How long a stat of the mount point may take before the FUSE loop is
+// considered unresponsive.
+const fuseProbeTimeout = 10 * time.Second
+
+// Checker follows the state of a mount and answers whether it is ready and
+// live. Safe for concurrent access.
+type Checker struct {
+	/////////////////////////
+	// Dependencies
+	/////////////////////////
+
+	clock timeutil.Clock
+	stat  func(name string) (os.FileInfo, error)
+
+	/////////////////////////
+	// Mutable state
+	/////////////////////////
+
+	mu sync.Mutex
+
+	// The mount points, or empty if the file systems have not been mounted
+	// yet.
+	//
+	// GUARDED_BY(mu)
+	mountPoints []string
+
+	// The time of the first failed call to GCS since the last successful one,
+	// or zero if the last call succeeded.
+	//
+	// GUARDED_BY(mu)
+	failingSince time.Time
+
+	// The most recent failure to authenticate to GCS, cleared by any
+	// successful call.
+	//
+	// GUARDED_BY(mu)
+	authErr error
+
+	// Set while a stat of the mount points is outstanding, so that a wedged
+	// mount does not pile up probes.
+	//
+	// GUARDED_BY(mu)
+	probing bool
+}
+
+// NewChecker creates a checker for a mount that is not yet established.
+func NewChecker(clock timeutil.Clock) *Checker {
+	return &Checker{
+		clock: clock,
+		stat:  os.Stat,
+	}
+}
+
+// SetMounted records that the file systems have been mounted at the given
+// points.
+func (c *Checker) SetMounted(mountPoints ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.mountPoints = mountPoints
+}
+
+// RecordGCSResult records the outcome of a call to GCS. Errors that GCS
+// itself returned for the request, such as a missing object or a failed
+// precondition, show that GCS is reachable and count as success.
+func (c *Checker) RecordGCSResult(err error) {
+	var notFoundErr *gcs.NotFoundError
+	var preconditionErr *gcs.PreconditionError
+	switch {
+	case errors.Is(err, context.Canceled):
+		// Says nothing about GCS.
+		return
+
+	case err == nil,
+		errors.As(err, &notFoundErr),
+		errors.As(err, &preconditionErr):
+		c.mu.Lock()
+		c.failingSince = time.Time{}
+		c.authErr = nil
+		c.mu.Unlock()
+
+	default:
+		c.mu.Lock()
+		if c.failingSince.IsZero() {
+			c.failingSince = c.clock.Now()
+		}
+		if isAuthError(err) {
+			c.authErr = err
+		}
+		c.mu.Unlock()
+	}
+}
+
+// isAuthError reports whether the error shows that our credentials were not
+// accepted.
+func isAuthError(err error) bool {
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) {
+		return true
+	}
+
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusUnauthorized
+}
+
+// Ready returns nil if the file systems are mounted and GCS has not rejected
+// our credentials since the last successful call.
+func (c *Checker) Ready() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.mountPoints) == 0 {
+		return errors.New("not mounted")
+	}
+
+	if c.authErr != nil {
+		return fmt.Errorf("credentials rejected: %w", c.authErr)
+	}
+
+	return nil
+}
+
+// Live returns nil unless calls to GCS have been failing for longer than
+// gcsFailureWindow, or a mount point does not answer a stat within
+// fuseProbeTimeout.
+func (c *Checker) Live() error {
+	c.mu.Lock()
+	mountPoints := c.mountPoints
+	failingSince := c.failingSince
+	c.mu.Unlock()
+
+	if !failingSince.IsZero() {
+		if d := c.clock.Now().Sub(failingSince); d > gcsFailureWindow {
+			return fmt.Errorf("calls to GCS have been failing for %v", d)
+		}
+	}
+
+	// Until the file systems are mounted there is no FUSE loop to probe.
+	if len(mountPoints) == 0 {
+		return nil
+	}
+
+	return c.probeFUSE(mountPoints)
+}
+
+// probeFUSE stats each mount point, which the kernel passes on to the FUSE
+// loop serving it.
+//
+// LOCKS_EXCLUDED(c.mu)
+func (c *Checker) probeFUSE(mountPoints []string) error {
+	c.mu.Lock()
+	if c.probing {
+		c.mu.Unlock()
+		return errors.New("an earlier stat of the mount points has not returned")
+	}
+	c.probing = true
+	c.mu.Unlock()
+
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		for _, mountPoint := range mountPoints {
+			if _, err = c.stat(mountPoint); err != nil {
+				err = fmt.Errorf("stat %q: %w", mountPoint, err)
+				break
+			}
+		}
+
+		c.mu.Lock()
+		c.probing = false
+		c.mu.Unlock()
+
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return err
+
+	case <-time.After(fuseProbeTimeout):
+		return fmt.Errorf("stat of the mount points did not return within %v", fuseProbeTimeout)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////
+// HTTP
+////////////////////////////////////////////////////////////////////////
+
+// Handler returns a handler serving the readiness probe at /readyz and the
+// liveness probe at /livez. Each answers 200 when healthy, and otherwise 503
+// with the reason in the body.
+func (c *Checker) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		writeProbe(w, c.Ready())
+	})
+	mux.HandleFunc("/livez", func(w http.ResponseWriter, r *http.Request) {
+		writeProbe(w, c.Live())
+	})
+	return mux
+}
+
+func writeProbe(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, err)
+		return
+	}
+
+	fmt.Fprintln(w, "ok")
+}
+
+// Serve listens on the given address and serves the checker's probes in the
+// background until the returned server is closed.
+func Serve(addr string, c *Checker) (srv *http.Server, err error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		err = fmt.Errorf("Listen: %w", err)
+		return
+	}
+
+	srv = &http.Server{Handler: c.Handler()}
+	go srv.Serve(l)
+	return
+}
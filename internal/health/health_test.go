@@ -0,0 +1,120 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package health
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jacobsa/gcloud/gcs"
+	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+	"google.golang.org/api/googleapi"
+)
+
+func TestHealth(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type CheckerTest struct {
+	clock   timeutil.SimulatedClock
+	statErr error
+	checker *Checker
+}
+
+func init() { RegisterTestSuite(&CheckerTest{}) }
+
+func (t *CheckerTest) SetUp(ti *TestInfo) {
+	t.clock.SetTime(time.Date(2015, 4, 5, 2, 15, 0, 0, time.Local))
+	t.checker = NewChecker(&t.clock)
+	t.checker.stat = func(name string) (os.FileInfo, error) {
+		return nil, t.statErr
+	}
+}
+
+func (t *CheckerTest) get(path string) (code int) {
+	w := httptest.NewRecorder()
+	t.checker.Handler().ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	code = w.Code
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *CheckerTest) NotReadyUntilMounted() {
+	ExpectNe(nil, t.checker.Ready())
+	ExpectEq(http.StatusServiceUnavailable, t.get("/readyz"))
+
+	t.checker.SetMounted("/mnt")
+	ExpectEq(nil, t.checker.Ready())
+	ExpectEq(http.StatusOK, t.get("/readyz"))
+}
+
+func (t *CheckerTest) NotReadyAfterCredentialsRejected() {
+	t.checker.SetMounted("/mnt")
+
+	t.checker.RecordGCSResult(fmt.Errorf("StatObject: %w", &googleapi.Error{Code: http.StatusUnauthorized}))
+	ExpectNe(nil, t.checker.Ready())
+
+	// Other failures leave the verdict alone.
+	t.checker.RecordGCSResult(&googleapi.Error{Code: http.StatusServiceUnavailable})
+	ExpectNe(nil, t.checker.Ready())
+
+	// A successful call clears it.
+	t.checker.RecordGCSResult(nil)
+	ExpectEq(nil, t.checker.Ready())
+}
+
+func (t *CheckerTest) LiveWhileGCSFailuresAreRecent() {
+	t.checker.RecordGCSResult(errors.New("taco"))
+	t.clock.AdvanceTime(gcsFailureWindow)
+	ExpectEq(nil, t.checker.Live())
+
+	t.checker.RecordGCSResult(errors.New("burrito"))
+	t.clock.AdvanceTime(time.Second)
+	ExpectNe(nil, t.checker.Live())
+	ExpectEq(http.StatusServiceUnavailable, t.get("/livez"))
+}
+
+func (t *CheckerTest) GCSErrorsForTheRequestCountAsSuccess() {
+	t.checker.RecordGCSResult(errors.New("taco"))
+	t.checker.RecordGCSResult(&gcs.NotFoundError{})
+	t.clock.AdvanceTime(2 * gcsFailureWindow)
+	ExpectEq(nil, t.checker.Live())
+
+	t.checker.RecordGCSResult(errors.New("taco"))
+	t.checker.RecordGCSResult(fmt.Errorf("DeleteObject: %w", &gcs.PreconditionError{}))
+	t.clock.AdvanceTime(2 * gcsFailureWindow)
+	ExpectEq(nil, t.checker.Live())
+}
+
+func (t *CheckerTest) NotLiveWhenMountPointFailsStat() {
+	t.checker.SetMounted("/mnt")
+	ExpectEq(nil, t.checker.Live())
+	ExpectEq(http.StatusOK, t.get("/livez"))
+
+	t.statErr = errors.New("transport endpoint is not connected")
+	ExpectNe(nil, t.checker.Live())
+	ExpectEq(http.StatusServiceUnavailable, t.get("/livez"))
+}
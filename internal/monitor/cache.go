@@ -0,0 +1,245 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/monitor/tags"
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcscaching"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+// The caches whose effectiveness is measured.
+const (
+	StatCache    = "stat"
+	TypeCache    = "type"
+	ListingCache = "listing"
+	FileCache    = "file"
+)
+
+var (
+	// OpenCensus measures
+	cacheHitCount      = stats.Int64("cache/hit_count", "The number of lookups answered by a cache.", stats.UnitDimensionless)
+	cacheMissCount     = stats.Int64("cache/miss_count", "The number of lookups a cache could not answer.", stats.UnitDimensionless)
+	cacheEvictionCount = stats.Int64("cache/eviction_count", "The number of entries evicted from a cache to make room.", stats.UnitDimensionless)
+
+	// In-process totals, for callers that want a snapshot without going
+	// through an exporter. The set of keys is fixed at init.
+	cacheCounts = map[string]*CacheCounts{
+		StatCache:    {},
+		TypeCache:    {},
+		ListingCache: {},
+		FileCache:    {},
+	}
+)
+
+// Initialize the metrics.
+func init() {
+	// OpenCensus views (aggregated measures)
+	if err := view.Register(
+		&view.View{
+			Name:        "cache/hit_count",
+			Measure:     cacheHitCount,
+			Description: "The cumulative number of lookups answered by a cache.",
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{tags.Cache},
+		},
+		&view.View{
+			Name:        "cache/miss_count",
+			Measure:     cacheMissCount,
+			Description: "The cumulative number of lookups a cache could not answer.",
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{tags.Cache},
+		},
+		&view.View{
+			Name:        "cache/eviction_count",
+			Measure:     cacheEvictionCount,
+			Description: "The cumulative number of entries evicted from a cache to make room.",
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{tags.Cache},
+		}); err != nil {
+		fmt.Printf("Failed to register OpenCensus metrics for caches: %v", err)
+	}
+}
+
+// CacheCounts holds the totals of events seen by one cache.
+type CacheCounts struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+}
+
+// CacheStats returns a snapshot of the totals for each cache.
+func CacheStats() (stats map[string]CacheCounts) {
+	stats = make(map[string]CacheCounts)
+	for cache, c := range cacheCounts {
+		stats[cache] = CacheCounts{
+			Hits:      atomic.LoadUint64(&c.Hits),
+			Misses:    atomic.LoadUint64(&c.Misses),
+			Evictions: atomic.LoadUint64(&c.Evictions),
+		}
+	}
+	return
+}
+
+// RecordCacheHit records a lookup answered by the given cache.
+func RecordCacheHit(cache string) {
+	atomic.AddUint64(&cacheCounts[cache].Hits, 1)
+	recordCacheEvent(cache, cacheHitCount)
+}
+
+// RecordCacheMiss records a lookup the given cache could not answer, either
+// because it held nothing for the key or because what it held had expired.
+func RecordCacheMiss(cache string) {
+	atomic.AddUint64(&cacheCounts[cache].Misses, 1)
+	recordCacheEvent(cache, cacheMissCount)
+}
+
+// RecordCacheEviction records an entry evicted from the given cache to make
+// room for another.
+func RecordCacheEviction(cache string) {
+	atomic.AddUint64(&cacheCounts[cache].Evictions, 1)
+	recordCacheEvent(cache, cacheEvictionCount)
+}
+
+func recordCacheEvent(cache string, m *stats.Int64Measure) {
+	if err := stats.RecordWithTags(
+		context.Background(),
+		[]tag.Mutator{
+			tag.Upsert(tags.Cache, cache),
+		},
+		m.M(1),
+	); err != nil {
+		errorLogger.Printf("Cannot record %v for the %v cache: %v", m.Name(), cache, err)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////
+// Eviction tracking
+////////////////////////////////////////////////////////////////////////
+
+// EvictionCounter follows the occupancy of a fixed capacity LRU cache that
+// doesn't report its evictions, and records an eviction whenever a new key is
+// inserted while the cache is full.
+//
+// External synchronization is required.
+type EvictionCounter struct {
+	cache    string
+	capacity int
+
+	// INVARIANT: 0 <= size <= capacity
+	size int
+}
+
+// NewEvictionCounter creates a counter for an empty cache holding at most
+// capacity entries.
+func NewEvictionCounter(cache string, capacity int) *EvictionCounter {
+	return &EvictionCounter{
+		cache:    cache,
+		capacity: capacity,
+	}
+}
+
+// Inserted records that a key was inserted, and whether it was already
+// present in the cache.
+func (ec *EvictionCounter) Inserted(present bool) {
+	if present {
+		return
+	}
+
+	if ec.size < ec.capacity {
+		ec.size++
+		return
+	}
+
+	RecordCacheEviction(ec.cache)
+}
+
+// Erased records that a key was erased, and whether it was present in the
+// cache.
+func (ec *EvictionCounter) Erased(present bool) {
+	if present && ec.size > 0 {
+		ec.size--
+	}
+}
+
+////////////////////////////////////////////////////////////////////////
+// Stat cache
+////////////////////////////////////////////////////////////////////////
+
+// NewStatCache creates a stat cache like gcscaching.NewStatCache, recording
+// its hits, misses and evictions.
+func NewStatCache(capacity int) gcscaching.StatCache {
+	return &monitoringStatCache{
+		wrapped:   gcscaching.NewStatCache(capacity),
+		evictions: NewEvictionCounter(StatCache, capacity),
+	}
+}
+
+// monitoringStatCache relies on the synchronization its user provides for the
+// wrapped cache.
+type monitoringStatCache struct {
+	wrapped   gcscaching.StatCache
+	evictions *EvictionCounter
+}
+
+// contains reports whether the wrapped cache holds an entry for the name,
+// whether or not it has expired.
+func (sc *monitoringStatCache) contains(name string) bool {
+	hit, _ := sc.wrapped.LookUp(name, time.Time{})
+	return hit
+}
+
+func (sc *monitoringStatCache) Insert(o *gcs.Object, expiration time.Time) {
+	present := sc.contains(o.Name)
+	sc.wrapped.Insert(o, expiration)
+	sc.evictions.Inserted(present)
+}
+
+func (sc *monitoringStatCache) AddNegativeEntry(name string, expiration time.Time) {
+	present := sc.contains(name)
+	sc.wrapped.AddNegativeEntry(name, expiration)
+	sc.evictions.Inserted(present)
+}
+
+func (sc *monitoringStatCache) Erase(name string) {
+	sc.evictions.Erased(sc.contains(name))
+	sc.wrapped.Erase(name)
+}
+
+func (sc *monitoringStatCache) LookUp(name string, now time.Time) (hit bool, o *gcs.Object) {
+	present := sc.contains(name)
+	hit, o = sc.wrapped.LookUp(name, now)
+	if hit {
+		RecordCacheHit(StatCache)
+		return
+	}
+
+	// An expired entry is erased by the lookup.
+	sc.evictions.Erased(present)
+	RecordCacheMiss(StatCache)
+	return
+}
+
+func (sc *monitoringStatCache) CheckInvariants() {
+	sc.wrapped.CheckInvariants()
+}
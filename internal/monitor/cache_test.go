@@ -0,0 +1,107 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package monitor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcscaching"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestCache(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type StatCacheTest struct {
+	cache  gcscaching.StatCache
+	before CacheCounts
+	now    time.Time
+}
+
+func init() { RegisterTestSuite(&StatCacheTest{}) }
+
+func (t *StatCacheTest) SetUp(ti *TestInfo) {
+	t.cache = NewStatCache(2)
+	t.before = CacheStats()[StatCache]
+	t.now = time.Date(2015, 4, 5, 2, 15, 0, 0, time.Local)
+}
+
+// counts returns the events recorded for the stat cache since SetUp.
+func (t *StatCacheTest) counts() CacheCounts {
+	after := CacheStats()[StatCache]
+	return CacheCounts{
+		Hits:      after.Hits - t.before.Hits,
+		Misses:    after.Misses - t.before.Misses,
+		Evictions: after.Evictions - t.before.Evictions,
+	}
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *StatCacheTest) HitsAndMisses() {
+	t.cache.Insert(&gcs.Object{Name: "foo"}, t.now.Add(time.Second))
+	t.cache.AddNegativeEntry("bar", t.now.Add(time.Second))
+
+	hit, _ := t.cache.LookUp("foo", t.now)
+	ExpectTrue(hit)
+	hit, _ = t.cache.LookUp("bar", t.now)
+	ExpectTrue(hit)
+	hit, _ = t.cache.LookUp("baz", t.now)
+	ExpectFalse(hit)
+
+	// An expired entry is a miss.
+	hit, _ = t.cache.LookUp("foo", t.now.Add(time.Minute))
+	ExpectFalse(hit)
+
+	counts := t.counts()
+	ExpectEq(2, counts.Hits)
+	ExpectEq(2, counts.Misses)
+	ExpectEq(0, counts.Evictions)
+}
+
+func (t *StatCacheTest) Evictions() {
+	expiration := t.now.Add(time.Second)
+
+	// Filling the cache, and replacing entries, evicts nothing.
+	t.cache.Insert(&gcs.Object{Name: "foo"}, expiration)
+	t.cache.Insert(&gcs.Object{Name: "bar"}, expiration)
+	t.cache.Insert(&gcs.Object{Name: "foo", Generation: 1}, expiration)
+	t.cache.AddNegativeEntry("bar", expiration)
+	ExpectEq(0, t.counts().Evictions)
+
+	// A new name does.
+	t.cache.Insert(&gcs.Object{Name: "baz"}, expiration)
+	ExpectEq(1, t.counts().Evictions)
+
+	// Erasing makes room again.
+	t.cache.Erase("baz")
+	t.cache.AddNegativeEntry("qux", expiration)
+	ExpectEq(1, t.counts().Evictions)
+
+	// So does an entry expiring.
+	t.cache.LookUp("qux", t.now.Add(time.Minute))
+	t.cache.Insert(&gcs.Object{Name: "taco"}, expiration)
+	ExpectEq(1, t.counts().Evictions)
+
+	t.cache.Insert(&gcs.Object{Name: "burrito"}, expiration)
+	ExpectEq(2, t.counts().Evictions)
+}
@@ -34,4 +34,7 @@ var (
 
 	// ReadType annotates the read operation with the type - Sequential/Random
 	ReadType = tag.MustNewKey("read_type")
+
+	// Cache annotates cache events with the cache they happened in.
+	Cache = tag.MustNewKey("cache")
 )
@@ -20,6 +20,7 @@
 package storage
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -35,6 +36,17 @@ import (
 type bucketHandle struct {
 	gcs.Bucket
 	bucket *storage.BucketHandle
+
+	// The last seen attributes of recently statted objects.
+	lastSeen *objectMemo
+
+	// Whether the bucket uses uniform bucket-level access, in which case object
+	// ACLs are neither returned nor accepted by GCS.
+	uniformBucketLevelAccess bool
+
+	// The number of key ranges into which listings are split. See
+	// listSharded.
+	listShards int
 }
 
 func (bh *bucketHandle) NewReader(
@@ -81,11 +93,50 @@ func (b *bucketHandle) DeleteObject(ctx context.Context, req *gcs.DeleteObjectRe
 
 func (b *bucketHandle) StatObject(ctx context.Context, req *gcs.StatObjectRequest) (o *gcs.Object, err error) {
 	var attrs *storage.ObjectAttrs
+	obj := b.bucket.Object(req.Name)
+
+	// If we have seen the object before, ask GCS to send its attributes only if
+	// they have changed. Revalidating an unchanged object then costs a short
+	// 304 response rather than the full metadata payload.
+	if prev := b.lastSeen.Get(req.Name); prev != nil {
+		attrs, err = obj.If(storage.Conditions{
+			GenerationMatch:        prev.Generation,
+			MetagenerationNotMatch: prev.MetaGeneration,
+		}).Attrs(ctx)
+
+		var apiErr *googleapi.Error
+		switch {
+		case err == nil:
+			// The metadata changed, and we have the new attributes.
+			o = storageutil.ObjectAttrsToBucketObject(attrs)
+			b.lastSeen.Set(o)
+			return
+
+		case errors.As(err, &apiErr) && apiErr.Code == http.StatusNotModified:
+			o = prev
+			err = nil
+			return
+
+		case errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed:
+			// The generation changed. Fetch the new one in full below.
+
+		case err == storage.ErrObjectNotExist:
+			b.lastSeen.Erase(req.Name)
+			err = &gcs.NotFoundError{Err: err}
+			return
+
+		default:
+			err = fmt.Errorf("Error in revalidating object attributes: %w", err)
+			return
+		}
+	}
+
 	// Retrieving object attrs through Go Storage Client.
-	attrs, err = b.bucket.Object(req.Name).Attrs(ctx)
+	attrs, err = obj.Attrs(ctx)
 
 	// If error is of type storage.ErrObjectNotExist
 	if err == storage.ErrObjectNotExist {
+		b.lastSeen.Erase(req.Name)
 		err = &gcs.NotFoundError{Err: err} // Special case error that object not found in the bucket.
 		return
 	}
@@ -96,6 +147,7 @@ func (b *bucketHandle) StatObject(ctx context.Context, req *gcs.StatObjectReques
 
 	// Converting attrs to type *Object
 	o = storageutil.ObjectAttrsToBucketObject(attrs)
+	b.lastSeen.Set(o)
 
 	return
 }
@@ -122,6 +174,14 @@ func (bh *bucketHandle) CreateObject(ctx context.Context, req *gcs.CreateObjectR
 		obj = obj.If(storage.Conditions{GenerationMatch: *req.GenerationPrecondition, MetagenerationMatch: *req.MetaGenerationPrecondition})
 	}
 
+	// GCS rejects object ACLs with a 400 when uniform bucket-level access is
+	// enabled, so don't send any.
+	if bh.uniformBucketLevelAccess && req.Acl != nil {
+		withoutAcl := *req
+		withoutAcl.Acl = nil
+		req = &withoutAcl
+	}
+
 	// Creating a NewWriter with requested attributes, using Go Storage Client.
 	// Chuck size for resumable upload is default i.e. 16MB.
 	wc := obj.NewWriter(ctx)
@@ -199,12 +259,39 @@ func getProjectionValue(req gcs.Projection) storage.Projection {
 }
 
 func (b *bucketHandle) ListObjects(ctx context.Context, req *gcs.ListObjectsRequest) (listing *gcs.Listing, err error) {
+	// Split listings of whole directories into key ranges listed concurrently,
+	// if configured. Small probing listings aren't worth it.
+	if b.listShards > 1 && (req.MaxResults == 0 || req.MaxResults >= minShardedListResults) {
+		listing, err = b.listSharded(ctx, req)
+		return
+	}
+
+	listing, err = b.listRange(ctx, req, "", "")
+	return
+}
+
+// List the objects matching req whose names are in [startOffset, endOffset).
+// An empty offset leaves that end of the range open.
+func (b *bucketHandle) listRange(
+	ctx context.Context,
+	req *gcs.ListObjectsRequest,
+	startOffset string,
+	endOffset string) (listing *gcs.Listing, err error) {
+	// There are no object ACLs to fetch when uniform bucket-level access is
+	// enabled.
+	projection := req.ProjectionVal
+	if b.uniformBucketLevelAccess {
+		projection = gcs.NoAcl
+	}
+
 	// Converting *ListObjectsRequest to type *storage.Query as expected by the Go Storage Client.
 	query := &storage.Query{
 		Delimiter:                req.Delimiter,
 		Prefix:                   req.Prefix,
-		Projection:               getProjectionValue(req.ProjectionVal),
+		Projection:               getProjectionValue(projection),
 		IncludeTrailingDelimiter: req.IncludeTrailingDelimiter,
+		StartOffset:              startOffset,
+		EndOffset:                endOffset,
 		//MaxResults: , (Field not present in storage.Query of Go Storage Library but present in ListObjectsQuery in Jacobsa code.)
 	}
 	itr := b.bucket.Objects(ctx, query) // Returning iterator to the list of objects.
@@ -18,11 +18,14 @@ import (
 	"context"
 	"errors"
 	"strings"
+	"syscall"
 	"testing"
 
 	"cloud.google.com/go/storage"
 	"github.com/jacobsa/gcloud/gcs"
+	. "github.com/jacobsa/oglematchers"
 	. "github.com/jacobsa/ogletest"
+	storagev1 "google.golang.org/api/storage/v1"
 )
 
 const missingObjectName string = "test/foo"
@@ -188,6 +191,79 @@ func (t *BucketHandleTest) TestStatObjectMethodWithMissingObject() {
 	AssertTrue(errors.As(err, &notfound))
 }
 
+func (t *BucketHandleTest) TestStatObjectMethodRevalidatesSeenObject() {
+	first, err := t.bucketHandle.StatObject(context.Background(),
+		&gcs.StatObjectRequest{
+			Name: TestObjectName,
+		})
+	AssertEq(nil, err)
+	AssertNe(nil, t.bucketHandle.lastSeen.Get(TestObjectName))
+
+	// The second stat is conditional on the remembered generation.
+	second, err := t.bucketHandle.StatObject(context.Background(),
+		&gcs.StatObjectRequest{
+			Name: TestObjectName,
+		})
+
+	AssertEq(nil, err)
+	ExpectEq(first.Generation, second.Generation)
+	ExpectEq(first.MetaGeneration, second.MetaGeneration)
+	ExpectEq(first.Size, second.Size)
+}
+
+func (t *BucketHandleTest) TestStatObjectMethodDoesNotShareMetadata() {
+	first, err := t.bucketHandle.StatObject(context.Background(),
+		&gcs.StatObjectRequest{
+			Name: TestObjectName,
+		})
+	AssertEq(nil, err)
+
+	// Modifying a returned record must not affect the remembered one, nor
+	// records returned later.
+	if first.Metadata == nil {
+		first.Metadata = make(map[string]string)
+	}
+	first.Metadata["foo"] = "bar"
+
+	remembered := t.bucketHandle.lastSeen.Get(TestObjectName)
+	AssertNe(nil, remembered)
+	_, ok := remembered.Metadata["foo"]
+	ExpectFalse(ok)
+
+	second, err := t.bucketHandle.StatObject(context.Background(),
+		&gcs.StatObjectRequest{
+			Name: TestObjectName,
+		})
+
+	AssertEq(nil, err)
+	_, ok = second.Metadata["foo"]
+	ExpectFalse(ok)
+}
+
+func (t *BucketHandleTest) TestStatObjectMethodAfterObjectDeleted() {
+	var notfound *gcs.NotFoundError
+
+	_, err := t.bucketHandle.StatObject(context.Background(),
+		&gcs.StatObjectRequest{
+			Name: TestObjectName,
+		})
+	AssertEq(nil, err)
+
+	err = t.bucketHandle.DeleteObject(context.Background(),
+		&gcs.DeleteObjectRequest{
+			Name: TestObjectName,
+		})
+	AssertEq(nil, err)
+
+	_, err = t.bucketHandle.StatObject(context.Background(),
+		&gcs.StatObjectRequest{
+			Name: TestObjectName,
+		})
+
+	AssertTrue(errors.As(err, &notfound))
+	ExpectEq(nil, t.bucketHandle.lastSeen.Get(TestObjectName))
+}
+
 func (t *BucketHandleTest) TestCopyObjectMethodWithValidObject() {
 	_, err := t.bucketHandle.CopyObject(context.Background(),
 		&gcs.CopyObjectRequest{
@@ -258,6 +334,66 @@ func (t *BucketHandleTest) TestCreateObjectMethodWhenGivenGenerationObjectNotExi
 	AssertTrue(strings.Contains(err.Error(), "Error 412: Precondition failed"))
 }
 
+func (t *BucketHandleTest) TestCreateObjectMethodWithUniformBucketLevelAccess() {
+	content := "Creating a new object"
+	t.bucketHandle.uniformBucketLevelAccess = true
+
+	req := &gcs.CreateObjectRequest{
+		Name:     "test_object",
+		Contents: strings.NewReader(content),
+		Acl: []*storagev1.ObjectAccessControl{
+			{Entity: "allUsers", Role: "READER"},
+		},
+	}
+
+	// The ACL should not be sent, and the caller's request left untouched.
+	obj, err := t.bucketHandle.CreateObject(context.Background(), req)
+
+	AssertEq(nil, err)
+	AssertEq(obj.Name, "test_object")
+	ExpectEq(1, len(req.Acl))
+}
+
+func (t *BucketHandleTest) TestSetObjectAclWithUniformBucketLevelAccess() {
+	t.bucketHandle.uniformBucketLevelAccess = true
+
+	err := t.bucketHandle.SetObjectAcl(context.Background(),
+		&SetObjectAclRequest{
+			Name: TestObjectName,
+			Acl: []*storagev1.ObjectAccessControl{
+				{Entity: "allUsers", Role: "READER"},
+			},
+		})
+
+	ExpectTrue(errors.Is(err, ErrUniformBucketLevelAccess))
+	ExpectTrue(errors.Is(err, syscall.ENOTSUP))
+}
+
+func (t *BucketHandleTest) TestStatObjectGenerationMethodWithValidGeneration() {
+	o, err := t.bucketHandle.StatObjectGeneration(context.Background(),
+		&StatObjectGenerationRequest{
+			Name:       TestObjectName,
+			Generation: TestObjectGeneration,
+		})
+
+	AssertEq(nil, err)
+	ExpectEq(TestObjectName, o.Name)
+	ExpectEq(TestObjectGeneration, o.Generation)
+	ExpectEq(len(ContentInTestObject), o.Size)
+}
+
+func (t *BucketHandleTest) TestStatObjectGenerationMethodWithMissingGeneration() {
+	var notfound *gcs.NotFoundError
+
+	_, err := t.bucketHandle.StatObjectGeneration(context.Background(),
+		&StatObjectGenerationRequest{
+			Name:       TestObjectName,
+			Generation: TestObjectGeneration + 1,
+		})
+
+	AssertTrue(errors.As(err, &notfound))
+}
+
 func (t *BucketHandleTest) TestGetProjectValueWhenGcloudProjectionIsNoAcl() {
 	proj := getProjectionValue(gcs.NoAcl)
 
@@ -353,3 +489,52 @@ func (t *BucketHandleTest) TestListObjectMethodWithEmptyDelimiter() {
 	AssertEq(TestObjectGeneration, obj.Objects[0].Generation)
 	AssertEq(nil, obj.CollapsedRuns)
 }
+
+func (t *BucketHandleTest) TestListObjectMethodWithShards() {
+	t.bucketHandle.listShards = 4
+
+	obj, err := t.bucketHandle.ListObjects(context.Background(),
+		&gcs.ListObjectsRequest{
+			Prefix:                   "gcsfuse/",
+			Delimiter:                "/",
+			IncludeTrailingDelimiter: true,
+			MaxResults:               5000,
+			ProjectionVal:            0,
+		})
+
+	AssertEq(nil, err)
+	AssertEq(3, len(obj.Objects))
+	AssertEq(1, len(obj.CollapsedRuns))
+	AssertEq(TestObjectRootFolderName, obj.Objects[0].Name)
+	AssertEq(TestObjectSubRootFolderName, obj.Objects[1].Name)
+	AssertEq(TestObjectName, obj.Objects[2].Name)
+	AssertEq(TestObjectSubRootFolderName, obj.CollapsedRuns[0])
+}
+
+func (t *BucketHandleTest) TestListObjectMethodWithShardsAndEmptyDelimiter() {
+	t.bucketHandle.listShards = 64
+
+	obj, err := t.bucketHandle.ListObjects(context.Background(),
+		&gcs.ListObjectsRequest{
+			Prefix:        "gcsfuse/",
+			ProjectionVal: 0,
+		})
+
+	AssertEq(nil, err)
+	AssertEq(4, len(obj.Objects))
+	AssertEq(TestObjectRootFolderName, obj.Objects[0].Name)
+	AssertEq(TestObjectSubRootFolderName, obj.Objects[1].Name)
+	AssertEq(TestSubObjectName, obj.Objects[2].Name)
+	AssertEq(TestObjectName, obj.Objects[3].Name)
+	AssertEq(nil, obj.CollapsedRuns)
+}
+
+func (t *BucketHandleTest) TestListShardBoundaries() {
+	AssertEq(nil, listShardBoundaries("foo/", 1))
+	ExpectThat(
+		listShardBoundaries("foo/", 4),
+		ElementsAre("foo/F", "foo/V", "foo/k"))
+
+	// The number of shards is capped by the alphabet.
+	ExpectEq(len(listShardAlphabet)-1, len(listShardBoundaries("", 1000)))
+}
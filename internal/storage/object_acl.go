@@ -0,0 +1,189 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"syscall"
+
+	"cloud.google.com/go/storage"
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+	storagev1 "google.golang.org/api/storage/v1"
+)
+
+// ErrUniformBucketLevelAccess is returned for attempts to set the ACL of an
+// object in a bucket with uniform bucket-level access, whose objects have no
+// ACLs of their own. It wraps ENOTSUP.
+var ErrUniformBucketLevelAccess = fmt.Errorf(
+	"the bucket uses uniform bucket-level access: %w",
+	syscall.ENOTSUP)
+
+// SetObjectAclRequest is a request to replace the ACL of an object.
+type SetObjectAclRequest struct {
+	// The name of the object. Must be specified.
+	Name string
+
+	// The generation of the object, or zero for the latest generation.
+	Generation int64
+
+	// If non-nil, the request fails without effect with *gcs.PreconditionError
+	// if the object's meta-generation is not equal to this value.
+	MetaGenerationPrecondition *int64
+
+	// The new ACL.
+	Acl []*storagev1.ObjectAccessControl
+}
+
+// An AclSetter replaces the ACLs of objects in place, as a metadata update.
+// Unlike writing the object again with a new ACL, this leaves its generation
+// and contents alone.
+type AclSetter interface {
+	SetObjectAcl(ctx context.Context, req *SetObjectAclRequest) (err error)
+}
+
+// Convert an error from a JSON API call to the gcs package's types where they
+// exist.
+func convertJSONError(err error) error {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.Code {
+		case http.StatusNotFound:
+			return &gcs.NotFoundError{Err: err}
+
+		case http.StatusPreconditionFailed:
+			return &gcs.PreconditionError{Err: err}
+		}
+	}
+
+	return err
+}
+
+func (bh *bucketHandle) SetObjectAcl(
+	ctx context.Context,
+	req *SetObjectAclRequest) (err error) {
+	if bh.uniformBucketLevelAccess {
+		err = ErrUniformBucketLevelAccess
+		return
+	}
+
+	obj := bh.bucket.Object(req.Name)
+	if req.Generation != 0 {
+		obj = obj.Generation(req.Generation)
+	}
+
+	if req.MetaGenerationPrecondition != nil {
+		obj = obj.If(storage.Conditions{MetagenerationMatch: *req.MetaGenerationPrecondition})
+	}
+
+	// An empty but non-nil list clears the ACL, where nil would leave it be.
+	rules := []storage.ACLRule{}
+	for _, a := range req.Acl {
+		rules = append(rules, storage.ACLRule{
+			Entity: storage.ACLEntity(a.Entity),
+			Role:   storage.ACLRole(a.Role),
+		})
+	}
+
+	_, err = obj.Update(ctx, storage.ObjectAttrsToUpdate{ACL: rules})
+	err = convertJSONError(err)
+	return
+}
+
+// NewJSONAclSetter returns an AclSetter for the named bucket that patches
+// objects through the supplied JSON API service. This is for connections that
+// don't use the storage client library. uniformBucketLevelAccess says whether
+// the bucket has uniform bucket-level access; see UniformBucketLevelAccess.
+func NewJSONAclSetter(
+	service *storagev1.Service,
+	bucketName string,
+	billingProject string,
+	uniformBucketLevelAccess bool) AclSetter {
+	return &jsonAclSetter{
+		service:                  service,
+		bucketName:               bucketName,
+		billingProject:           billingProject,
+		uniformBucketLevelAccess: uniformBucketLevelAccess,
+	}
+}
+
+type jsonAclSetter struct {
+	service                  *storagev1.Service
+	bucketName               string
+	billingProject           string
+	uniformBucketLevelAccess bool
+}
+
+func (s *jsonAclSetter) SetObjectAcl(
+	ctx context.Context,
+	req *SetObjectAclRequest) (err error) {
+	if s.uniformBucketLevelAccess {
+		err = ErrUniformBucketLevelAccess
+		return
+	}
+
+	// Send the ACL even if it is empty, so that it is cleared.
+	call := s.service.Objects.Patch(
+		s.bucketName,
+		req.Name,
+		&storagev1.Object{
+			Acl:             req.Acl,
+			ForceSendFields: []string{"Acl"},
+		})
+
+	if req.Generation != 0 {
+		call = call.Generation(req.Generation)
+	}
+
+	if req.MetaGenerationPrecondition != nil {
+		call = call.IfMetagenerationMatch(*req.MetaGenerationPrecondition)
+	}
+
+	if s.billingProject != "" {
+		call = call.UserProject(s.billingProject)
+	}
+
+	_, err = call.Context(ctx).Fields("metageneration").Do()
+	err = convertJSONError(err)
+	return
+}
+
+// UniformBucketLevelAccess reports whether the named bucket has uniform
+// bucket-level access enabled, asking GCS through the supplied JSON API
+// service.
+func UniformBucketLevelAccess(
+	ctx context.Context,
+	service *storagev1.Service,
+	bucketName string,
+	billingProject string) (enabled bool, err error) {
+	call := service.Buckets.Get(bucketName)
+	if billingProject != "" {
+		call = call.UserProject(billingProject)
+	}
+
+	b, err := call.Context(ctx).Fields("iamConfiguration").Do()
+	if err != nil {
+		err = convertJSONError(err)
+		return
+	}
+
+	enabled = b.IamConfiguration != nil &&
+		b.IamConfiguration.UniformBucketLevelAccess != nil &&
+		b.IamConfiguration.UniformBucketLevelAccess.Enabled
+	return
+}
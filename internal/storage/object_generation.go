@@ -0,0 +1,110 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+
+	"cloud.google.com/go/storage"
+	"github.com/googlecloudplatform/gcsfuse/internal/storage/storageutil"
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+	storagev1 "google.golang.org/api/storage/v1"
+)
+
+// StatObjectGenerationRequest is a request to stat a particular generation of
+// an object, which need not be the current one.
+type StatObjectGenerationRequest struct {
+	// The name of the object. Must be specified.
+	Name string
+
+	// The generation of the object. Must be specified.
+	Generation int64
+}
+
+// A GenerationStatter stats particular generations of objects. gcs.Bucket
+// can only stat the current generation; in buckets with object versioning,
+// this also finds noncurrent ones. It returns *gcs.NotFoundError if GCS
+// doesn't keep the generation.
+type GenerationStatter interface {
+	StatObjectGeneration(
+		ctx context.Context,
+		req *StatObjectGenerationRequest) (o *gcs.Object, err error)
+}
+
+func (bh *bucketHandle) StatObjectGeneration(
+	ctx context.Context,
+	req *StatObjectGenerationRequest) (o *gcs.Object, err error) {
+	// Bypass lastSeen, which remembers only the current generation.
+	attrs, err := bh.bucket.Object(req.Name).Generation(req.Generation).Attrs(ctx)
+	if err == storage.ErrObjectNotExist {
+		err = &gcs.NotFoundError{Err: err}
+		return
+	}
+
+	if err != nil {
+		err = fmt.Errorf("Error in fetching object attributes: %w", err)
+		return
+	}
+
+	o = storageutil.ObjectAttrsToBucketObject(attrs)
+	return
+}
+
+// NewJSONGenerationStatter returns a GenerationStatter for the named bucket
+// that gets objects through the supplied JSON API service. This is for
+// connections that don't use the storage client library.
+func NewJSONGenerationStatter(
+	service *storagev1.Service,
+	bucketName string,
+	billingProject string) GenerationStatter {
+	return &jsonGenerationStatter{
+		service:        service,
+		bucketName:     bucketName,
+		billingProject: billingProject,
+	}
+}
+
+type jsonGenerationStatter struct {
+	service        *storagev1.Service
+	bucketName     string
+	billingProject string
+}
+
+func (s *jsonGenerationStatter) StatObjectGeneration(
+	ctx context.Context,
+	req *StatObjectGenerationRequest) (o *gcs.Object, err error) {
+	call := s.service.Objects.Get(s.bucketName, req.Name).
+		Generation(req.Generation).
+		Projection("full")
+
+	if s.billingProject != "" {
+		call = call.UserProject(s.billingProject)
+	}
+
+	raw, err := call.Context(ctx).Do()
+	if err != nil {
+		err = convertJSONError(err)
+		return
+	}
+
+	o, err = storageutil.RawObjectToBucketObject(raw)
+	if err != nil {
+		err = fmt.Errorf("RawObjectToBucketObject: %w", err)
+		return
+	}
+
+	return
+}
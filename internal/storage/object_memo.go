@@ -0,0 +1,87 @@
+// Copyright 2022 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"sync"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/util/lrucache"
+)
+
+// The number of objects whose attributes are remembered for revalidation.
+const objectMemoCapacity = 4096
+
+// An objectMemo remembers the most recently seen attributes of objects by
+// name, so that they can be revalidated with preconditions rather than
+// fetched again in full. Safe for concurrent access.
+type objectMemo struct {
+	mu sync.Mutex
+
+	// INVARIANT: entries.CheckInvariants() does not panic
+	// INVARIANT: Each value is of type *gcs.Object
+	//
+	// GUARDED_BY(mu)
+	entries lrucache.Cache
+}
+
+func newObjectMemo(capacity int) *objectMemo {
+	return &objectMemo{
+		entries: lrucache.New(capacity),
+	}
+}
+
+// Return a copy of the attributes that shares no maps with the original, so
+// that neither the memo nor its callers see the other's modifications.
+func copyObject(o *gcs.Object) *gcs.Object {
+	copied := *o
+	if o.Metadata != nil {
+		copied.Metadata = make(map[string]string, len(o.Metadata))
+		for k, v := range o.Metadata {
+			copied.Metadata[k] = v
+		}
+	}
+
+	return &copied
+}
+
+// Get returns a copy of the remembered attributes for the name, or nil.
+func (m *objectMemo) Get(name string) *gcs.Object {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	v := m.entries.LookUp(name)
+	if v == nil {
+		return nil
+	}
+
+	return copyObject(v.(*gcs.Object))
+}
+
+// Set remembers a copy of the given attributes.
+func (m *objectMemo) Set(o *gcs.Object) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries.Insert(o.Name, copyObject(o))
+}
+
+// Erase forgets anything remembered for the name.
+func (m *objectMemo) Erase(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries.Erase(name)
+}
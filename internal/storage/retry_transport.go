@@ -0,0 +1,252 @@
+// Copyright 2022 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jacobsa/gcloud/httputil"
+)
+
+// ErrRetryBudgetExhausted is returned in place of a retryable failure once
+// too many requests have failed recently, so that callers fail fast instead
+// of piling more retries onto an outage.
+var ErrRetryBudgetExhausted = errors.New("retry budget exhausted")
+
+// The fraction of a token earned back by each successful request.
+const retryBudgetRefill = 0.1
+
+// A RetryBudget limits retries across all requests, in the manner of gRPC
+// retry throttling: each retryable failure spends a token and each success
+// earns back a fraction of one. While no more than half of the tokens remain,
+// retryable failures are reported rather than retried.
+//
+// A nil *RetryBudget imposes no limit. Safe for concurrent access.
+type RetryBudget struct {
+	/////////////////////////
+	// Constant data
+	/////////////////////////
+
+	size float64
+
+	/////////////////////////
+	// Mutable state
+	/////////////////////////
+
+	mu sync.Mutex
+
+	// INVARIANT: 0 <= tokens <= size
+	//
+	// GUARDED_BY(mu)
+	tokens float64
+}
+
+// NewRetryBudget returns a budget holding the given number of tokens, or nil
+// (no limit) if size is not positive.
+func NewRetryBudget(size int) *RetryBudget {
+	if size <= 0 {
+		return nil
+	}
+
+	return &RetryBudget{
+		size:   float64(size),
+		tokens: float64(size),
+	}
+}
+
+// Record a retryable failure, returning whether it may be retried.
+func (b *RetryBudget) spend() bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.tokens >= 1 {
+		b.tokens--
+	} else {
+		b.tokens = 0
+	}
+
+	return b.tokens > b.size/2
+}
+
+// Record a successful request.
+func (b *RetryBudget) earn() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens += retryBudgetRefill
+	if b.tokens > b.size {
+		b.tokens = b.size
+	}
+}
+
+// A round tripper that charges retryable failures (429 and 5xx) to a retry
+// budget, and that honors the Retry-After header of 429 and 503 responses by
+// waiting and resending the request itself rather than leaving the caller to
+// its own backoff.
+type retryTransport struct {
+	wrapped httputil.CancellableRoundTripper
+	budget  *RetryBudget
+
+	// Retry-After delays longer than this are not waited for.
+	maxWait time.Duration
+}
+
+// NewRetryTransport wraps the supplied round tripper so that Retry-After
+// delays of up to maxWait are honored, and so that retryable failures are
+// returned as ErrRetryBudgetExhausted once the budget has run dry.
+func NewRetryTransport(
+	wrapped httputil.CancellableRoundTripper,
+	budget *RetryBudget,
+	maxWait time.Duration) httputil.CancellableRoundTripper {
+	return &retryTransport{
+		wrapped: wrapped,
+		budget:  budget,
+		maxWait: maxWait,
+	}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (resp *http.Response, err error) {
+	for {
+		resp, err = t.wrapped.RoundTrip(req)
+		if err != nil {
+			return
+		}
+
+		if !isRetryableStatus(resp.StatusCode) {
+			t.budget.earn()
+			return
+		}
+
+		if !t.budget.spend() {
+			discardResponse(resp)
+			resp = nil
+			err = ErrRetryBudgetExhausted
+			return
+		}
+
+		// Can we wait for the server, and send the request again?
+		delay, ok := retryAfter(resp)
+		if !ok || delay > t.maxWait {
+			return
+		}
+
+		next, ok := rewindRequest(req)
+		if !ok {
+			return
+		}
+
+		discardResponse(resp)
+		resp = nil
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			err = req.Context().Err()
+			return
+
+		case <-timer.C:
+		}
+
+		req = next
+	}
+}
+
+func (t *retryTransport) CancelRequest(req *http.Request) {
+	t.wrapped.CancelRequest(req)
+}
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+// Is the status one that callers retry?
+func isRetryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || (code >= 500 && code < 600)
+}
+
+// Return the delay requested by a 429 or 503 response's Retry-After header,
+// which may be given either in seconds or as a date.
+func retryAfter(resp *http.Response) (d time.Duration, ok bool) {
+	if resp.StatusCode != http.StatusTooManyRequests &&
+		resp.StatusCode != http.StatusServiceUnavailable {
+		return
+	}
+
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return
+	}
+
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		d = time.Duration(secs) * time.Second
+		ok = true
+		return
+	}
+
+	if date, err := http.ParseTime(v); err == nil {
+		d = time.Until(date)
+		if d < 0 {
+			d = 0
+		}
+		ok = true
+		return
+	}
+
+	return
+}
+
+// Return a copy of the request that can be sent again, if its body allows.
+func rewindRequest(req *http.Request) (next *http.Request, ok bool) {
+	next = req.Clone(req.Context())
+	if req.Body == nil || req.Body == http.NoBody {
+		ok = true
+		return
+	}
+
+	if req.GetBody == nil {
+		return
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return
+	}
+
+	next.Body = body
+	ok = true
+	return
+}
+
+// Drain and close a response we won't be returning, so that its connection
+// can be reused.
+func discardResponse(resp *http.Response) {
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+}
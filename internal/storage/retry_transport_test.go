@@ -0,0 +1,124 @@
+// Copyright 2022 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestRetryTransport(t *testing.T) { RunTests(t) }
+
+type RetryTransportTest struct {
+	// The number of requests received by the server.
+	requests int32
+
+	// The responses of the server, by request number. Later requests get the
+	// last response.
+	statuses   []int
+	retryAfter string
+
+	server *httptest.Server
+}
+
+var _ SetUpInterface = &RetryTransportTest{}
+var _ TearDownInterface = &RetryTransportTest{}
+
+func init() { RegisterTestSuite(&RetryTransportTest{}) }
+
+func (t *RetryTransportTest) SetUp(_ *TestInfo) {
+	t.server = httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			n := int(atomic.AddInt32(&t.requests, 1))
+			if n > len(t.statuses) {
+				n = len(t.statuses)
+			}
+
+			status := t.statuses[n-1]
+			if status != http.StatusOK && t.retryAfter != "" {
+				w.Header().Set("Retry-After", t.retryAfter)
+			}
+			w.WriteHeader(status)
+		}))
+}
+
+func (t *RetryTransportTest) TearDown() {
+	t.server.Close()
+}
+
+func (t *RetryTransportTest) client(budget *RetryBudget) *http.Client {
+	return &http.Client{
+		Transport: NewRetryTransport(&http.Transport{}, budget, time.Second),
+	}
+}
+
+func (t *RetryTransportTest) TestRetryAfterIsHonored() {
+	t.statuses = []int{http.StatusServiceUnavailable, http.StatusOK}
+	t.retryAfter = "0"
+
+	resp, err := t.client(nil).Get(t.server.URL)
+
+	AssertEq(nil, err)
+	resp.Body.Close()
+	ExpectEq(http.StatusOK, resp.StatusCode)
+	ExpectEq(2, atomic.LoadInt32(&t.requests))
+}
+
+func (t *RetryTransportTest) TestRetryAfterLongerThanMaxWait() {
+	t.statuses = []int{http.StatusTooManyRequests, http.StatusOK}
+	t.retryAfter = "60"
+
+	resp, err := t.client(nil).Get(t.server.URL)
+
+	AssertEq(nil, err)
+	resp.Body.Close()
+	ExpectEq(http.StatusTooManyRequests, resp.StatusCode)
+	ExpectEq(1, atomic.LoadInt32(&t.requests))
+}
+
+func (t *RetryTransportTest) TestRetryBudgetExhausted() {
+	t.statuses = []int{http.StatusInternalServerError}
+	c := t.client(NewRetryBudget(4))
+
+	// The first failure is within budget, and is returned for the caller to
+	// retry.
+	resp, err := c.Get(t.server.URL)
+
+	AssertEq(nil, err)
+	resp.Body.Close()
+	ExpectEq(http.StatusInternalServerError, resp.StatusCode)
+
+	// The second leaves only half the budget, and fails fast.
+	_, err = c.Get(t.server.URL)
+
+	ExpectTrue(errors.Is(err, ErrRetryBudgetExhausted))
+}
+
+func (t *RetryTransportTest) TestRetryBudgetRefilledBySuccess() {
+	b := NewRetryBudget(4)
+
+	ExpectTrue(b.spend())
+	for i := 0; i < 10; i++ {
+		b.earn()
+	}
+
+	ExpectTrue(b.spend())
+}
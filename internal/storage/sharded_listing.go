@@ -0,0 +1,114 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+	"golang.org/x/sync/errgroup"
+)
+
+// Listings asking for fewer results than this are never sharded. They are
+// probes (e.g. "is this directory empty?") that would only be slowed down by
+// fanning out.
+const minShardedListResults = 1000
+
+// The characters after the listing prefix at which the keyspace is split,
+// sorted by byte value. They are the ones most common at the start of object
+// names; names starting with anything else fall into the first or last shard.
+const listShardAlphabet = "-0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ_abcdefghijklmnopqrstuvwxyz"
+
+// Return the offsets that split names with the given prefix into n key
+// ranges, in increasing order. There are at most len(listShardAlphabet)
+// ranges.
+func listShardBoundaries(prefix string, n int) (boundaries []string) {
+	if n > len(listShardAlphabet) {
+		n = len(listShardAlphabet)
+	}
+
+	for i := 1; i < n; i++ {
+		c := listShardAlphabet[i*len(listShardAlphabet)/n]
+		boundaries = append(boundaries, prefix+string(c))
+	}
+
+	return
+}
+
+// Is the name within the key range [start, end)? Empty offsets are open.
+func inListShard(name string, start string, end string) bool {
+	return name >= start && (end == "" || name < end)
+}
+
+// List the objects matching req by splitting the keyspace under req.Prefix
+// into b.listShards ranges and listing them concurrently. The shards are
+// disjoint and ordered, so concatenating their results preserves the
+// ordering guarantees of gcs.Listing.
+//
+// A collapsed run never straddles two shards: every boundary is the prefix
+// followed by a single character, and all names in a run share the character
+// following the prefix.
+func (b *bucketHandle) listSharded(
+	ctx context.Context,
+	req *gcs.ListObjectsRequest) (listing *gcs.Listing, err error) {
+	boundaries := listShardBoundaries(req.Prefix, b.listShards)
+	shards := make([]*gcs.Listing, len(boundaries)+1)
+
+	group, ctx := errgroup.WithContext(ctx)
+	for i := range shards {
+		i := i
+
+		var start, end string
+		if i > 0 {
+			start = boundaries[i-1]
+		}
+		if i < len(boundaries) {
+			end = boundaries[i]
+		}
+
+		group.Go(func() (err error) {
+			var shard *gcs.Listing
+			shard, err = b.listRange(ctx, req, start, end)
+			if err != nil {
+				err = fmt.Errorf("listing [%q, %q): %w", start, end, err)
+				return
+			}
+
+			// Not every backend applies the offsets to objects that are also
+			// reported as collapsed runs, so enforce them here.
+			shards[i] = &gcs.Listing{CollapsedRuns: shard.CollapsedRuns}
+			for _, o := range shard.Objects {
+				if inListShard(o.Name, start, end) {
+					shards[i].Objects = append(shards[i].Objects, o)
+				}
+			}
+
+			return
+		})
+	}
+
+	if err = group.Wait(); err != nil {
+		return
+	}
+
+	listing = &gcs.Listing{}
+	for _, shard := range shards {
+		listing.Objects = append(listing.Objects, shard.Objects...)
+		listing.CollapsedRuns = append(listing.CollapsedRuns, shard.CollapsedRuns...)
+	}
+
+	return
+}
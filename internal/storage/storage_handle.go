@@ -15,16 +15,21 @@
 package storage
 
 import (
-	"crypto/tls"
 	"fmt"
 	"net/http"
+	"os"
+	"sync"
 	"time"
 
 	"cloud.google.com/go/storage"
 	"github.com/googleapis/gax-go/v2"
+	"github.com/googlecloudplatform/gcsfuse/internal/logger"
 	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
 	"google.golang.org/api/option"
+	"google.golang.org/api/option/internaloption"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 type StorageHandle interface {
@@ -32,54 +37,48 @@ type StorageHandle interface {
 }
 
 type storageClient struct {
-	client *storage.Client
+	client     *storage.Client
+	listShards int
 }
 
 type StorageClientConfig struct {
-	DisableHTTP2        bool
-	MaxConnsPerHost     int
-	MaxIdleConnsPerHost int
-	TokenSrc            oauth2.TokenSource
-	HttpClientTimeout   time.Duration
-	MaxRetryDuration    time.Duration
-	RetryMultiplier     float64
+	DisableHTTP2         bool
+	MaxConnsPerHost      int
+	MaxIdleConnsPerHost  int
+	TCPKeepAlive         time.Duration
+	HTTP2ReadIdleTimeout time.Duration
+	HTTP2PingTimeout     time.Duration
+
+	// The source of credentials for requests. If nil, requests are sent
+	// without credentials, which suffices for public buckets.
+	TokenSrc oauth2.TokenSource
+
+	HttpClientTimeout time.Duration
+	MaxRetryDuration  time.Duration
+	RetryMultiplier   float64
+	RetryBudget       int
+
+	// If set, talk to GCS over its gRPC API, using DirectPath where the
+	// environment supports it, rather than JSON over HTTP. The HTTP settings
+	// above, and the retry budget, do not apply.
+	EnableGRPC bool
+
+	// If greater than one, listings of whole directories are split into this
+	// many key ranges that are listed concurrently.
+	ListShards int
 }
 
 // NewStorageHandle returns the handle of Go storage client containing
 // customized http client. We can configure the http client using the
 // storageClientConfig parameter.
 func NewStorageHandle(ctx context.Context, clientConfig StorageClientConfig) (sh StorageHandle, err error) {
-	var transport *http.Transport
-	// Disabling the http2 makes the client more performant.
-	if clientConfig.DisableHTTP2 {
-		transport = &http.Transport{
-			MaxConnsPerHost:     clientConfig.MaxConnsPerHost,
-			MaxIdleConnsPerHost: clientConfig.MaxIdleConnsPerHost,
-			// This disables HTTP/2 in transport.
-			TLSNextProto: make(
-				map[string]func(string, *tls.Conn) http.RoundTripper,
-			),
-		}
+	var sc *storage.Client
+	if clientConfig.EnableGRPC {
+		sc, err = newGRPCClient(ctx, clientConfig.TokenSrc)
 	} else {
-		// For http2, change in MaxConnsPerHost doesn't affect the performance.
-		transport = &http.Transport{
-			DisableKeepAlives: true,
-			MaxConnsPerHost:   clientConfig.MaxConnsPerHost,
-			ForceAttemptHTTP2: true,
-		}
+		sc, err = newHTTPClient(ctx, clientConfig)
 	}
 
-	// Custom http client for Go Client.
-	httpClient := &http.Client{
-		Transport: &oauth2.Transport{
-			Base:   transport,
-			Source: clientConfig.TokenSrc,
-		},
-		Timeout: clientConfig.HttpClientTimeout,
-	}
-
-	var sc *storage.Client
-	sc, err = storage.NewClient(ctx, option.WithHTTPClient(httpClient))
 	if err != nil {
 		err = fmt.Errorf("go storage client creation failed: %w", err)
 		return
@@ -94,17 +93,105 @@ func NewStorageHandle(ctx context.Context, clientConfig StorageClientConfig) (sh
 		}),
 		storage.WithPolicy(storage.RetryAlways))
 
-	sh = &storageClient{client: sc}
+	sh = &storageClient{client: sc, listShards: clientConfig.ListShards}
+	return
+}
+
+func newHTTPClient(ctx context.Context, clientConfig StorageClientConfig) (sc *storage.Client, err error) {
+	transport, err := NewTransport(TransportConfig{
+		DisableHTTP2:         clientConfig.DisableHTTP2,
+		MaxConnsPerHost:      clientConfig.MaxConnsPerHost,
+		MaxIdleConnsPerHost:  clientConfig.MaxIdleConnsPerHost,
+		TCPKeepAlive:         clientConfig.TCPKeepAlive,
+		HTTP2ReadIdleTimeout: clientConfig.HTTP2ReadIdleTimeout,
+		HTTP2PingTimeout:     clientConfig.HTTP2PingTimeout,
+	})
+	if err != nil {
+		return
+	}
+
+	// HTTP/2 connections are not kept for reuse by this client, but a PING
+	// still catches one that stalls in the middle of a request.
+	if !clientConfig.DisableHTTP2 {
+		transport.DisableKeepAlives = true
+	}
+
+	var rt http.RoundTripper = NewRetryTransport(
+		transport,
+		NewRetryBudget(clientConfig.RetryBudget),
+		clientConfig.MaxRetryDuration)
+
+	if clientConfig.TokenSrc != nil {
+		rt = &oauth2.Transport{
+			Base:   rt,
+			Source: clientConfig.TokenSrc,
+		}
+	}
+
+	// Custom http client for Go Client.
+	httpClient := &http.Client{
+		Transport: rt,
+		Timeout:   clientConfig.HttpClientTimeout,
+	}
+
+	sc, err = storage.NewClient(ctx, option.WithHTTPClient(httpClient))
+	return
+}
+
+// The client library only offers its gRPC client to callers that set this
+// environment variable while creating a client.
+const useGRPCEnvVar = "STORAGE_USE_GRPC"
+
+// Serializes creating gRPC clients, which sets useGRPCEnvVar for the process.
+var grpcClientMu sync.Mutex
+
+func newGRPCClient(ctx context.Context, tokenSrc oauth2.TokenSource) (sc *storage.Client, err error) {
+	grpcClientMu.Lock()
+	defer grpcClientMu.Unlock()
+
+	if err = os.Setenv(useGRPCEnvVar, "true"); err != nil {
+		err = fmt.Errorf("Setenv: %w", err)
+		return
+	}
+	defer os.Unsetenv(useGRPCEnvVar)
+
+	opts := []option.ClientOption{
+		option.WithTokenSource(tokenSrc),
+		internaloption.EnableDirectPath(true),
+	}
+
+	// DirectPath needs credentials, and without them the library would dial
+	// insecurely, so ask for plain TLS instead.
+	if tokenSrc == nil {
+		opts = []option.ClientOption{
+			option.WithoutAuthentication(),
+			option.WithGRPCDialOption(
+				grpc.WithTransportCredentials(credentials.NewTLS(nil))),
+		}
+	}
+
+	sc, err = storage.NewClient(ctx, opts...)
 	return
 }
 
 func (sh *storageClient) BucketHandle(bucketName string) (bh *bucketHandle, err error) {
 	storageBucketHandle := sh.client.Bucket(bucketName)
-	_, err = storageBucketHandle.Attrs(context.Background())
+	attrs, err := storageBucketHandle.Attrs(context.Background())
 	if err != nil {
 		return
 	}
 
-	bh = &bucketHandle{bucket: storageBucketHandle}
+	bh = &bucketHandle{
+		bucket:                   storageBucketHandle,
+		lastSeen:                 newObjectMemo(objectMemoCapacity),
+		uniformBucketLevelAccess: attrs.UniformBucketLevelAccess.Enabled,
+		listShards:               sh.listShards,
+	}
+
+	if bh.uniformBucketLevelAccess {
+		logger.Infof(
+			"Bucket %q uses uniform bucket-level access; skipping object ACLs.\n",
+			bucketName)
+	}
 	return
 }
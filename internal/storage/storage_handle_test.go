@@ -16,6 +16,7 @@ package storage
 
 import (
 	"context"
+	"os"
 	"testing"
 	"time"
 
@@ -99,3 +100,27 @@ func (t *StorageHandleTest) TestNewStorageHandleWithZeroMaxConnsPerHost() {
 
 	t.invokeAndVerifyStorageHandle(sc)
 }
+
+func (t *StorageHandleTest) TestNewStorageHandleWithoutTokenSource() {
+	sc := getDefaultStorageClientConfig()
+	sc.TokenSrc = nil
+
+	t.invokeAndVerifyStorageHandle(sc)
+}
+
+func (t *StorageHandleTest) TestNewStorageHandleGRPCWithoutTokenSource() {
+	sc := getDefaultStorageClientConfig()
+	sc.EnableGRPC = true
+	sc.TokenSrc = nil
+
+	t.invokeAndVerifyStorageHandle(sc)
+}
+
+func (t *StorageHandleTest) TestNewStorageHandleGRPC() {
+	sc := getDefaultStorageClientConfig()
+	sc.EnableGRPC = true
+
+	t.invokeAndVerifyStorageHandle(sc)
+	_, set := os.LookupEnv(useGRPCEnvVar)
+	ExpectFalse(set)
+}
@@ -2,6 +2,9 @@ package storageutil
 
 import (
 	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
 	"time"
 
 	"cloud.google.com/go/storage"
@@ -74,6 +77,75 @@ func ObjectAttrsToBucketObject(attrs *storage.ObjectAttrs) *gcs.Object {
 	}
 }
 
+// RawObjectToBucketObject converts an object resource returned by the JSON
+// API, as the gcs package does for the objects it returns.
+func RawObjectToBucketObject(in *storagev1.Object) (out *gcs.Object, err error) {
+	out = &gcs.Object{
+		Name:               in.Name,
+		ContentType:        in.ContentType,
+		ContentLanguage:    in.ContentLanguage,
+		CacheControl:       in.CacheControl,
+		Size:               in.Size,
+		ContentEncoding:    in.ContentEncoding,
+		MediaLink:          in.MediaLink,
+		Metadata:           in.Metadata,
+		Generation:         in.Generation,
+		MetaGeneration:     in.Metageneration,
+		StorageClass:       in.StorageClass,
+		ComponentCount:     in.ComponentCount,
+		ContentDisposition: in.ContentDisposition,
+		CustomTime:         in.CustomTime,
+		EventBasedHold:     in.EventBasedHold,
+		Acl:                in.Acl,
+	}
+
+	if in.Owner != nil {
+		out.Owner = in.Owner.Entity
+	}
+
+	if in.TimeDeleted != "" {
+		if out.Deleted, err = time.Parse(time.RFC3339, in.TimeDeleted); err != nil {
+			err = fmt.Errorf("Decoding TimeDeleted field: %w", err)
+			return
+		}
+	}
+
+	if in.Updated != "" {
+		if out.Updated, err = time.Parse(time.RFC3339, in.Updated); err != nil {
+			err = fmt.Errorf("Decoding Updated field: %w", err)
+			return
+		}
+	}
+
+	// MD5 is missing for composite objects.
+	if in.Md5Hash != "" {
+		var b []byte
+		b, err = base64.StdEncoding.DecodeString(in.Md5Hash)
+		if err != nil || len(b) != md5.Size {
+			err = fmt.Errorf("Unexpected Md5Hash field: %q", in.Md5Hash)
+			return
+		}
+
+		out.MD5 = new([md5.Size]byte)
+		copy(out.MD5[:], b)
+	}
+
+	// CRC32C is missing for buckets using customer-managed encryption keys.
+	if in.Crc32c != "" {
+		var b []byte
+		b, err = base64.StdEncoding.DecodeString(in.Crc32c)
+		if err != nil || len(b) != 4 {
+			err = fmt.Errorf("Unexpected Crc32c field: %q", in.Crc32c)
+			return
+		}
+
+		crc := binary.BigEndian.Uint32(b)
+		out.CRC32C = &crc
+	}
+
+	return
+}
+
 // SetAttrsInWriter - for setting object-attributes filed in storage.Writer object.
 // These attributes will be assigned to the newly created or old object.
 func SetAttrsInWriter(wc *storage.Writer, req *gcs.CreateObjectRequest) *storage.Writer {
@@ -181,3 +181,43 @@ func (t objectAttrsTest) TestSetAttrsInWriterMethod() {
 	ExpectTrue(writer.SendCRC32C)
 	ExpectEq(string(writer.MD5[:]), string(createObjectRequest.MD5[:]))
 }
+
+func (t objectAttrsTest) TestRawObjectToBucketObjectMethod() {
+	raw := &storagev1.Object{
+		Name:           TestObjectName,
+		ContentType:    "ContentType",
+		Size:           16,
+		Md5Hash:        "AAECAwQFBgcICQoLDA0ODw==",
+		Crc32c:         "AQIDBA==",
+		Metadata:       map[string]string{"foo": "bar"},
+		Generation:     780,
+		Metageneration: 3,
+		Owner:          &storagev1.ObjectOwner{Entity: "Owner"},
+		Updated:        "2023-01-02T03:04:05Z",
+	}
+
+	o, err := RawObjectToBucketObject(raw)
+
+	AssertEq(nil, err)
+	ExpectEq(raw.Name, o.Name)
+	ExpectEq(raw.ContentType, o.ContentType)
+	ExpectEq(raw.Size, o.Size)
+	AssertNe(nil, o.MD5)
+	ExpectEq("\x00\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b\x0c\x0d\x0e\x0f", string(o.MD5[:]))
+	AssertNe(nil, o.CRC32C)
+	ExpectEq(0x01020304, *o.CRC32C)
+	ExpectEq("bar", o.Metadata["foo"])
+	ExpectEq(raw.Generation, o.Generation)
+	ExpectEq(raw.Metageneration, o.MetaGeneration)
+	ExpectEq("Owner", o.Owner)
+	ExpectTrue(o.Updated.Equal(time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)))
+	ExpectTrue(o.Deleted.IsZero())
+}
+
+func (t objectAttrsTest) TestRawObjectToBucketObjectMethodWithoutChecksums() {
+	o, err := RawObjectToBucketObject(&storagev1.Object{Name: TestObjectName})
+
+	AssertEq(nil, err)
+	ExpectEq(nil, o.MD5)
+	ExpectEq(nil, o.CRC32C)
+}
@@ -0,0 +1,87 @@
+// Copyright 2022 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// How long to wait for a TCP connection to GCS to be established, as in
+// http.DefaultTransport.
+const dialTimeout = 30 * time.Second
+
+// TransportConfig controls the connections made to GCS over HTTP.
+type TransportConfig struct {
+	// Speak HTTP/1.1 only, rather than negotiating HTTP/2.
+	DisableHTTP2 bool
+
+	// Limits on the connections kept to each host. Zero means no limit, or for
+	// idle connections the net/http default.
+	MaxConnsPerHost     int
+	MaxIdleConnsPerHost int
+
+	// The interval between TCP keepalive probes. Zero uses the net package's
+	// default; negative disables the probes.
+	TCPKeepAlive time.Duration
+
+	// If non-zero, an HTTP/2 connection on which no frame has been received for
+	// this long is sent a PING, and closed if no reply arrives within
+	// HTTP2PingTimeout. This detects connections that a NAT or proxy has
+	// dropped without telling either end.
+	HTTP2ReadIdleTimeout time.Duration
+	HTTP2PingTimeout     time.Duration
+}
+
+// NewTransport returns an HTTP transport configured as described by cfg, with
+// the remaining settings taken from http.DefaultTransport.
+func NewTransport(cfg TransportConfig) (t *http.Transport, err error) {
+	t = http.DefaultTransport.(*http.Transport).Clone()
+
+	dialer := &net.Dialer{
+		Timeout:   dialTimeout,
+		KeepAlive: cfg.TCPKeepAlive,
+	}
+	t.DialContext = dialer.DialContext
+	t.MaxConnsPerHost = cfg.MaxConnsPerHost
+	t.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+
+	if cfg.DisableHTTP2 {
+		// A non-nil, empty map disables HTTP/2 in the transport.
+		t.ForceAttemptHTTP2 = false
+		t.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+		return
+	}
+
+	if cfg.HTTP2ReadIdleTimeout == 0 {
+		return
+	}
+
+	// Health checks are only available by configuring HTTP/2 explicitly.
+	h2, err := http2.ConfigureTransports(t)
+	if err != nil {
+		err = fmt.Errorf("ConfigureTransports: %w", err)
+		return
+	}
+
+	h2.ReadIdleTimeout = cfg.HTTP2ReadIdleTimeout
+	h2.PingTimeout = cfg.HTTP2PingTimeout
+	return
+}
@@ -0,0 +1,78 @@
+// Copyright 2022 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestTransport(t *testing.T) { RunTests(t) }
+
+type TransportTest struct {
+}
+
+func init() { RegisterTestSuite(&TransportTest{}) }
+
+func (t *TransportTest) ConnectionLimits() {
+	transport, err := NewTransport(TransportConfig{
+		MaxConnsPerHost:     10,
+		MaxIdleConnsPerHost: 100,
+	})
+
+	AssertEq(nil, err)
+	ExpectEq(10, transport.MaxConnsPerHost)
+	ExpectEq(100, transport.MaxIdleConnsPerHost)
+	ExpectTrue(transport.ForceAttemptHTTP2)
+	ExpectEq(nil, transport.TLSNextProto)
+}
+
+func (t *TransportTest) DisableHTTP2() {
+	transport, err := NewTransport(TransportConfig{
+		DisableHTTP2:         true,
+		HTTP2ReadIdleTimeout: time.Minute,
+	})
+
+	AssertEq(nil, err)
+	ExpectFalse(transport.ForceAttemptHTTP2)
+	AssertNe(nil, transport.TLSNextProto)
+	ExpectEq(0, len(transport.TLSNextProto))
+}
+
+func (t *TransportTest) HTTP2HealthChecks() {
+	transport, err := NewTransport(TransportConfig{
+		HTTP2ReadIdleTimeout: time.Minute,
+		HTTP2PingTimeout:     time.Second,
+	})
+
+	AssertEq(nil, err)
+	_, ok := transport.TLSNextProto["h2"]
+	ExpectTrue(ok)
+}
+
+func (t *TransportTest) DoesNotModifyDefaultTransport() {
+	_, err := NewTransport(TransportConfig{
+		MaxConnsPerHost:      10,
+		HTTP2ReadIdleTimeout: time.Minute,
+	})
+
+	AssertEq(nil, err)
+	transport, err := NewTransport(TransportConfig{})
+	AssertEq(nil, err)
+	ExpectEq(0, transport.MaxConnsPerHost)
+	ExpectEq(nil, transport.TLSNextProto)
+}
@@ -20,13 +20,14 @@
 package main
 
 import (
-	"crypto/tls"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"path"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/googlecloudplatform/gcsfuse/internal/storage"
@@ -36,6 +37,7 @@ import (
 	"github.com/googlecloudplatform/gcsfuse/internal/auth"
 	"github.com/googlecloudplatform/gcsfuse/internal/canned"
 	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/googlecloudplatform/gcsfuse/internal/health"
 	"github.com/googlecloudplatform/gcsfuse/internal/locker"
 	"github.com/googlecloudplatform/gcsfuse/internal/logger"
 	"github.com/googlecloudplatform/gcsfuse/internal/monitor"
@@ -43,6 +45,7 @@ import (
 	"github.com/jacobsa/daemonize"
 	"github.com/jacobsa/fuse"
 	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/timeutil"
 	"github.com/kardianos/osext"
 	"github.com/urfave/cli"
 )
@@ -73,11 +76,49 @@ func registerSIGINTHandler(mountPoint string) {
 	}()
 }
 
+// Reload the token source on SIGHUP, so that a rotated key file is picked up
+// without remounting.
+func registerSIGHUPHandler(tokenSrc *auth.ReloadableTokenSource) {
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGHUP)
+
+	go func() {
+		for range signalChan {
+			logger.Info("Received SIGHUP, reloading credentials...")
+			if err := tokenSrc.Reload(); err != nil {
+				logger.Infof("Failed to reload credentials; keeping the current ones: %v", err)
+			} else {
+				logger.Info("Successfully reloaded credentials.")
+			}
+		}
+	}()
+}
+
+// Create a token source that can be reloaded on SIGHUP.
+func getReloadableTokenSource(
+	keyFile string,
+	tokenUrl string,
+	reuseTokenFromUrl bool) (tokenSrc *auth.ReloadableTokenSource, err error) {
+	tokenSrc, err = auth.NewReloadableTokenSource(func() (oauth2.TokenSource, error) {
+		return auth.GetTokenSource(
+			context.Background(),
+			keyFile,
+			tokenUrl,
+			reuseTokenFromUrl,
+		)
+	})
+	if err != nil {
+		return
+	}
+
+	registerSIGHUPHandler(tokenSrc)
+	return
+}
+
 func getConn(flags *flagStorage) (c *gcsx.Connection, err error) {
 	var tokenSrc oauth2.TokenSource
-	if flags.Endpoint.Hostname() == "storage.googleapis.com" {
-		tokenSrc, err = auth.GetTokenSource(
-			context.Background(),
+	if !flags.AnonymousAccess && flags.Endpoint.Hostname() == "storage.googleapis.com" {
+		tokenSrc, err = getReloadableTokenSource(
 			flags.KeyFile,
 			flags.TokenUrl,
 			flags.ReuseTokenFromUrl,
@@ -87,7 +128,7 @@ func getConn(flags *flagStorage) (c *gcsx.Connection, err error) {
 			return
 		}
 	} else {
-		// Do not use OAuth with non-Google hosts.
+		// Do not use OAuth with non-Google hosts, or when asked not to.
 		tokenSrc = oauth2.StaticTokenSource(&oauth2.Token{})
 	}
 
@@ -103,14 +144,30 @@ func getConn(flags *flagStorage) (c *gcsx.Connection, err error) {
 	// does not create new TCP connections even when the idle connections
 	// run out. To specify multiple connections per host, HTTP/2 is disabled
 	// on purpose.
-	if flags.DisableHTTP2 {
-		cfg.Transport = &http.Transport{
-			MaxConnsPerHost: flags.MaxConnsPerHost,
-			// This disables HTTP/2 in the transport.
-			TLSNextProto: make(
-				map[string]func(string, *tls.Conn) http.RoundTripper,
-			),
-		}
+	transport, err := storage.NewTransport(storage.TransportConfig{
+		DisableHTTP2:         flags.DisableHTTP2,
+		MaxConnsPerHost:      flags.MaxConnsPerHost,
+		MaxIdleConnsPerHost:  flags.MaxIdleConnsPerHost,
+		TCPKeepAlive:         flags.TCPKeepAlive,
+		HTTP2ReadIdleTimeout: flags.HTTP2ReadIdleTimeout,
+		HTTP2PingTimeout:     flags.HTTP2PingTimeout,
+	})
+	if err != nil {
+		err = fmt.Errorf("NewTransport: %w", err)
+		return
+	}
+
+	// Honor Retry-After and charge failures to the retry budget beneath the
+	// connection's own retry loop.
+	cfg.Transport = storage.NewRetryTransport(
+		transport,
+		storage.NewRetryBudget(flags.RetryBudget),
+		flags.MaxRetrySleep)
+
+	// The connection sends an Authorization header even for the empty token,
+	// which GCS rejects rather than treating the request as anonymous.
+	if flags.AnonymousAccess {
+		cfg.Transport = auth.NewAnonymousTransport(cfg.Transport)
 	}
 
 	if flags.DebugHTTP {
@@ -140,30 +197,43 @@ func getConnWithRetry(flags *flagStorage) (c *gcsx.Connection, err error) {
 
 // Mount the file system according to arguments in the supplied context.
 func createStorageHandle(flags *flagStorage) (storageHandle storage.StorageHandle, err error) {
-	tokenSrc, err := auth.GetTokenSource(context.Background(), flags.KeyFile, flags.TokenUrl, true)
-	if err != nil {
-		err = fmt.Errorf("get token source: %w", err)
-		return
+	// A nil token source means requests are sent without credentials.
+	var tokenSrc oauth2.TokenSource
+	if !flags.AnonymousAccess {
+		tokenSrc, err = getReloadableTokenSource(flags.KeyFile, flags.TokenUrl, true)
+		if err != nil {
+			err = fmt.Errorf("get token source: %w", err)
+			return
+		}
 	}
+
 	storageClientConfig := storage.StorageClientConfig{
-		DisableHTTP2:        flags.DisableHTTP2,
-		MaxConnsPerHost:     flags.MaxConnsPerHost,
-		MaxIdleConnsPerHost: flags.MaxIdleConnsPerHost,
-		TokenSrc:            tokenSrc,
-		HttpClientTimeout:   flags.HttpClientTimeout,
-		MaxRetryDuration:    flags.MaxRetryDuration,
-		RetryMultiplier:     flags.RetryMultiplier,
+		DisableHTTP2:         flags.DisableHTTP2,
+		MaxConnsPerHost:      flags.MaxConnsPerHost,
+		MaxIdleConnsPerHost:  flags.MaxIdleConnsPerHost,
+		TCPKeepAlive:         flags.TCPKeepAlive,
+		HTTP2ReadIdleTimeout: flags.HTTP2ReadIdleTimeout,
+		HTTP2PingTimeout:     flags.HTTP2PingTimeout,
+		TokenSrc:             tokenSrc,
+		HttpClientTimeout:    flags.HttpClientTimeout,
+		MaxRetryDuration:     flags.MaxRetryDuration,
+		RetryMultiplier:      flags.RetryMultiplier,
+		RetryBudget:          flags.RetryBudget,
+		EnableGRPC:           flags.EnableGRPC,
+		ListShards:           flags.ListShards,
 	}
 
 	storageHandle, err = storage.NewStorageHandle(context.Background(), storageClientConfig)
 	return
 }
 
+// Mount the given buckets, sharing one connection to GCS between them. If any
+// fails to mount, those already mounted are unmounted again.
 func mountWithArgs(
-	bucketName string,
-	mountPoint string,
+	mounts []mountSpec,
 	flags *flagStorage,
-	mountStatus *log.Logger) (mfs *fuse.MountedFileSystem, err error) {
+	healthChecker *health.Checker,
+	mountStatus *log.Logger) (mfss []*fuse.MountedFileSystem, err error) {
 	// Enable invariant checking if requested.
 	if flags.DebugInvariants {
 		locker.EnableInvariantsCheck()
@@ -174,11 +244,21 @@ func mountWithArgs(
 
 	// Grab the connection.
 	//
-	// Special case: if we're mounting the fake bucket, we don't need an actual
-	// connection.
+	// Special case: if we're only mounting the fake bucket, we don't need an
+	// actual connection.
+	needConn := false
+	for _, m := range mounts {
+		if m.bucketName != canned.FakeBucketName {
+			needConn = true
+		}
+	}
+
 	var conn *gcsx.Connection
 	var storageHandle storage.StorageHandle
-	if bucketName != canned.FakeBucketName {
+	if needConn && flags.FakeBackend {
+		mountStatus.Println("Using an in-memory fake of GCS.")
+		conn = gcsx.NewFakeConnection(timeutil.RealClock())
+	} else if needConn {
 		mountStatus.Println("Opening GCS connection...")
 
 		if flags.EnableStorageClientLibrary {
@@ -193,20 +273,34 @@ func mountWithArgs(
 		}
 	}
 
-	// Mount the file system.
-	logger.Infof("Creating a mount at %q\n", mountPoint)
-	mfs, err = mountWithConn(
-		context.Background(),
-		bucketName,
-		mountPoint,
-		flags,
-		conn,
-		storageHandle,
-		mountStatus)
+	// Mount the file systems.
+	for _, m := range mounts {
+		logger.Infof("Creating a mount at %q\n", m.mountPoint)
 
-	if err != nil {
-		err = fmt.Errorf("mountWithConn: %w", err)
-		return
+		var mfs *fuse.MountedFileSystem
+		mfs, err = mountWithConn(
+			context.Background(),
+			m.bucketName,
+			m.mountPoint,
+			m.flags,
+			conn,
+			storageHandle,
+			healthChecker,
+			mountStatus)
+
+		if err != nil {
+			err = fmt.Errorf("mountWithConn: %w", err)
+			for _, mounted := range mfss {
+				if unmountErr := fuse.Unmount(mounted.Dir()); unmountErr != nil {
+					logger.Infof("Failed to unmount %q: %v", mounted.Dir(), unmountErr)
+				}
+			}
+
+			mfss = nil
+			return
+		}
+
+		mfss = append(mfss, mfs)
 	}
 
 	return
@@ -264,14 +358,39 @@ func runCLIApp(c *cli.Context) (err error) {
 		}
 	}
 
-	var bucketName string
-	var mountPoint string
-	bucketName, mountPoint, err = populateArgs(c)
-	if err != nil {
-		return
+	// Find the buckets to mount, from the config file if there is one.
+	var mounts []mountSpec
+	if flags.ConfigFile != "" {
+		if len(c.Args()) != 0 {
+			err = fmt.Errorf(
+				"%s takes no arguments with --config-file. Run `%s --help` for more info.",
+				path.Base(os.Args[0]),
+				path.Base(os.Args[0]))
+			return
+		}
+
+		mounts, err = loadMountConfig(flags.ConfigFile, os.Args[1:])
+		if err != nil {
+			err = fmt.Errorf("loadMountConfig: %w", err)
+			return
+		}
+	} else {
+		var bucketName string
+		var mountPoint string
+		bucketName, mountPoint, err = populateArgs(c)
+		if err != nil {
+			return
+		}
+
+		mounts = []mountSpec{{bucketName, mountPoint, flags}}
+	}
+
+	var mountPoints []string
+	for _, m := range mounts {
+		mountPoints = append(mountPoints, m.mountPoint)
 	}
 
-	logger.Infof("Start gcsfuse/%s for app %q using mount point: %s\n", getVersion(), flags.AppName, mountPoint)
+	logger.Infof("Start gcsfuse/%s for app %q using mount points: %s\n", getVersion(), flags.AppName, strings.Join(mountPoints, ", "))
 
 	// If we haven't been asked to run in foreground mode, we should run a daemon
 	// with the foreground flag set and wait for it to mount.
@@ -287,7 +406,9 @@ func runCLIApp(c *cli.Context) (err error) {
 		// Set up arguments. Be sure to use foreground mode, and to send along the
 		// potentially-modified mount point.
 		args := append([]string{"--foreground"}, os.Args[1:]...)
-		args[len(args)-1] = mountPoint
+		if flags.ConfigFile == "" {
+			args[len(args)-1] = mounts[0].mountPoint
+		}
 
 		// Pass along PATH so that the daemon can find fusermount on Linux.
 		env := []string{
@@ -354,16 +475,35 @@ func runCLIApp(c *cli.Context) (err error) {
 	monitor.EnableStackdriverExporter(flags.StackdriverExportInterval)
 	monitor.EnableOpenTelemetryCollectorExporter(flags.OtelCollectorAddress)
 
+	// Serve health probes, if requested. Readiness waits for the mount below.
+	var healthChecker *health.Checker
+	if flags.HealthAddr != "" {
+		healthChecker = health.NewChecker(timeutil.RealClock())
+
+		var healthServer *http.Server
+		healthServer, err = health.Serve(flags.HealthAddr, healthChecker)
+		if err != nil {
+			err = fmt.Errorf("health.Serve: %w", err)
+			daemonize.SignalOutcome(err)
+			return
+		}
+
+		defer healthServer.Close()
+	}
+
 	// Mount, writing information about our progress to the writer that package
 	// daemonize gives us and telling it about the outcome.
-	var mfs *fuse.MountedFileSystem
+	var mfss []*fuse.MountedFileSystem
 	{
 		mountStatus := logger.NewNotice("")
-		mfs, err = mountWithArgs(bucketName, mountPoint, flags, mountStatus)
+		mfss, err = mountWithArgs(mounts, flags, healthChecker, mountStatus)
 
 		if err == nil {
 			mountStatus.Println("File system has been successfully mounted.")
 			daemonize.SignalOutcome(nil)
+			if healthChecker != nil {
+				healthChecker.SetMounted(mountPoints...)
+			}
 		} else {
 			err = fmt.Errorf("mountWithArgs: %w", err)
 			daemonize.SignalOutcome(err)
@@ -371,11 +511,21 @@ func runCLIApp(c *cli.Context) (err error) {
 		}
 	}
 
-	// Let the user unmount with Ctrl-C (SIGINT).
-	registerSIGINTHandler(mfs.Dir())
+	// Let the user unmount with Ctrl-C (SIGINT), and wait for every file system
+	// to be unmounted.
+	joinErrs := make(chan error, len(mfss))
+	for _, mfs := range mfss {
+		registerSIGINTHandler(mfs.Dir())
+		go func(mfs *fuse.MountedFileSystem) {
+			joinErrs <- mfs.Join(context.Background())
+		}(mfs)
+	}
 
-	// Wait for the file system to be unmounted.
-	err = mfs.Join(context.Background())
+	for range mfss {
+		if joinErr := <-joinErrs; joinErr != nil && err == nil {
+			err = joinErr
+		}
+	}
 
 	monitor.CloseStackdriverExporter()
 	monitor.CloseOpenTelemetryCollectorExporter()
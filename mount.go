@@ -17,13 +17,16 @@ package main
 import (
 	"fmt"
 	"log"
+	"net/url"
 	"os"
+	"path/filepath"
 
 	"github.com/googlecloudplatform/gcsfuse/internal/storage"
 	"golang.org/x/net/context"
 
 	"github.com/googlecloudplatform/gcsfuse/internal/fs"
 	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/googlecloudplatform/gcsfuse/internal/health"
 	"github.com/googlecloudplatform/gcsfuse/internal/logger"
 	"github.com/googlecloudplatform/gcsfuse/internal/perms"
 	"github.com/jacobsa/fuse"
@@ -40,6 +43,7 @@ func mountWithConn(
 	flags *flagStorage,
 	conn *gcsx.Connection,
 	storageHandle storage.StorageHandle,
+	healthChecker *health.Checker,
 	status *log.Logger) (mfs *fuse.MountedFileSystem, err error) {
 	// Sanity check: make sure the temporary directory exists and is writable
 	// currently. This gives a better user experience than harder to debug EIO
@@ -84,18 +88,65 @@ be interacting with the file system.`)
 		gid = uint32(flags.Gid)
 	}
 
+	var encryptionKey []byte
+	if flags.EncryptionKeyFile != "" {
+		encryptionKey, err = gcsx.LoadEncryptionKey(flags.EncryptionKeyFile)
+		if err != nil {
+			err = fmt.Errorf("LoadEncryptionKey: %w", err)
+			return
+		}
+	}
+
+	// Whether the file system is mounted with "-o ro".
+	_, readOnly := flags.MountOptions["ro"]
+
+	// Writes queued while offline are saved for the next mount of the same
+	// bucket and directory. A read-only mount can't replay them, so leaves them
+	// for a later one.
+	offlineQueueDir := flags.OfflineQueueDir
+	if offlineQueueDir == "" {
+		tempDir := flags.TempDir
+		if tempDir == "" {
+			tempDir = os.TempDir()
+		}
+
+		offlineQueueDir = filepath.Join(
+			tempDir,
+			"gcsfuse_offline_queue",
+			url.PathEscape(bucketName+"/"+flags.OnlyDir))
+	}
+
+	if readOnly {
+		offlineQueueDir = ""
+	}
+
 	bucketCfg := gcsx.BucketConfig{
-		BillingProject:                     flags.BillingProject,
-		OnlyDir:                            flags.OnlyDir,
-		EgressBandwidthLimitBytesPerSecond: flags.EgressBandwidthLimitBytesPerSecond,
-		OpRateLimitHz:                      flags.OpRateLimitHz,
-		StatCacheCapacity:                  flags.StatCacheCapacity,
-		StatCacheTTL:                       flags.StatCacheTTL,
-		EnableMonitoring:                   flags.StackdriverExportInterval > 0,
-		AppendThreshold:                    1 << 21, // 2 MiB, a total guess.
-		TmpObjectPrefix:                    ".gcsfuse_tmp/",
-		DebugGCS:                           flags.DebugGCS,
-		EnableStorageClientLibrary:         flags.EnableStorageClientLibrary,
+		BillingProject:                      flags.BillingProject,
+		OnlyDir:                             flags.OnlyDir,
+		IngressBandwidthLimitBytesPerSecond: flags.IngressBandwidthLimitBytesPerSecond,
+		EgressBandwidthLimitBytesPerSecond:  flags.EgressBandwidthLimitBytesPerSecond,
+		OpRateLimitHz:                       flags.OpRateLimitHz,
+		StatCacheCapacity:                   flags.StatCacheCapacity,
+		StatCacheTTL:                        flags.StatCacheTTL,
+		EnableMonitoring:                    flags.StackdriverExportInterval > 0,
+		AppendThreshold:                     1 << 21, // 2 MiB, a total guess.
+		TmpObjectPrefix:                     ".gcsfuse_tmp/",
+		DebugGCS:                            flags.DebugGCS,
+		EnableStorageClientLibrary:          flags.EnableStorageClientLibrary,
+		EncryptionKey:                       encryptionKey,
+		EnableCompression:                   flags.CompressObjects,
+		ContentTypeOverrides:                flags.ContentTypeOverrides,
+		ObjectHeaderRules:                   flags.ObjectHeaderRules,
+		TempDir:                             flags.TempDir,
+		ReadOnly:                            readOnly,
+		CircuitBreakerThreshold:             flags.CircuitBreakerThreshold,
+		CircuitBreakerCooldown:              flags.CircuitBreakerCooldown,
+		HealthChecker:                       healthChecker,
+		CallTimeouts: gcsx.CallTimeouts{
+			Metadata: flags.MetadataTimeout,
+			Read:     flags.ReadTimeout,
+			Upload:   flags.UploadTimeout,
+		},
 	}
 	bm := gcsx.NewBucketManager(bucketCfg, conn, storageHandle)
 
@@ -105,8 +156,11 @@ be interacting with the file system.`)
 		BucketManager:          bm,
 		BucketName:             bucketName,
 		LocalFileCache:         flags.LocalFileCache,
+		VerifyCacheCRC32C:      flags.VerifyCacheCRC32C,
 		DebugFS:                flags.DebugFS,
 		TempDir:                flags.TempDir,
+		MaxTempUsageMb:         flags.MaxTempUsageMb,
+		TempFileMemoryLimitKb:  flags.TempFileMemoryLimitKb,
 		ImplicitDirectories:    flags.ImplicitDirs,
 		InodeAttributeCacheTTL: flags.StatCacheTTL,
 		DirTypeCacheTTL:        flags.TypeCacheTTL,
@@ -116,6 +170,22 @@ be interacting with the file system.`)
 		DirPerms:               os.FileMode(flags.DirMode),
 		RenameDirLimit:         flags.RenameDirLimit,
 		SequentialReadSizeMb:   flags.SequentialReadSizeMb,
+		FuseWorkerPoolSize:     flags.FuseWorkerPoolSize,
+		TempFileIdleTimeout:    flags.TempFileIdleTimeout,
+		ArchiveReadPolicy:      flags.ArchiveReadPolicy,
+		OfflineMode:            flags.OfflineMode,
+		OfflineQueueDir:        offlineQueueDir,
+		WritePolicy:            flags.WritePolicy,
+		WriteBackMaxDirtyMb:    flags.WriteBackMaxDirtyMb,
+		EmulateHardLinks:       flags.EmulateHardLinks,
+		Use32BitInodes:         flags.Use32BitInodes,
+		MkdirMode:              flags.MkdirMode,
+		ACLPrincipals:          flags.ACLPrincipals,
+		ReadOnlyPrefixes:       flags.ReadOnlyPrefixes,
+		MaxBytesWritten:        flags.MaxBytesWritten,
+		MaxObjectsCreated:      flags.MaxObjectsCreated,
+		MaxFileSize:            flags.MaxFileSize,
+		AuditLogFile:           flags.AuditLog,
 	}
 
 	logger.Infof("Creating a new server...\n")
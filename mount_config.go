@@ -0,0 +1,185 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/urfave/cli"
+)
+
+// The contents of the file given by --config-file.
+type mountConfigFile struct {
+	Mounts []mountConfig `json:"mounts"`
+}
+
+// A bucket to mount, as given in the file named by --config-file.
+type mountConfig struct {
+	Bucket     string `json:"bucket"`
+	MountPoint string `json:"mount_point"`
+
+	// Flags for this mount alone, in command line syntax, e.g.
+	// "--implicit-dirs" or "--only-dir=logs". They are applied on top of the
+	// flags given on the command line.
+	Flags []string `json:"flags"`
+}
+
+// A bucket to mount and the flags to mount it with.
+type mountSpec struct {
+	bucketName string
+	mountPoint string
+	flags      *flagStorage
+}
+
+// Flags that configure the process as a whole rather than a single mount:
+// logging, monitoring, credentials and the shared connection to GCS. They may
+// only be given on the command line.
+var processWideFlags = map[string]bool{
+	"foreground":                  true,
+	"config-file":                 true,
+	"app-name":                    true,
+	"endpoint":                    true,
+	"fake-backend":                true,
+	"key-file":                    true,
+	"anonymous-access":            true,
+	"token-url":                   true,
+	"reuse-token-from-url":        true,
+	"max-retry-sleep":             true,
+	"retry-budget":                true,
+	"http-client-timeout":         true,
+	"max-retry-duration":          true,
+	"retry-multiplier":            true,
+	"disable-http2":               true,
+	"max-conns-per-host":          true,
+	"max-idle-conns-per-host":     true,
+	"tcp-keepalive-interval":      true,
+	"http2-read-idle-timeout":     true,
+	"http2-ping-timeout":          true,
+	"stackdriver-export-interval": true,
+	"experimental-opentelemetry-collector-address": true,
+	"log-file":         true,
+	"log-format":       true,
+	"health-addr":      true,
+	"debug_gcs":        true,
+	"debug_http":       true,
+	"debug_invariants": true,
+	"debug_mutex":      true,
+	"experimental-enable-storage-client-library": true,
+	"experimental-enable-grpc":                   true,
+}
+
+// flagName returns the name of the flag set by a command line argument, or
+// the empty string if the argument is not a flag.
+func flagName(arg string) string {
+	if !strings.HasPrefix(arg, "-") {
+		return ""
+	}
+
+	name := strings.TrimLeft(arg, "-")
+	if i := strings.Index(name, "="); i >= 0 {
+		name = name[:i]
+	}
+
+	return name
+}
+
+// parseFlags parses command line arguments, without the program name, into
+// flags.
+func parseFlags(args []string) (flags *flagStorage, err error) {
+	// Create a CLI app, and use it to parse the arguments.
+	app := newApp()
+	app.Action = func(c *cli.Context) {
+		if err = resolvePathForTheFlagsInContext(c); err != nil {
+			err = fmt.Errorf("Resolving path: %w", err)
+			return
+		}
+
+		flags, err = populateFlags(c)
+	}
+
+	if runErr := app.Run(append([]string{os.Args[0]}, args...)); runErr != nil {
+		err = runErr
+	}
+
+	return
+}
+
+// loadMountConfig reads the mounts listed in the given config file. The
+// flags of each are those of the supplied command line arguments, which must
+// not include a bucket or mount point, overridden by the mount's own.
+func loadMountConfig(configFile string, args []string) (mounts []mountSpec, err error) {
+	contents, err := os.ReadFile(configFile)
+	if err != nil {
+		err = fmt.Errorf("ReadFile: %w", err)
+		return
+	}
+
+	var cfg mountConfigFile
+	if err = json.Unmarshal(contents, &cfg); err != nil {
+		err = fmt.Errorf("Unmarshal: %w", err)
+		return
+	}
+
+	if len(cfg.Mounts) == 0 {
+		err = fmt.Errorf("%s lists no mounts", configFile)
+		return
+	}
+
+	seen := make(map[string]bool)
+	for i, m := range cfg.Mounts {
+		if m.MountPoint == "" {
+			err = fmt.Errorf("mount %d has no mount_point", i)
+			return
+		}
+
+		for _, arg := range m.Flags {
+			if name := flagName(arg); processWideFlags[name] {
+				err = fmt.Errorf("mount %q: --%s applies to the whole process and may only be given on the command line", m.MountPoint, name)
+				return
+			}
+		}
+
+		var spec mountSpec
+		spec.bucketName = m.Bucket
+
+		// Canonicalize the mount point, as for one given on the command line.
+		spec.mountPoint, err = getResolvedPath(m.MountPoint)
+		if err != nil {
+			err = fmt.Errorf("canonicalizing mount point %q: %w", m.MountPoint, err)
+			return
+		}
+
+		spec.mountPoint = path.Clean(spec.mountPoint)
+		if seen[spec.mountPoint] {
+			err = fmt.Errorf("mount point %q is listed twice", spec.mountPoint)
+			return
+		}
+		seen[spec.mountPoint] = true
+
+		spec.flags, err = parseFlags(append(append([]string{}, args...), m.Flags...))
+		if err != nil {
+			err = fmt.Errorf("flags for mount %q: %w", m.MountPoint, err)
+			return
+		}
+
+		mounts = append(mounts, spec)
+	}
+
+	return
+}
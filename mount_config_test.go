@@ -0,0 +1,110 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type MountConfigTest struct {
+	dir string
+}
+
+func init() { RegisterTestSuite(&MountConfigTest{}) }
+
+func (t *MountConfigTest) SetUp(ti *TestInfo) {
+	var err error
+	t.dir, err = os.MkdirTemp("", "mount_config_test")
+	AssertEq(nil, err)
+}
+
+func (t *MountConfigTest) TearDown() {
+	os.RemoveAll(t.dir)
+}
+
+// writeConfig writes a config file with the given contents, returning its
+// path.
+func (t *MountConfigTest) writeConfig(contents string) string {
+	p := path.Join(t.dir, "mounts.json")
+	AssertEq(nil, os.WriteFile(p, []byte(contents), 0644))
+	return p
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *MountConfigTest) FlagsOverrideCommandLine() {
+	p := t.writeConfig(`{
+		"mounts": [
+			{"bucket": "logs", "mount_point": "/mnt/logs", "flags": ["--only-dir=2023", "--implicit-dirs"]},
+			{"bucket": "data", "mount_point": "/mnt/data"}
+		]
+	}`)
+
+	mounts, err := loadMountConfig(p, []string{"--only-dir=shared", "--stat-cache-capacity=7"})
+	AssertEq(nil, err)
+	AssertEq(2, len(mounts))
+
+	ExpectEq("logs", mounts[0].bucketName)
+	ExpectEq("/mnt/logs", mounts[0].mountPoint)
+	ExpectEq("2023", mounts[0].flags.OnlyDir)
+	ExpectTrue(mounts[0].flags.ImplicitDirs)
+	ExpectEq(7, mounts[0].flags.StatCacheCapacity)
+
+	ExpectEq("data", mounts[1].bucketName)
+	ExpectEq("/mnt/data", mounts[1].mountPoint)
+	ExpectEq("shared", mounts[1].flags.OnlyDir)
+	ExpectFalse(mounts[1].flags.ImplicitDirs)
+	ExpectEq(7, mounts[1].flags.StatCacheCapacity)
+}
+
+func (t *MountConfigTest) ProcessWideFlag() {
+	p := t.writeConfig(`{
+		"mounts": [
+			{"bucket": "logs", "mount_point": "/mnt/logs", "flags": ["--key-file=/tmp/key.json"]}
+		]
+	}`)
+
+	_, err := loadMountConfig(p, nil)
+	ExpectThat(err, Error(HasSubstr("--key-file")))
+}
+
+func (t *MountConfigTest) MountPointListedTwice() {
+	p := t.writeConfig(`{
+		"mounts": [
+			{"bucket": "logs", "mount_point": "/mnt/a"},
+			{"bucket": "data", "mount_point": "/mnt/a/"}
+		]
+	}`)
+
+	_, err := loadMountConfig(p, nil)
+	ExpectThat(err, Error(HasSubstr("listed twice")))
+}
+
+func (t *MountConfigTest) NoMounts() {
+	p := t.writeConfig(`{"mounts": []}`)
+
+	_, err := loadMountConfig(p, nil)
+	ExpectThat(err, Error(HasSubstr("no mounts")))
+}